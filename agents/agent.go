@@ -0,0 +1,387 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// outputInterval is how often the output of the commands is sent
+	outputInterval = 250 * time.Millisecond
+	// retryInterval is how long to wait before trying again when the
+	// controller can't be reached
+	retryInterval = 5 * time.Second
+)
+
+// errUnknownAgent is returned when the controller doesn't know the agent,
+// like after a restart, and it has to register again
+var errUnknownAgent = fmt.Errorf("unknown agent")
+
+// AgentOptions configures an Agent
+type AgentOptions struct {
+	// Controller is the URL of the controller, like https://controller:8090
+	Controller string
+	Token      string
+	// RootCAs are the certificate authorities trusted for a controller
+	// served with TLS. Defaults to the ones of the system
+	RootCAs *x509.CertPool
+	// Name defaults to the hostname
+	Name string
+	Tags []string
+	// Concurrency is how many tasks the agent runs at once
+	Concurrency int
+	Logger      *logrus.Logger
+}
+
+// Agent runs the tasks of a controller on this machine
+type Agent struct {
+	options *AgentOptions
+	client  *http.Client
+	id      string
+}
+
+// NewAgent creates a new Agent
+func NewAgent(options *AgentOptions) (*Agent, error) {
+	if options.Controller == "" {
+		return nil, fmt.Errorf("no controller given")
+	}
+	if options.Name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		options.Name = hostname
+	}
+	if options.Concurrency < 1 {
+		options.Concurrency = 1
+	}
+	if options.Logger == nil {
+		options.Logger = logrus.StandardLogger()
+	}
+	options.Controller = strings.TrimSuffix(options.Controller, "/")
+	if strings.HasPrefix(options.Controller, "http://") {
+		options.Logger.Warnf("Connecting to %s without TLS. The token and the steps are sent unencrypted", options.Controller)
+	}
+
+	return &Agent{
+		options: options,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: options.RootCAs},
+			},
+		},
+	}, nil
+}
+
+// Run registers the agent and runs the tasks it gets until the context is
+// done. The running tasks are stopped then
+func (a *Agent) Run(ctx context.Context) error {
+	slots := make(chan struct{}, a.options.Concurrency)
+	running := &sync.WaitGroup{}
+	defer running.Wait()
+
+	for {
+		if a.id == "" {
+			if err := a.register(ctx); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				a.options.Logger.WithError(err).Error("Failed to register with the controller")
+				a.wait(ctx, retryInterval)
+				continue
+			}
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+
+		task, err := a.poll(ctx)
+		if err != nil || task == nil {
+			<-slots
+
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err == errUnknownAgent {
+				a.id = ""
+			} else if err != nil {
+				a.options.Logger.WithError(err).Error("Failed to get tasks from the controller")
+				a.wait(ctx, retryInterval)
+			}
+
+			continue
+		}
+
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			defer func() { <-slots }()
+
+			a.runTask(ctx, task)
+		}()
+	}
+}
+
+func (a *Agent) register(ctx context.Context) error {
+	registration := &Registration{
+		Name: a.options.Name,
+		OS:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+		Tags: a.options.Tags,
+	}
+	body, err := json.Marshal(registration)
+	if err != nil {
+		return err
+	}
+
+	info := &Info{}
+	if _, err = a.request(ctx, http.MethodPost, "/agents", body, info); err != nil {
+		return err
+	}
+	a.id = info.ID
+
+	a.options.Logger.WithField("agent", a.options.Name).Infof("Registered with %s", a.options.Controller)
+
+	return nil
+}
+
+// poll waits for the next task from the controller. It returns nil if there
+// wasn't any
+func (a *Agent) poll(ctx context.Context) (*utils.AgentTask, error) {
+	task := &utils.AgentTask{}
+	status, err := a.request(ctx, http.MethodGet, "/agents/"+a.id+"/tasks", nil, task)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNoContent {
+		return nil, nil
+	}
+
+	return task, nil
+}
+
+// runTask runs the command of the task and sends its output and result to
+// the controller
+func (a *Agent) runTask(ctx context.Context, task *utils.AgentTask) {
+	logger := a.options.Logger.WithField("workflow", task.Workflow).WithField("step", task.Step)
+	logger.Info("Running")
+
+	if len(task.Command) == 0 {
+		a.sendResult(ctx, task, &Result{ExitCode: -1, Error: "no command"})
+		return
+	}
+
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(taskCtx, task.Command[0], task.Command[1:]...)
+	cmd.Env = append(os.Environ(), task.Env...)
+	cmd.Dir = task.Workdir
+	if task.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(task.Stdin)
+	}
+
+	stdout := &taskOutput{signal: &sync.Mutex{}}
+	stderr := &taskOutput{signal: &sync.Mutex{}}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	done := make(chan struct{})
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		a.sendOutput(ctx, task, stdout, stderr, done, cancel)
+	}()
+
+	stopProcess, err := utils.StartProcess(cmd, task.GracePeriod)
+	if err == nil {
+		err = cmd.Wait()
+		stopProcess()
+	}
+	close(done)
+	<-sent
+
+	result := &Result{}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			result.ExitCode = exitErr.ExitCode()
+			if result.ExitCode == -1 {
+				// killed by a signal
+				result.ExitCode = 1
+			}
+		} else {
+			result.ExitCode = -1
+			result.Error = err.Error()
+		}
+	}
+
+	logger.WithField("exit_code", result.ExitCode).Info("Done")
+	a.sendResult(ctx, task, result)
+}
+
+// sendOutput sends the output of the command until it's done. The command is
+// cancelled if the controller says so
+func (a *Agent) sendOutput(ctx context.Context, task *utils.AgentTask, stdout *taskOutput, stderr *taskOutput, done chan struct{}, cancel context.CancelFunc) {
+	ticker := time.NewTicker(outputInterval)
+	defer ticker.Stop()
+
+	lastSent := time.Now()
+	for {
+		finished := false
+		select {
+		case <-ticker.C:
+		case <-done:
+			finished = true
+		}
+
+		for _, stream := range []struct {
+			name   string
+			output *taskOutput
+		}{{"stdout", stdout}, {"stderr", stderr}} {
+			for {
+				chunk := stream.output.take(maxOutputChunk)
+				// an empty stdout chunk tells the controller the task is
+				// still running
+				if len(chunk) == 0 && (stream.name != "stdout" || time.Since(lastSent) < heartbeatInterval) {
+					break
+				}
+
+				status := &taskStatus{}
+				path := fmt.Sprintf("/agents/%s/tasks/%s/%s", a.id, task.ID, stream.name)
+				if _, err := a.request(ctx, http.MethodPost, path, chunk, status); err != nil {
+					a.options.Logger.WithError(err).Warn("Failed to send output")
+				} else if status.Cancelled {
+					cancel()
+				}
+				lastSent = time.Now()
+
+				if len(chunk) < maxOutputChunk {
+					break
+				}
+			}
+		}
+
+		if finished {
+			return
+		}
+	}
+}
+
+// sendResult sends the result of the task, trying again for a while if the
+// controller can't be reached
+func (a *Agent) sendResult(ctx context.Context, task *utils.AgentTask, result *Result) {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+
+	path := fmt.Sprintf("/agents/%s/tasks/%s/result", a.id, task.ID)
+	deadline := time.Now().Add(agentTimeout)
+	for {
+		_, err = a.request(context.Background(), http.MethodPost, path, body, &taskStatus{})
+		if err == nil || err == errUnknownAgent || time.Now().After(deadline) {
+			break
+		}
+
+		a.options.Logger.WithError(err).Warn("Failed to send the result")
+		time.Sleep(retryInterval)
+	}
+}
+
+// request calls the controller and decodes the response to result
+func (a *Agent) request(ctx context.Context, method string, path string, body []byte, result interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	// long polls take up to pollTimeout
+	reqCtx, cancel := context.WithTimeout(ctx, pollTimeout+agentTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(method, a.options.Controller+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(reqCtx)
+	if a.options.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.options.Token)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound && strings.HasPrefix(path, "/agents/"):
+		return resp.StatusCode, errUnknownAgent
+	case resp.StatusCode == http.StatusNoContent:
+		return resp.StatusCode, nil
+	case resp.StatusCode >= 300:
+		return resp.StatusCode, fmt.Errorf("controller returned %s: %s", resp.Status, strings.TrimSpace(string(content)))
+	}
+
+	return resp.StatusCode, json.Unmarshal(content, result)
+}
+
+func (a *Agent) wait(ctx context.Context, duration time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(duration):
+	}
+}
+
+// taskOutput buffers the output of a command until it's sent
+type taskOutput struct {
+	signal *sync.Mutex
+	buffer bytes.Buffer
+}
+
+// Write implements io.Writer
+func (o *taskOutput) Write(p []byte) (int, error) {
+	o.signal.Lock()
+	defer o.signal.Unlock()
+
+	return o.buffer.Write(p)
+}
+
+// take returns up to max bytes of the buffered output
+func (o *taskOutput) take(max int) []byte {
+	o.signal.Lock()
+	defer o.signal.Unlock()
+
+	chunk := make([]byte, o.buffer.Len())
+	if len(chunk) > max {
+		chunk = chunk[:max]
+	}
+	_, _ = o.buffer.Read(chunk)
+
+	return chunk
+}
@@ -0,0 +1,62 @@
+package agents
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	// pollTimeout is how long an agent waits for a task before asking again
+	pollTimeout = 30 * time.Second
+	// heartbeatInterval is how often agents report on the tasks they run,
+	// even without output
+	heartbeatInterval = 5 * time.Second
+	// agentTimeout is how long an agent or a task can go without being heard
+	// from before it's considered gone
+	agentTimeout = 30 * time.Second
+	// maxOutputChunk is the largest chunk of output sent at once
+	maxOutputChunk = 1024 * 1024
+)
+
+// Registration describes an agent to the controller
+type Registration struct {
+	Name string   `json:"name"`
+	OS   string   `json:"os"`
+	Arch string   `json:"arch"`
+	Tags []string `json:"tags"`
+}
+
+// Info is an agent registered with the controller
+type Info struct {
+	ID string `json:"id"`
+	Registration
+	LastSeen time.Time `json:"last_seen"`
+	// Tasks is the number of tasks the agent is running
+	Tasks int `json:"tasks"`
+}
+
+// Result is how the command of a task finished
+type Result struct {
+	ExitCode int `json:"exit_code"`
+	// Error is why the command couldn't run
+	Error string `json:"error,omitempty"`
+}
+
+// taskStatus is returned to the agents when they report on a task
+type taskStatus struct {
+	Cancelled bool `json:"cancelled"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
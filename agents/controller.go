@@ -0,0 +1,407 @@
+package agents
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ControllerOptions configures a Controller
+type ControllerOptions struct {
+	// Token is required as a bearer token from the agents if set. Without
+	// one, any agent reaching the controller gets the steps and their
+	// environment
+	Token  string
+	Logger *logrus.Logger
+}
+
+// Controller is a utils.AgentDispatcher running the tasks on the agents
+// registered with it. It serves the API the agents use to register, get
+// their tasks and send back their output and results
+type Controller struct {
+	options *ControllerOptions
+	signal  *sync.Mutex
+	agents  map[string]*Info
+	tasks   map[string]*task
+	// pending are the tasks waiting for an agent, oldest first
+	pending []*task
+	// changed is closed when there are new tasks for the agents
+	changed chan struct{}
+}
+
+// task is a task dispatched to the agents
+type task struct {
+	*utils.AgentTask
+	// agent is the id of the agent running the task
+	agent    string
+	stdout   io.Writer
+	stderr   io.Writer
+	lastSeen time.Time
+	// cancelled tells the agent to stop the command
+	cancelled bool
+	// closed stops writing the output once the task is over
+	closed bool
+	result *Result
+	done   chan struct{}
+}
+
+// NewController creates a new Controller
+func NewController(options *ControllerOptions) *Controller {
+	if options == nil {
+		options = &ControllerOptions{}
+	}
+	if options.Logger == nil {
+		options.Logger = logrus.StandardLogger()
+	}
+
+	return &Controller{
+		options: options,
+		signal:  &sync.Mutex{},
+		agents:  make(map[string]*Info),
+		tasks:   make(map[string]*task),
+		changed: make(chan struct{}),
+	}
+}
+
+// Dispatch implements utils.AgentDispatcher. Tasks wait for a matching agent
+// to register for a while before failing
+func (c *Controller) Dispatch(ctx context.Context, agentTask *utils.AgentTask, stdout io.Writer, stderr io.Writer) error {
+	t := &task{
+		AgentTask: agentTask,
+		stdout:    stdout,
+		stderr:    stderr,
+		done:      make(chan struct{}),
+	}
+
+	c.signal.Lock()
+	c.tasks[t.ID] = t
+	c.pending = append(c.pending, t)
+	c.notify()
+	c.signal.Unlock()
+
+	defer func() {
+		c.signal.Lock()
+		defer c.signal.Unlock()
+
+		t.closed = true
+		delete(c.tasks, t.ID)
+		c.removePending(t)
+		if agent, ok := c.agents[t.agent]; ok && t.result == nil {
+			agent.Tasks--
+		}
+	}()
+
+	queued := time.Now()
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			c.signal.Lock()
+			name := c.agentName(t.agent)
+			c.signal.Unlock()
+
+			if t.result.Error != "" {
+				return fmt.Errorf("failed to run on agent %s: %s", name, t.result.Error)
+			}
+			if t.result.ExitCode != 0 {
+				return &utils.AgentExitError{Agent: name, ExitCode: t.result.ExitCode}
+			}
+
+			return nil
+		case <-ticker.C:
+			c.signal.Lock()
+			lost := t.agent != "" && time.Since(t.lastSeen) > agentTimeout
+			unmatched := t.agent == "" && time.Since(queued) > agentTimeout && !c.anyMatch(t.Selector)
+			name := c.agentName(t.agent)
+			c.signal.Unlock()
+
+			if lost {
+				return fmt.Errorf("lost agent %s", name)
+			}
+			if unmatched {
+				return fmt.Errorf("no agent matches %s", t.Selector)
+			}
+		case <-ctx.Done():
+			c.signal.Lock()
+			t.cancelled = true
+			assigned := t.agent != ""
+			c.removePending(t)
+			c.signal.Unlock()
+
+			// the agent is told to stop the command the next time it reports
+			if assigned {
+				select {
+				case <-t.done:
+				case <-time.After(t.GracePeriod + 2*heartbeatInterval):
+				}
+			}
+
+			return ctx.Err()
+		}
+	}
+}
+
+// Agents returns the agents registered with the controller
+func (c *Controller) Agents() []Info {
+	c.signal.Lock()
+	defer c.signal.Unlock()
+
+	c.forgetAgents()
+
+	agents := make([]Info, 0, len(c.agents))
+	for _, agent := range c.agents {
+		agents = append(agents, *agent)
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].Name < agents[j].Name
+	})
+
+	return agents
+}
+
+// ServeHTTP implements http.Handler
+func (c *Controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if c.options.Token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.options.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+			return
+		}
+	}
+
+	// paths are /agents, /agents/{id}/tasks and
+	// /agents/{id}/tasks/{task}/{stdout,stderr,result}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "agents" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, c.Agents())
+	case len(parts) == 1 && parts[0] == "agents" && r.Method == http.MethodPost:
+		c.register(w, r)
+	case len(parts) == 3 && parts[0] == "agents" && parts[2] == "tasks" && r.Method == http.MethodGet:
+		c.poll(w, r, parts[1])
+	case len(parts) == 5 && parts[0] == "agents" && parts[2] == "tasks" && r.Method == http.MethodPost:
+		c.report(w, r, parts[1], parts[3], parts[4])
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s %s not found", r.Method, r.URL.Path))
+	}
+}
+
+func (c *Controller) register(w http.ResponseWriter, r *http.Request) {
+	var registration Registration
+	if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if registration.Name == "" || registration.OS == "" || registration.Arch == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("agents need a name, os and arch"))
+		return
+	}
+
+	agent := &Info{
+		ID:           uuid.New().String(),
+		Registration: registration,
+		LastSeen:     time.Now(),
+	}
+
+	c.signal.Lock()
+	c.agents[agent.ID] = agent
+	c.signal.Unlock()
+
+	c.options.Logger.WithField("agent", agent.Name).Infof("Agent registered (%s/%s %v)", agent.OS, agent.Arch, agent.Tags)
+	writeJSON(w, http.StatusCreated, agent)
+}
+
+// poll returns the next task for the agent, waiting for one up to
+// pollTimeout. Agents poll again right after, so this tells that they're
+// still there
+func (c *Controller) poll(w http.ResponseWriter, r *http.Request, id string) {
+	timeout := time.NewTimer(pollTimeout)
+	defer timeout.Stop()
+
+	for {
+		c.signal.Lock()
+		agent, ok := c.agents[id]
+		if !ok {
+			c.signal.Unlock()
+			writeError(w, http.StatusNotFound, fmt.Errorf("agent %s not found", id))
+			return
+		}
+		agent.LastSeen = time.Now()
+
+		if t := c.nextTask(agent); t != nil {
+			t.agent = agent.ID
+			t.lastSeen = time.Now()
+			agent.Tasks++
+			c.removePending(t)
+			c.signal.Unlock()
+
+			c.options.Logger.WithField("agent", agent.Name).Debugf("Running %s of %s", t.Step, t.Workflow)
+			writeJSON(w, http.StatusOK, t.AgentTask)
+			return
+		}
+		changed := c.changed
+		c.signal.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// report takes the output or the result of a task from the agent running it
+func (c *Controller) report(w http.ResponseWriter, r *http.Request, agentID string, taskID string, kind string) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxOutputChunk))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	c.signal.Lock()
+	defer c.signal.Unlock()
+
+	agent, ok := c.agents[agentID]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("agent %s not found", agentID))
+		return
+	}
+	agent.LastSeen = time.Now()
+
+	// tasks that are over are cancelled so the agent stops them
+	t, ok := c.tasks[taskID]
+	if !ok || t.agent != agentID || t.closed {
+		writeJSON(w, http.StatusOK, &taskStatus{Cancelled: true})
+		return
+	}
+	t.lastSeen = time.Now()
+
+	switch kind {
+	case "stdout", "stderr":
+		out := t.stdout
+		if kind == "stderr" {
+			out = t.stderr
+		}
+		if len(body) != 0 {
+			_, _ = out.Write(body)
+		}
+	case "result":
+		if t.result != nil {
+			break
+		}
+
+		result := &Result{}
+		if err = json.Unmarshal(body, result); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		t.result = result
+		agent.Tasks--
+		close(t.done)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &taskStatus{Cancelled: t.cancelled})
+}
+
+// nextTask returns the oldest pending task the agent can run
+func (c *Controller) nextTask(agent *Info) *task {
+	for _, t := range c.pending {
+		if matches(t.Selector, agent) {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// anyMatch returns true if an agent matching the selector is registered
+func (c *Controller) anyMatch(selector *utils.AgentSelector) bool {
+	c.forgetAgents()
+
+	for _, agent := range c.agents {
+		if matches(selector, agent) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forgetAgents removes the agents that stopped polling
+func (c *Controller) forgetAgents() {
+	for id, agent := range c.agents {
+		if agent.Tasks == 0 && time.Since(agent.LastSeen) > agentTimeout {
+			delete(c.agents, id)
+		}
+	}
+}
+
+func (c *Controller) removePending(t *task) {
+	for idx, item := range c.pending {
+		if item == t {
+			c.pending = append(c.pending[:idx], c.pending[idx+1:]...)
+			return
+		}
+	}
+}
+
+func (c *Controller) agentName(id string) string {
+	if agent, ok := c.agents[id]; ok {
+		return agent.Name
+	}
+
+	return id
+}
+
+// notify wakes up the agents waiting for tasks
+func (c *Controller) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// matches returns true if the agent matches the selector
+func matches(selector *utils.AgentSelector, agent *Info) bool {
+	if selector == nil {
+		return true
+	}
+	if selector.OS != "" && selector.OS != agent.OS {
+		return false
+	}
+	if selector.Arch != "" && selector.Arch != agent.Arch {
+		return false
+	}
+	for _, tag := range selector.Tags {
+		found := false
+		for _, agentTag := range agent.Tags {
+			if tag == agentTag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,178 @@
+package builder
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+// Builder builds a workflow in Go, for services that make their workflows
+// without writing them as workflow files:
+//
+//	workflow, err := builder.New().
+//		Step("build", builder.Cmd("make")).
+//		Step("deploy", builder.Cmd("make deploy"), builder.After("build")).
+//		Build(ctx, options)
+//
+// The workflow is checked and runs like one loaded from a file
+type Builder struct {
+	options   []func(workflow *utils.Workflow)
+	steps     []*definition
+	cleanup   []*definition
+	templates []*definition
+}
+
+// StepOption sets an attribute of a step
+type StepOption func(step *utils.Step)
+
+type definition struct {
+	name    string
+	options []StepOption
+}
+
+// New creates a builder for an empty workflow
+func New() *Builder {
+	return &Builder{}
+}
+
+// Step adds a step with the name to the workflow. Steps are in the order they
+// are added, like in a workflow file
+func (b *Builder) Step(name string, options ...StepOption) *Builder {
+	b.steps = append(b.steps, &definition{name: name, options: options})
+
+	return b
+}
+
+// Cleanup adds a cleanup step, run once the other steps are done
+func (b *Builder) Cleanup(name string, options ...StepOption) *Builder {
+	b.cleanup = append(b.cleanup, &definition{name: name, options: options})
+
+	return b
+}
+
+// Template adds a template the steps can use with UseTemplate
+func (b *Builder) Template(name string, options ...StepOption) *Builder {
+	b.templates = append(b.templates, &definition{name: name, options: options})
+
+	return b
+}
+
+// Variable sets a variable of the workflow
+func (b *Builder) Variable(name string, value string) *Builder {
+	return b.with(func(workflow *utils.Workflow) {
+		if workflow.Variables == nil {
+			workflow.Variables = make(map[string]string)
+		}
+		workflow.Variables[name] = value
+	})
+}
+
+// Metadata sets a metadata value of the workflow
+func (b *Builder) Metadata(key string, value string) *Builder {
+	return b.with(func(workflow *utils.Workflow) {
+		if workflow.Metadata == nil {
+			workflow.Metadata = make(map[string]string)
+		}
+		workflow.Metadata[key] = value
+	})
+}
+
+// Env adds environment variables, like KEY=value, to all steps
+func (b *Builder) Env(env ...string) *Builder {
+	return b.with(func(workflow *utils.Workflow) {
+		workflow.Env = append(workflow.Env, env...)
+	})
+}
+
+// Shell sets the shell the commands of the steps run with
+func (b *Builder) Shell(shell string) *Builder {
+	return b.with(func(workflow *utils.Workflow) {
+		workflow.Shell = shell
+	})
+}
+
+// Timeout sets how long the workflow can run for
+func (b *Builder) Timeout(timeout time.Duration) *Builder {
+	return b.with(func(workflow *utils.Workflow) {
+		workflow.Timeout = &timeout
+	})
+}
+
+// Logger sets how the workflow and its steps log
+func (b *Builder) Logger(definition *utils.LogDefinition) *Builder {
+	return b.with(func(workflow *utils.Workflow) {
+		workflow.Logger = definition
+	})
+}
+
+// Group adds a group of steps of which at most limit run at once
+func (b *Builder) Group(name string, limit int) *Builder {
+	return b.with(func(workflow *utils.Workflow) {
+		if workflow.Groups == nil {
+			workflow.Groups = make(map[string]int)
+		}
+		workflow.Groups[name] = limit
+	})
+}
+
+// With changes any other attribute of the workflow
+func (b *Builder) With(option func(workflow *utils.Workflow)) *Builder {
+	return b.with(option)
+}
+
+func (b *Builder) with(option func(workflow *utils.Workflow)) *Builder {
+	b.options = append(b.options, option)
+
+	return b
+}
+
+// Definition returns the workflow as it would be read from a workflow file.
+// Each call returns a new one
+func (b *Builder) Definition() *utils.Workflow {
+	workflow := &utils.Workflow{Version: "1"}
+	for _, option := range b.options {
+		option(workflow)
+	}
+
+	for _, template := range b.templates {
+		if workflow.Templates == nil {
+			workflow.Templates = make(map[string]*utils.Step)
+		}
+		// templates don't have a name of their own
+		workflow.Templates[template.name] = (&definition{options: template.options}).step()
+	}
+	for _, step := range b.steps {
+		workflow.Steps = append(workflow.Steps, step.step())
+	}
+	for _, step := range b.cleanup {
+		workflow.Cleanup = append(workflow.Cleanup, step.step())
+	}
+
+	return workflow
+}
+
+// Build returns the workflow, checked and ready to run like a workflow
+// loaded from a file. It can be built more than once, for more than one run
+func (b *Builder) Build(ctx context.Context, options *utils.WorkflowOptions) (*utils.Workflow, error) {
+	return utils.LoadWorkflow(ctx, options, b.Definition())
+}
+
+// Run builds the workflow and runs it
+func (b *Builder) Run(ctx context.Context, options *utils.WorkflowOptions) (*utils.WorkflowResult, error) {
+	workflow, err := b.Build(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return workflow.Run(ctx)
+}
+
+func (d *definition) step() *utils.Step {
+	step := &utils.Step{Name: d.name}
+	for _, option := range d.options {
+		option(step)
+	}
+
+	return step
+}
@@ -0,0 +1,161 @@
+package builder
+
+import (
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+// Cmd sets the command of the step
+func Cmd(command string) StepOption {
+	return func(step *utils.Step) {
+		step.Command = command
+	}
+}
+
+// After makes the step depend on the steps with the names
+func After(names ...string) StepOption {
+	return AfterStatus("", names...)
+}
+
+// AfterStatus makes the step depend on the steps with the names finishing
+// with the status: utils.DependencySuccess, utils.DependencyFailure or
+// utils.DependencyAny
+func AfterStatus(status string, names ...string) StepOption {
+	return func(step *utils.Step) {
+		for _, name := range names {
+			step.DependsOn = append(step.DependsOn, &utils.Dependency{Step: name, Status: status})
+		}
+	}
+}
+
+// Env adds environment variables, like KEY=value, to the step
+func Env(env ...string) StepOption {
+	return func(step *utils.Step) {
+		step.Env = append(step.Env, env...)
+	}
+}
+
+// Metadata sets a metadata value of the step
+func Metadata(key string, value string) StepOption {
+	return func(step *utils.Step) {
+		if step.Metadata == nil {
+			step.Metadata = make(map[string]string)
+		}
+		step.Metadata[key] = value
+	}
+}
+
+// Workdir sets the directory the command runs in
+func Workdir(dir string) StepOption {
+	return func(step *utils.Step) {
+		step.Workdir = dir
+	}
+}
+
+// Timeout sets how long the command can run for
+func Timeout(timeout time.Duration) StepOption {
+	return func(step *utils.Step) {
+		step.Timeout = &timeout
+	}
+}
+
+// ContinueOnFail lets the workflow go on if the step fails
+func ContinueOnFail() StepOption {
+	return func(step *utils.Step) {
+		step.ContinueOnFail = true
+	}
+}
+
+// When runs the step only if the condition is true
+func When(condition string) StepOption {
+	return func(step *utils.Step) {
+		step.When = condition
+	}
+}
+
+// Retry retries the step with the policy if it fails
+func Retry(policy *utils.RetryPolicy) StepOption {
+	return func(step *utils.Step) {
+		step.Retry = policy
+	}
+}
+
+// Probe checks the step succeeded with the command once it's done
+func Probe(command string) StepOption {
+	return func(step *utils.Step) {
+		step.Probe = &utils.Probe{Command: command}
+	}
+}
+
+// Priority sets the priority of the step. Higher priority steps run first
+func Priority(priority int) StepOption {
+	return func(step *utils.Step) {
+		step.Priority = priority
+	}
+}
+
+// Lock makes the step run only when no other step with the lock is running
+func Lock(name string) StepOption {
+	return func(step *utils.Step) {
+		step.Lock = name
+	}
+}
+
+// Group puts the step in a group of the workflow
+func Group(name string) StepOption {
+	return func(step *utils.Step) {
+		step.Group = name
+	}
+}
+
+// Disabled keeps the step from running
+func Disabled() StepOption {
+	return func(step *utils.Step) {
+		step.Disabled = true
+	}
+}
+
+// Output adds an output with the name, read from the output of the command,
+// or from the file if given
+func Output(name string, file string) StepOption {
+	return func(step *utils.Step) {
+		step.OutputDefinitions = append(step.OutputDefinitions, utils.OutputDefinition{Name: name, File: file})
+	}
+}
+
+// Rollback sets the command undoing the step
+func Rollback(command string) StepOption {
+	return func(step *utils.Step) {
+		step.Rollback = command
+	}
+}
+
+// UseTemplate makes the step use the template with the name and args
+func UseTemplate(name string, args map[string]string) StepOption {
+	return func(step *utils.Step) {
+		step.Template = name
+		step.Args = args
+	}
+}
+
+// Docker runs the command in a container
+func Docker(options *utils.DockerOptions) StepOption {
+	return func(step *utils.Step) {
+		step.Type = utils.StepTypeDocker
+		step.Docker = options
+	}
+}
+
+// SSH runs the command on a host
+func SSH(options *utils.SSHOptions) StepOption {
+	return func(step *utils.Step) {
+		step.Type = utils.StepTypeSSH
+		step.SSH = options
+	}
+}
+
+// With sets any other attribute of the step
+func With(option func(step *utils.Step)) StepOption {
+	return option
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/cloud66-oss/trackman/agents"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Run the steps of a controller on this machine",
+	Run:   agentExec,
+}
+
+func init() {
+	agentCmd.Flags().String("controller", "", "URL of the controller to get the steps from, like https://controller:8090")
+	agentCmd.Flags().String("name", "", "name of the agent. Defaults to the hostname")
+	agentCmd.Flags().StringSlice("tags", nil, "tags the steps can select the agent with. Can be used multiple times")
+	agentCmd.Flags().Int("concurrency", 1, "number of steps to run at once")
+
+	_ = viper.BindPFlag("agent.controller", agentCmd.Flags().Lookup("controller"))
+	_ = viper.BindPFlag("agent.name", agentCmd.Flags().Lookup("name"))
+	_ = viper.BindPFlag("agent.tags", agentCmd.Flags().Lookup("tags"))
+	_ = viper.BindPFlag("agent.concurrency", agentCmd.Flags().Lookup("concurrency"))
+
+	rootCmd.AddCommand(agentCmd)
+}
+
+func agentExec(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger, err := utils.NewLogger(nil, utils.NewLoggingContext(nil, nil))
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	var rootCAs *x509.CertPool
+	if ca := configOrEnv("agents.tls.ca", "TRACKMAN_AGENTS_TLS_CA"); ca != "" {
+		buff, err := ioutil.ReadFile(ca)
+		if err != nil {
+			logger.Error(err)
+			os.Exit(1)
+		}
+		rootCAs = x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(buff) {
+			logger.Errorf("No certificates found in %s", ca)
+			os.Exit(1)
+		}
+	}
+
+	agent, err := agents.NewAgent(&agents.AgentOptions{
+		Controller:  viper.GetString("agent.controller"),
+		Token:       configOrEnv("agents.token", "TRACKMAN_AGENTS_TOKEN"),
+		RootCAs:     rootCAs,
+		Name:        viper.GetString("agent.name"),
+		Tags:        viper.GetStringSlice("agent.tags"),
+		Concurrency: viper.GetInt("agent.concurrency"),
+		Logger:      logger,
+	})
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	// stop the running steps on Ctrl-C or when asked to terminate
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		sig := <-signals
+		logger.Warnf("Received %s. Stopping the agent", sig)
+		cancel()
+	}()
+
+	if err = agent.Run(ctx); err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+}
+
+// agentController serves the API the agents connect to on the address and
+// returns the controller dispatching the steps to them, or nil if there's
+// no address
+func agentController(addr string) utils.AgentDispatcher {
+	if addr == "" {
+		return nil
+	}
+
+	// agents get the commands of the steps and their environment, so only
+	// the ones with the token can connect
+	token := configOrEnv("agents.token", "TRACKMAN_AGENTS_TOKEN")
+	if token == "" {
+		utils.PrintError("--agents-addr needs a token, set with agents.token in the config file or TRACKMAN_AGENTS_TOKEN")
+		os.Exit(utils.ExitInvalid)
+	}
+	cert := configOrEnv("agents.tls.cert", "TRACKMAN_AGENTS_TLS_CERT")
+	key := configOrEnv("agents.tls.key", "TRACKMAN_AGENTS_TLS_KEY")
+	if (cert == "") != (key == "") {
+		utils.PrintError("the agents need both a TLS certificate and a key")
+		os.Exit(utils.ExitInvalid)
+	}
+
+	controller := agents.NewController(&agents.ControllerOptions{
+		Token: token,
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/agents", controller)
+	mux.Handle("/agents/", controller)
+	go func() {
+		var err error
+		if cert != "" {
+			err = http.ListenAndServeTLS(addr, cert, key, mux)
+		} else {
+			err = http.ListenAndServe(addr, mux)
+		}
+		if err != nil {
+			fmt.Printf("Failed to serve the agents: %s\n", err)
+		}
+	}()
+
+	return controller
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/cloud66-oss/trackman/notifiers"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var alertCmd = &cobra.Command{
+	Use:   "alert",
+	Short: "Manage the alerts sent to PagerDuty or Opsgenie when workflows fail",
+}
+
+var alertTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Send a test alert with the alert settings of the config file",
+	Args:  cobra.NoArgs,
+	Run:   alertTestExec,
+}
+
+func init() {
+	alertTestCmd.Flags().Bool("resolve", false, "resolve the test alert instead of opening it")
+
+	alertCmd.AddCommand(alertTestCmd)
+	rootCmd.AddCommand(alertCmd)
+}
+
+func alertTestExec(cmd *cobra.Command, args []string) {
+	alert, err := alertNotifier()
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	key := "trackman:test"
+	if resolve, _ := cmd.Flags().GetBool("resolve"); resolve {
+		if err = alert.Resolve(ctx, key); err != nil {
+			utils.PrintError(err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Println("Resolved the test alert")
+		return
+	}
+
+	err = alert.Trigger(ctx, &notifiers.Alert{
+		Key:      key,
+		Summary:  "Test alert from Trackman",
+		Severity: utils.SeverityInfo,
+		Workflow: "test",
+	})
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Println("Sent a test alert. Resolve it with trackman alert test --resolve")
+}
+
+// alertNotifier returns the alert notifier set up in the alert section of the
+// config file
+func alertNotifier() (*notifiers.AlertNotifier, error) {
+	if viper.GetString("alert.provider") == "" {
+		return nil, fmt.Errorf("no alert provider. Set alert.provider in the config file")
+	}
+
+	return notifiers.NewAlertNotifier(&notifiers.AlertOptions{
+		Provider:   viper.GetString("alert.provider"),
+		RoutingKey: viper.GetString("alert.routing_key"),
+		APIKey:     viper.GetString("alert.api_key"),
+		URL:        viper.GetString("alert.url"),
+		Severities: viper.GetStringMapString("alert.severities"),
+	})
+}
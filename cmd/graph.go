@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/spf13/cobra"
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Print the dependency graph of the workflow as DOT or Mermaid",
+	Run:   graphExec,
+}
+
+var (
+	graphWorkflowFile string
+)
+
+func init() {
+	graphCmd.Flags().StringVarP(&graphWorkflowFile, "file", "f", "", "workflow file to draw")
+	graphCmd.Flags().StringArray("set", nil, "set a workflow variable as key=value. Can be used multiple times")
+	addLoadFlags(graphCmd)
+	graphCmd.Flags().String("format", utils.GraphDOT, "format of the graph. Valid values are dot and mermaid")
+	graphCmd.Flags().String("report", "", "json report of a run to annotate the steps with their status and duration")
+
+	rootCmd.AddCommand(graphCmd)
+}
+
+func graphExec(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	options := &utils.WorkflowOptions{
+		Notifiers: utils.NewNotifierRegistry(),
+	}
+
+	workflow, err := loadWorkflow(ctx, args, options, cmd)
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	var result *utils.WorkflowResult
+	if report, _ := cmd.Flags().GetString("report"); report != "" {
+		buff, err := ioutil.ReadFile(report)
+		if err != nil {
+			utils.PrintError(err.Error())
+			os.Exit(1)
+		}
+		if err = json.Unmarshal(buff, &result); err != nil {
+			utils.PrintError(fmt.Sprintf("invalid report %s: %s", report, err))
+			os.Exit(1)
+		}
+	}
+
+	format, _ := cmd.Flags().GetString("format")
+	graph, err := workflow.ExportGraph(format, result)
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Print(graph)
+}
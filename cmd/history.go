@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloud66-oss/trackman/history"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the history of workflow runs kept with --history-dir or --history-db",
+}
+
+var historyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the past runs, most recent first",
+	Args:  cobra.NoArgs,
+	Run:   historyListExec,
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:   "show <run>",
+	Short: "Show the steps of a past run",
+	Args:  cobra.ExactArgs(1),
+	Run:   historyShowExec,
+}
+
+var historyCompareCmd = &cobra.Command{
+	Use:   "compare <run> <run>",
+	Short: "Compare how long the steps of two runs took",
+	Args:  cobra.ExactArgs(2),
+	Run:   historyCompareExec,
+}
+
+func init() {
+	historyListCmd.Flags().String("workflow", "", "only list the runs of this workflow")
+	historyListCmd.Flags().Int("limit", 20, "maximum number of runs to list. 0 lists all of them")
+
+	historyCmd.AddCommand(historyListCmd)
+	historyCmd.AddCommand(historyShowCmd)
+	historyCmd.AddCommand(historyCompareCmd)
+	rootCmd.AddCommand(historyCmd)
+}
+
+func historyStore() history.RunStore {
+	store, err := newHistoryStore()
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+	if store == nil {
+		utils.PrintError("no history. Use --history-dir or --history-db, or set history.dir or history.db in the config file")
+		os.Exit(1)
+	}
+
+	return store
+}
+
+// newHistoryStore returns the store of the history in the database or the
+// directory given, or nil if there is none
+func newHistoryStore() (history.RunStore, error) {
+	if path := viper.GetString("history.db"); path != "" {
+		return history.NewSQLiteStore(path)
+	}
+	if dir := viper.GetString("history.dir"); dir != "" {
+		return history.NewFileStore(dir)
+	}
+
+	return nil, nil
+}
+
+func historyListExec(cmd *cobra.Command, args []string) {
+	workflow, _ := cmd.Flags().GetString("workflow")
+	limit, _ := cmd.Flags().GetInt("limit")
+
+	runs, err := historyStore().List(&history.Filter{Workflow: workflow, Limit: limit})
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "RUN\tWORKFLOW\tOUTCOME\tSTARTED\tDURATION")
+	for _, run := range runs {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", run.SessionID, run.Workflow, run.Outcome, run.StartedAt.Local().Format(time.RFC3339), run.Duration.Round(time.Millisecond))
+	}
+	_ = table.Flush()
+}
+
+func historyShowExec(cmd *cobra.Command, args []string) {
+	run, err := historyStore().Get(args[0])
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("Run:      %s\n", run.SessionID)
+	fmt.Printf("Workflow: %s\n", run.Workflow)
+	fmt.Printf("Outcome:  %s\n", run.Outcome)
+	fmt.Printf("Started:  %s\n", run.StartedAt.Local().Format(time.RFC3339))
+	fmt.Printf("Duration: %s\n", run.Duration.Round(time.Millisecond))
+	for _, message := range run.Errors {
+		fmt.Printf("Error:    %s\n", message)
+	}
+	fmt.Println()
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "STEP\tSTATUS\tDURATION\tATTEMPTS\tCPU\tMEMORY\tLOG\tERROR")
+	for _, step := range run.Steps {
+		log := ""
+		if step.Log != nil && step.Log.Type == "file" {
+			log = step.Log.Destination
+		}
+
+		cpu, memory := "-", "-"
+		if step.Usage != nil {
+			cpu = step.Usage.CPUTime().Round(time.Millisecond).String()
+			if step.Usage.MaxRSS != 0 {
+				memory = fmt.Sprintf("%.1fMB", float64(step.Usage.MaxRSS)/(1024*1024))
+			}
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", step.Name, step.Status, step.Duration.Round(time.Millisecond), step.Attempts, cpu, memory, log, run.StepErrors[step.Name])
+	}
+	_ = table.Flush()
+}
+
+func historyCompareExec(cmd *cobra.Command, args []string) {
+	store := historyStore()
+
+	var runs []*history.Run
+	for _, id := range args {
+		run, err := store.Get(id)
+		if err != nil {
+			utils.PrintError(err.Error())
+			os.Exit(1)
+		}
+
+		runs = append(runs, run)
+	}
+	if runs[0].Workflow != runs[1].Workflow {
+		utils.PrintError("the runs are of different workflows: %s and %s", runs[0].Workflow, runs[1].Workflow)
+		os.Exit(1)
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "STEP\t%s\t%s\tCHANGE\n", runs[0].SessionID, runs[1].SessionID)
+	for _, comparison := range history.Compare(runs[0], runs[1]) {
+		change := ""
+		if !comparison.Missing {
+			change = changeString(comparison.Before, comparison.After)
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", comparison.Name, durationString(comparison.Before), durationString(comparison.After), change)
+	}
+	fmt.Fprintf(table, "TOTAL\t%s\t%s\t%s\n", durationString(runs[0].Duration), durationString(runs[1].Duration), changeString(runs[0].Duration, runs[1].Duration))
+	_ = table.Flush()
+}
+
+func durationString(value time.Duration) string {
+	if value == 0 {
+		return "-"
+	}
+
+	return value.Round(time.Millisecond).String()
+}
+
+// changeString returns the change from before to after with its percentage
+func changeString(before time.Duration, after time.Duration) string {
+	change := (after - before).Round(time.Millisecond)
+	sign := "+"
+	if change < 0 {
+		sign = ""
+	}
+	if before == 0 {
+		return fmt.Sprintf("%s%s", sign, change)
+	}
+
+	return fmt.Sprintf("%s%s (%s%.0f%%)", sign, change, sign, float64(change)/float64(before)*100)
+}
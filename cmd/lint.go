@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloud66-oss/trackman/history"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check the workflow for things that are valid but likely to be mistakes",
+	Run:   lintExec,
+}
+
+var (
+	lintWorkflowFile string
+)
+
+func init() {
+	lintCmd.Flags().StringVarP(&lintWorkflowFile, "file", "f", "", "workflow file to lint")
+	lintCmd.Flags().StringArray("set", nil, "set a workflow variable as key=value. Can be used multiple times")
+	addLoadFlags(lintCmd)
+	lintCmd.Flags().StringSlice("disable", nil, "rules not to run")
+	lintCmd.Flags().StringSlice("enable", nil, "only run these rules")
+	lintCmd.Flags().StringArray("severity", nil, "change the severity of a rule as rule=severity. Can be used multiple times")
+	lintCmd.Flags().String("fail-on", utils.SeverityError, "fail if any finding is at least this severe. Valid values are info, warning and error")
+	lintCmd.Flags().Duration("long-running", time.Minute, "how long a step has to take to need a timeout")
+	lintCmd.Flags().Bool("rules", false, "list the rules and exit")
+
+	_ = viper.BindPFlag("lint.disable", lintCmd.Flags().Lookup("disable"))
+	_ = viper.BindPFlag("lint.enable", lintCmd.Flags().Lookup("enable"))
+	_ = viper.BindPFlag("lint.fail_on", lintCmd.Flags().Lookup("fail-on"))
+	_ = viper.BindPFlag("lint.long_running", lintCmd.Flags().Lookup("long-running"))
+
+	rootCmd.AddCommand(lintCmd)
+}
+
+func lintExec(cmd *cobra.Command, args []string) {
+	if list, _ := cmd.Flags().GetBool("rules"); list {
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "RULE\tSEVERITY\tDESCRIPTION")
+		for _, rule := range utils.LintRules() {
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", rule.ID, rule.Severity, rule.Description)
+		}
+		_ = writer.Flush()
+
+		return
+	}
+
+	ctx := context.Background()
+
+	options := &utils.WorkflowOptions{
+		Notifiers: utils.NewNotifierRegistry(),
+	}
+
+	workflow, err := loadWorkflow(ctx, args, options, cmd)
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	lintOptions, err := lintOptions(cmd, options.Name)
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	findings, err := utils.LintWorkflow(workflow, lintOptions)
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	for _, finding := range findings {
+		fmt.Println(finding)
+	}
+
+	if utils.LintFailed(findings, viper.GetString("lint.fail_on")) {
+		os.Exit(1)
+	}
+}
+
+// lintOptions returns the options of the linter from the flags and the lint
+// section of the configuration. Steps are checked against how long they
+// took in the last run of the workflow if there is a history
+func lintOptions(cmd *cobra.Command, name string) (*utils.LintOptions, error) {
+	options := &utils.LintOptions{
+		Disable:     viper.GetStringSlice("lint.disable"),
+		Enable:      viper.GetStringSlice("lint.enable"),
+		Severities:  viper.GetStringMapString("lint.severity"),
+		LongRunning: viper.GetDuration("lint.long_running"),
+	}
+
+	severities, err := cmd.Flags().GetStringArray("severity")
+	if err != nil {
+		return nil, err
+	}
+	for _, severity := range severities {
+		parts := strings.SplitN(severity, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid severity %s. Use rule=severity", severity)
+		}
+		options.Severities[parts[0]] = parts[1]
+	}
+
+	store, err := newHistoryStore()
+	if err != nil {
+		return nil, err
+	}
+	if store != nil {
+		runs, err := store.List(&history.Filter{Workflow: name, Limit: 1})
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) != 0 {
+			options.Durations = make(map[string]time.Duration, len(runs[0].Steps))
+			for _, step := range runs[0].Steps {
+				options.Durations[step.Name] = step.Duration
+			}
+		}
+	}
+
+	return options, nil
+}
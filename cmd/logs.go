@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs <run>",
+	Short: "Show the output of a run of a trackman server",
+	Args:  cobra.ExactArgs(1),
+	Run:   logsExec,
+}
+
+func init() {
+	logsCmd.Flags().String("server", "http://localhost:7070", "URL of the trackman server")
+	logsCmd.Flags().String("token", "", "bearer token of the server. Defaults to server.token in the config file")
+	logsCmd.Flags().BoolP("follow", "f", false, "keep showing the output until the run is finished")
+	logsCmd.Flags().StringSlice("step", nil, "only show the output of this step. Can be used multiple times")
+	logsCmd.Flags().Bool("events", false, "show the events of the run with its output")
+
+	_ = viper.BindPFlag("logs.server", logsCmd.Flags().Lookup("server"))
+
+	rootCmd.AddCommand(logsCmd)
+}
+
+// sseMessage is a message of the stream of a run
+type sseMessage struct {
+	id    string
+	event string
+	data  string
+}
+
+func logsExec(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	follow, _ := cmd.Flags().GetBool("follow")
+	steps, _ := cmd.Flags().GetStringSlice("step")
+	events, _ := cmd.Flags().GetBool("events")
+	token, _ := cmd.Flags().GetString("token")
+	if token == "" {
+		token = viper.GetString("server.token")
+	}
+
+	query := url.Values{}
+	query.Set("follow", fmt.Sprintf("%t", follow))
+	for _, step := range steps {
+		query.Add("step", step)
+	}
+	if !events {
+		query.Set("only", "log")
+	}
+	address := fmt.Sprintf("%s/runs/%s/stream?%s", strings.TrimRight(viper.GetString("logs.server"), "/"), url.PathEscape(args[0]), query.Encode())
+
+	// a dropped stream is picked up where it stopped when following
+	lastID := ""
+	for {
+		status, err := streamLogs(ctx, address, token, lastID, func(message *sseMessage) {
+			lastID = message.id
+			printStreamMessage(message, len(steps) == 1)
+		})
+		switch {
+		case ctx.Err() != nil:
+			// stopped with Ctrl-C
+			return
+		case err == nil:
+			// exit like the run did
+			os.Exit((&utils.WorkflowResult{Outcome: status}).ExitCode())
+		case !follow || lastID == "":
+			utils.PrintError(err.Error())
+			os.Exit(utils.ExitError)
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// streamLogs reads the stream of a run from the server, calling handle with
+// each message, and returns the status of the run once it ends
+func streamLogs(ctx context.Context, address string, token string, lastID string, handle func(message *sseMessage)) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&failure) == nil && failure.Error != "" {
+			return "", fmt.Errorf("%s", failure.Error)
+		}
+
+		return "", fmt.Errorf("the server returned %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	message := &sseMessage{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			field := strings.SplitN(line, ":", 2)
+			if len(field) != 2 {
+				continue
+			}
+			value := strings.TrimPrefix(field[1], " ")
+			switch field[0] {
+			case "id":
+				message.id = value
+			case "event":
+				message.event = value
+			case "data":
+				message.data += value
+			}
+
+			continue
+		}
+
+		// a blank line ends the message
+		if message.event == "end" {
+			var end struct {
+				Status string `json:"status"`
+			}
+			if err = json.Unmarshal([]byte(message.data), &end); err != nil {
+				return "", err
+			}
+
+			return end.Status, nil
+		}
+		if message.event != "" {
+			handle(message)
+		}
+		message = &sseMessage{}
+	}
+	if err = scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("the stream ended before the run")
+}
+
+// printStreamMessage prints a line of output, prefixed with the name of its
+// step unless only one step is shown, or an event
+func printStreamMessage(message *sseMessage, oneStep bool) {
+	switch message.event {
+	case "log":
+		var line struct {
+			Name string `json:"name"`
+			Text string `json:"text"`
+		}
+		if json.Unmarshal([]byte(message.data), &line) != nil {
+			return
+		}
+		if oneStep {
+			fmt.Println(line.Text)
+		} else {
+			fmt.Printf("%s | %s\n", line.Name, line.Text)
+		}
+	case "event":
+		var event struct {
+			Name string `json:"name"`
+			Step *struct {
+				Name string `json:"name"`
+			} `json:"step"`
+		}
+		if json.Unmarshal([]byte(message.data), &event) != nil {
+			return
+		}
+		if event.Step != nil {
+			fmt.Printf("==> %s %s\n", event.Name, event.Step.Name)
+		} else {
+			fmt.Printf("==> %s\n", event.Name)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate a version 1 workflow to version 2 and print the output",
+	Run:   migrateExec,
+}
+
+var (
+	migratingWorkflowFile string
+)
+
+func init() {
+	migrateCmd.Flags().StringVarP(&migratingWorkflowFile, "file", "f", "", "workflow file to migrate")
+
+	rootCmd.AddCommand(migrateCmd)
+}
+
+func migrateExec(cmd *cobra.Command, args []string) {
+	var buff []byte
+	var err error
+	if migratingWorkflowFile == "-" {
+		buff, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		buff, err = ioutil.ReadFile(migratingWorkflowFile)
+	}
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	result, err := utils.MigrateWorkflowBytes(buff)
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Println(string(result))
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/cloud66-oss/trackman/notifiers"
 	"github.com/cloud66-oss/trackman/utils"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v2"
@@ -23,6 +22,8 @@ var (
 
 func init() {
 	parseCmd.Flags().StringVarP(&parsingWorkflowFile, "file", "f", "", "workflow file to parse")
+	parseCmd.Flags().StringArray("set", nil, "set a workflow variable as key=value. Can be used multiple times")
+	addLoadFlags(parseCmd)
 
 	rootCmd.AddCommand(parseCmd)
 }
@@ -31,7 +32,7 @@ func parseExec(cmd *cobra.Command, args []string) {
 	ctx := context.Background()
 
 	options := &utils.WorkflowOptions{
-		Notifier: notifiers.ConsoleNotify,
+		Notifiers: utils.NewNotifierRegistry(),
 	}
 
 	workflow, err := loadWorkflow(ctx, args, options, cmd)
@@ -46,10 +47,12 @@ func parseExec(cmd *cobra.Command, args []string) {
 	// 	os.Exit(1)
 	// }
 
-	for _, step := range workflow.Steps {
-		if err = step.EnrichStep(ctx); err != nil {
-			utils.PrintError(err.Error())
-			os.Exit(1)
+	for _, steps := range [][]*utils.Step{workflow.Steps, workflow.Cleanup} {
+		for _, step := range steps {
+			if err = step.EnrichStep(ctx); err != nil {
+				utils.PrintError(err.Error())
+				os.Exit(1)
+			}
 		}
 	}
 
@@ -15,7 +15,7 @@ import (
 var rootCmd = &cobra.Command{
 	Use:              "trackman",
 	Short:            "Trackman is a tool to run commands in a sequence",
-	PersistentPreRun: checkForUpdates,
+	PersistentPreRun: setup,
 }
 
 var (
@@ -33,12 +33,32 @@ func init() {
 	rootCmd.PersistentFlags().String("log-format", "text", "log format. Valid values are text and json")
 	rootCmd.PersistentFlags().String("log-file", "trackman.log", "file path for logs. Only used when log-type is file")
 	rootCmd.PersistentFlags().Bool("no-update", false, "turn off auto update")
+	rootCmd.PersistentFlags().String("history-dir", "", "directory to keep the history of workflow runs in")
+	rootCmd.PersistentFlags().String("history-db", "", "SQLite database to keep the history of workflow runs in, instead of --history-dir. Needs trackman built with -tags sqlite")
+	rootCmd.PersistentFlags().String("cache-dir", "", "directory to cache the results of the steps with a cache key in (default is trackman in the user cache directory)")
+	rootCmd.PersistentFlags().Bool("no-cache", false, "run all steps, even if their cache key matches a previous run")
+	rootCmd.PersistentFlags().String("secrets-file", "", "file of KEY=VALUE lines to get the secrets used by the workflows from")
+	// patterns can have commas, so they aren't split like a string slice
+	rootCmd.PersistentFlags().StringArray("redact", nil, "regular expression of values to mask in the output, logs and events. Can be used multiple times")
+	rootCmd.PersistentFlags().Bool("require-signature", false, "refuse to run workflows without a valid signature by one of the keys in the config file")
+	rootCmd.PersistentFlags().String("artifacts-dir", "", "directory to keep the artifacts of the steps in (default is trackman/artifacts in the temp directory)")
+	rootCmd.PersistentFlags().String("outbox-dir", "", "directory to keep the slack and webhook notifications in until they are delivered, retrying them across restarts")
+	rootCmd.PersistentFlags().String("outbox-db", "", "SQLite database to keep the slack and webhook notifications in, instead of --outbox-dir. Needs trackman built with -tags sqlite")
 
 	_ = viper.BindPFlag("log-level", rootCmd.PersistentFlags().Lookup("log-level"))
 	_ = viper.BindPFlag("log-file", rootCmd.PersistentFlags().Lookup("log-file"))
 	_ = viper.BindPFlag("log-type", rootCmd.PersistentFlags().Lookup("log-type"))
 	_ = viper.BindPFlag("log-format", rootCmd.PersistentFlags().Lookup("log-format"))
 	_ = viper.BindPFlag("no-update", rootCmd.PersistentFlags().Lookup("no-update"))
+	_ = viper.BindPFlag("history.dir", rootCmd.PersistentFlags().Lookup("history-dir"))
+	_ = viper.BindPFlag("history.db", rootCmd.PersistentFlags().Lookup("history-db"))
+	_ = viper.BindPFlag("cache.dir", rootCmd.PersistentFlags().Lookup("cache-dir"))
+	_ = viper.BindPFlag("cache.disabled", rootCmd.PersistentFlags().Lookup("no-cache"))
+	_ = viper.BindPFlag("artifacts.dir", rootCmd.PersistentFlags().Lookup("artifacts-dir"))
+	_ = viper.BindPFlag("outbox.dir", rootCmd.PersistentFlags().Lookup("outbox-dir"))
+	_ = viper.BindPFlag("outbox.db", rootCmd.PersistentFlags().Lookup("outbox-db"))
+	_ = viper.BindPFlag("secrets.file", rootCmd.PersistentFlags().Lookup("secrets-file"))
+	_ = viper.BindPFlag("signing.required", rootCmd.PersistentFlags().Lookup("require-signature"))
 }
 
 func initConfig() {
@@ -60,10 +80,32 @@ func initConfig() {
 	_ = viper.ReadInConfig()
 }
 
+func setup(cmd *cobra.Command, args []string) {
+	addRedactPatterns(cmd)
+	checkForUpdates(cmd, args)
+}
+
+// addRedactPatterns masks the values matching the patterns in the redact
+// flags and the redact list of the configuration
+func addRedactPatterns(cmd *cobra.Command) {
+	patterns, err := cmd.Flags().GetStringArray("redact")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	for _, pattern := range append(viper.GetStringSlice("redact"), patterns...) {
+		if err = utils.AddRedactPattern(pattern); err != nil {
+			utils.PrintError(err.Error())
+			os.Exit(1)
+		}
+	}
+}
+
 func checkForUpdates(cmd *cobra.Command, args []string) {
 	if utils.Channel != "dev" && cmd.Name() != "update" && cmd.Name() != "version" && !viper.GetBool("no-update") {
+		UpdateDone.Add(1)
 		go func() {
-			UpdateDone.Add(1)
 			defer UpdateDone.Done()
 
 			update(true)
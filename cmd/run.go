@@ -2,14 +2,30 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/cloud66-oss/trackman/locks"
+	"github.com/cloud66-oss/trackman/metrics"
 	"github.com/cloud66-oss/trackman/notifiers"
+	"github.com/cloud66-oss/trackman/outbox"
+	"github.com/cloud66-oss/trackman/secrets"
+	"github.com/cloud66-oss/trackman/signing"
+	"github.com/cloud66-oss/trackman/tracing"
+	"github.com/cloud66-oss/trackman/tui"
 	"github.com/cloud66-oss/trackman/utils"
+	"github.com/mattn/go-isatty"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -25,52 +41,334 @@ var (
 )
 
 func init() {
-	runCmd.Flags().StringVarP(&workflowFile, "file", "f", "", "workflow file to run")
+	runCmd.Flags().StringVarP(&workflowFile, "file", "f", "", "workflow file to run, or a directory to run all of its workflow files")
 	runCmd.Flags().DurationP("timeout", "", 10*time.Second, "global timeout unless overwritten by a step")
-	runCmd.Flags().IntP("concurrency", "", runtime.NumCPU()-1, "maximum number of concurrent steps to run")
+	runCmd.Flags().StringP("concurrency", "", strconv.Itoa(runtime.NumCPU()-1), "maximum number of concurrent steps to run, or auto to change it with the load of the machine")
+	runCmd.Flags().Int("concurrency-min", 1, "lowest concurrency with --concurrency auto")
+	runCmd.Flags().Int("concurrency-max", runtime.NumCPU(), "highest concurrency with --concurrency auto")
+	runCmd.Flags().Duration("grace-period", 10*time.Second, "time given to steps to stop when cancelled or timed out before they are killed")
 	runCmd.Flags().BoolP("yes", "y", false, "Answer Yes to all confirmation questions")
+	runCmd.Flags().Bool("dry-run", false, "show the execution plan without running any steps")
+	runCmd.Flags().StringArray("set", nil, "set a workflow variable as key=value. Can be used multiple times")
+	runCmd.Flags().String("state-file", "", "file to save the state of the steps as the workflow runs")
+	runCmd.Flags().Bool("resume", false, "resume the workflow from the state file, skipping successful steps")
+	runCmd.Flags().Bool("rollback", false, "run the rollback commands of the successful steps if the workflow fails")
+	runCmd.Flags().Bool("critical-path-first", false, "run the steps with the longest chain of steps depending on them first")
+	runCmd.Flags().String("slack-webhook", "", "slack webhook url to send notifications to")
+	runCmd.Flags().String("slack-token", "", "slack api token to send notifications with. Threads messages of each run")
+	runCmd.Flags().String("slack-channel", "", "slack channel to send notifications to when using a token")
+	runCmd.Flags().String("teams-webhook", "", "microsoft teams webhook url to post the summary of the run to")
+	runCmd.Flags().String("discord-webhook", "", "discord webhook url to post the summary of the run to")
+	runCmd.Flags().Bool("notify-desktop", false, "show a desktop notification when the workflow finishes and when a step first fails")
+	runCmd.Flags().String("report", "", "file to write a report of the run to")
+	runCmd.Flags().String("report-format", "", "format of the report. Valid values are json and junit. Defaults to junit for .xml files and json otherwise")
+	runCmd.Flags().Bool("tui", false, "show a live view of the steps instead of their logs. Only the output of failed steps is shown")
+	runCmd.Flags().Bool("stream-output", false, "show the output of the steps as it's produced, prefixed with their names. Otherwise it's logged at debug level")
+	runCmd.Flags().Bool("no-color", false, "don't color the step names before their output")
+	runCmd.Flags().Bool("raw", false, "show the output of the steps as is, without the step names")
+	runCmd.Flags().String("log-dir", "", "directory to write the output of each step to, in a .out and .err file per step")
+	runCmd.Flags().Int64("log-max-size", 0, "size in megabytes the step output files can grow to before they are rotated. 0 never rotates them")
+	runCmd.Flags().Int("log-max-backups", 0, "number of rotated step output files to keep. 0 keeps all of them")
+	runCmd.Flags().Duration("log-max-age", 0, "how long rotated step output files are kept. 0 keeps them forever")
+	runCmd.Flags().String("metrics-addr", "", "address to serve Prometheus metrics on while the workflow runs, like :9090")
+	runCmd.Flags().Bool("debug", false, "ask before running each step, showing its command, environment and variables")
+	runCmd.Flags().StringSlice("only", nil, "run only these steps and the steps they depend on. Can be used multiple times")
+	runCmd.Flags().StringSlice("skip", nil, "don't run these steps. Can be used multiple times")
+	runCmd.Flags().String("from", "", "start from this step, assuming the steps it depends on are done")
+	runCmd.Flags().String("until", "", "don't run the steps depending on this step")
+	runCmd.Flags().Duration("workflow-timeout", 0, "time the whole workflow can run for before its steps are stopped. Overrides the timeout of the workflow")
+	runCmd.Flags().String("agents-addr", "", "address agents connect to for the steps with an agent selector, like :8090")
+	runCmd.Flags().String("otlp-endpoint", "", "OpenTelemetry collector to send traces to over OTLP/HTTP, like http://localhost:4318")
+	runCmd.Flags().StringArray("webhook", nil, "url to post all events to as json. Can be used multiple times")
+	runCmd.Flags().String("webhook-secret", "", "secret used to sign the webhook payloads with HMAC-SHA256")
+	runCmd.Flags().StringArray("webhook-header", nil, "header to add to webhook requests as key=value. Can be used multiple times")
+	runCmd.Flags().Int("event-buffer", 0, "number of events held for each notifier, sent in the background so slow notifiers don't slow the steps. 0 waits for the notifiers")
+	runCmd.Flags().String("event-overflow", utils.OverflowBlock, "what happens to events for a notifier whose buffer is full. Valid values are block and drop_oldest")
+	runCmd.Flags().String("rate-limit", "", "most steps to start in an interval, like 5/s or 20/1m, with an optional burst like 5/s:5. Overrides the rate limit of the workflow")
+	runCmd.Flags().Bool("timestamps", false, "prefix each line of the output of the steps with the time and the time since the step started, even if the workflow doesn't ask for it")
+	runCmd.Flags().Bool("strip-ansi", false, "remove colors and other escape codes from the output of the steps in the logs, step log files and reports. The console keeps them")
+	runCmd.Flags().Duration("stall-after", 0, "warn about steps without a stall_after that print no output and use no CPU for this long. 0 doesn't check")
+	runCmd.Flags().String("profile", "", "profile of the workflow to overlay its values with, like staging")
+	runCmd.Flags().Bool("ignore-requires", false, "run all workflows of a directory at once, without waiting for the workflows they require")
+	addLoadFlags(runCmd)
 
 	_ = viper.BindPFlag("timeout", runCmd.Flags().Lookup("timeout"))
+	_ = viper.BindPFlag("workflow-timeout", runCmd.Flags().Lookup("workflow-timeout"))
 	_ = viper.BindPFlag("concurrency", runCmd.Flags().Lookup("concurrency"))
+	_ = viper.BindPFlag("concurrency-min", runCmd.Flags().Lookup("concurrency-min"))
+	_ = viper.BindPFlag("concurrency-max", runCmd.Flags().Lookup("concurrency-max"))
+	_ = viper.BindPFlag("grace-period", runCmd.Flags().Lookup("grace-period"))
+	_ = viper.BindPFlag("rollback", runCmd.Flags().Lookup("rollback"))
+	_ = viper.BindPFlag("critical-path-first", runCmd.Flags().Lookup("critical-path-first"))
+	_ = viper.BindPFlag("slack.webhook", runCmd.Flags().Lookup("slack-webhook"))
+	_ = viper.BindPFlag("slack.token", runCmd.Flags().Lookup("slack-token"))
+	_ = viper.BindPFlag("slack.channel", runCmd.Flags().Lookup("slack-channel"))
+	_ = viper.BindPFlag("teams.webhook", runCmd.Flags().Lookup("teams-webhook"))
+	_ = viper.BindPFlag("discord.webhook", runCmd.Flags().Lookup("discord-webhook"))
+	_ = viper.BindPFlag("notify-desktop", runCmd.Flags().Lookup("notify-desktop"))
+	_ = viper.BindPFlag("report", runCmd.Flags().Lookup("report"))
+	_ = viper.BindPFlag("report-format", runCmd.Flags().Lookup("report-format"))
+	_ = viper.BindPFlag("tui", runCmd.Flags().Lookup("tui"))
+	_ = viper.BindPFlag("stream-output", runCmd.Flags().Lookup("stream-output"))
+	_ = viper.BindPFlag("no-color", runCmd.Flags().Lookup("no-color"))
+	_ = viper.BindPFlag("raw", runCmd.Flags().Lookup("raw"))
+	_ = viper.BindPFlag("log-dir", runCmd.Flags().Lookup("log-dir"))
+	_ = viper.BindPFlag("log-max-size", runCmd.Flags().Lookup("log-max-size"))
+	_ = viper.BindPFlag("log-max-backups", runCmd.Flags().Lookup("log-max-backups"))
+	_ = viper.BindPFlag("log-max-age", runCmd.Flags().Lookup("log-max-age"))
+	_ = viper.BindPFlag("metrics-addr", runCmd.Flags().Lookup("metrics-addr"))
+	_ = viper.BindPFlag("agents.addr", runCmd.Flags().Lookup("agents-addr"))
+	_ = viper.BindPFlag("tracing.endpoint", runCmd.Flags().Lookup("otlp-endpoint"))
+	_ = viper.BindPFlag("webhook.secret", runCmd.Flags().Lookup("webhook-secret"))
 	_ = viper.BindPFlag("confirm.yes", runCmd.Flags().Lookup("yes"))
+	_ = viper.BindPFlag("events.buffer", runCmd.Flags().Lookup("event-buffer"))
+	_ = viper.BindPFlag("events.overflow", runCmd.Flags().Lookup("event-overflow"))
+	_ = viper.BindPFlag("profile", runCmd.Flags().Lookup("profile"))
+	_ = viper.BindPFlag("rate-limit", runCmd.Flags().Lookup("rate-limit"))
+	_ = viper.BindPFlag("stall-after", runCmd.Flags().Lookup("stall-after"))
+	_ = viper.BindPFlag("strip-ansi", runCmd.Flags().Lookup("strip-ansi"))
+	_ = viper.BindPFlag("timestamps", runCmd.Flags().Lookup("timestamps"))
 
 	rootCmd.AddCommand(runCmd)
 }
 
 func runExec(cmd *cobra.Command, args []string) {
-	ctx := context.Background()
+	if isWorkflowDir(workflowFile) {
+		runDirExec(cmd, workflowFile)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stateFile, err := cmd.Flags().GetString("state-file")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(utils.ExitInvalid)
+	}
+
+	registry, flushNotifiers, err := newNotifiers(cmd)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(utils.ExitInvalid)
+	}
 
 	options := &utils.WorkflowOptions{
-		Notifier:    notifiers.ConsoleNotify,
-		Concurrency: viper.GetInt("concurrency"),
-		Timeout:     viper.GetDuration("timeout"),
+		Notifiers:           registry,
+		Concurrency:         concurrency(),
+		AdaptiveConcurrency: adaptiveConcurrency(),
+		Timeout:             viper.GetDuration("timeout"),
+		WorkflowTimeout:     viper.GetDuration("workflow-timeout"),
+		GracePeriod:         viper.GetDuration("grace-period"),
+		Rollback:            viper.GetBool("rollback"),
+		CriticalPathFirst:   viper.GetBool("critical-path-first"),
+		StateFile:           stateFile,
+		Cache:               stepCache(),
+		Artifacts:           artifactStore(),
+		Secrets:             secretProvider(),
+		Verifier:            signatureVerifier(),
+		Agents:              agentController(viper.GetString("agents.addr")),
+		Locks:               lockProvider(),
+		OutputTail:          outputTail(),
+		Events:              eventBus(),
+		Profile:             viper.GetString("profile"),
+		RateLimit:           rateLimit(),
+		StallAfter:          viper.GetDuration("stall-after"),
+		StripANSI:           viper.GetBool("strip-ansi"),
+		Timestamps:          viper.GetBool("timestamps"),
+	}
+
+	debug, _ := cmd.Flags().GetBool("debug")
+	if debug {
+		// steps are asked about one after the other
+		options.Debug = true
+		options.Concurrency = 1
+		options.AdaptiveConcurrency = nil
+	}
+
+	var progress *tui.ProgressView
+	if viper.GetBool("tui") && !debug {
+		if progress, err = newProgressView(registry); err != nil {
+			fmt.Println(err)
+			os.Exit(utils.ExitInvalid)
+		}
+
+		options.Output = progress
+	} else if viper.GetBool("stream-output") && viper.GetString("log-format") != "json" {
+		// json logs keep the output of the steps in the logs so they can be parsed
+		options.Output = utils.NewOutputMultiplexer(os.Stdout, &utils.MultiplexerOptions{
+			NoColor: viper.GetBool("no-color"),
+			Raw:     viper.GetBool("raw"),
+		})
+	} else {
+		options.LogOutput = true
+	}
+
+	if dir := viper.GetString("log-dir"); dir != "" {
+		options.StepLogs = &utils.StepLogOptions{
+			Dir:        dir,
+			Workflow:   workflowName(workflowFile),
+			MaxSize:    viper.GetInt64("log-max-size") * 1024 * 1024,
+			MaxBackups: viper.GetInt("log-max-backups"),
+			MaxAge:     viper.GetDuration("log-max-age"),
+		}
+	}
+
+	if addr := viper.GetString("metrics-addr"); addr != "" {
+		options.Metrics = serveMetrics(addr)
+	}
+
+	closeTracer := func() error { return nil }
+	if endpoint := tracingEndpoint(); endpoint != "" {
+		tracer, err := tracing.NewOTLPTracer(&tracing.OTLPOptions{
+			Endpoint:    endpoint,
+			ServiceName: viper.GetString("tracing.service"),
+			TraceParent: os.Getenv("TRACEPARENT"),
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(utils.ExitInvalid)
+		}
+
+		options.Tracer = tracer
+		closeTracer = tracer.Close
 	}
 
 	workflow, err := loadWorkflow(ctx, args, options, cmd)
 	if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(utils.ExitInvalid)
 	}
 
-	logger, err := utils.NewLogger(workflow.Logger, utils.NewLoggingContext(workflow, nil))
+	logDefinition := workflow.Logger
+	if progress != nil {
+		// the logs of the workflow are not shown with the progress view,
+		// but the outcome should be
+		logDefinition = &utils.LogDefinition{Type: "stdout"}
+	}
+
+	logger, err := utils.NewLogger(logDefinition, utils.NewLoggingContext(workflow, nil))
 	if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(utils.ExitInvalid)
 	}
 
-	err, stepErrors := workflow.Run(ctx)
+	dryRun, err := cmd.Flags().GetBool("dry-run")
 	if err != nil {
+		fmt.Println(err)
+		os.Exit(utils.ExitInvalid)
+	}
+
+	if dryRun {
+		if err = workflow.DryRun(ctx); err != nil {
+			logger.Error(err)
+			os.Exit(utils.ExitError)
+		}
+
+		return
+	}
+
+	// stop the workflow gracefully on Ctrl-C or when asked to terminate
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			logger.Warnf("Received %s. Stopping the workflow", sig)
+			if _, err := workflow.Cancel(context.Background(), fmt.Sprintf("received %s", sig)); err != nil {
+				// not running yet
+				cancel()
+			}
+		case <-ctx.Done():
+		}
+	}()
+
+	only, _ := cmd.Flags().GetStringSlice("only")
+	skip, _ := cmd.Flags().GetStringSlice("skip")
+	if err = workflow.Select(only, skip); err != nil {
+		logger.Error(err)
+		os.Exit(utils.ExitInvalid)
+	}
+	from, _ := cmd.Flags().GetString("from")
+	until, _ := cmd.Flags().GetString("until")
+	if err = workflow.SelectRange(from, until); err != nil {
 		logger.Error(err)
-		os.Exit(1)
+		os.Exit(utils.ExitInvalid)
 	}
 
-	if stepErrors != nil {
+	resume, err := cmd.Flags().GetBool("resume")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(utils.ExitInvalid)
+	}
+
+	if progress != nil {
+		progress.Start(workflow)
+	}
+
+	var result *utils.WorkflowResult
+	if resume {
+		if stateFile == "" {
+			logger.Error("resume needs a state file")
+			os.Exit(utils.ExitInvalid)
+		}
+
+		result, err = workflow.Resume(ctx, stateFile)
+	} else {
+		result, err = workflow.Run(ctx)
+	}
+	if progress != nil {
+		progress.Stop()
+	}
+	flushNotifiers()
+	if err := closeTracer(); err != nil {
+		logger.Errorf("Failed to send traces: %s", err)
+	}
+	// the run has a result even if an error like a failed preflight check
+	// stopped it
+	if report := viper.GetString("report"); report != "" && result != nil {
+		if err := writeReport(result, report, viper.GetString("report-format")); err != nil {
+			logger.Errorf("Failed to write the report: %s", err)
+		}
+	}
+	if err != nil {
+		logger.Error(err)
+		os.Exit(utils.ExitError)
+	}
+
+	logTimings(logger, result)
+
+	switch result.Outcome {
+	case utils.OutcomeFailed:
 		// this is already logged, just get out
 		logger.Error("Done with errors")
-		os.Exit(1)
-	} else {
+	case utils.OutcomePartial, utils.OutcomeDegraded:
+		var names []string
+		for _, step := range result.Failed() {
+			names = append(names, step.Name)
+		}
+
+		if result.Outcome == utils.OutcomeDegraded {
+			logger.Warnf("Done degraded with failed steps: %s", strings.Join(names, ", "))
+		} else {
+			logger.Warnf("Done with failed steps: %s", strings.Join(names, ", "))
+		}
+	case utils.OutcomeStopped:
+		logger.Info("Stopped")
+	case utils.OutcomeCancelled:
+		if result.CancelReason != "" {
+			logger.Warnf("Cancelled: %s", result.CancelReason)
+		} else {
+			logger.Warn("Cancelled")
+		}
+	default:
 		logger.Info("Done")
 	}
+
+	if code := result.ExitCode(); code != utils.ExitSuccess {
+		os.Exit(code)
+	}
 }
 
 func loadWorkflow(ctx context.Context, args []string, options *utils.WorkflowOptions, cmd *cobra.Command) (*utils.Workflow, error) {
@@ -80,15 +378,644 @@ func loadWorkflow(ctx context.Context, args []string, options *utils.WorkflowOpt
 		return nil, err
 	}
 
-	var reader io.Reader
-	if file == "-" {
-		reader = os.Stdin
-	} else {
-		reader, err = os.Open(file)
+	variables, err := cmd.Flags().GetStringArray("set")
+	if err != nil {
+		return nil, err
+	}
+	if options.Variables, err = parseVariables(variables); err != nil {
+		return nil, err
+	}
+
+	if signature, _ := cmd.Flags().GetString("signature"); signature != "" {
+		if options.Signature, err = ioutil.ReadFile(signature); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.Name == "" {
+		options.Name = workflowName(file)
+	}
+
+	if remoteWorkflow(file) {
+		remote, err := remoteOptions(cmd)
 		if err != nil {
 			return nil, err
 		}
+
+		return utils.LoadWorkflowFromURL(ctx, options, file, remote)
+	}
+
+	if file == "-" {
+		return utils.LoadWorkflowFromReader(ctx, options, os.Stdin)
+	}
+
+	return utils.LoadWorkflowFromFile(ctx, options, file)
+}
+
+// logTimings logs the critical path of the workflow and how long each step
+// took with its slack
+func logTimings(logger *logrus.Logger, result *utils.WorkflowResult) {
+	for _, step := range result.Steps {
+		if step.Duration == 0 {
+			continue
+		}
+
+		logger.WithField(utils.FldStep, step.Name).Debugf("Took %s with %s of slack", step.Duration.Round(time.Millisecond), step.Slack.Round(time.Millisecond))
+	}
+
+	if len(result.CriticalPath) != 0 {
+		logger.Infof("Critical path: %s (%s)", strings.Join(result.CriticalPath, " -> "), result.CriticalPathDuration.Round(time.Millisecond))
+	}
+}
+
+// serveMetrics serves the metrics of the workflow on /metrics of the given address
+func serveMetrics(addr string) *metrics.PrometheusCollector {
+	collector := metrics.NewPrometheusCollector()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", collector)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Printf("Failed to serve metrics: %s\n", err)
+		}
+	}()
+
+	return collector
+}
+
+// newProgressView creates the progress view and stops the console logs from
+// drawing over it
+func newProgressView(registry *utils.NotifierRegistry) (*tui.ProgressView, error) {
+	if !isatty.IsTerminal(os.Stdout.Fd()) {
+		return nil, errors.New("tui needs a terminal")
+	}
+
+	registry.Unregister("console")
+	if viper.GetString("log-type") != "file" {
+		viper.Set("log-type", "discard")
+	}
+
+	return tui.NewProgressView(os.Stdout), nil
+}
+
+// writeReport writes the report of the run to the file. The format is based
+// on the file extension if not given
+func writeReport(result *utils.WorkflowResult, filename string, format string) error {
+	if format == "" {
+		format = utils.ReportJSON
+		if strings.EqualFold(filepath.Ext(filename), ".xml") {
+			format = utils.ReportJUnit
+		}
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return result.WriteReport(file, format)
+}
+
+// workflowName returns a name for the workflow based on its file
+func workflowName(file string) string {
+	if file == "" || file == "-" {
+		return "workflow"
+	}
+
+	// remote workflows can have a query, like the ref of a git source
+	file = strings.SplitN(file, "?", 2)[0]
+
+	return strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+}
+
+// addLoadFlags adds the flags used to load workflows from URLs and git
+// repositories and to verify them
+func addLoadFlags(cmd *cobra.Command) {
+	cmd.Flags().String("checksum", "", "SHA-256 the workflow file fetched from a URL or git repository must have")
+	cmd.Flags().String("signature", "", "file with the signature of the workflow when it has to be signed. Defaults to the signature next to the workflow file")
+	cmd.Flags().StringArray("remote-header", nil, "header to add to the requests fetching the workflow as key=value. Can be used multiple times")
+}
+
+// remoteWorkflow returns true if the workflow file is a URL or a git source
+func remoteWorkflow(file string) bool {
+	if strings.HasPrefix(file, "git::") {
+		return true
+	}
+
+	for _, scheme := range []string{"https://", "http://", "s3://"} {
+		if strings.HasPrefix(file, scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remoteOptions returns how to fetch remote workflows from the flags added by
+// addLoadFlags and the remote options of the configuration
+func remoteOptions(cmd *cobra.Command) (*utils.RemoteOptions, error) {
+	checksum, err := cmd.Flags().GetString("checksum")
+	if err != nil {
+		return nil, err
+	}
+	headers, err := cmd.Flags().GetStringArray("remote-header")
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := parseVariables(append(viper.GetStringSlice("remote.headers"), headers...))
+	if err != nil {
+		return nil, err
+	}
+
+	return &utils.RemoteOptions{
+		Headers:  parsed,
+		Checksum: checksum,
+		S3: &utils.S3Options{
+			Region:   viper.GetString("remote.s3.region"),
+			Endpoint: viper.GetString("remote.s3.endpoint"),
+			Credentials: utils.AWSCredentials{
+				AccessKeyID:     viper.GetString("remote.s3.access_key_id"),
+				SecretAccessKey: viper.GetString("remote.s3.secret_access_key"),
+				SessionToken:    viper.GetString("remote.s3.session_token"),
+			},
+		},
+	}, nil
+}
+
+// stepCache returns the cache of the steps with a cache key, or nil if
+// caching is turned off
+func stepCache() utils.StepCache {
+	if viper.GetBool("cache.disabled") {
+		return nil
+	}
+
+	dir := viper.GetString("cache.dir")
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil
+		}
+
+		dir = filepath.Join(userDir, "trackman")
+	}
+
+	return utils.NewFileCache(dir)
+}
+
+// artifactStore returns the store to keep the artifacts of the steps in
+func artifactStore() utils.ArtifactStore {
+	dir := viper.GetString("artifacts.dir")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "trackman", "artifacts")
+	}
+
+	return utils.NewLocalArtifactStore(dir)
+}
+
+// secretProvider returns the providers of the secrets set in the
+// configuration, tried in the order of the env file, Vault and AWS Secrets
+// Manager, or nil if there are none
+func secretProvider() utils.SecretProvider {
+	var chain secrets.Chain
+
+	if file := viper.GetString("secrets.file"); file != "" {
+		provider, err := secrets.NewEnvFile(file)
+		if err != nil {
+			utils.PrintError(err.Error())
+			os.Exit(utils.ExitInvalid)
+		}
+
+		chain = append(chain, provider)
+	}
+
+	if address := configOrEnv("secrets.vault.address", "VAULT_ADDR"); address != "" {
+		provider, err := secrets.NewVault(&secrets.VaultOptions{
+			Address:   address,
+			Token:     configOrEnv("secrets.vault.token", "VAULT_TOKEN"),
+			Namespace: configOrEnv("secrets.vault.namespace", "VAULT_NAMESPACE"),
+			Mount:     viper.GetString("secrets.vault.mount"),
+		})
+		if err != nil {
+			utils.PrintError(err.Error())
+			os.Exit(utils.ExitInvalid)
+		}
+
+		chain = append(chain, provider)
+	}
+
+	// AWS credentials are often in the environment for other reasons, so it's
+	// only used when configured
+	if region := viper.GetString("secrets.aws.region"); region != "" {
+		provider, err := secrets.NewAWSSecretsManager(&secrets.AWSOptions{
+			Region:          region,
+			AccessKeyID:     configOrEnv("secrets.aws.access_key_id", "AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: configOrEnv("secrets.aws.secret_access_key", "AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    configOrEnv("secrets.aws.session_token", "AWS_SESSION_TOKEN"),
+			Endpoint:        viper.GetString("secrets.aws.endpoint"),
+		})
+		if err != nil {
+			utils.PrintError(err.Error())
+			os.Exit(utils.ExitInvalid)
+		}
+
+		chain = append(chain, provider)
+	}
+
+	if len(chain) == 0 {
+		return nil
+	}
+
+	return chain
+}
+
+// lockProvider returns the provider of the global locks of the steps: Consul
+// if it's set in the configuration, or lock files shared by the processes on
+// this machine
+func lockProvider() utils.LockProvider {
+	if address := viper.GetString("locks.consul.address"); address != "" {
+		provider, err := locks.NewConsulLocks(&locks.ConsulOptions{
+			Address: address,
+			Token:   configOrEnv("locks.consul.token", "CONSUL_HTTP_TOKEN"),
+			Prefix:  viper.GetString("locks.consul.prefix"),
+			TTL:     viper.GetDuration("locks.consul.ttl"),
+		})
+		if err != nil {
+			utils.PrintError(err.Error())
+			os.Exit(utils.ExitInvalid)
+		}
+
+		return provider
+	}
+
+	dir := viper.GetString("locks.dir")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "trackman", "locks")
+	}
+
+	provider, err := locks.NewFileLocks(dir)
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(utils.ExitInvalid)
+	}
+
+	return provider
+}
+
+// signatureVerifier returns the verifier of the signatures of the workflows
+// with the keys in the configuration, or nil if they don't have to be signed
+func signatureVerifier() utils.SignatureVerifier {
+	if !viper.GetBool("signing.required") {
+		return nil
+	}
+
+	minisignKeys := viper.GetStringSlice("signing.minisign_keys")
+	gpgKeys := viper.GetStringSlice("signing.gpg_keys")
+
+	var verifier utils.SignatureVerifier
+	var err error
+	switch {
+	case len(minisignKeys) != 0 && len(gpgKeys) != 0:
+		err = fmt.Errorf("use either minisign or gpg keys to verify the workflows")
+	case len(minisignKeys) != 0:
+		verifier, err = signing.NewMinisign(minisignKeys)
+	case len(gpgKeys) != 0:
+		verifier, err = signing.NewGPG(gpgKeys)
+	default:
+		err = fmt.Errorf("no keys to verify the signatures of the workflows with. Set signing.minisign_keys or signing.gpg_keys in the config file")
+	}
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(utils.ExitInvalid)
+	}
+
+	return verifier
+}
+
+// flagsOrConfig returns the values of a flag of the command that can be used
+// multiple times, or the list at key in the configuration if it isn't used.
+// Unlike viper, it doesn't split the values at commas
+func flagsOrConfig(cmd *cobra.Command, flag string, key string) []string {
+	if values, _ := cmd.Flags().GetStringArray(flag); len(values) != 0 {
+		return values
+	}
+
+	return viper.GetStringSlice(key)
+}
+
+// configOrEnv returns the value of the key in the configuration, falling
+// back to the environment variable
+func configOrEnv(key string, env string) string {
+	if value := viper.GetString(key); value != "" {
+		return value
+	}
+
+	return os.Getenv(env)
+}
+
+// tracingEndpoint returns the OTLP endpoint to send traces to, falling back
+// to the standard OpenTelemetry environment variable
+func tracingEndpoint() string {
+	if endpoint := viper.GetString("tracing.endpoint"); endpoint != "" {
+		return endpoint
+	}
+
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+}
+
+// parseVariables converts a list of key=value pairs into a map
+func parseVariables(pairs []string) (map[string]string, error) {
+	variables := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid variable %s. use key=value", pair)
+		}
+
+		variables[parts[0]] = parts[1]
+	}
+
+	return variables, nil
+}
+
+// notifierFilter returns the filter of the notifier from the events, steps
+// and severity in its section of the configuration
+func notifierFilter(key string) *utils.NotificationFilter {
+	return &utils.NotificationFilter{
+		Events:   viper.GetStringSlice(key + ".events"),
+		Steps:    viper.GetStringSlice(key + ".steps"),
+		Severity: viper.GetString(key + ".severity"),
+	}
+}
+
+// eventBus returns the options to send events in the background, or nil to
+// wait for the notifiers. The console logs stay in order with the output
+func eventBus() *utils.EventBusOptions {
+	size := viper.GetInt("events.buffer")
+	if size == 0 {
+		return nil
+	}
+
+	return &utils.EventBusOptions{
+		BufferSize:  size,
+		Overflow:    viper.GetString("events.overflow"),
+		Synchronous: []string{"console"},
+	}
+}
+
+// concurrency returns the concurrency of the workflow, or 0 if it changes
+// with the load of the machine
+func concurrency() int {
+	value := viper.GetString("concurrency")
+	if value == "auto" {
+		return 0
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		utils.PrintError(fmt.Sprintf("invalid concurrency %s. use a number or auto", value))
+		os.Exit(utils.ExitInvalid)
+	}
+
+	return number
+}
+
+// adaptiveConcurrency returns the bounds of the concurrency if it changes
+// with the load of the machine, or nil
+func adaptiveConcurrency() *utils.AdaptiveConcurrency {
+	if viper.GetString("concurrency") != "auto" {
+		return nil
+	}
+
+	return &utils.AdaptiveConcurrency{
+		Min: viper.GetInt("concurrency-min"),
+		Max: viper.GetInt("concurrency-max"),
+	}
+}
+
+// rateLimit returns the rate limit of the steps set with --rate-limit, or nil
+// to use the one of the workflow
+func rateLimit() *utils.RateLimit {
+	value := viper.GetString("rate-limit")
+	if value == "" {
+		return nil
+	}
+
+	limit, err := utils.ParseRateLimit(value)
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(utils.ExitInvalid)
+	}
+
+	return limit
+}
+
+// outputTail returns the number of lines of output kept for the failed
+// steps, the most any of the configured notifiers sends
+func outputTail() int {
+	lines := 0
+	for _, notifier := range []struct {
+		key      string
+		required string
+		lines    int
+	}{
+		{"email", "host", 20},
+		{"teams", "webhook", 10},
+		{"discord", "webhook", 10},
+	} {
+		if viper.GetString(notifier.key+"."+notifier.required) == "" {
+			continue
+		}
+		if configured := viper.GetInt(notifier.key + ".output_lines"); configured != 0 {
+			notifier.lines = configured
+		}
+		if notifier.lines > lines {
+			lines = notifier.lines
+		}
+	}
+
+	return lines
+}
+
+// newNotifiers returns the notifiers used by the CLI and a function to call
+// once the workflow is done so queued notifications are sent
+func newNotifiers(cmd *cobra.Command) (*utils.NotifierRegistry, func(), error) {
+	var flushes []func()
+	flush := func() {
+		for _, flush := range flushes {
+			flush()
+		}
+	}
+	registry := utils.NewNotifierRegistry()
+	if err := registry.Register("console", notifiers.ConsoleNotify); err != nil {
+		return nil, nil, err
+	}
+
+	// slack and webhook notifications are kept in the outbox until they are
+	// delivered if there is one
+	var box *outbox.Outbox
+	if path := viper.GetString("outbox.db"); path != "" {
+		store, err := outbox.NewSQLiteStore(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		box = outbox.NewOutbox(store, nil)
+	} else if dir := viper.GetString("outbox.dir"); dir != "" {
+		store, err := outbox.NewFileStore(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		box = outbox.NewOutbox(store, nil)
+	}
+
+	if viper.GetString("slack.webhook") != "" || viper.GetString("slack.token") != "" {
+		slack, err := notifiers.NewSlackNotifier(&notifiers.SlackOptions{
+			WebhookURL: viper.GetString("slack.webhook"),
+			Token:      viper.GetString("slack.token"),
+			Channel:    viper.GetString("slack.channel"),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		notify := slack.Notify
+		if box != nil {
+			box.Register("slack", slack)
+			notify = box.Notifier("slack")
+		}
+		if err = registry.RegisterWithFilter("slack", notify, notifierFilter("slack")); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if viper.GetString("email.host") != "" {
+		email, err := notifiers.NewEmailNotifier(&notifiers.EmailOptions{
+			Host:     viper.GetString("email.host"),
+			Port:     viper.GetInt("email.port"),
+			Username: viper.GetString("email.username"),
+			Password: viper.GetString("email.password"),
+			From:     viper.GetString("email.from"),
+			To:       viper.GetStringSlice("email.to"),
+			TLS:      viper.GetString("email.tls"),
+			Subject:  viper.GetString("email.subject"),
+			Body:     viper.GetString("email.body"),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		filter := notifierFilter("email")
+		if len(filter.Events) == 0 {
+			filter.Events = notifiers.EmailEvents
+		}
+		if err = registry.RegisterWithFilter("email", email.Notify, filter); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if viper.GetString("teams.webhook") != "" {
+		teams, err := notifiers.NewTeamsNotifier(&notifiers.TeamsOptions{
+			WebhookURL:  viper.GetString("teams.webhook"),
+			OutputLines: viper.GetInt("teams.output_lines"),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		filter := notifierFilter("teams")
+		if len(filter.Events) == 0 {
+			filter.Events = notifiers.ChatEvents
+		}
+		if err = registry.RegisterWithFilter("teams", teams.Notify, filter); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if viper.GetString("discord.webhook") != "" {
+		discord, err := notifiers.NewDiscordNotifier(&notifiers.DiscordOptions{
+			WebhookURL:  viper.GetString("discord.webhook"),
+			Username:    viper.GetString("discord.username"),
+			OutputLines: viper.GetInt("discord.output_lines"),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		filter := notifierFilter("discord")
+		if len(filter.Events) == 0 {
+			filter.Events = notifiers.ChatEvents
+		}
+		if err = registry.RegisterWithFilter("discord", discord.Notify, filter); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if viper.GetBool("notify-desktop") {
+		desktop, err := notifiers.NewDesktopNotifier()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err = registry.Register("desktop", desktop.Notify, notifiers.DesktopEvents...); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if viper.GetString("alert.provider") != "" {
+		alert, err := alertNotifier()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err = registry.Register("alert", alert.Notify, notifiers.AlertEvents...); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	store, err := newHistoryStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	if store != nil {
+		if err = registry.Register("history", notifiers.NewHistoryNotifier(store), notifiers.HistoryEvents...); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if urls := flagsOrConfig(cmd, "webhook", "webhook.urls"); len(urls) != 0 {
+		headers, err := parseVariables(flagsOrConfig(cmd, "webhook-header", "webhook.headers"))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		webhook, err := notifiers.NewWebhookNotifier(&notifiers.WebhookOptions{
+			URLs:    urls,
+			Headers: headers,
+			Secret:  viper.GetString("webhook.secret"),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		notify := webhook.Notify
+		if box != nil {
+			box.Register("webhook", webhook)
+			notify = box.Notifier("webhook")
+		}
+		if err = registry.RegisterWithFilter("webhook", notify, notifierFilter("webhook")); err != nil {
+			return nil, nil, err
+		}
+
+		flushes = append(flushes, func() { webhook.Close(30 * time.Second) })
+	}
+
+	if box != nil {
+		// the notifications not delivered by then are sent by the next process
+		flushes = append(flushes, func() { box.Close(30 * time.Second) })
 	}
 
-	return utils.LoadWorkflowFromReader(ctx, options, reader)
+	return registry, flush, nil
 }
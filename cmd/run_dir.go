@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cloud66-oss/trackman/tracing"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// dirUnsupportedFlags are the flags of a single workflow run that can't be
+// used when running a directory of workflows
+var dirUnsupportedFlags = []string{"state-file", "resume", "debug", "tui", "only", "skip", "from", "until", "checksum", "signature"}
+
+// isWorkflowDir returns true if the workflow file is a directory of workflows
+func isWorkflowDir(file string) bool {
+	if file == "" || file == "-" || remoteWorkflow(file) {
+		return false
+	}
+
+	info, err := os.Stat(file)
+	return err == nil && info.IsDir()
+}
+
+// runDirExec runs all workflows of a directory, each once the workflows it
+// requires succeeded, with the concurrency shared between them
+func runDirExec(cmd *cobra.Command, dir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, name := range dirUnsupportedFlags {
+		if cmd.Flags().Changed(name) {
+			utils.PrintError(fmt.Sprintf("--%s can't be used with a directory of workflows", name))
+			os.Exit(utils.ExitInvalid)
+		}
+	}
+	if viper.GetBool("tui") {
+		utils.PrintError("--tui can't be used with a directory of workflows")
+		os.Exit(utils.ExitInvalid)
+	}
+
+	variables, _ := cmd.Flags().GetStringArray("set")
+	parsed, err := parseVariables(variables)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(utils.ExitInvalid)
+	}
+
+	registry, flushNotifiers, err := newNotifiers(cmd)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(utils.ExitInvalid)
+	}
+
+	var output utils.OutputSink
+	if viper.GetBool("stream-output") && viper.GetString("log-format") != "json" {
+		output = utils.NewOutputMultiplexer(os.Stdout, &utils.MultiplexerOptions{
+			NoColor: viper.GetBool("no-color"),
+			Raw:     viper.GetBool("raw"),
+		})
+	}
+
+	options := &utils.WorkflowOptions{
+		Notifiers:         registry,
+		Concurrency:       concurrency(),
+		Timeout:           viper.GetDuration("timeout"),
+		WorkflowTimeout:   viper.GetDuration("workflow-timeout"),
+		GracePeriod:       viper.GetDuration("grace-period"),
+		Rollback:          viper.GetBool("rollback"),
+		CriticalPathFirst: viper.GetBool("critical-path-first"),
+		Variables:         parsed,
+		Cache:             stepCache(),
+		Artifacts:         artifactStore(),
+		Secrets:           secretProvider(),
+		Verifier:          signatureVerifier(),
+		Agents:            agentController(viper.GetString("agents.addr")),
+		Locks:             lockProvider(),
+		OutputTail:        outputTail(),
+		Events:            eventBus(),
+		Profile:           viper.GetString("profile"),
+		RateLimit:         rateLimit(),
+		StallAfter:        viper.GetDuration("stall-after"),
+		StripANSI:         viper.GetBool("strip-ansi"),
+		Timestamps:        viper.GetBool("timestamps"),
+		LogOutput:         output == nil,
+	}
+
+	if addr := viper.GetString("metrics-addr"); addr != "" {
+		options.Metrics = serveMetrics(addr)
+	}
+
+	closeTracer := func() error { return nil }
+	if endpoint := tracingEndpoint(); endpoint != "" {
+		tracer, err := tracing.NewOTLPTracer(&tracing.OTLPOptions{
+			Endpoint:    endpoint,
+			ServiceName: viper.GetString("tracing.service"),
+			TraceParent: os.Getenv("TRACEPARENT"),
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(utils.ExitInvalid)
+		}
+
+		options.Tracer = tracer
+		closeTracer = tracer.Close
+	}
+
+	ignoreRequires, _ := cmd.Flags().GetBool("ignore-requires")
+	set, err := utils.LoadWorkflowSetFromDir(ctx, dir, func(name string) *utils.WorkflowOptions {
+		workflowOptions := *options
+		if output != nil {
+			workflowOptions.Output = &prefixedSink{sink: output, prefix: name + "/"}
+		}
+		if logDir := viper.GetString("log-dir"); logDir != "" {
+			workflowOptions.StepLogs = &utils.StepLogOptions{
+				Dir:        logDir,
+				Workflow:   name,
+				MaxSize:    viper.GetInt64("log-max-size") * 1024 * 1024,
+				MaxBackups: viper.GetInt("log-max-backups"),
+				MaxAge:     viper.GetDuration("log-max-age"),
+			}
+		}
+
+		return &workflowOptions
+	}, &utils.WorkflowSetOptions{
+		Concurrency:         concurrency(),
+		AdaptiveConcurrency: adaptiveConcurrency(),
+		IgnoreRequires:      ignoreRequires,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(utils.ExitInvalid)
+	}
+
+	logger, err := utils.NewLogger(nil, utils.NewLoggingContext(nil, nil))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(utils.ExitInvalid)
+	}
+
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		for _, workflow := range set.Workflows {
+			if len(workflow.Requires) != 0 && !ignoreRequires {
+				logger.Infof("Workflow %s, after %s", workflow.Name(), strings.Join(workflow.Requires, ", "))
+			} else {
+				logger.Infof("Workflow %s", workflow.Name())
+			}
+
+			if err = workflow.DryRun(ctx); err != nil {
+				logger.Error(err)
+				os.Exit(utils.ExitError)
+			}
+		}
+
+		return
+	}
+
+	// stop the workflows gracefully on Ctrl-C or when asked to terminate
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			logger.Warnf("Received %s. Stopping the workflows", sig)
+			set.Cancel(fmt.Sprintf("received %s", sig))
+		case <-ctx.Done():
+		}
+	}()
+
+	result := set.Run(ctx)
+	flushNotifiers()
+	if err := closeTracer(); err != nil {
+		logger.Errorf("Failed to send traces: %s", err)
+	}
+
+	if report := viper.GetString("report"); report != "" {
+		if err = writeSetReport(result, report, viper.GetString("report-format")); err != nil {
+			logger.Errorf("Failed to write the report: %s", err)
+		}
+	}
+
+	logSetSummary(logger, result)
+
+	if code := result.ExitCode(); code != utils.ExitSuccess {
+		os.Exit(code)
+	}
+}
+
+// logSetSummary logs the outcome of each workflow of the directory
+func logSetSummary(logger *logrus.Logger, result *utils.WorkflowSetResult) {
+	for _, item := range result.Workflows {
+		switch {
+		case item.Error != nil:
+			logger.Errorf("Workflow %s failed: %s", item.Name, item.Error)
+		case item.Outcome == utils.ResultNotRun:
+			logger.Warnf("Workflow %s didn't run", item.Name)
+		case item.Outcome == utils.OutcomeSuccess:
+			logger.Infof("Workflow %s done in %s", item.Name, item.Result.Duration.Round(time.Millisecond))
+		default:
+			logger.Warnf("Workflow %s %s in %s", item.Name, item.Outcome, item.Result.Duration.Round(time.Millisecond))
+		}
+	}
+
+	switch result.Outcome {
+	case utils.OutcomeSuccess:
+		logger.Info("Done")
+	case utils.OutcomeFailed:
+		logger.Error("Done with errors")
+	default:
+		logger.Warnf("Done %s", result.Outcome)
+	}
+}
+
+// writeSetReport writes the combined report of the workflows to the file.
+// The format is based on the file extension if not given
+func writeSetReport(result *utils.WorkflowSetResult, filename string, format string) error {
+	if format == "" {
+		format = utils.ReportJSON
+		if strings.EqualFold(filepath.Ext(filename), ".xml") {
+			format = utils.ReportJUnit
+		}
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return result.WriteReport(file, format)
+}
+
+// prefixedSink sends the output of the steps of a workflow to a sink shared
+// with other workflows, with the names of the steps prefixed with the
+// workflow so they can be told apart
+type prefixedSink struct {
+	sink   utils.OutputSink
+	prefix string
+}
+
+// Writer implements utils.OutputSink
+func (p *prefixedSink) Writer(step string, name string) io.WriteCloser {
+	return p.sink.Writer(p.prefix+step, p.prefix+name)
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/cloud66-oss/trackman/scheduler"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Run workflows on their cron schedules",
+	Run:   scheduleExec,
+}
+
+// schedulesFile maps workflow files to their schedules
+type schedulesFile struct {
+	Schedules []*scheduler.Entry `yaml:"schedules"`
+}
+
+func init() {
+	scheduleCmd.Flags().StringSliceP("file", "f", nil, "workflow file with a schedule to run. Can be used multiple times")
+	scheduleCmd.Flags().String("schedules", "", "file with the workflow files to run and their schedules")
+	addWorkflowFlags(scheduleCmd)
+
+	rootCmd.AddCommand(scheduleCmd)
+}
+
+func scheduleExec(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger, err := utils.NewLogger(nil, utils.NewLoggingContext(nil, nil))
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	registry, flushNotifiers, err := newNotifiers(cmd)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	options := workflowOptions(cmd, registry, nil)
+	runner, err := scheduler.NewScheduler(&scheduler.Options{
+		WorkflowOptions: options,
+		Logger:          logger,
+	})
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	entries, err := scheduleEntries(ctx, cmd, options)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+	if len(entries) == 0 {
+		logger.Error("no workflows to schedule. Use --file or --schedules")
+		os.Exit(1)
+	}
+
+	for _, entry := range entries {
+		if err = runner.Add(entry); err != nil {
+			logger.Error(err)
+			os.Exit(1)
+		}
+
+		logger.Infof("Scheduled %s at %s", entry.File, entry.Cron)
+	}
+
+	// stop scheduling and cancel the running workflows on Ctrl-C or when
+	// asked to terminate
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			logger.Warnf("Received %s. Stopping the scheduler", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	runner.Run(ctx)
+	flushNotifiers()
+}
+
+// scheduleEntries returns the workflow files given with their own schedule
+// and the ones in the schedules file
+func scheduleEntries(ctx context.Context, cmd *cobra.Command, options func() *utils.WorkflowOptions) ([]*scheduler.Entry, error) {
+	var entries []*scheduler.Entry
+
+	files, _ := cmd.Flags().GetStringSlice("file")
+	for _, file := range files {
+		workflow, err := utils.LoadWorkflowFromFile(ctx, options(), file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		if workflow.Schedule == nil {
+			return nil, fmt.Errorf("%s has no schedule", file)
+		}
+
+		entries = append(entries, &scheduler.Entry{File: file, ScheduleDefinition: *workflow.Schedule})
+	}
+
+	if filename, _ := cmd.Flags().GetString("schedules"); filename != "" {
+		buff, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		var schedules *schedulesFile
+		if err = yaml.UnmarshalStrict(buff, &schedules); err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		if schedules == nil {
+			return entries, nil
+		}
+
+		for _, entry := range schedules.Schedules {
+			// workflow files are relative to the schedules file
+			if !filepath.IsAbs(entry.File) {
+				entry.File = filepath.Join(filepath.Dir(filename), entry.File)
+			}
+
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
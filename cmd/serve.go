@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/cloud66-oss/trackman/metrics"
+	"github.com/cloud66-oss/trackman/server"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run trackman as a server that runs workflows submitted to its REST and gRPC APIs",
+	Run:   serveExec,
+}
+
+func init() {
+	serveCmd.Flags().String("addr", "127.0.0.1:7070", "address to serve the API on. Addresses other than loopback ones need a token")
+	serveCmd.Flags().String("token", "", "bearer token required by all API requests")
+	serveCmd.Flags().Int("max-runs", server.DefaultMaxRuns, "number of finished runs to keep")
+	serveCmd.Flags().Bool("dashboard", false, "serve a web dashboard of the runs on /")
+	addWorkflowFlags(serveCmd)
+
+	_ = viper.BindPFlag("server.addr", serveCmd.Flags().Lookup("addr"))
+	_ = viper.BindPFlag("server.token", serveCmd.Flags().Lookup("token"))
+	_ = viper.BindPFlag("server.max-runs", serveCmd.Flags().Lookup("max-runs"))
+	_ = viper.BindPFlag("server.dashboard", serveCmd.Flags().Lookup("dashboard"))
+
+	rootCmd.AddCommand(serveCmd)
+}
+
+func serveExec(cmd *cobra.Command, args []string) {
+	logger, err := utils.NewLogger(nil, utils.NewLoggingContext(nil, nil))
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	// anyone reaching the API can run commands, so it's only open to the
+	// machine itself without a token
+	addr := viper.GetString("server.addr")
+	if viper.GetString("server.token") == "" && !isLoopbackAddr(addr) {
+		logger.Errorf("A token is needed to serve the API on %s. Set one with --token or serve on a loopback address like 127.0.0.1:7070", addr)
+		os.Exit(1)
+	}
+
+	registry, flushNotifiers, err := newNotifiers(cmd)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	// the runs are saved to the history by its notifier
+	runs, err := newHistoryStore()
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	collector := metrics.NewPrometheusCollector()
+	srv, err := server.NewServer(&server.Options{
+		WorkflowOptions: workflowOptions(cmd, registry, collector),
+		Token:           viper.GetString("server.token"),
+		MaxRuns:         viper.GetInt("server.max-runs"),
+		History:         runs,
+		Dashboard:       viper.GetBool("server.dashboard"),
+		Logger:          logger,
+	})
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", srv)
+	mux.Handle("/metrics", collector)
+
+	// gRPC clients connect with HTTP/2 without TLS
+	protocols := &http.Protocols{}
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	httpServer := &http.Server{
+		Addr:      addr,
+		Handler:   mux,
+		Protocols: protocols,
+	}
+
+	// stop taking new workflows and cancel the running ones on Ctrl-C or
+	// when asked to terminate
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		sig := <-signals
+		logger.Warnf("Received %s. Stopping the server", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(ctx)
+	}()
+
+	logger.Infof("Serving the API on %s", httpServer.Addr)
+	if err = httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	srv.Close()
+	flushNotifiers()
+}
+
+// addWorkflowFlags adds the flags of the workflows run by long running
+// commands
+func addWorkflowFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration("timeout", 10*time.Second, "global timeout unless overwritten by a step")
+	cmd.Flags().Duration("workflow-timeout", 0, "time each workflow can run for before its steps are stopped. Overrides the timeout of the workflow")
+	cmd.Flags().Int("concurrency", runtime.NumCPU()-1, "maximum number of concurrent steps to run in each workflow")
+	cmd.Flags().Duration("grace-period", 10*time.Second, "time given to steps to stop when cancelled or timed out before they are killed")
+	cmd.Flags().Bool("rollback", false, "run the rollback commands of the successful steps if a workflow fails")
+	cmd.Flags().Bool("critical-path-first", false, "run the steps with the longest chain of steps depending on them first")
+	cmd.Flags().String("agents-addr", "", "address agents connect to for the steps with an agent selector, like :8090")
+}
+
+// workflowOptions returns a function creating the options of each workflow
+// from the flags added by addWorkflowFlags. The flags are read directly as
+// the run command binds the same names
+func workflowOptions(cmd *cobra.Command, registry *utils.NotifierRegistry, collector utils.MetricsCollector) func() *utils.WorkflowOptions {
+	flags := cmd.Flags()
+	concurrency, _ := flags.GetInt("concurrency")
+	timeout, _ := flags.GetDuration("timeout")
+	workflowTimeout, _ := flags.GetDuration("workflow-timeout")
+	gracePeriod, _ := flags.GetDuration("grace-period")
+	rollback, _ := flags.GetBool("rollback")
+	criticalPathFirst, _ := flags.GetBool("critical-path-first")
+	agentsAddr, _ := flags.GetString("agents-addr")
+
+	secretProvider := secretProvider()
+	verifier := signatureVerifier()
+	controller := agentController(agentsAddr)
+	lockProvider := lockProvider()
+
+	return func() *utils.WorkflowOptions {
+		return &utils.WorkflowOptions{
+			Notifiers:         registry,
+			Concurrency:       concurrency,
+			Timeout:           timeout,
+			WorkflowTimeout:   workflowTimeout,
+			GracePeriod:       gracePeriod,
+			Rollback:          rollback,
+			CriticalPathFirst: criticalPathFirst,
+			Metrics:           collector,
+			Cache:             stepCache(),
+			Artifacts:         artifactStore(),
+			Secrets:           secretProvider,
+			Verifier:          verifier,
+			Agents:            controller,
+			Locks:             lockProvider,
+			OutputTail:        outputTail(),
+		}
+	}
+}
+
+// isLoopbackAddr returns true if the address is only reachable from the
+// machine itself, like 127.0.0.1:7070 or localhost:7070
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
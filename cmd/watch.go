@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/cloud66-oss/trackman/watcher"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Run the workflow every time the files it watches change",
+	Run:   watchExec,
+}
+
+func init() {
+	watchCmd.Flags().StringP("file", "f", "", "workflow file to run")
+	watchCmd.Flags().StringArray("set", nil, "set a workflow variable as key=value. Can be used multiple times")
+	addLoadFlags(watchCmd)
+	watchCmd.Flags().StringSlice("path", nil, "directory, file or glob pattern to watch instead of the ones in the workflow. Can be used multiple times")
+	watchCmd.Flags().StringSlice("ignore", nil, "pattern of files to ignore changes to, on top of the ones in the workflow. Can be used multiple times")
+	watchCmd.Flags().Duration("debounce", 0, "how long to wait for the files to stop changing before running the workflow")
+	addWorkflowFlags(watchCmd)
+
+	rootCmd.AddCommand(watchCmd)
+}
+
+func watchExec(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger, err := utils.NewLogger(nil, utils.NewLoggingContext(nil, nil))
+	if err != nil {
+		utils.PrintError(err.Error())
+		os.Exit(1)
+	}
+
+	registry, flushNotifiers, err := newNotifiers(cmd)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+	defer flushNotifiers()
+
+	newOptions := workflowOptions(cmd, registry, nil)
+	load := func() (*utils.Workflow, error) {
+		options := newOptions()
+		options.Output = utils.NewOutputMultiplexer(os.Stdout, nil)
+
+		return loadWorkflow(ctx, args, options, cmd)
+	}
+
+	workflow, err := load()
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	options, err := watchOptions(cmd, workflow, logger)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+
+	files, err := watcher.NewWatcher(options)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+	defer files.Close()
+
+	// stop watching on Ctrl-C or when asked to terminate
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case sig := <-signals:
+			logger.Warnf("Received %s. Stopping", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	logger.Infof("Watching %s", strings.Join(options.Paths, ", "))
+
+	outputs := watchRun(ctx, logger, workflow, nil, nil)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case changed := <-files.Changes():
+			logger.Infof("Changed: %s", strings.Join(changed, ", "))
+
+			// the workflow file might have changed too
+			if workflow, err = load(); err != nil {
+				logger.Error(err)
+				continue
+			}
+
+			outputs = watchRun(ctx, logger, workflow, workflow.StepsFor(changed), outputs)
+		}
+	}
+}
+
+// watchRun runs the given steps of the workflow, or all of them if none are
+// given, and returns the outputs of the steps. No outputs are returned if
+// the workflow didn't succeed, so it runs in full the next time
+func watchRun(ctx context.Context, logger *logrus.Logger, workflow *utils.Workflow, steps []string, outputs map[string]map[string]string) map[string]map[string]string {
+	var result *utils.WorkflowResult
+	var err error
+	if len(steps) == 0 || outputs == nil {
+		result, err = workflow.Run(ctx)
+	} else {
+		logger.Infof("Running %s", strings.Join(steps, ", "))
+		result, err = workflow.Rerun(ctx, steps, outputs)
+	}
+	if err != nil {
+		logger.Error(err)
+	}
+	if result == nil || ctx.Err() != nil {
+		return nil
+	}
+
+	logger.Infof("Done with %s. Waiting for changes", result.Outcome)
+	if result.Outcome != utils.OutcomeSuccess {
+		return nil
+	}
+
+	return workflow.Outputs()
+}
+
+// watchOptions returns the paths to watch from the flags or the workflow.
+// Paths in the workflow are relative to the workflow file, which is always
+// watched too
+func watchOptions(cmd *cobra.Command, workflow *utils.Workflow, logger *logrus.Logger) (*watcher.Options, error) {
+	file, _ := cmd.Flags().GetString("file")
+	if file == "" || file == "-" {
+		return nil, errors.New("watch needs a workflow file")
+	}
+	dir := filepath.Dir(file)
+
+	options := &watcher.Options{Logger: logger}
+	if definition := workflow.Watch; definition != nil {
+		for _, item := range definition.Paths {
+			options.Paths = append(options.Paths, relativeTo(dir, item))
+		}
+		for _, pattern := range definition.Ignore {
+			// patterns without a / match anywhere
+			if strings.Contains(pattern, "/") {
+				pattern = relativeTo(dir, pattern)
+			}
+
+			options.Ignore = append(options.Ignore, pattern)
+		}
+
+		options.Debounce = definition.Debounce
+	}
+
+	if paths, _ := cmd.Flags().GetStringSlice("path"); len(paths) != 0 {
+		options.Paths = paths
+	}
+	if ignore, _ := cmd.Flags().GetStringSlice("ignore"); len(ignore) != 0 {
+		options.Ignore = append(options.Ignore, ignore...)
+	}
+	if debounce, _ := cmd.Flags().GetDuration("debounce"); debounce != 0 {
+		options.Debounce = debounce
+	}
+
+	if len(options.Paths) == 0 {
+		return nil, errors.New("no paths to watch. Use --path or set them in the workflow")
+	}
+
+	options.Paths = append(options.Paths, file)
+
+	return options, nil
+}
+
+func relativeTo(dir string, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+
+	return filepath.Join(dir, name)
+}
@@ -0,0 +1,41 @@
+package history
+
+import "time"
+
+// StepComparison is how long a step took in two runs
+type StepComparison struct {
+	Name   string        `json:"name"`
+	Before time.Duration `json:"before"`
+	After  time.Duration `json:"after"`
+	// Missing is set if the step is only in one of the runs
+	Missing bool `json:"missing,omitempty"`
+}
+
+// Change returns how much longer the step took in the second run
+func (c *StepComparison) Change() time.Duration {
+	return c.After - c.Before
+}
+
+// Compare returns how long each step took in the two runs, in the order of
+// the steps of the second run followed by the ones only in the first
+func Compare(before *Run, after *Run) []*StepComparison {
+	var comparisons []*StepComparison
+	for _, step := range after.Steps {
+		comparison := &StepComparison{Name: step.Name, After: step.Duration}
+		if previous := before.Step(step.Name); previous != nil {
+			comparison.Before = previous.Duration
+		} else {
+			comparison.Missing = true
+		}
+
+		comparisons = append(comparisons, comparison)
+	}
+
+	for _, step := range before.Steps {
+		if after.Step(step.Name) == nil {
+			comparisons = append(comparisons, &StepComparison{Name: step.Name, Before: step.Duration, Missing: true})
+		}
+	}
+
+	return comparisons
+}
@@ -0,0 +1,122 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloud66-oss/trackman/sqlite"
+)
+
+// SQLiteStore is a RunStore keeping the runs in a SQLite database, which can
+// be shared by the processes on a machine. It needs trackman built with
+// -tags sqlite
+type SQLiteStore struct {
+	db *sqlite.DB
+}
+
+// NewSQLiteStore opens the database in the file, creating it and its
+// directory if needed
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := sqlite.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// runs are kept as JSON, like in a FileStore, with the columns to find
+	// them
+	if err = db.Exec(`CREATE TABLE IF NOT EXISTS runs (
+		id TEXT PRIMARY KEY,
+		workflow TEXT NOT NULL,
+		started_at INTEGER NOT NULL,
+		run BLOB NOT NULL
+	)`); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err = db.Exec("CREATE INDEX IF NOT EXISTS runs_by_workflow ON runs (workflow, started_at)"); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// Save implements RunStore
+func (s *SQLiteStore) Save(run *Run) error {
+	buff, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	buff = []byte(run.MaskSecrets(string(buff)))
+
+	return s.db.Exec("INSERT OR REPLACE INTO runs (id, workflow, started_at, run) VALUES (?, ?, ?, ?)", run.SessionID, run.Workflow, run.StartedAt.UnixNano(), buff)
+}
+
+// List implements RunStore
+func (s *SQLiteStore) List(filter *Filter) ([]*Run, error) {
+	if filter == nil {
+		filter = &Filter{}
+	}
+
+	query := "SELECT id, run FROM runs"
+	var args []interface{}
+	if filter.Workflow != "" {
+		query += " WHERE workflow = ?"
+		args = append(args, filter.Workflow)
+	}
+	query += " ORDER BY started_at DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	var runs []*Run
+	err := s.db.Query(query, args, func(row *sqlite.Row) error {
+		run, err := decodeRun(row.Text(0), row.Blob(1))
+		runs = append(runs, run)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// Get implements RunStore
+func (s *SQLiteStore) Get(id string) (*Run, error) {
+	var run *Run
+	err := s.db.Query("SELECT id, run FROM runs WHERE id = ?", []interface{}{id}, func(row *sqlite.Row) (err error) {
+		run, err = decodeRun(row.Text(0), row.Blob(1))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if run == nil {
+		return nil, fmt.Errorf("run %s not found", id)
+	}
+
+	return run, nil
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func decodeRun(id string, buff []byte) (*Run, error) {
+	var run *Run
+	if err := json.Unmarshal(buff, &run); err != nil {
+		return nil, fmt.Errorf("invalid run %s: %s", id, err)
+	}
+
+	return run, nil
+}
@@ -0,0 +1,167 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/hashicorp/go-multierror"
+)
+
+// Run is a finished workflow run as kept in the history
+type Run struct {
+	*utils.WorkflowResult
+	// Workflow is the name of the workflow
+	Workflow string   `json:"workflow"`
+	Errors   []string `json:"errors,omitempty"`
+	// StepErrors are the errors of the failed steps by step name
+	StepErrors map[string]string `json:"step_errors,omitempty"`
+}
+
+// Filter limits the runs listed
+type Filter struct {
+	// Workflow only lists the runs of the workflow with this name if set
+	Workflow string
+	// Limit is the maximum number of runs listed, most recent first. 0
+	// lists all of them
+	Limit int
+}
+
+// RunStore keeps the history of workflow runs
+type RunStore interface {
+	// Save adds the run to the history
+	Save(run *Run) error
+	// List returns the runs matching the filter, most recent first
+	List(filter *Filter) ([]*Run, error)
+	// Get returns the run with the given id
+	Get(id string) (*Run, error)
+}
+
+// NewRun creates the history entry of a workflow result
+func NewRun(workflow string, result *utils.WorkflowResult) *Run {
+	run := &Run{
+		WorkflowResult: result,
+		Workflow:       workflow,
+	}
+
+	if merr, ok := result.Errors.(*multierror.Error); ok {
+		for _, err := range merr.Errors {
+			run.Errors = append(run.Errors, err.Error())
+		}
+	} else if result.Errors != nil {
+		run.Errors = append(run.Errors, result.Errors.Error())
+	}
+
+	for _, step := range result.Steps {
+		if step.Error == nil {
+			continue
+		}
+		if run.StepErrors == nil {
+			run.StepErrors = make(map[string]string)
+		}
+
+		run.StepErrors[step.Name] = step.Error.Error()
+	}
+
+	return run
+}
+
+// FileStore is a RunStore keeping each run in a JSON file in a directory
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a new FileStore in the directory, creating it if
+// needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// Save implements RunStore
+func (s *FileStore) Save(run *Run) error {
+	buff, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	buff = []byte(run.MaskSecrets(string(buff)))
+
+	// write to a temp file first so a crash never leaves a half written run
+	filename := filepath.Join(s.dir, run.SessionID+".json")
+	tempFile := filename + ".tmp"
+	if err = ioutil.WriteFile(tempFile, buff, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tempFile, filename)
+}
+
+// List implements RunStore
+func (s *FileStore) List(filter *Filter) ([]*Run, error) {
+	if filter == nil {
+		filter = &Filter{}
+	}
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []*Run
+	for _, file := range files {
+		run, err := s.read(file)
+		if err != nil {
+			return nil, err
+		}
+		if filter.Workflow != "" && run.Workflow != filter.Workflow {
+			continue
+		}
+
+		runs = append(runs, run)
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	if filter.Limit > 0 && len(runs) > filter.Limit {
+		runs = runs[:filter.Limit]
+	}
+
+	return runs, nil
+}
+
+// Get implements RunStore
+func (s *FileStore) Get(id string) (*Run, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, fmt.Errorf("invalid run id %s", id)
+	}
+
+	run, err := s.read(filepath.Join(s.dir, id+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("run %s not found", id)
+	}
+
+	return run, err
+}
+
+func (s *FileStore) read(filename string) (*Run, error) {
+	buff, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var run *Run
+	if err = json.Unmarshal(buff, &run); err != nil {
+		return nil, fmt.Errorf("invalid run %s: %s", filepath.Base(filename), err)
+	}
+
+	return run, nil
+}
@@ -0,0 +1,150 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/sqlite"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/hashicorp/go-multierror"
+)
+
+func newTestRun(workflow string, id string, startedAt time.Time) *Run {
+	result := &utils.WorkflowResult{
+		SessionID: id,
+		Outcome:   utils.OutcomeFailed,
+		StartedAt: startedAt,
+		Steps: []*utils.StepResult{
+			{Name: "build", Status: utils.ResultSuccess, Duration: time.Second},
+			{Name: "test", Status: utils.ResultFailed, Duration: 2 * time.Second, Error: errors.New("exit status 1")},
+		},
+		Errors: multierror.Append(nil, errors.New("step test failed"), errors.New("cleanup failed")),
+	}
+
+	return NewRun(workflow, result)
+}
+
+func TestNewRun(t *testing.T) {
+	run := newTestRun("deploy", "abc", time.Now())
+
+	if run.Workflow != "deploy" || strings.Join(run.Errors, ", ") != "step test failed, cleanup failed" {
+		t.Errorf("the run is of %s with errors %q", run.Workflow, run.Errors)
+	}
+	if len(run.StepErrors) != 1 || run.StepErrors["test"] != "exit status 1" {
+		t.Errorf("the step errors are %v, want test: exit status 1", run.StepErrors)
+	}
+}
+
+// testRunStore checks the runs saved to the store are listed and read back
+func testRunStore(t *testing.T, store RunStore) {
+	t.Helper()
+
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for idx, workflow := range []string{"deploy", "backup", "deploy", "deploy"} {
+		run := newTestRun(workflow, fmt.Sprintf("run%d", idx), start.Add(time.Duration(idx)*time.Hour))
+		if err := store.Save(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// saving a run again replaces it
+	again := newTestRun("deploy", "run3", start.Add(3*time.Hour))
+	again.Outcome = utils.OutcomeSuccess
+	if err := store.Save(again); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter *Filter
+		want   []string
+	}{
+		{filter: nil, want: []string{"run3", "run2", "run1", "run0"}},
+		{filter: &Filter{Workflow: "deploy"}, want: []string{"run3", "run2", "run0"}},
+		{filter: &Filter{Workflow: "deploy", Limit: 2}, want: []string{"run3", "run2"}},
+		{filter: &Filter{Workflow: "missing"}, want: nil},
+	}
+
+	for _, test := range tests {
+		runs, err := store.List(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []string
+		for _, run := range runs {
+			ids = append(ids, run.SessionID)
+		}
+		if strings.Join(ids, " ") != strings.Join(test.want, " ") {
+			t.Errorf("the runs listed with %+v are %v, want %v", test.filter, ids, test.want)
+		}
+	}
+
+	run, err := store.Get("run3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Outcome != utils.OutcomeSuccess || run.Workflow != "deploy" || !run.StartedAt.Equal(start.Add(3*time.Hour)) {
+		t.Errorf("run3 is a %s run of %s started at %s", run.Outcome, run.Workflow, run.StartedAt)
+	}
+	if len(run.Steps) != 2 || run.Steps[1].Duration != 2*time.Second || run.StepErrors["test"] != "exit status 1" {
+		t.Errorf("the steps of run3 weren't kept: %+v", run.Steps)
+	}
+
+	for _, id := range []string{"missing", "", "../run3"} {
+		if _, err := store.Get(id); err == nil {
+			t.Errorf("getting run %q didn't fail", id)
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRunStore(t, store)
+}
+
+func TestCompare(t *testing.T) {
+	before := newTestRun("deploy", "run0", time.Now())
+	after := newTestRun("deploy", "run1", time.Now())
+	after.Steps = []*utils.StepResult{
+		{Name: "test", Duration: 5 * time.Second},
+		{Name: "publish", Duration: time.Second},
+	}
+
+	comparisons := Compare(before, after)
+	want := []StepComparison{
+		{Name: "test", Before: 2 * time.Second, After: 5 * time.Second},
+		{Name: "publish", After: time.Second, Missing: true},
+		{Name: "build", Before: time.Second, Missing: true},
+	}
+	if len(comparisons) != len(want) {
+		t.Fatalf("got %d comparisons, want %d", len(comparisons), len(want))
+	}
+	for idx, comparison := range comparisons {
+		if *comparison != want[idx] {
+			t.Errorf("comparison %d is %+v, want %+v", idx, *comparison, want[idx])
+		}
+	}
+	if change := comparisons[0].Change(); change != 3*time.Second {
+		t.Errorf("test took %s longer, want 3s", change)
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "history", "runs.db"))
+	if err == sqlite.ErrNotSupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	testRunStore(t, store)
+}
@@ -0,0 +1,159 @@
+package locks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConsulPrefix = "trackman/locks"
+	defaultConsulTTL    = 15 * time.Second
+)
+
+// ConsulOptions configures the Consul locks
+type ConsulOptions struct {
+	// Address is the address of the Consul agent, like http://localhost:8500
+	Address string
+	Token   string
+	// Prefix is the key the locks are kept under. Defaults to trackman/locks
+	Prefix string
+	// TTL is how long a lock is kept after the process holding it stops
+	// renewing it, like when it dies. Defaults to 15 seconds
+	TTL time.Duration
+}
+
+// ConsulLocks is a utils.LockProvider holding the locks as keys of the
+// Consul KV store, shared by all processes using the same Consul cluster.
+// Each lock has its own session, renewed while it's held
+type ConsulLocks struct {
+	options *ConsulOptions
+	client  *http.Client
+}
+
+// NewConsulLocks creates the Consul locks
+func NewConsulLocks(options *ConsulOptions) (*ConsulLocks, error) {
+	if options.Address == "" {
+		return nil, errors.New("consul needs an address")
+	}
+	if options.Prefix == "" {
+		options.Prefix = defaultConsulPrefix
+	}
+	if options.TTL == 0 {
+		options.TTL = defaultConsulTTL
+	}
+	// consul doesn't take TTLs under 10 seconds
+	if options.TTL < 10*time.Second {
+		return nil, fmt.Errorf("invalid consul ttl %s. it has to be at least 10s", options.TTL)
+	}
+
+	return &ConsulLocks{
+		options: options,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// TryLock implements utils.LockProvider
+func (c *ConsulLocks) TryLock(ctx context.Context, name string) (func() error, error) {
+	var session struct {
+		ID string `json:"ID"`
+	}
+	err := c.call(ctx, "/v1/session/create", map[string]string{
+		"Name":      "trackman " + name,
+		"TTL":       c.options.TTL.String(),
+		"Behavior":  "delete",
+		"LockDelay": "0s",
+	}, &session)
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("/v1/kv/%s/%s", strings.Trim(c.options.Prefix, "/"), safeName(name))
+	hostname, _ := os.Hostname()
+	var acquired bool
+	if err = c.call(ctx, key+"?acquire="+session.ID, fmt.Sprintf("%s %d", hostname, os.Getpid()), &acquired); err != nil || !acquired {
+		_ = c.call(context.Background(), "/v1/session/destroy/"+session.ID, nil, nil)
+		return nil, err
+	}
+
+	// the session is renewed until the lock is released. if the process
+	// dies, consul deletes the key once the ttl is over
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(c.options.TTL / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				_ = c.call(context.Background(), "/v1/session/renew/"+session.ID, nil, nil)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	once := &sync.Once{}
+	return func() error {
+		var err error
+		once.Do(func() {
+			close(stop)
+			err = c.call(context.Background(), key+"?release="+session.ID, nil, nil)
+			if destroyErr := c.call(context.Background(), "/v1/session/destroy/"+session.ID, nil, nil); err == nil {
+				err = destroyErr
+			}
+		})
+
+		return err
+	}, nil
+}
+
+// call sends a PUT request to the Consul API with the body, as JSON unless
+// it's a string, and decodes the response into result if given
+func (c *ConsulLocks) call(ctx context.Context, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(body)
+	default:
+		buff, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(buff)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(c.options.Address, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	if c.options.Token != "" {
+		req.Header.Set("X-Consul-Token", c.options.Token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("consul returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
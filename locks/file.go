@@ -0,0 +1,64 @@
+package locks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileLocks is a utils.LockProvider locking files in a directory. The locks
+// are shared by all processes using the same directory on a machine, or on
+// a shared file system that supports locks. They are released by the
+// operating system if the process holding them dies
+type FileLocks struct {
+	dir string
+}
+
+// NewFileLocks creates the locks in dir. The directory is created with the
+// first lock
+func NewFileLocks(dir string) (*FileLocks, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("file locks need a directory")
+	}
+
+	return &FileLocks{dir: dir}, nil
+}
+
+// TryLock implements utils.LockProvider
+func (l *FileLocks) TryLock(ctx context.Context, name string) (func() error, error) {
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(l.dir, safeName(name)+".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := lockFile(file)
+	if err != nil || !locked {
+		file.Close()
+		return nil, err
+	}
+
+	// who holds the lock, for whoever is waiting for it
+	hostname, _ := os.Hostname()
+	if err = file.Truncate(0); err == nil {
+		_, _ = fmt.Fprintf(file, "%s %d\n", hostname, os.Getpid())
+	}
+
+	once := &sync.Once{}
+	return func() error {
+		var err error
+		once.Do(func() {
+			err = unlockFile(file)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		})
+
+		return err
+	}, nil
+}
@@ -0,0 +1,16 @@
+// Package locks holds the global locks of the steps, shared by the workflows
+// run by different processes so a step like a database migration never runs
+// twice at the same time
+package locks
+
+import (
+	"regexp"
+)
+
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// safeName returns the name of the lock with only the characters that can
+// be used in file names and keys
+func safeName(name string) string {
+	return unsafeName.ReplaceAllString(name, "_")
+}
@@ -0,0 +1,257 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+// DefaultBuckets are the upper bounds of the duration histograms in seconds
+var DefaultBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600}
+
+// PrometheusCollector is a utils.MetricsCollector that serves its metrics in
+// the Prometheus text format
+type PrometheusCollector struct {
+	workflowsStarted  *counter
+	workflowsFinished *counter
+	workflowDurations *histogram
+	stepsStarted      *counter
+	stepsFinished     *counter
+	stepDurations     *histogram
+	stepTimeouts      *counter
+	stepsRunning      float64
+	signal            *sync.Mutex
+
+	// attempts holds when the running attempt of each step started
+	attempts map[*utils.Step]time.Time
+}
+
+// NewPrometheusCollector creates a new PrometheusCollector
+func NewPrometheusCollector() *PrometheusCollector {
+	return &PrometheusCollector{
+		workflowsStarted:  newCounter("trackman_workflows_started_total", "Number of workflows started"),
+		workflowsFinished: newCounter("trackman_workflows_finished_total", "Number of workflows finished by outcome", "outcome"),
+		workflowDurations: newHistogram("trackman_workflow_duration_seconds", "Duration of workflows", DefaultBuckets, "outcome"),
+		stepsStarted:      newCounter("trackman_steps_started_total", "Number of step attempts started", "step"),
+		stepsFinished:     newCounter("trackman_steps_finished_total", "Number of steps finished by status", "step", "status"),
+		stepDurations:     newHistogram("trackman_step_duration_seconds", "Duration of step attempts", DefaultBuckets, "step"),
+		stepTimeouts:      newCounter("trackman_step_timeouts_total", "Number of step timeouts", "step"),
+		signal:            &sync.Mutex{},
+		attempts:          make(map[*utils.Step]time.Time),
+	}
+}
+
+// WorkflowStarted implements utils.MetricsCollector
+func (c *PrometheusCollector) WorkflowStarted(workflow *utils.Workflow) {
+	c.signal.Lock()
+	defer c.signal.Unlock()
+
+	c.workflowsStarted.add(1)
+}
+
+// WorkflowFinished implements utils.MetricsCollector
+func (c *PrometheusCollector) WorkflowFinished(workflow *utils.Workflow, result *utils.WorkflowResult) {
+	c.signal.Lock()
+	defer c.signal.Unlock()
+
+	c.workflowsFinished.add(1, result.Outcome)
+	c.workflowDurations.observe(result.Duration.Seconds(), result.Outcome)
+}
+
+// StepStarted implements utils.MetricsCollector
+func (c *PrometheusCollector) StepStarted(step *utils.Step) {
+	c.signal.Lock()
+	defer c.signal.Unlock()
+
+	c.stepsStarted.add(1, step.Name)
+	c.stepsRunning++
+	c.attempts[step] = time.Now()
+}
+
+// StepFinished implements utils.MetricsCollector
+func (c *PrometheusCollector) StepFinished(step *utils.Step, result *utils.StepResult) {
+	c.signal.Lock()
+	defer c.signal.Unlock()
+
+	c.stepsRunning--
+	// the result has the duration of all attempts, so each one is timed here
+	if startedAt, ok := c.attempts[step]; ok {
+		c.stepDurations.observe(time.Since(startedAt).Seconds(), step.Name)
+		delete(c.attempts, step)
+	}
+
+	// the step is going to be retried so it's not finished yet
+	if result.Status != utils.ResultRunning {
+		c.stepsFinished.add(1, step.Name, result.Status)
+	}
+}
+
+// StepTimedOut implements utils.MetricsCollector
+func (c *PrometheusCollector) StepTimedOut(step *utils.Step) {
+	c.signal.Lock()
+	defer c.signal.Unlock()
+
+	c.stepTimeouts.add(1, step.Name)
+}
+
+// ServeHTTP serves the metrics to Prometheus
+func (c *PrometheusCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	if err := c.Write(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Write writes the metrics in the Prometheus text format
+func (c *PrometheusCollector) Write(w io.Writer) error {
+	c.signal.Lock()
+	defer c.signal.Unlock()
+
+	buf := &strings.Builder{}
+	c.workflowsStarted.write(buf)
+	c.workflowsFinished.write(buf)
+	c.workflowDurations.write(buf)
+	c.stepsStarted.write(buf)
+	c.stepsFinished.write(buf)
+	c.stepDurations.write(buf)
+	c.stepTimeouts.write(buf)
+	fmt.Fprintf(buf, "# HELP trackman_steps_running Number of steps running now\n")
+	fmt.Fprintf(buf, "# TYPE trackman_steps_running gauge\n")
+	fmt.Fprintf(buf, "trackman_steps_running %v\n", c.stepsRunning)
+
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+type counter struct {
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+}
+
+func newCounter(name string, help string, labels ...string) *counter {
+	return &counter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+}
+
+func (c *counter) add(value float64, labelValues ...string) {
+	c.values[formatLabels(c.labels, labelValues)] += value
+}
+
+func (c *counter) write(buf *strings.Builder) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(buf, "# TYPE %s counter\n", c.name)
+
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(buf, "%s 0\n", c.name)
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(buf, "%s%s %v\n", c.name, key, c.values[key])
+	}
+}
+
+type histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+func newHistogram(name string, help string, buckets []float64, labels ...string) *histogram {
+	return &histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+}
+
+func (h *histogram) observe(value float64, labelValues ...string) {
+	key := formatLabels(h.labels, labelValues)
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{
+			labelValues: labelValues,
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = series
+	}
+
+	for idx, bound := range h.buckets {
+		if value <= bound {
+			series.counts[idx]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *histogram) write(buf *strings.Builder) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", h.name, h.help)
+	fmt.Fprintf(buf, "# TYPE %s histogram\n", h.name)
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketLabels := append(append([]string{}, h.labels...), "le")
+	for _, key := range keys {
+		series := h.series[key]
+		for idx, bound := range h.buckets {
+			labels := formatLabels(bucketLabels, append(append([]string{}, series.labelValues...), fmt.Sprint(bound)))
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", h.name, labels, series.counts[idx])
+		}
+		labels := formatLabels(bucketLabels, append(append([]string{}, series.labelValues...), "+Inf"))
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", h.name, labels, series.count)
+		fmt.Fprintf(buf, "%s_sum%s %v\n", h.name, key, series.sum)
+		fmt.Fprintf(buf, "%s_count%s %d\n", h.name, key, series.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for idx, name := range names {
+		pairs[idx] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[idx]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+func TestStepAttemptDurations(t *testing.T) {
+	collector := NewPrometheusCollector()
+	step := &utils.Step{Name: "build"}
+
+	// the result holds the duration of all attempts, or none at all
+	for _, status := range []string{utils.ResultRunning, utils.ResultSuccess} {
+		collector.StepStarted(step)
+		time.Sleep(20 * time.Millisecond)
+		collector.StepFinished(step, &utils.StepResult{Name: step.Name, Status: status, Duration: time.Hour})
+	}
+
+	buf := &strings.Builder{}
+	if err := collector.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		`trackman_step_duration_seconds_count{step="build"} 2`,
+		`trackman_step_duration_seconds_bucket{step="build",le="0.5"} 2`,
+		`trackman_steps_started_total{step="build"} 2`,
+		`trackman_steps_finished_total{step="build",status="success"} 1`,
+		"trackman_steps_running 0",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%s is missing from the metrics:\n%s", want, out)
+		}
+	}
+}
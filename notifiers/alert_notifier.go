@@ -0,0 +1,313 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// AlertPagerDuty sends the alerts to the PagerDuty Events API v2
+	AlertPagerDuty = "pagerduty"
+	// AlertOpsgenie sends the alerts to the Opsgenie Alert API
+	AlertOpsgenie = "opsgenie"
+
+	pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	opsgenieAlertsURL  = "https://api.opsgenie.com/v2/alerts"
+)
+
+// AlertEvents are the events the alert notifier needs
+var AlertEvents = []string{utils.EventWorkflowSuccess, utils.EventWorkflowFail}
+
+// AlertDefaultSeverities map the severities of the failures to the ones of
+// each provider
+var AlertDefaultSeverities = map[string]map[string]string{
+	AlertPagerDuty: {
+		utils.SeverityError:   "error",
+		utils.SeverityWarning: "warning",
+		utils.SeverityInfo:    "info",
+	},
+	AlertOpsgenie: {
+		utils.SeverityError:   "P2",
+		utils.SeverityWarning: "P3",
+		utils.SeverityInfo:    "P5",
+	},
+}
+
+// AlertOptions configures an AlertNotifier
+type AlertOptions struct {
+	// Provider is pagerduty or opsgenie
+	Provider string
+	// RoutingKey is the integration key of the PagerDuty service
+	RoutingKey string
+	// APIKey is the key of the Opsgenie API integration
+	APIKey string
+	// URL overrides the URL of the API of the provider, like the EU one of
+	// Opsgenie
+	URL string
+	// Severities overrides the severities of the provider by the severity
+	// of the failure: error for failed workflows and warning for cancelled
+	// ones. Defaults to AlertDefaultSeverities
+	Severities map[string]string
+}
+
+// Alert is an incident opened or resolved by the AlertNotifier
+type Alert struct {
+	// Key deduplicates the alerts. Alerts with the same key are the same
+	// incident
+	Key      string
+	Summary  string
+	Severity string
+	Workflow string
+	Step     string
+	Details  map[string]string
+}
+
+// AlertNotifier opens an incident for each failed step of a failed workflow,
+// or one for the workflow if no step failed, and resolves them when a later
+// run succeeds. Incidents are deduplicated by workflow and step name
+type AlertNotifier struct {
+	options *AlertOptions
+	client  *http.Client
+}
+
+// NewAlertNotifier creates a new AlertNotifier
+func NewAlertNotifier(options *AlertOptions) (*AlertNotifier, error) {
+	defaults, ok := AlertDefaultSeverities[options.Provider]
+	if !ok {
+		return nil, fmt.Errorf("invalid alert provider %s. Valid values are %s and %s", options.Provider, AlertPagerDuty, AlertOpsgenie)
+	}
+	if options.Provider == AlertPagerDuty && options.RoutingKey == "" {
+		return nil, errors.New("pagerduty needs a routing key")
+	}
+	if options.Provider == AlertOpsgenie && options.APIKey == "" {
+		return nil, errors.New("opsgenie needs an api key")
+	}
+
+	severities := make(map[string]string, len(defaults))
+	for severity, value := range defaults {
+		severities[severity] = value
+	}
+	for severity, value := range options.Severities {
+		severities[severity] = value
+	}
+	options.Severities = severities
+
+	if options.URL == "" {
+		options.URL = pagerDutyEventsURL
+		if options.Provider == AlertOpsgenie {
+			options.URL = opsgenieAlertsURL
+		}
+	}
+
+	return &AlertNotifier{
+		options: options,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Notify implements utils.Notifier
+func (n *AlertNotifier) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	result, ok := event.Payload.Extras.(*utils.WorkflowResult)
+	if !ok || event.Payload.Workflow == nil {
+		return nil
+	}
+	workflow := event.Payload.Workflow.Name()
+
+	if event.Name == utils.EventWorkflowFail {
+		return n.open(ctx, workflow, result)
+	}
+
+	return n.resolve(ctx, workflow, result)
+}
+
+// open triggers an alert for each failed step of the result
+func (n *AlertNotifier) open(ctx context.Context, workflow string, result *utils.WorkflowResult) error {
+	severity := utils.SeverityError
+	if result.Outcome == utils.OutcomeCancelled {
+		severity = utils.SeverityWarning
+	}
+
+	details := map[string]string{
+		"session_id": result.SessionID,
+		"outcome":    result.Outcome,
+	}
+	if result.CancelReason != "" {
+		details["cancel_reason"] = result.MaskSecrets(result.CancelReason)
+	}
+
+	var alerts []*Alert
+	for _, step := range result.Steps {
+		if step.Status != utils.ResultFailed {
+			continue
+		}
+
+		alerts = append(alerts, &Alert{
+			Key:      alertKey(workflow, step.Name),
+			Summary:  result.MaskSecrets(fmt.Sprintf("Step %s of workflow %s failed with exit code %d", step.Name, workflow, step.ExitCode)),
+			Severity: severity,
+			Workflow: workflow,
+			Step:     step.Name,
+			Details:  details,
+		})
+	}
+	if len(alerts) == 0 {
+		alerts = append(alerts, &Alert{
+			Key:      alertKey(workflow, ""),
+			Summary:  result.MaskSecrets(fmt.Sprintf("Workflow %s finished with %s", workflow, result.Outcome)),
+			Severity: severity,
+			Workflow: workflow,
+			Details:  details,
+		})
+	}
+
+	var errs error
+	for _, alert := range alerts {
+		if err := n.Trigger(ctx, alert); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// resolve resolves the alerts of the workflow and of its successful steps
+func (n *AlertNotifier) resolve(ctx context.Context, workflow string, result *utils.WorkflowResult) error {
+	keys := []string{alertKey(workflow, "")}
+	for _, step := range result.Steps {
+		if step.Status == utils.ResultSuccess || step.Status == utils.ResultCached {
+			keys = append(keys, alertKey(workflow, step.Name))
+		}
+	}
+
+	var errs error
+	for _, key := range keys {
+		if err := n.Resolve(ctx, key); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// Trigger opens the alert, or updates the open one with the same key
+func (n *AlertNotifier) Trigger(ctx context.Context, alert *Alert) error {
+	severity := n.options.Severities[alert.Severity]
+	summary := utils.MaskSecrets(alert.Summary)
+
+	if n.options.Provider == AlertOpsgenie {
+		tags := []string{"trackman", alert.Workflow}
+		if alert.Step != "" {
+			tags = append(tags, alert.Step)
+		}
+
+		return n.post(ctx, n.options.URL, map[string]interface{}{
+			"message":     truncate(summary, 130),
+			"alias":       alert.Key,
+			"description": summary,
+			"priority":    severity,
+			"source":      "trackman",
+			"tags":        tags,
+			"details":     alert.Details,
+		})
+	}
+
+	source, _ := os.Hostname()
+	if source == "" {
+		source = "trackman"
+	}
+
+	payload := map[string]interface{}{
+		"summary":        truncate(summary, 1024),
+		"source":         source,
+		"severity":       severity,
+		"group":          alert.Workflow,
+		"custom_details": alert.Details,
+	}
+	if alert.Step != "" {
+		payload["component"] = alert.Step
+	}
+
+	return n.post(ctx, n.options.URL, map[string]interface{}{
+		"routing_key":  n.options.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    alert.Key,
+		"payload":      payload,
+	})
+}
+
+// Resolve resolves the alert with the key. Resolving an alert that isn't
+// open does nothing
+func (n *AlertNotifier) Resolve(ctx context.Context, key string) error {
+	if n.options.Provider == AlertOpsgenie {
+		closeURL := fmt.Sprintf("%s/%s/close?identifierType=alias", strings.TrimSuffix(n.options.URL, "/"), url.PathEscape(key))
+
+		return n.post(ctx, closeURL, map[string]interface{}{
+			"source": "trackman",
+		})
+	}
+
+	return n.post(ctx, n.options.URL, map[string]interface{}{
+		"routing_key":  n.options.RoutingKey,
+		"event_action": "resolve",
+		"dedup_key":    key,
+	})
+}
+
+func (n *AlertNotifier) post(ctx context.Context, target string, body interface{}) error {
+	buff, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(buff))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.options.Provider == AlertOpsgenie {
+		req.Header.Set("Authorization", "GenieKey "+n.options.APIKey)
+	}
+
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	// closing an alert that doesn't exist isn't an error
+	if resp.StatusCode >= 300 && !(n.options.Provider == AlertOpsgenie && resp.StatusCode == http.StatusNotFound) {
+		return fmt.Errorf("%s returned %s", n.options.Provider, resp.Status)
+	}
+
+	return nil
+}
+
+// alertKey returns the deduplication key of the alerts of the step of the
+// workflow, or of the workflow itself if step is empty
+func alertKey(workflow string, step string) string {
+	if step == "" {
+		return fmt.Sprintf("trackman:%s", workflow)
+	}
+
+	return fmt.Sprintf("trackman:%s:%s", workflow, step)
+}
+
+func truncate(value string, length int) string {
+	if len(value) <= length {
+		return value
+	}
+
+	return value[:length-3] + "..."
+}
@@ -0,0 +1,76 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+// ChatEvents are the events the Teams and Discord notifiers post the
+// summary of the run for
+var ChatEvents = []string{utils.EventWorkflowSuccess, utils.EventWorkflowFail}
+
+// defaultChatOutputLines is the number of lines of output of the failed
+// steps posted to a chat
+const defaultChatOutputLines = 10
+
+// chatTitle returns the title of the summary of the run
+func chatTitle(workflow string, result *utils.WorkflowResult) string {
+	return fmt.Sprintf("Workflow %s finished with %s in %s", workflow, result.Outcome, result.Duration.Round(time.Millisecond))
+}
+
+// chatSucceeded returns true if the run is shown as successful
+func chatSucceeded(result *utils.WorkflowResult) bool {
+	return result.Outcome == utils.OutcomeSuccess
+}
+
+// chatStep returns the status of the step as posted to a chat
+func chatStep(step *utils.StepResult) string {
+	if step.Duration == 0 {
+		return step.Status
+	}
+
+	return fmt.Sprintf("%s in %s", step.Status, step.Duration.Round(time.Millisecond))
+}
+
+// chatOutput returns the last lines of the output of the step, masked
+func chatOutput(step *utils.StepResult, lines int) string {
+	output := step.Output
+	if len(output) > lines {
+		output = output[len(output)-lines:]
+	}
+
+	return utils.MaskSecrets(strings.Join(output, "\n"))
+}
+
+// postJSON posts the body as JSON to the URL and fails if it's not accepted
+func postJSON(ctx context.Context, client *http.Client, url string, body interface{}) error {
+	buff, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(buff))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	return nil
+}
@@ -2,6 +2,8 @@ package notifiers
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	"github.com/cloud66-oss/trackman/utils"
 	"github.com/sirupsen/logrus"
@@ -9,23 +11,74 @@ import (
 
 // ConsoleNotify writes notifications to console
 func ConsoleNotify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	// only step events are written to console
+	if event.Payload.Spinner == nil {
+		return nil
+	}
+
+	// the event on the context is used by structured logs
+	entry := logger.WithContext(context.WithValue(ctx, utils.CtxEvent, event)).WithField(utils.FldStep, event.Payload.Spinner.Name)
+
 	switch event.Name {
 	case utils.EventRunRequested:
-		logger.WithField(utils.FldStep, event.Payload.Spinner.Name).Info("Starting")
+		entry.Info("Starting")
 	case utils.EventRunStarted:
-		logger.WithField(utils.FldStep, event.Payload.Spinner.Name).Debug("Running")
+		entry.Debug("Running")
 	case utils.EventRunSuccess:
-		logger.WithField(utils.FldStep, event.Payload.Spinner.Name).Info("Successfully finished")
+		entry.Info("Successfully finished")
 	case utils.EventRunError:
-		logger.WithField(utils.FldStep, event.Payload.Spinner.Name).Error("Failed to run")
+		entry.Error("Failed to run")
 	case utils.EventRunFail:
-		logger.WithField(utils.FldStep, event.Payload.Spinner.Name).Errorf("Finished with error %v", event.Payload.Extras)
+		fail := event.Payload.Extras.(*utils.RunFailPayload)
+		switch {
+		case fail.Quorum != nil:
+			entry.Errorf("Only %d of %d steps succeeded, %d needed", len(fail.Quorum.Succeeded), len(fail.Quorum.Succeeded)+len(fail.Quorum.Failed), fail.Quorum.Required)
+		case fail.OOMKilled:
+			entry.Error("Killed for running out of memory")
+		case fail.Signal != "":
+			entry.Errorf("Killed by signal %s", fail.Signal)
+		case fail.Outcome != "":
+			entry.Errorf("Finished with error %s", fail.Outcome)
+		default:
+			entry.Errorf("Finished with error %d", fail.ExitCode)
+		}
 	case utils.EventRunTimeout:
-		logger.WithField(utils.FldStep, event.Payload.Spinner.Name).Error("Timed out")
+		entry.Errorf("Timed out after %s", event.Payload.Extras.(*utils.TimeoutPayload).Limit)
 	case utils.EventRunWaitError:
-		logger.WithField(utils.FldStep, event.Payload.Spinner.Name).Error("Error during wait")
+		entry.Errorf("Error during wait: %s", event.Payload.Extras.(*utils.ErrorPayload).Error)
+	case utils.EventRunRetry:
+		attempt := event.Payload.Extras.(*utils.RetryAttempt)
+		entry.Warnf("Retrying in %s (attempt %d of %d)", attempt.Delay, attempt.Attempt, attempt.MaxAttempts)
+	case utils.EventRunCancelled:
+		entry.Warn("Cancelled")
+	case utils.EventRunStalled:
+		entry.Warnf("No output or CPU progress for %s", event.Payload.Extras.(*utils.StalledPayload).StallAfter)
+	case utils.EventRunProgress:
+		entry.Debugf("Progress %.0f%%", event.Payload.Extras.(*utils.ProgressPayload).Progress*100)
+	case utils.EventRunUsage:
+		entry.Debugf("Used %s", event.Payload.Extras.(*utils.ResourceUsage))
+	case utils.EventRunLimitExceeded:
+		exceeded := event.Payload.Extras.(*utils.LimitExceeded)
+		entry.Errorf("Killed for going over its %s limit of %s", exceeded.Resource, exceeded.Limit)
+	case utils.EventRunDeadline:
+		entry.Errorf("Stopped at its deadline %s", event.Payload.Extras.(*utils.DeadlinePayload).Deadline.Format(time.RFC3339))
+	case utils.EventRunDeadlineMissed:
+		entry.Warnf("Deadline %s passed before starting", event.Payload.Extras.(*utils.DeadlinePayload).Deadline.Format(time.RFC3339))
+	case utils.EventRunDegraded:
+		quorum := event.Payload.Extras.(*utils.QuorumPayload)
+		entry.Warnf("Degraded: %d of %d steps succeeded (%s failed)", len(quorum.Succeeded), len(quorum.Succeeded)+len(quorum.Failed), strings.Join(quorum.Failed, ", "))
+	case utils.EventRunGenerated:
+		entry.Infof("Generated %s", strings.Join(event.Payload.Extras.(*utils.GeneratedPayload).Steps, ", "))
+	case utils.EventRunSkipped:
+		entry.Info("Skipped")
+	case utils.EventRunCacheHit:
+		entry.Infof("Cached. Skipping (ran in session %s)", event.Payload.Extras.(*utils.CacheEntry).SessionID)
 	case utils.EventRunningProbe:
-		logger.WithField(utils.FldStep, event.Payload.Spinner.Name).Debug("Running a probe")
+		entry.Debug("Running a probe")
+	case utils.EventServiceReady:
+		entry.Info("Running in the background")
+	case utils.EventServiceStopped:
+		entry.Info("Service stopped")
 	}
 
 	return nil
@@ -0,0 +1,35 @@
+//go:build darwin
+// +build darwin
+
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// desktopAvailable checks osascript can be run
+func desktopAvailable() error {
+	if _, err := exec.LookPath("osascript"); err != nil {
+		return fmt.Errorf("desktop notifications need osascript: %s", err)
+	}
+
+	return nil
+}
+
+// desktopNotify shows the notification in the notification center. The
+// title and message are passed as arguments so they don't need quoting
+func desktopNotify(ctx context.Context, title string, message string, urgent bool) error {
+	script := "display notification (item 2 of argv) with title (item 1 of argv)"
+	if urgent {
+		script += ` sound name "Basso"`
+	}
+
+	output, err := exec.CommandContext(ctx, "osascript", "-e", "on run argv", "-e", script, "-e", "end run", title, message).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to show the desktop notification: %s %s", err, output)
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// DesktopEvents are the events the desktop notifier needs
+var DesktopEvents = []string{
+	utils.EventRunFail,
+	utils.EventRunError,
+	utils.EventRunTimeout,
+	utils.EventWorkflowSuccess,
+	utils.EventWorkflowFail,
+}
+
+// DesktopNotifier shows a desktop notification when a workflow finishes and
+// when its first step fails, using the notification center on macOS,
+// libnotify (notify-send) on Linux and toasts on Windows
+type DesktopNotifier struct {
+	mu sync.Mutex
+	// failed are the sessions whose first failure was shown
+	failed map[string]bool
+}
+
+// NewDesktopNotifier creates a new DesktopNotifier. It fails if the tool to
+// show the notifications isn't installed
+func NewDesktopNotifier() (*DesktopNotifier, error) {
+	if err := desktopAvailable(); err != nil {
+		return nil, err
+	}
+
+	return &DesktopNotifier{
+		failed: make(map[string]bool),
+	}, nil
+}
+
+// Notify implements utils.Notifier
+func (n *DesktopNotifier) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	if event.Payload.Workflow == nil {
+		return nil
+	}
+	workflow := event.Payload.Workflow.Name()
+	session := event.Payload.Workflow.SessionID()
+
+	if result, ok := event.Payload.Extras.(*utils.WorkflowResult); ok {
+		n.mu.Lock()
+		delete(n.failed, session)
+		n.mu.Unlock()
+
+		message := fmt.Sprintf("Finished with %s in %s", result.Outcome, result.Duration.Round(time.Millisecond))
+		if failed := len(result.Failed()); failed != 0 {
+			message = fmt.Sprintf("%s. %d of %d steps failed", message, failed, len(result.Steps))
+		}
+
+		return desktopNotify(ctx, "Trackman: "+workflow, event.Payload.Workflow.MaskSecrets(message), !chatSucceeded(result))
+	}
+
+	if event.Payload.Spinner == nil {
+		return nil
+	}
+
+	// only the first failure is shown so a failing workflow doesn't flood
+	// the desktop
+	n.mu.Lock()
+	first := !n.failed[session]
+	n.failed[session] = true
+	n.mu.Unlock()
+	if !first {
+		return nil
+	}
+
+	message := fmt.Sprintf("Step %s failed", event.Payload.Spinner.Name)
+	switch extras := event.Payload.Extras.(type) {
+	case *utils.RunFailPayload:
+		if extras.Signal == "" && extras.Outcome == "" {
+			message = fmt.Sprintf("%s with exit code %d", message, extras.ExitCode)
+		}
+	case *utils.TimeoutPayload:
+		message = fmt.Sprintf("Step %s timed out after %s", event.Payload.Spinner.Name, extras.Limit)
+	}
+
+	return desktopNotify(ctx, "Trackman: "+workflow, event.Payload.Workflow.MaskSecrets(message), true)
+}
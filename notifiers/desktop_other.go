@@ -0,0 +1,35 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// desktopAvailable checks notify-send from libnotify can be run
+func desktopAvailable() error {
+	if _, err := exec.LookPath("notify-send"); err != nil {
+		return fmt.Errorf("desktop notifications need notify-send from libnotify: %s", err)
+	}
+
+	return nil
+}
+
+// desktopNotify shows the notification with notify-send. Urgent ones stay
+// until they are dismissed
+func desktopNotify(ctx context.Context, title string, message string, urgent bool) error {
+	urgency := "normal"
+	if urgent {
+		urgency = "critical"
+	}
+
+	output, err := exec.CommandContext(ctx, "notify-send", "--app-name", "trackman", "--urgency", urgency, "--", title, message).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to show the desktop notification: %s %s", err, output)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+//go:build windows
+// +build windows
+
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// desktopAppID is the id of PowerShell, which toasts can be shown for
+// without registering an app
+const desktopAppID = `{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe`
+
+// desktopScript shows a toast with the title and message in the
+// environment, so they don't need quoting
+const desktopScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode($env:TRACKMAN_TITLE)) > $null
+$text.Item(1).AppendChild($template.CreateTextNode($env:TRACKMAN_MESSAGE)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($env:TRACKMAN_APP_ID).Show([Windows.UI.Notifications.ToastNotification]::new($template))`
+
+// desktopAvailable checks PowerShell can be run
+func desktopAvailable() error {
+	if _, err := exec.LookPath("powershell.exe"); err != nil {
+		return fmt.Errorf("desktop notifications need powershell: %s", err)
+	}
+
+	return nil
+}
+
+// desktopNotify shows the notification as a toast. Windows shows all toasts
+// the same way so urgent isn't used
+func desktopNotify(ctx context.Context, title string, message string, urgent bool) error {
+	cmd := exec.CommandContext(ctx, "powershell.exe", "-NoProfile", "-NonInteractive", "-Command", desktopScript)
+	cmd.Env = append(os.Environ(), "TRACKMAN_TITLE="+title, "TRACKMAN_MESSAGE="+message, "TRACKMAN_APP_ID="+desktopAppID)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to show the desktop notification: %s %s", err, output)
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package notifiers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	discordGreen = 0x2eb886
+	discordRed   = 0xe01e5a
+
+	// limits of the Discord embeds
+	discordMaxFields     = 25
+	discordMaxFieldValue = 1024
+	discordMaxTitle      = 256
+)
+
+// DiscordOptions configures a DiscordNotifier
+type DiscordOptions struct {
+	// WebhookURL is the URL of the webhook of the channel
+	WebhookURL string
+	// Username overrides the name the webhook posts as
+	Username string
+	// OutputLines is the number of lines of output of each failed step to
+	// post. Defaults to 10
+	OutputLines int
+}
+
+// DiscordNotifier posts the summary of each finished workflow to a Discord
+// channel as an embed
+type DiscordNotifier struct {
+	options *DiscordOptions
+	client  *http.Client
+}
+
+// NewDiscordNotifier creates a new DiscordNotifier
+func NewDiscordNotifier(options *DiscordOptions) (*DiscordNotifier, error) {
+	if options.WebhookURL == "" {
+		return nil, errors.New("discord needs a webhook url")
+	}
+	if options.OutputLines == 0 {
+		options.OutputLines = defaultChatOutputLines
+	}
+
+	return &DiscordNotifier{
+		options: options,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Notify implements utils.Notifier
+func (n *DiscordNotifier) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	result, ok := event.Payload.Extras.(*utils.WorkflowResult)
+	if !ok || event.Payload.Workflow == nil {
+		return nil
+	}
+
+	body := map[string]interface{}{
+		"embeds": []map[string]interface{}{n.embed(event.Payload.Workflow.Name(), result)},
+	}
+	if n.options.Username != "" {
+		body["username"] = n.options.Username
+	}
+
+	return postJSON(ctx, n.client, n.options.WebhookURL, body)
+}
+
+// embed returns the embed with the summary of the run. Steps are fields
+// with the output of the failed ones, as many as Discord allows
+func (n *DiscordNotifier) embed(workflow string, result *utils.WorkflowResult) map[string]interface{} {
+	color := discordGreen
+	if !chatSucceeded(result) {
+		color = discordRed
+	}
+
+	description := fmt.Sprintf("Session `%s`", result.SessionID)
+	if result.CancelReason != "" {
+		description += "\nCancelled: " + result.MaskSecrets(result.CancelReason)
+	}
+
+	var fields []map[string]interface{}
+	for _, step := range result.Steps {
+		value := chatStep(step)
+		if output := chatOutput(step, n.options.OutputLines); step.Status == utils.ResultFailed && output != "" {
+			// keep the end of the output, it's where the error usually is
+			limit := discordMaxFieldValue - len(value) - len("\n```\n\n```")
+			if len(output) > limit {
+				output = output[len(output)-limit:]
+			}
+			value += "\n```\n" + output + "\n```"
+		}
+
+		fields = append(fields, map[string]interface{}{
+			"name":   step.Name,
+			"value":  value,
+			"inline": step.Status != utils.ResultFailed,
+		})
+	}
+	if len(fields) > discordMaxFields {
+		hidden := len(fields) - discordMaxFields + 1
+		fields = append(fields[:discordMaxFields-1], map[string]interface{}{
+			"name":  "...",
+			"value": fmt.Sprintf("%d more steps", hidden),
+		})
+	}
+
+	return map[string]interface{}{
+		"title":       truncate(chatTitle(workflow, result), discordMaxTitle),
+		"description": description,
+		"color":       color,
+		"fields":      fields,
+		"timestamp":   result.FinishedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,233 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EmailStartTLS upgrades the connection to the SMTP server with STARTTLS
+	EmailStartTLS = "starttls"
+	// EmailTLS connects to the SMTP server over TLS, usually on port 465
+	EmailTLS = "tls"
+	// EmailNoTLS doesn't encrypt the connection to the SMTP server
+	EmailNoTLS = "none"
+
+	// EmailDefaultSubject is the subject of the email unless set in the options
+	EmailDefaultSubject = "[trackman] {{ .Workflow }} finished with {{ .Result.Outcome }}"
+	// EmailDefaultBody is the body of the email unless set in the options
+	EmailDefaultBody = `Workflow {{ .Workflow }} ({{ .Result.SessionID }}) finished with {{ .Result.Outcome }} in {{ .Result.Duration }}
+{{ with .Result.CancelReason }}
+Cancelled: {{ . }}
+{{ end }}
+{{ .Summary }}
+{{- range .Failed }}
+--- {{ .Name }} failed with exit code {{ .ExitCode }} ---
+{{ range .Output }}{{ . }}
+{{ end }}{{ end }}`
+)
+
+// EmailEvents are the events the email notifier needs
+var EmailEvents = []string{utils.EventWorkflowSuccess, utils.EventWorkflowFail}
+
+// EmailOptions configures an EmailNotifier
+type EmailOptions struct {
+	Host string
+	// Port defaults to 465 with TLS and 587 otherwise
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+	// TLS is starttls, tls or none. Defaults to starttls
+	TLS string
+	// Subject and Body are Golang templates rendered with an EmailSummary.
+	// They default to EmailDefaultSubject and EmailDefaultBody
+	Subject string
+	Body    string
+}
+
+// EmailSummary is what the subject and body templates are rendered with
+type EmailSummary struct {
+	Workflow string
+	Result   *utils.WorkflowResult
+	// Summary is a table of the steps with their status and duration
+	Summary string
+	// Failed are the failed steps with the end of their output
+	Failed []*utils.StepResult
+}
+
+// EmailNotifier sends an email with the summary of each finished workflow
+type EmailNotifier struct {
+	options *EmailOptions
+	subject *template.Template
+	body    *template.Template
+}
+
+// NewEmailNotifier creates a new EmailNotifier
+func NewEmailNotifier(options *EmailOptions) (*EmailNotifier, error) {
+	if options.Host == "" || options.From == "" || len(options.To) == 0 {
+		return nil, errors.New("email needs a host, a from address and at least one to address")
+	}
+
+	switch options.TLS {
+	case "":
+		options.TLS = EmailStartTLS
+	case EmailStartTLS, EmailTLS, EmailNoTLS:
+	default:
+		return nil, fmt.Errorf("invalid email tls %s. Valid values are %s, %s and %s", options.TLS, EmailStartTLS, EmailTLS, EmailNoTLS)
+	}
+	if options.Port == 0 {
+		options.Port = 587
+		if options.TLS == EmailTLS {
+			options.Port = 465
+		}
+	}
+	if options.Subject == "" {
+		options.Subject = EmailDefaultSubject
+	}
+	if options.Body == "" {
+		options.Body = EmailDefaultBody
+	}
+
+	subject, err := template.New("subject").Parse(options.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email subject: %s", err)
+	}
+	body, err := template.New("body").Parse(options.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email body: %s", err)
+	}
+
+	return &EmailNotifier{
+		options: options,
+		subject: subject,
+		body:    body,
+	}, nil
+}
+
+// Notify implements utils.Notifier
+func (n *EmailNotifier) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	result, ok := event.Payload.Extras.(*utils.WorkflowResult)
+	if !ok || event.Payload.Workflow == nil {
+		return nil
+	}
+
+	summary := &EmailSummary{
+		Workflow: event.Payload.Workflow.Name(),
+		Result:   result,
+		Summary:  emailTable(result),
+		Failed:   result.Failed(),
+	}
+
+	subject := &bytes.Buffer{}
+	if err := n.subject.Execute(subject, summary); err != nil {
+		return err
+	}
+	body := &bytes.Buffer{}
+	if err := n.body.Execute(body, summary); err != nil {
+		return err
+	}
+
+	return n.send(event.Payload.Workflow.MaskSecrets(strings.TrimSpace(subject.String())), event.Payload.Workflow.MaskSecrets(body.String()))
+}
+
+// emailTable returns the steps of the result as a table
+func emailTable(result *utils.WorkflowResult) string {
+	buff := &bytes.Buffer{}
+	writer := tabwriter.NewWriter(buff, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "STEP\tSTATUS\tDURATION\tEXIT CODE")
+	for _, step := range result.Steps {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%d\n", step.Name, step.Status, step.Duration.Round(time.Millisecond), step.ExitCode)
+	}
+	_ = writer.Flush()
+
+	return buff.String()
+}
+
+// send sends the email to all the recipients
+func (n *EmailNotifier) send(subject string, body string) error {
+	addr := net.JoinHostPort(n.options.Host, strconv.Itoa(n.options.Port))
+	tlsConfig := &tls.Config{ServerName: n.options.Host}
+
+	var conn net.Conn
+	var err error
+	if n.options.TLS == EmailTLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, 10*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	// the whole conversation has to finish in time
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	client, err := smtp.NewClient(conn, n.options.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if n.options.TLS == EmailStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s doesn't support STARTTLS. Set the email tls to none to send the emails unencrypted", n.options.Host)
+		}
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if n.options.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", n.options.Username, n.options.Password, n.options.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(n.options.From); err != nil {
+		return err
+	}
+	for _, to := range n.options.To {
+		if err = client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	message := &bytes.Buffer{}
+	fmt.Fprintf(message, "From: %s\r\n", n.options.From)
+	fmt.Fprintf(message, "To: %s\r\n", strings.Join(n.options.To, ", "))
+	fmt.Fprintf(message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(message, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	if _, err = writer.Write(message.Bytes()); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
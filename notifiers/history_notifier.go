@@ -0,0 +1,25 @@
+package notifiers
+
+import (
+	"context"
+
+	"github.com/cloud66-oss/trackman/history"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// HistoryEvents are the events the history notifier needs
+var HistoryEvents = []string{utils.EventWorkflowSuccess, utils.EventWorkflowFail}
+
+// NewHistoryNotifier returns a notifier saving the result of each finished
+// workflow to the store
+func NewHistoryNotifier(store history.RunStore) utils.Notifier {
+	return func(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+		result, ok := event.Payload.Extras.(*utils.WorkflowResult)
+		if !ok {
+			return nil
+		}
+
+		return store.Save(history.NewRun(event.Payload.Workflow.Name(), result))
+	}
+}
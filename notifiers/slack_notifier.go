@@ -0,0 +1,289 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/cloud66-oss/trackman/outbox"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+// SlackDefaultTemplates are the messages sent to Slack for each event
+var SlackDefaultTemplates = map[string]string{
+	utils.EventWorkflowStarted:  "Workflow {{ .Payload.Workflow.SessionID }} started",
+	utils.EventWorkflowSuccess:  "Workflow {{ .Payload.Workflow.SessionID }} finished with {{ .Payload.Extras.Outcome }} in {{ .Payload.Extras.Duration }}",
+	utils.EventWorkflowFail:     "Workflow {{ .Payload.Workflow.SessionID }} finished with {{ .Payload.Extras.Outcome }} in {{ .Payload.Extras.Duration }}",
+	utils.EventWorkflowTimeout:  "Workflow {{ .Payload.Workflow.SessionID }} timed out after {{ .Payload.Extras.Limit }}",
+	utils.EventWorkflowPaused:   "Workflow {{ .Payload.Workflow.SessionID }} is paused",
+	utils.EventWorkflowResumed:  "Workflow {{ .Payload.Workflow.SessionID }} resumed",
+	utils.EventRunFail:          "Step {{ .Payload.Spinner.Name }} failed",
+	utils.EventRunError:         "Step {{ .Payload.Spinner.Name }} failed to run",
+	utils.EventRunTimeout:       "Step {{ .Payload.Spinner.Name }} timed out",
+	utils.EventRollbackStarted:  "Workflow {{ .Payload.Workflow.SessionID }} is rolling back",
+	utils.EventRollbackFinished: "Workflow {{ .Payload.Workflow.SessionID }} rolled back {{ len .Payload.Extras.RolledBack }} steps{{ with .Payload.Extras.Failed }}. Failed to roll back {{ . }}{{ end }}",
+}
+
+// SlackOptions configures a SlackNotifier. Either WebhookURL or Token and
+// Channel should be set. Messages are only threaded per workflow run when
+// using a Token
+type SlackOptions struct {
+	WebhookURL string
+	Token      string
+	Channel    string
+	// Templates are Golang templates of the messages by event name, rendered
+	// with the event. Defaults to SlackDefaultTemplates
+	Templates map[string]string
+	// MaxMessages is the maximum number of step messages sent in each
+	// Interval. Workflow messages are always sent
+	MaxMessages int
+	Interval    time.Duration
+}
+
+// SlackNotifier posts workflow and step failure events to Slack
+type SlackNotifier struct {
+	options    *SlackOptions
+	templates  map[string]*template.Template
+	client     *http.Client
+	threads    map[string]string
+	sent       int
+	suppressed int
+	windowEnds time.Time
+	signal     *sync.Mutex
+}
+
+type slackMessage struct {
+	Channel  string `json:"channel,omitempty"`
+	Text     string `json:"text"`
+	ThreadTS string `json:"thread_ts,omitempty"`
+}
+
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+	TS    string `json:"ts"`
+}
+
+// NewSlackNotifier creates a new SlackNotifier
+func NewSlackNotifier(options *SlackOptions) (*SlackNotifier, error) {
+	if options.WebhookURL == "" && (options.Token == "" || options.Channel == "") {
+		return nil, errors.New("slack needs a webhook url or a token and a channel")
+	}
+
+	if options.Templates == nil {
+		options.Templates = SlackDefaultTemplates
+	}
+	if options.MaxMessages == 0 {
+		options.MaxMessages = 20
+	}
+	if options.Interval == 0 {
+		options.Interval = time.Minute
+	}
+
+	templates := make(map[string]*template.Template, len(options.Templates))
+	for name, value := range options.Templates {
+		tmpl, err := template.New(name).Parse(value)
+		if err != nil {
+			return nil, err
+		}
+
+		templates[name] = tmpl
+	}
+
+	return &SlackNotifier{
+		options:   options,
+		templates: templates,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		threads:   make(map[string]string),
+		signal:    &sync.Mutex{},
+	}, nil
+}
+
+// Notify implements utils.Notifier
+func (n *SlackNotifier) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	text, err := n.render(event)
+	if err != nil || text == "" {
+		return err
+	}
+
+	// not using the context of the workflow so notifications of a cancelled
+	// workflow are still sent
+	err = n.post(context.Background(), eventSessionID(event), text)
+	if event.Name == utils.EventWorkflowSuccess || event.Name == utils.EventWorkflowFail {
+		n.endThread(eventSessionID(event))
+	}
+
+	return err
+}
+
+// Messages implements outbox.Transport
+func (n *SlackNotifier) Messages(event *utils.Event) ([]*outbox.Message, error) {
+	text, err := n.render(event)
+	if err != nil || text == "" {
+		return nil, err
+	}
+
+	return []*outbox.Message{{
+		Event:     event.Name,
+		EventUUID: event.Payload.EventUUID,
+		SessionID: eventSessionID(event),
+		Body:      []byte(text),
+	}}, nil
+}
+
+// Send implements outbox.Transport
+func (n *SlackNotifier) Send(ctx context.Context, message *outbox.Message) error {
+	if err := n.post(ctx, message.SessionID, string(message.Body)); err != nil {
+		return err
+	}
+
+	// the last message of the run is retried in the thread until it's sent
+	if message.Event == utils.EventWorkflowSuccess || message.Event == utils.EventWorkflowFail {
+		n.endThread(message.SessionID)
+	}
+
+	return nil
+}
+
+// endThread forgets the thread of a finished run, so threads aren't kept
+// for all the runs of a long running trackman
+func (n *SlackNotifier) endThread(sessionID string) {
+	n.signal.Lock()
+	defer n.signal.Unlock()
+
+	delete(n.threads, sessionID)
+}
+
+// render returns the text of the message for the event, or empty if it isn't
+// sent
+func (n *SlackNotifier) render(event *utils.Event) (string, error) {
+	tmpl, ok := n.templates[event.Name]
+	if !ok {
+		return "", nil
+	}
+
+	isWorkflowEvent := event.Payload.Spinner == nil
+	if !isWorkflowEvent && !n.allow() {
+		return "", nil
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, event); err != nil {
+		return "", err
+	}
+
+	text := event.Payload.Workflow.MaskSecrets(buf.String())
+	if event.Name == utils.EventWorkflowSuccess || event.Name == utils.EventWorkflowFail {
+		if suppressed := n.resetSuppressed(); suppressed != 0 {
+			text = fmt.Sprintf("%s (%d notifications were not sent to avoid flooding)", text, suppressed)
+		}
+	}
+
+	return text, nil
+}
+
+func eventSessionID(event *utils.Event) string {
+	if event.Payload.Workflow == nil {
+		return ""
+	}
+
+	return event.Payload.Workflow.SessionID()
+}
+
+// allow applies the rate limit and counts the messages that are not sent
+func (n *SlackNotifier) allow() bool {
+	n.signal.Lock()
+	defer n.signal.Unlock()
+
+	now := time.Now()
+	if now.After(n.windowEnds) {
+		n.windowEnds = now.Add(n.options.Interval)
+		n.sent = 0
+	}
+
+	if n.sent >= n.options.MaxMessages {
+		n.suppressed++
+		return false
+	}
+
+	n.sent++
+	return true
+}
+
+func (n *SlackNotifier) resetSuppressed() int {
+	n.signal.Lock()
+	defer n.signal.Unlock()
+
+	suppressed := n.suppressed
+	n.suppressed = 0
+
+	return suppressed
+}
+
+func (n *SlackNotifier) post(ctx context.Context, sessionID string, text string) error {
+	message := &slackMessage{Text: text}
+	url := n.options.WebhookURL
+	if n.options.Token != "" {
+		url = slackPostMessageURL
+		message.Channel = n.options.Channel
+
+		n.signal.Lock()
+		message.ThreadTS = n.threads[sessionID]
+		n.signal.Unlock()
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if n.options.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.options.Token)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack returned %s", resp.Status)
+	}
+
+	// webhooks only return ok, the api returns the message details
+	if n.options.Token == "" {
+		return nil
+	}
+
+	var result slackResponse
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.OK {
+		return fmt.Errorf("slack returned %s", result.Error)
+	}
+
+	// the first message of each run starts the thread for the rest
+	n.signal.Lock()
+	if _, ok := n.threads[sessionID]; !ok && sessionID != "" {
+		n.threads[sessionID] = result.TS
+	}
+	n.signal.Unlock()
+
+	return nil
+}
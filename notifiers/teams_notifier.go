@@ -0,0 +1,134 @@
+package notifiers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// TeamsOptions configures a TeamsNotifier
+type TeamsOptions struct {
+	// WebhookURL is the URL of the incoming webhook, or of the workflow
+	// posting to the channel
+	WebhookURL string
+	// OutputLines is the number of lines of output of each failed step to
+	// post. Defaults to 10
+	OutputLines int
+}
+
+// TeamsNotifier posts the summary of each finished workflow to a Microsoft
+// Teams channel as an adaptive card
+type TeamsNotifier struct {
+	options *TeamsOptions
+	client  *http.Client
+}
+
+// NewTeamsNotifier creates a new TeamsNotifier
+func NewTeamsNotifier(options *TeamsOptions) (*TeamsNotifier, error) {
+	if options.WebhookURL == "" {
+		return nil, errors.New("teams needs a webhook url")
+	}
+	if options.OutputLines == 0 {
+		options.OutputLines = defaultChatOutputLines
+	}
+
+	return &TeamsNotifier{
+		options: options,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Notify implements utils.Notifier
+func (n *TeamsNotifier) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	result, ok := event.Payload.Extras.(*utils.WorkflowResult)
+	if !ok || event.Payload.Workflow == nil {
+		return nil
+	}
+
+	return postJSON(ctx, n.client, n.options.WebhookURL, map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{
+			{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content":     n.card(event.Payload.Workflow.Name(), result),
+			},
+		},
+	})
+}
+
+// card returns the adaptive card with the summary of the run
+func (n *TeamsNotifier) card(workflow string, result *utils.WorkflowResult) map[string]interface{} {
+	color := "Good"
+	if !chatSucceeded(result) {
+		color = "Attention"
+	}
+
+	facts := []map[string]string{
+		{"title": "Session", "value": result.SessionID},
+	}
+	if result.CancelReason != "" {
+		facts = append(facts, map[string]string{"title": "Cancelled", "value": result.MaskSecrets(result.CancelReason)})
+	}
+
+	steps := make([]map[string]string, len(result.Steps))
+	for idx, step := range result.Steps {
+		steps[idx] = map[string]string{"title": step.Name, "value": chatStep(step)}
+	}
+
+	body := []map[string]interface{}{
+		{
+			"type":   "TextBlock",
+			"text":   chatTitle(workflow, result),
+			"size":   "Medium",
+			"weight": "Bolder",
+			"color":  color,
+			"wrap":   true,
+		},
+		{"type": "FactSet", "facts": facts},
+		{"type": "FactSet", "facts": steps, "separator": true},
+	}
+
+	for _, step := range result.Failed() {
+		body = append(body, map[string]interface{}{
+			"type":      "TextBlock",
+			"text":      step.Name + " failed",
+			"weight":    "Bolder",
+			"color":     "Attention",
+			"separator": true,
+		})
+		// Teams doesn't always keep the line breaks of a text block so
+		// each line of the output is a block of its own
+		output := chatOutput(step, n.options.OutputLines)
+		if output == "" {
+			continue
+		}
+		var lines []map[string]interface{}
+		for _, line := range strings.Split(output, "\n") {
+			lines = append(lines, map[string]interface{}{
+				"type":     "TextBlock",
+				"text":     line,
+				"fontType": "Monospace",
+				"spacing":  "None",
+				"wrap":     true,
+			})
+		}
+		body = append(body, map[string]interface{}{
+			"type":  "Container",
+			"style": "emphasis",
+			"items": lines,
+		})
+	}
+
+	return map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+		"msteams": map[string]string{"width": "Full"},
+	}
+}
@@ -0,0 +1,327 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/outbox"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// WebhookSignatureHeader holds the HMAC-SHA256 signature of the body
+	WebhookSignatureHeader = "X-Trackman-Signature"
+	// WebhookEventHeader holds the name of the event
+	WebhookEventHeader = "X-Trackman-Event"
+	// WebhookDeliveryHeader holds the UUID of the event
+	WebhookDeliveryHeader = "X-Trackman-Delivery"
+)
+
+// WebhookOptions configures a WebhookNotifier
+type WebhookOptions struct {
+	URLs    []string
+	Headers map[string]string
+	// Secret signs each body with HMAC-SHA256 when set
+	Secret string
+	// MaxAttempts is the number of times a delivery is tried before it is dropped
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for every retry
+	// up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// QueueSize is the number of events waiting to be delivered to each URL
+	// before new ones are dropped
+	QueueSize int
+}
+
+// WebhookNotifier posts events as JSON to URLs. Events are queued and
+// delivered in the background so slow endpoints don't hold up the steps
+type WebhookNotifier struct {
+	options *WebhookOptions
+	client  *http.Client
+	queues  map[string]chan *webhookDelivery
+	done    chan struct{}
+	joiner  *sync.WaitGroup
+	closed  bool
+	signal  *sync.Mutex
+}
+
+// WebhookEvent is the body posted to the webhooks
+type WebhookEvent struct {
+	// SchemaVersion is the version of the event and its extras
+	SchemaVersion string          `json:"schema_version"`
+	Name          string          `json:"name"`
+	EventUUID     string          `json:"event_uuid"`
+	SessionID     string          `json:"session_id,omitempty"`
+	Step          *utils.Step     `json:"step,omitempty"`
+	Extras        utils.EventData `json:"extras,omitempty"`
+	Timestamp     time.Time       `json:"timestamp"`
+}
+
+type webhookDelivery struct {
+	event  string
+	uuid   string
+	body   []byte
+	logger *logrus.Logger
+}
+
+// NewWebhookNotifier creates a new WebhookNotifier and starts delivering
+// to its URLs
+func NewWebhookNotifier(options *WebhookOptions) (*WebhookNotifier, error) {
+	if len(options.URLs) == 0 {
+		return nil, errors.New("webhook needs at least one url")
+	}
+
+	if options.MaxAttempts == 0 {
+		options.MaxAttempts = 5
+	}
+	if options.Backoff == 0 {
+		options.Backoff = time.Second
+	}
+	if options.MaxBackoff == 0 {
+		options.MaxBackoff = 30 * time.Second
+	}
+	if options.QueueSize == 0 {
+		options.QueueSize = 100
+	}
+
+	notifier := &WebhookNotifier{
+		options: options,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queues:  make(map[string]chan *webhookDelivery, len(options.URLs)),
+		done:    make(chan struct{}),
+		joiner:  &sync.WaitGroup{},
+		signal:  &sync.Mutex{},
+	}
+
+	// each url has its own queue so a slow one doesn't hold up the others
+	for _, url := range options.URLs {
+		queue := make(chan *webhookDelivery, options.QueueSize)
+		notifier.queues[url] = queue
+
+		notifier.joiner.Add(1)
+		go notifier.deliver(url, queue)
+	}
+
+	return notifier, nil
+}
+
+// Notify implements utils.Notifier
+func (n *WebhookNotifier) Notify(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+	body, err := webhookBody(event)
+	if err != nil {
+		return err
+	}
+
+	delivery := &webhookDelivery{
+		event:  event.Name,
+		uuid:   event.Payload.EventUUID,
+		body:   body,
+		logger: logger,
+	}
+
+	n.signal.Lock()
+	defer n.signal.Unlock()
+
+	if n.closed {
+		return errors.New("webhook notifier is closed")
+	}
+
+	var dropped []string
+	for url, queue := range n.queues {
+		select {
+		case queue <- delivery:
+		default:
+			dropped = append(dropped, url)
+		}
+	}
+	if len(dropped) != 0 {
+		return fmt.Errorf("webhook queue is full. %s event dropped for %v", event.Name, dropped)
+	}
+
+	return nil
+}
+
+// Messages implements outbox.Transport with a message for each URL
+func (n *WebhookNotifier) Messages(event *utils.Event) ([]*outbox.Message, error) {
+	body, err := webhookBody(event)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*outbox.Message, 0, len(n.options.URLs))
+	for _, url := range n.options.URLs {
+		messages = append(messages, &outbox.Message{
+			Target:    url,
+			Event:     event.Name,
+			EventUUID: event.Payload.EventUUID,
+			Body:      body,
+		})
+	}
+
+	return messages, nil
+}
+
+// Send implements outbox.Transport. The retries are left to the outbox
+func (n *WebhookNotifier) Send(ctx context.Context, message *outbox.Message) error {
+	retry, err := n.post(ctx, message.Target, &webhookDelivery{
+		event: message.Event,
+		uuid:  message.EventUUID,
+		body:  message.Body,
+	})
+	if err != nil && !retry {
+		return &outbox.PermanentError{Err: err}
+	}
+
+	return err
+}
+
+// Close stops accepting events and waits for the queued ones to be delivered.
+// Deliveries still pending after timeout are dropped
+func (n *WebhookNotifier) Close(timeout time.Duration) {
+	n.signal.Lock()
+	if n.closed {
+		n.signal.Unlock()
+		return
+	}
+	n.closed = true
+	for _, queue := range n.queues {
+		close(queue)
+	}
+	n.signal.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		n.joiner.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(timeout):
+		close(n.done)
+		<-finished
+	}
+}
+
+func (n *WebhookNotifier) deliver(url string, queue chan *webhookDelivery) {
+	defer n.joiner.Done()
+
+	for delivery := range queue {
+		if err := n.send(url, delivery); err != nil {
+			delivery.logger.WithField("Webhook", url).Errorf("Failed to deliver %s: %s", delivery.event, err)
+		}
+	}
+}
+
+// send posts the delivery to the url, retrying with an exponential backoff
+func (n *WebhookNotifier) send(url string, delivery *webhookDelivery) error {
+	backoff := n.options.Backoff
+
+	var err error
+	for attempt := 1; attempt <= n.options.MaxAttempts; attempt++ {
+		var retry bool
+		if retry, err = n.post(context.Background(), url, delivery); err == nil || !retry {
+			return err
+		}
+
+		if attempt == n.options.MaxAttempts {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-n.done:
+			return fmt.Errorf("dropped on close: %s", err)
+		}
+
+		backoff *= 2
+		if backoff > n.options.MaxBackoff {
+			backoff = n.options.MaxBackoff
+		}
+	}
+
+	return fmt.Errorf("gave up after %d attempts: %s", n.options.MaxAttempts, err)
+}
+
+// post sends the delivery once and returns if it is worth retrying on error
+func (n *WebhookNotifier) post(ctx context.Context, url string, delivery *webhookDelivery) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(delivery.body))
+	if err != nil {
+		return false, err
+	}
+
+	for key, value := range n.options.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, delivery.event)
+	req.Header.Set(WebhookDeliveryHeader, delivery.uuid)
+	if n.options.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhookBody(n.options.Secret, delivery.body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	// the endpoint is not going to accept it no matter how many times we try
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+// SignWebhookBody returns the hex encoded HMAC-SHA256 of the body. Receivers
+// can use it to verify the X-Trackman-Signature header
+func SignWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBody returns the body posted for the event, with the secrets masked
+func webhookBody(event *utils.Event) ([]byte, error) {
+	body, err := json.Marshal(NewWebhookEvent(event))
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(event.Payload.Workflow.MaskSecrets(string(body))), nil
+}
+
+// NewWebhookEvent returns the event as it is posted to the webhooks
+func NewWebhookEvent(event *utils.Event) *WebhookEvent {
+	webhookEvent := &WebhookEvent{
+		SchemaVersion: utils.EventSchemaVersion,
+		Name:          event.Name,
+		EventUUID:     event.Payload.EventUUID,
+		Extras:        event.Payload.Extras,
+		Timestamp:     event.At.UTC(),
+	}
+
+	if event.Payload.Workflow != nil {
+		webhookEvent.SessionID = event.Payload.Workflow.SessionID()
+	}
+	if event.Payload.Spinner != nil {
+		step := event.Payload.Step
+		webhookEvent.Step = &step
+	}
+
+	return webhookEvent
+}
@@ -0,0 +1,304 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/thanhpk/randstr"
+)
+
+// Transport delivers the messages of a notifier, like a webhook or Slack
+type Transport interface {
+	// Messages returns the messages to deliver for the event, or none if the
+	// notifier doesn't send it. Their ids and notifier are set by the outbox
+	Messages(event *utils.Event) ([]*Message, error)
+	// Send delivers the message once. A PermanentError drops the message,
+	// other errors have it retried
+	Send(ctx context.Context, message *Message) error
+}
+
+// PermanentError is returned by a Transport for a message that can't be
+// delivered no matter how many times it's tried
+type PermanentError struct {
+	Err error
+}
+
+// Error implements error
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns why the message can't be delivered
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Options configures an Outbox
+type Options struct {
+	// Backoff is the delay before the first retry of a message, doubled for
+	// every retry up to MaxBackoff. Defaults to 1s and 5m
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MaxAge is how long a message is retried before it's dropped. Defaults
+	// to 24h
+	MaxAge time.Duration
+	Logger *logrus.Logger
+}
+
+// Outbox saves the messages of its notifiers before delivering them, and
+// retries the ones that fail, including the ones left by a previous process.
+// Messages to the same notifier and target are delivered in order
+type Outbox struct {
+	store      Store
+	options    *Options
+	transports map[string]Transport
+	signal     *sync.Mutex
+	closed     bool
+	wake       chan struct{}
+	closing    chan struct{}
+	stopped    chan struct{}
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// NewOutbox creates a new Outbox and starts delivering the messages in the
+// store
+func NewOutbox(store Store, options *Options) *Outbox {
+	if options == nil {
+		options = &Options{}
+	}
+	if options.Backoff == 0 {
+		options.Backoff = time.Second
+	}
+	if options.MaxBackoff == 0 {
+		options.MaxBackoff = 5 * time.Minute
+	}
+	if options.MaxAge == 0 {
+		options.MaxAge = 24 * time.Hour
+	}
+	if options.Logger == nil {
+		options.Logger = logrus.StandardLogger()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	outbox := &Outbox{
+		store:      store,
+		options:    options,
+		transports: make(map[string]Transport),
+		signal:     &sync.Mutex{},
+		wake:       make(chan struct{}, 1),
+		closing:    make(chan struct{}),
+		stopped:    make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	go outbox.run()
+
+	return outbox
+}
+
+// Register adds the transport of the notifier with the name. Messages kept
+// for a notifier without a transport wait for it to be registered
+func (o *Outbox) Register(name string, transport Transport) {
+	o.signal.Lock()
+	o.transports[name] = transport
+	o.signal.Unlock()
+
+	o.notify()
+}
+
+// Notifier returns a notifier saving the messages of the transport with the
+// name to the outbox, which then delivers them
+func (o *Outbox) Notifier(name string) utils.Notifier {
+	return func(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+		o.signal.Lock()
+		transport, ok := o.transports[name]
+		closed := o.closed
+		o.signal.Unlock()
+
+		if closed {
+			return errors.New("outbox is closed")
+		}
+		if !ok {
+			return fmt.Errorf("no transport for notifier %s", name)
+		}
+
+		messages, err := transport.Messages(event)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, message := range messages {
+			message.ID = fmt.Sprintf("%d-%s", now.UnixNano(), randstr.String(8))
+			message.Notifier = name
+			message.CreatedAt = now
+			message.NextAttemptAt = now
+			if err = o.store.Save(message); err != nil {
+				return err
+			}
+		}
+
+		o.notify()
+
+		return nil
+	}
+}
+
+// Close stops accepting messages and tries to deliver the ones due until
+// timeout. The messages not delivered are kept in the store for the next
+// Outbox
+func (o *Outbox) Close(timeout time.Duration) {
+	o.signal.Lock()
+	if o.closed {
+		o.signal.Unlock()
+		return
+	}
+	o.closed = true
+	o.signal.Unlock()
+
+	close(o.closing)
+
+	select {
+	case <-o.stopped:
+	case <-time.After(timeout):
+		o.cancel()
+		<-o.stopped
+	}
+	o.cancel()
+}
+
+// notify wakes up the delivery loop
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers the messages as they are due, until the outbox is closed
+func (o *Outbox) run() {
+	defer close(o.stopped)
+
+	for {
+		wait := o.deliver()
+
+		select {
+		case <-o.wake:
+		case <-time.After(wait):
+		case <-o.closing:
+			// one last try for the messages due
+			o.deliver()
+			return
+		}
+	}
+}
+
+// deliver sends the messages that are due and returns how long to wait for
+// the next one
+func (o *Outbox) deliver() time.Duration {
+	wait := time.Minute
+
+	messages, err := o.store.List()
+	if err != nil {
+		o.options.Logger.Errorf("Failed to read the outbox: %s", err)
+		return wait
+	}
+
+	// a message waiting for a retry holds up the ones after it to the same
+	// notifier and target
+	held := make(map[string]bool)
+	for _, message := range messages {
+		if o.ctx.Err() != nil {
+			return wait
+		}
+
+		key := message.Notifier + " " + message.Target
+		if held[key] {
+			continue
+		}
+
+		o.signal.Lock()
+		transport, ok := o.transports[message.Notifier]
+		o.signal.Unlock()
+		if !ok {
+			held[key] = true
+			continue
+		}
+
+		if time.Since(message.CreatedAt) > o.options.MaxAge {
+			o.drop(message, fmt.Errorf("not delivered within %s: %s", o.options.MaxAge, message.LastError))
+			continue
+		}
+
+		if until := time.Until(message.NextAttemptAt); until > 0 {
+			held[key] = true
+			if until < wait {
+				wait = until
+			}
+			continue
+		}
+
+		if err = o.send(transport, message); err != nil {
+			held[key] = true
+			if until := time.Until(message.NextAttemptAt); until < wait {
+				wait = until
+			}
+		}
+	}
+
+	return wait
+}
+
+// send delivers the message once, and schedules its next attempt if it fails
+func (o *Outbox) send(transport Transport, message *Message) error {
+	err := transport.Send(o.ctx, message)
+	if err == nil {
+		if removeErr := o.store.Remove(message.ID); removeErr != nil {
+			o.options.Logger.Errorf("Failed to remove delivered message %s from the outbox: %s", message.ID, removeErr)
+		}
+		return nil
+	}
+
+	var permanent *PermanentError
+	if errors.As(err, &permanent) {
+		o.drop(message, err)
+		return nil
+	}
+
+	message.Attempts++
+	message.LastError = err.Error()
+	message.NextAttemptAt = time.Now().Add(o.backoff(message.Attempts))
+	if saveErr := o.store.Save(message); saveErr != nil {
+		o.options.Logger.Errorf("Failed to save message %s to the outbox: %s", message.ID, saveErr)
+	}
+	o.options.Logger.WithField("Notifier", message.Notifier).Warnf("Failed to deliver %s, retrying in %s: %s", message.Event, time.Until(message.NextAttemptAt).Round(time.Second), err)
+
+	return err
+}
+
+// drop removes a message that won't be delivered
+func (o *Outbox) drop(message *Message, err error) {
+	o.options.Logger.WithField("Notifier", message.Notifier).Errorf("Dropped %s: %s", message.Event, err)
+	if removeErr := o.store.Remove(message.ID); removeErr != nil {
+		o.options.Logger.Errorf("Failed to remove message %s from the outbox: %s", message.ID, removeErr)
+	}
+}
+
+// backoff returns the delay before the attempt after the given failed ones
+func (o *Outbox) backoff(attempts int) time.Duration {
+	backoff := o.options.Backoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= o.options.MaxBackoff {
+			return o.options.MaxBackoff
+		}
+	}
+
+	return backoff
+}
@@ -0,0 +1,291 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/sqlite"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// testTransport fails the given number of sends before delivering the
+// messages, or drops them with permanent
+type testTransport struct {
+	signal    *sync.Mutex
+	failures  int
+	permanent bool
+	attempts  []string
+	delivered chan string
+}
+
+func newTestTransport(failures int) *testTransport {
+	return &testTransport{signal: &sync.Mutex{}, failures: failures, delivered: make(chan string, 100)}
+}
+
+func (t *testTransport) Messages(event *utils.Event) ([]*Message, error) {
+	return []*Message{{Target: "hook", Event: event.Name, Body: []byte(event.Name)}}, nil
+}
+
+func (t *testTransport) Send(ctx context.Context, message *Message) error {
+	t.signal.Lock()
+	defer t.signal.Unlock()
+
+	t.attempts = append(t.attempts, message.Event)
+	if t.permanent {
+		return &PermanentError{Err: errors.New("invalid target")}
+	}
+	if t.failures > 0 {
+		t.failures--
+		return errors.New("connection refused")
+	}
+
+	t.delivered <- message.Event
+	return nil
+}
+
+func (t *testTransport) sent() string {
+	t.signal.Lock()
+	defer t.signal.Unlock()
+
+	return strings.Join(t.attempts, " ")
+}
+
+func newTestOutbox(t *testing.T, store Store) *Outbox {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	outbox := NewOutbox(store, &Options{Backoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, Logger: logger})
+	t.Cleanup(func() { outbox.Close(time.Second) })
+
+	return outbox
+}
+
+func notify(t *testing.T, notifier utils.Notifier, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		if err := notifier(context.Background(), logrus.StandardLogger(), &utils.Event{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func waitForDelivery(t *testing.T, transport *testTransport, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		select {
+		case delivered := <-transport.delivered:
+			if delivered != name {
+				t.Fatalf("%s was delivered, want %s", delivered, name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s wasn't delivered", name)
+		}
+	}
+}
+
+func newTestFileStore(t *testing.T) *FileStore {
+	t.Helper()
+
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+// testStore checks the messages saved to the store are listed oldest first
+// until they are removed
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, message := range []*Message{
+		{ID: "b", Notifier: "webhook", Event: "run.success", Body: []byte(`{"name":"build"}`), CreatedAt: start.Add(time.Second)},
+		{ID: "c", Notifier: "slack", Event: "run.fail", CreatedAt: start.Add(time.Second)},
+		{ID: "a", Notifier: "webhook", Event: "workflow.started", CreatedAt: start},
+	} {
+		if err := store.Save(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// saving a message again updates it
+	if err := store.Save(&Message{ID: "b", Notifier: "webhook", Event: "run.success", Body: []byte(`{"name":"build"}`), CreatedAt: start.Add(time.Second), Attempts: 2, LastError: "timeout"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Remove("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Remove("c"); err != nil {
+		t.Errorf("removing a message twice failed: %s", err)
+	}
+
+	messages, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].ID != "a" || messages[1].ID != "b" {
+		t.Fatalf("got %d messages, want a and b", len(messages))
+	}
+	if b := messages[1]; b.Attempts != 2 || b.LastError != "timeout" || string(b.Body) != `{"name":"build"}` || !b.CreatedAt.Equal(start.Add(time.Second)) {
+		t.Errorf("message b is %+v", b)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	store := newTestFileStore(t)
+	testStore(t, store)
+
+	if err := store.Save(&Message{ID: "../a"}); err == nil {
+		t.Error("a message with an invalid id was saved")
+	}
+}
+
+func newTestSQLiteStore(t *testing.T, path string) *SQLiteStore {
+	t.Helper()
+
+	store, err := NewSQLiteStore(path)
+	if err == sqlite.ErrNotSupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	return store
+}
+
+func TestSQLiteStore(t *testing.T) {
+	testStore(t, newTestSQLiteStore(t, filepath.Join(t.TempDir(), "outbox", "messages.db")))
+}
+
+func TestRetry(t *testing.T) {
+	store := newTestFileStore(t)
+	outbox := newTestOutbox(t, store)
+	transport := newTestTransport(2)
+	outbox.Register("webhook", transport)
+
+	notify(t, outbox.Notifier("webhook"), "e1", "e2")
+	waitForDelivery(t, transport, "e1", "e2")
+
+	// e2 waits for e1 as they go to the same target
+	if sent := transport.sent(); sent != "e1 e1 e1 e2" {
+		t.Errorf("the sends were %s, want e1 retried twice before e2", sent)
+	}
+	if messages, err := store.List(); err != nil || len(messages) != 0 {
+		t.Errorf("%d messages were left in the store: %v", len(messages), err)
+	}
+}
+
+func TestPermanentError(t *testing.T) {
+	store := newTestFileStore(t)
+	outbox := newTestOutbox(t, store)
+	transport := newTestTransport(0)
+	transport.permanent = true
+	outbox.Register("webhook", transport)
+
+	notify(t, outbox.Notifier("webhook"), "e1")
+	outbox.Close(time.Second)
+
+	if sent := transport.sent(); sent != "e1" {
+		t.Errorf("the sends were %s, want e1 once", sent)
+	}
+	if messages, err := store.List(); err != nil || len(messages) != 0 {
+		t.Errorf("%d messages were left in the store: %v", len(messages), err)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	dir := t.TempDir()
+	testRestart(t, func() Store {
+		store, err := NewFileStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return store
+	})
+}
+
+func TestRestartWithSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	testRestart(t, func() Store { return newTestSQLiteStore(t, path) })
+}
+
+// testRestart checks the messages left by an outbox are delivered by the
+// next one using the store opened again
+func testRestart(t *testing.T, open func() Store) {
+	t.Helper()
+
+	// the first outbox stops before it can deliver the message
+	store := open()
+	outbox := newTestOutbox(t, store)
+	outbox.Register("webhook", newTestTransport(1000))
+	notify(t, outbox.Notifier("webhook"), "e1")
+	outbox.Close(time.Second)
+
+	if err := outbox.Notifier("webhook")(context.Background(), logrus.StandardLogger(), &utils.Event{Name: "e2"}); err == nil {
+		t.Error("a closed outbox took a message")
+	}
+	messages, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Attempts == 0 || messages[0].LastError != "connection refused" {
+		t.Fatalf("the store has %d messages, want e1 with its failed attempts", len(messages))
+	}
+
+	// the next one delivers it once the notifier is registered
+	transport := newTestTransport(0)
+	outbox = newTestOutbox(t, open())
+	outbox.Register("webhook", transport)
+	waitForDelivery(t, transport, "e1")
+}
+
+func TestMaxAge(t *testing.T) {
+	store := newTestFileStore(t)
+	if err := store.Save(&Message{ID: "old", Notifier: "webhook", Event: "e1", CreatedAt: time.Now().Add(-48 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := newTestOutbox(t, store)
+	transport := newTestTransport(0)
+	outbox.Register("webhook", transport)
+	notify(t, outbox.Notifier("webhook"), "e2")
+	waitForDelivery(t, transport, "e2")
+
+	if sent := transport.sent(); sent != "e2" {
+		t.Errorf("the sends were %s, want only e2", sent)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	outbox := &Outbox{options: &Options{Backoff: time.Second, MaxBackoff: 5 * time.Second}}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: time.Second},
+		{attempts: 2, want: 2 * time.Second},
+		{attempts: 3, want: 4 * time.Second},
+		{attempts: 4, want: 5 * time.Second},
+		{attempts: 100, want: 5 * time.Second},
+	}
+
+	for _, test := range tests {
+		if got := outbox.backoff(test.attempts); got != test.want {
+			t.Errorf("backoff after %d attempts is %s, want %s", test.attempts, got, test.want)
+		}
+	}
+}
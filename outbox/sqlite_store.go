@@ -0,0 +1,85 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloud66-oss/trackman/sqlite"
+)
+
+// SQLiteStore is a Store keeping the messages in a SQLite database. It needs
+// trackman built with -tags sqlite
+type SQLiteStore struct {
+	db *sqlite.DB
+}
+
+// NewSQLiteStore opens the database in the file, creating it and its
+// directory if needed
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := sqlite.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the messages have secrets masked, but their targets can have tokens
+	if err = os.Chmod(path, 0600); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	if err = db.Exec(`CREATE TABLE IF NOT EXISTS messages (
+		id TEXT PRIMARY KEY,
+		created_at INTEGER NOT NULL,
+		message BLOB NOT NULL
+	)`); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// Save implements Store
+func (s *SQLiteStore) Save(message *Message) error {
+	buff, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Exec("INSERT OR REPLACE INTO messages (id, created_at, message) VALUES (?, ?, ?)", message.ID, message.CreatedAt.UnixNano(), buff)
+}
+
+// Remove implements Store
+func (s *SQLiteStore) Remove(id string) error {
+	return s.db.Exec("DELETE FROM messages WHERE id = ?", id)
+}
+
+// List implements Store
+func (s *SQLiteStore) List() ([]*Message, error) {
+	messages := make([]*Message, 0)
+	err := s.db.Query("SELECT id, message FROM messages ORDER BY created_at, id", nil, func(row *sqlite.Row) error {
+		var message *Message
+		if err := json.Unmarshal(row.Blob(1), &message); err != nil {
+			return fmt.Errorf("invalid message %s: %s", row.Text(0), err)
+		}
+		messages = append(messages, message)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
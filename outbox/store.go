@@ -0,0 +1,136 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message is a notification kept in the outbox until it's delivered
+type Message struct {
+	ID string `json:"id"`
+	// Notifier is the name of the transport delivering the message
+	Notifier string `json:"notifier"`
+	// Target is where the transport delivers the message, like a URL
+	Target    string `json:"target,omitempty"`
+	Event     string `json:"event"`
+	EventUUID string `json:"event_uuid,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	// Body is what the transport sends, with the secrets masked
+	Body      []byte    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	// Attempts is the number of failed deliveries so far
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// Store keeps the messages of an outbox across restarts
+type Store interface {
+	// Save adds the message, or updates it if it's already in the store
+	Save(message *Message) error
+	// Remove deletes the message with the id
+	Remove(id string) error
+	// List returns all messages, oldest first
+	List() ([]*Message, error)
+}
+
+// FileStore is a Store keeping each message in a JSON file in a directory
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a new FileStore in the directory, creating it if
+// needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// Save implements Store
+func (s *FileStore) Save(message *Message) error {
+	filename, err := s.filename(message.ID)
+	if err != nil {
+		return err
+	}
+
+	buff, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file first so a crash never leaves a half written message
+	tempFile := filename + ".tmp"
+	if err = ioutil.WriteFile(tempFile, buff, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tempFile, filename)
+}
+
+// Remove implements Store
+func (s *FileStore) Remove(id string) error {
+	filename, err := s.filename(id)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// List implements Store
+func (s *FileStore) List() ([]*Message, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*Message, 0, len(files))
+	for _, file := range files {
+		buff, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			// delivered since listed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var message *Message
+		if err = json.Unmarshal(buff, &message); err != nil {
+			return nil, fmt.Errorf("invalid message %s: %s", filepath.Base(file), err)
+		}
+
+		messages = append(messages, message)
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		if messages[i].CreatedAt.Equal(messages[j].CreatedAt) {
+			return messages[i].ID < messages[j].ID
+		}
+
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+
+	return messages, nil
+}
+
+func (s *FileStore) filename(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", fmt.Errorf("invalid message id %s", id)
+	}
+
+	return filepath.Join(s.dir, id+".json"), nil
+}
@@ -0,0 +1,216 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Entry is a workflow file to run on a schedule
+type Entry struct {
+	File string `yaml:"file" json:"file"`
+	// Variables override the variables of the workflow
+	Variables                map[string]string `yaml:"variables" json:"variables"`
+	utils.ScheduleDefinition `yaml:",inline"`
+}
+
+// Options configures a Scheduler
+type Options struct {
+	// WorkflowOptions returns the options to run each workflow with
+	WorkflowOptions func() *utils.WorkflowOptions
+	Logger          *logrus.Logger
+}
+
+// Scheduler runs workflow files on their cron schedules
+type Scheduler struct {
+	options *Options
+	jobs    []*job
+}
+
+type job struct {
+	entry    *Entry
+	schedule *utils.CronSchedule
+	logger   *logrus.Entry
+	options  *Options
+}
+
+// NewScheduler creates a new Scheduler with no workflows. It returns
+// ErrNoOptions without workflow options
+func NewScheduler(options *Options) (*Scheduler, error) {
+	if options == nil || options.WorkflowOptions == nil {
+		return nil, utils.ErrNoOptions
+	}
+	if options.Logger == nil {
+		options.Logger = logrus.StandardLogger()
+	}
+
+	return &Scheduler{
+		options: options,
+	}, nil
+}
+
+// Add schedules the workflow file of the entry
+func (s *Scheduler) Add(entry *Entry) error {
+	if err := entry.Validate(); err != nil {
+		return fmt.Errorf("%s: %s", entry.File, err)
+	}
+
+	schedule, err := entry.CronSchedule()
+	if err != nil {
+		return fmt.Errorf("%s: %s", entry.File, err)
+	}
+
+	s.jobs = append(s.jobs, &job{
+		entry:    entry,
+		schedule: schedule,
+		logger:   s.options.Logger.WithField("workflow", entry.File),
+		options:  s.options,
+	})
+
+	return nil
+}
+
+// Run runs the workflows on their schedules until the context is done. The
+// running workflows are cancelled then, and waited for
+func (s *Scheduler) Run(ctx context.Context) {
+	joiner := &sync.WaitGroup{}
+	for _, item := range s.jobs {
+		joiner.Add(1)
+		go func(item *job) {
+			defer joiner.Done()
+
+			item.run(ctx)
+		}(item)
+	}
+
+	joiner.Wait()
+}
+
+// wait returns how long to wait for the next run, with jitter
+func (j *job) wait() (time.Duration, bool) {
+	next := j.schedule.Next(time.Now())
+	if next.IsZero() {
+		return 0, false
+	}
+
+	if j.entry.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(j.entry.Jitter))))
+	}
+
+	j.logger.Debugf("Next run at %s", next.Format(time.RFC3339))
+
+	return time.Until(next), true
+}
+
+func (j *job) run(ctx context.Context) {
+	wait, ok := j.wait()
+	if !ok {
+		j.logger.Warn("Schedule never runs")
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	// done is closed when the current run is finished. It's nil when
+	// nothing is running, which blocks forever below
+	var cancel context.CancelFunc
+	var done chan struct{}
+	queued := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			if done != nil {
+				<-done
+			}
+
+			return
+		case <-done:
+			cancel, done = nil, nil
+			if queued {
+				queued = false
+				cancel, done = j.start(ctx)
+			}
+		case <-timer.C:
+			if wait, ok = j.wait(); ok {
+				timer.Reset(wait)
+			}
+
+			switch {
+			case done == nil:
+				cancel, done = j.start(ctx)
+			case j.entry.OverlapPolicy() == utils.OverlapQueue && !queued:
+				j.logger.Info("Previous run is still running. Queueing this run")
+				queued = true
+			case j.entry.OverlapPolicy() == utils.OverlapCancelPrevious:
+				j.logger.Warn("Previous run is still running. Cancelling it")
+				cancel()
+				<-done
+				cancel, done = j.start(ctx)
+			default:
+				j.logger.Warn("Previous run is still running. Skipping this run")
+			}
+		}
+	}
+}
+
+// start loads the workflow file and runs it. The file is read for every run
+// so changes to it are picked up. The returned channel is closed once the
+// workflow is finished
+func (j *job) start(ctx context.Context) (context.CancelFunc, chan struct{}) {
+	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(ctx)
+
+	workflow, err := j.load(ctx)
+	if err != nil {
+		j.logger.Errorf("Failed to load the workflow: %s", err)
+		cancel()
+		close(done)
+		return cancel, done
+	}
+
+	logger := j.logger.WithField("session", workflow.SessionID())
+	logger.Info("Starting scheduled run")
+
+	go func() {
+		defer close(done)
+		defer cancel()
+
+		result, err := workflow.Run(ctx)
+		if err != nil {
+			logger.Error(err)
+		}
+
+		logger.Infof("Scheduled run finished with %s", result.Outcome)
+	}()
+
+	return cancel, done
+}
+
+func (j *job) load(ctx context.Context) (*utils.Workflow, error) {
+	options := j.options.WorkflowOptions()
+	options.Name = strings.TrimSuffix(filepath.Base(j.entry.File), filepath.Ext(j.entry.File))
+	options.Variables = j.entry.Variables
+
+	workflow, err := utils.LoadWorkflowFromFile(ctx, options, j.entry.File)
+	if err != nil {
+		return nil, err
+	}
+
+	// there is no one to answer
+	for _, step := range workflow.Steps {
+		if step.AskToProceed {
+			return nil, fmt.Errorf("step %s asks to proceed, which isn't possible on a schedule", step.Name)
+		}
+	}
+
+	return workflow, nil
+}
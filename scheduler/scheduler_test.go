@@ -0,0 +1,94 @@
+package scheduler
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+func newTestScheduler(t *testing.T) *Scheduler {
+	t.Helper()
+
+	scheduler, err := NewScheduler(&Options{
+		WorkflowOptions: func() *utils.WorkflowOptions {
+			return &utils.WorkflowOptions{Timeout: 10 * time.Second}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return scheduler
+}
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		schedule utils.ScheduleDefinition
+		wantErr  string
+	}{
+		{schedule: utils.ScheduleDefinition{Cron: "*/5 * * * *", Overlap: utils.OverlapQueue}},
+		{schedule: utils.ScheduleDefinition{Cron: "61 * * * *"}, wantErr: "nightly.yml: invalid minute"},
+		{schedule: utils.ScheduleDefinition{Cron: "@daily", Overlap: "later"}, wantErr: "nightly.yml: invalid schedule overlap"},
+	}
+
+	for _, test := range tests {
+		err := newTestScheduler(t).Add(&Entry{File: "nightly.yml", ScheduleDefinition: test.schedule})
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%s: %s", test.schedule.Cron, err)
+		case test.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), test.wantErr)):
+			t.Errorf("%s: got error %v, want %s", test.schedule.Cron, err, test.wantErr)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackman-scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	workflows := map[string]string{
+		"nightly.yml": "version: 1\nsteps:\n  - name: build\n    command: echo\n",
+		"confirm.yml": "version: 1\nsteps:\n  - name: deploy\n    command: echo\n    ask_to_proceed: true\n",
+	}
+	for name, content := range workflows {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scheduler := newTestScheduler(t)
+	for _, name := range []string{"nightly.yml", "confirm.yml"} {
+		entry := &Entry{
+			File:               filepath.Join(dir, name),
+			Variables:          map[string]string{"target": "release"},
+			ScheduleDefinition: utils.ScheduleDefinition{Cron: "@daily"},
+		}
+		if err = scheduler.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	workflow, err := scheduler.jobs[0].load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workflow.Name() != "nightly" {
+		t.Errorf("the workflow is named %s, want nightly", workflow.Name())
+	}
+	if target := workflow.Var()["target"]; target != "release" {
+		t.Errorf("the target variable is %q, want the one of the entry", target)
+	}
+
+	if _, err = scheduler.jobs[1].load(context.Background()); err == nil || !strings.Contains(err.Error(), "asks to proceed") {
+		t.Errorf("a step asking to proceed was loaded with %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+const (
+	awsService      = "secretsmanager"
+	awsNotFoundType = "ResourceNotFoundException"
+)
+
+// AWSOptions configures an AWS Secrets Manager provider
+type AWSOptions struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is needed with temporary credentials
+	SessionToken string
+	// Endpoint overrides the endpoint of the region, like for a VPC endpoint
+	Endpoint string
+}
+
+// AWSSecretsManager is a utils.SecretProvider reading the secrets from AWS
+// Secrets Manager. Secrets are named after their name or ARN. If the secret
+// holds JSON, a value in it can be used with its key, like db#password
+type AWSSecretsManager struct {
+	options *AWSOptions
+	client  *http.Client
+}
+
+// NewAWSSecretsManager creates a new AWS Secrets Manager provider
+func NewAWSSecretsManager(options *AWSOptions) (*AWSSecretsManager, error) {
+	if options.Region == "" {
+		return nil, errors.New("aws secrets manager needs a region")
+	}
+	if options.AccessKeyID == "" || options.SecretAccessKey == "" {
+		return nil, errors.New("aws secrets manager needs an access key")
+	}
+	if options.Endpoint == "" {
+		options.Endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", awsService, options.Region)
+	}
+
+	return &AWSSecretsManager{
+		options: options,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Secret implements utils.SecretProvider
+func (m *AWSSecretsManager) Secret(ctx context.Context, name string) (string, error) {
+	id, key := splitName(name)
+
+	body, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(m.options.Endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	utils.SignAWSRequest(req, body, &utils.AWSCredentials{
+		AccessKeyID:     m.options.AccessKeyID,
+		SecretAccessKey: m.options.SecretAccessKey,
+		SessionToken:    m.options.SessionToken,
+	}, m.options.Region, awsService, time.Now())
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		SecretString string `json:"SecretString"`
+		SecretBinary []byte `json:"SecretBinary"`
+		Type         string `json:"__type"`
+		Message      string `json:"message"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+
+	switch {
+	case resp.StatusCode != http.StatusOK && strings.HasSuffix(result.Type, awsNotFoundType):
+		return "", ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("aws secrets manager returned %s %s %s", resp.Status, result.Type, result.Message)
+	case decodeErr != nil:
+		return "", fmt.Errorf("invalid response from aws secrets manager: %s", decodeErr)
+	}
+
+	value := result.SecretString
+	if value == "" {
+		value = string(result.SecretBinary)
+	}
+	if key == "" {
+		return value, nil
+	}
+
+	var values map[string]interface{}
+	if err = json.Unmarshal([]byte(value), &values); err != nil {
+		return "", fmt.Errorf("secret %s isn't JSON to get %s from", id, key)
+	}
+	item, ok := values[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if text, ok := item.(string); ok {
+		return text, nil
+	}
+
+	buff, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+
+	return string(buff), nil
+}
@@ -0,0 +1,81 @@
+package secrets
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnvFile is a utils.SecretProvider reading the secrets from a file of
+// KEY=VALUE lines, like a .env file
+type EnvFile struct {
+	values map[string]string
+}
+
+// NewEnvFile reads the secrets in the file. Empty lines and lines starting
+// with # are ignored, lines can start with export and values can be quoted
+func NewEnvFile(filename string) (*EnvFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid line %d in %s", number, filename)
+		}
+
+		value, err := unquote(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value on line %d in %s: %s", number, filename, err)
+		}
+
+		values[key] = value
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &EnvFile{values: values}, nil
+}
+
+// Secret implements utils.SecretProvider
+func (f *EnvFile) Secret(ctx context.Context, name string) (string, error) {
+	value, ok := f.values[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return value, nil
+}
+
+// unquote removes the quotes around the value. Escapes like \n are only
+// used in double quoted values
+func unquote(value string) (string, error) {
+	if len(value) < 2 {
+		return value, nil
+	}
+
+	switch {
+	case value[0] == '"' && value[len(value)-1] == '"':
+		return strconv.Unquote(value)
+	case value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1], nil
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,41 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+// ErrNotFound is returned by the providers for secrets they don't have
+var ErrNotFound = errors.New("secret not found")
+
+// Chain is a utils.SecretProvider getting each secret from the first of its
+// providers that has it
+type Chain []utils.SecretProvider
+
+// Secret implements utils.SecretProvider
+func (c Chain) Secret(ctx context.Context, name string) (string, error) {
+	for _, provider := range c {
+		value, err := provider.Secret(ctx, name)
+		if err == ErrNotFound {
+			continue
+		}
+
+		return value, err
+	}
+
+	return "", ErrNotFound
+}
+
+// splitName splits a secret name into the secret and the key of the value
+// in it, like db#password
+func splitName(name string) (string, string) {
+	idx := strings.LastIndex(name, "#")
+	if idx == -1 {
+		return name, ""
+	}
+
+	return name[:idx], name[idx+1:]
+}
@@ -0,0 +1,109 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultVaultMount = "secret"
+
+// VaultOptions configures a Vault provider
+type VaultOptions struct {
+	// Address is the address of the Vault server, like https://vault:8200
+	Address string
+	Token   string
+	// Namespace is the Vault Enterprise namespace of the secrets
+	Namespace string
+	// Mount is where the KV version 2 secrets engine is mounted. Defaults to
+	// secret
+	Mount string
+}
+
+// Vault is a utils.SecretProvider reading the secrets from the KV version 2
+// secrets engine of HashiCorp Vault. Secrets are named after their path and
+// the key of the value in it, like db#password. The key defaults to value
+type Vault struct {
+	options *VaultOptions
+	client  *http.Client
+}
+
+// NewVault creates a new Vault provider
+func NewVault(options *VaultOptions) (*Vault, error) {
+	if options.Address == "" {
+		return nil, errors.New("vault needs an address")
+	}
+	if options.Token == "" {
+		return nil, errors.New("vault needs a token")
+	}
+	if options.Mount == "" {
+		options.Mount = defaultVaultMount
+	}
+
+	return &Vault{
+		options: options,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Secret implements utils.SecretProvider
+func (v *Vault) Secret(ctx context.Context, name string) (string, error) {
+	path, key := splitName(name)
+	if key == "" {
+		key = "value"
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimSuffix(v.options.Address, "/"), strings.Trim(v.options.Mount, "/"), strings.TrimPrefix(path, "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.options.Token)
+	if v.options.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.options.Namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+		Errors []string `json:"errors"`
+	}
+	// errors have a body too
+	decodeErr := json.NewDecoder(resp.Body).Decode(&body)
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("vault returned %s %s", resp.Status, strings.Join(body.Errors, ", "))
+	case decodeErr != nil:
+		return "", fmt.Errorf("invalid response from vault: %s", decodeErr)
+	}
+
+	value, ok := body.Data.Data[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if text, ok := value.(string); ok {
+		return text, nil
+	}
+
+	// other values are used as JSON
+	buff, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(buff), nil
+}
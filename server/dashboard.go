@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cloud66-oss/trackman/history"
+)
+
+// defaultHistoryLimit is the number of past runs listed by default
+const defaultHistoryLimit = 50
+
+// historyView is how a past run is listed by the API
+type historyView struct {
+	ID         string        `json:"id"`
+	Workflow   string        `json:"workflow"`
+	Outcome    string        `json:"outcome"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// serveDashboard serves the page of the dashboard. It uses the API for the
+// rest, with the token the user enters if one is needed
+func (s *Server) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, dashboardPage)
+}
+
+// serveHistory lists the past runs, optionally of one workflow and up to
+// limit of them, or shows the one with the id
+func (s *Server) serveHistory(w http.ResponseWriter, r *http.Request, ids []string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+		return
+	}
+	if s.options.History == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no history. Use --history-dir to keep it"))
+		return
+	}
+
+	if len(ids) == 1 {
+		run, err := s.options.History.Get(ids[0])
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, run)
+		return
+	}
+
+	limit := defaultHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %s", value))
+			return
+		}
+	}
+
+	runs, err := s.options.History.List(&history.Filter{Workflow: r.URL.Query().Get("workflow"), Limit: limit})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	views := make([]*historyView, 0, len(runs))
+	for _, run := range runs {
+		views = append(views, &historyView{
+			ID:         run.SessionID,
+			Workflow:   run.Workflow,
+			Outcome:    run.Outcome,
+			StartedAt:  run.StartedAt,
+			FinishedAt: run.FinishedAt,
+			Duration:   run.Duration,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, views)
+}
@@ -0,0 +1,422 @@
+package server
+
+// dashboardPage is the web dashboard. It's one page using the API, without
+// anything to load from elsewhere
+const dashboardPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Trackman</title>
+<style>
+  body { margin: 0; font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; font-size: 14px; color: #1f2328; display: flex; height: 100vh; }
+  aside { width: 300px; border-right: 1px solid #d0d7de; overflow-y: auto; background: #f6f8fa; }
+  aside h2 { font-size: 12px; text-transform: uppercase; color: #656d76; margin: 16px 12px 4px; }
+  aside ul { list-style: none; margin: 0; padding: 0; }
+  aside li { padding: 6px 12px; cursor: pointer; display: flex; justify-content: space-between; gap: 8px; }
+  aside li:hover, aside li.selected { background: #eaeef2; }
+  aside li small { color: #656d76; }
+  main { flex: 1; display: flex; flex-direction: column; min-width: 0; }
+  header { padding: 12px 16px; border-bottom: 1px solid #d0d7de; display: flex; align-items: center; gap: 12px; }
+  header h1 { font-size: 18px; margin: 0; flex: 1; }
+  button { border: 1px solid #d0d7de; background: #f6f8fa; border-radius: 6px; padding: 4px 12px; cursor: pointer; }
+  button:hover { background: #eaeef2; }
+  .status { border-radius: 10px; padding: 1px 8px; color: #fff; font-size: 12px; }
+  #graph { overflow: auto; border-bottom: 1px solid #d0d7de; max-height: 45vh; }
+  #graph svg { display: block; }
+  #graph g.node { cursor: pointer; }
+  #logs { flex: 1; overflow: auto; margin: 0; padding: 8px 16px; background: #0d1117; color: #e6edf3; font: 12px/1.5 ui-monospace, SFMono-Regular, Menlo, monospace; white-space: pre-wrap; }
+  #logs .event { color: #7d8590; }
+  #logs .name { color: #58a6ff; }
+  #empty { padding: 32px; color: #656d76; }
+</style>
+</head>
+<body>
+<aside>
+  <h2>Runs</h2>
+  <ul id="runs"></ul>
+  <h2>History</h2>
+  <ul id="history"></ul>
+</aside>
+<main>
+  <header>
+    <h1 id="title">Trackman</h1>
+    <span id="filter"></span>
+    <span id="actions"></span>
+  </header>
+  <div id="graph"></div>
+  <pre id="logs"><span id="empty">Select a run</span></pre>
+</main>
+<script>
+"use strict";
+
+var colors = {
+  success: "#2da44e", failed: "#cf222e", skipped: "#9a6700", cached: "#8250df",
+  disabled: "#6e7781", not_run: "#6e7781", cancelled: "#bc4c00", running: "#0969da",
+  degraded: "#bf8700", partial: "#bf8700", stopped: "#6e7781"
+};
+
+var selected = null;
+var stepFilter = null;
+var stream = null;
+var refreshTimer = null;
+
+function el(tag, attributes, children) {
+  var node = document.createElement(tag);
+  Object.keys(attributes || {}).forEach(function (key) {
+    if (key === "text") {
+      node.textContent = attributes[key];
+    } else if (key === "onclick") {
+      node.onclick = attributes[key];
+    } else {
+      node.setAttribute(key, attributes[key]);
+    }
+  });
+  (children || []).forEach(function (child) { node.appendChild(child); });
+  return node;
+}
+
+function svg(tag, attributes) {
+  var node = document.createElementNS("http://www.w3.org/2000/svg", tag);
+  Object.keys(attributes || {}).forEach(function (key) { node.setAttribute(key, attributes[key]); });
+  return node;
+}
+
+function badge(status) {
+  return el("span", { "class": "status", style: "background:" + (colors[status] || "#6e7781"), text: status });
+}
+
+function duration(nanoseconds) {
+  if (!nanoseconds) {
+    return "";
+  }
+  var seconds = nanoseconds / 1e9;
+  if (seconds < 60) {
+    return seconds.toFixed(1) + "s";
+  }
+  return Math.floor(seconds / 60) + "m" + Math.round(seconds % 60) + "s";
+}
+
+// api calls the API with the token, asking for it if it's needed
+function api(path, options) {
+  options = options || {};
+  options.headers = options.headers || {};
+  var token = localStorage.getItem("trackman.token");
+  if (token) {
+    options.headers.Authorization = "Bearer " + token;
+  }
+  return fetch(path, options).then(function (response) {
+    if (response.status === 401) {
+      var entered = prompt("Token of the Trackman server");
+      if (entered === null) {
+        throw new Error("no token");
+      }
+      localStorage.setItem("trackman.token", entered);
+      return api(path, options);
+    }
+    return response;
+  });
+}
+
+function json(path, options) {
+  return api(path, options).then(function (response) {
+    return response.json().then(function (body) {
+      if (!response.ok) {
+        throw new Error(body.error || response.statusText);
+      }
+      return body;
+    });
+  });
+}
+
+function loadLists() {
+  json("/runs").then(function (runs) {
+    var list = document.getElementById("runs");
+    list.textContent = "";
+    runs.slice().reverse().forEach(function (run) {
+      var item = el("li", { onclick: function () { select("run", run.id); } }, [
+        el("span", { text: run.name }, [el("br"), el("small", { text: run.id + " " + new Date(run.submitted_at).toLocaleTimeString() })]),
+        badge(run.paused ? "paused" : run.status)
+      ]);
+      if (selected && selected.kind === "run" && selected.id === run.id) {
+        item.className = "selected";
+      }
+      list.appendChild(item);
+    });
+  }).catch(function () {});
+
+  json("/history?limit=50").then(function (runs) {
+    var list = document.getElementById("history");
+    list.textContent = "";
+    runs.forEach(function (run) {
+      var item = el("li", { onclick: function () { select("history", run.id); } }, [
+        el("span", { text: run.workflow }, [el("br"), el("small", { text: run.id + " " + new Date(run.started_at).toLocaleString() })]),
+        badge(run.outcome)
+      ]);
+      if (selected && selected.kind === "history" && selected.id === run.id) {
+        item.className = "selected";
+      }
+      list.appendChild(item);
+    });
+  }).catch(function () {});
+}
+
+function select(kind, id) {
+  selected = { kind: kind, id: id };
+  stepFilter = null;
+  loadLists();
+  show();
+}
+
+// show draws the selected run and streams its logs
+function show() {
+  if (stream) {
+    stream.abort();
+    stream = null;
+  }
+  document.getElementById("logs").textContent = "";
+  drawFilter();
+
+  if (selected.kind === "history") {
+    json("/history/" + encodeURIComponent(selected.id)).then(function (run) {
+      drawHeader(run.workflow, run.session_id, run.outcome, []);
+      drawGraph(run.steps || []);
+      var logs = document.getElementById("logs");
+      logs.appendChild(el("span", { "class": "event", text: "The output of past runs isn't kept. Errors of the steps:\n" }));
+      Object.keys(run.step_errors || {}).forEach(function (step) {
+        if (!stepFilter || stepFilter === step) {
+          logs.appendChild(el("span", { "class": "name", text: step + " | " }));
+          logs.appendChild(document.createTextNode(run.step_errors[step] + "\n"));
+        }
+      });
+    }).catch(showError);
+    return;
+  }
+
+  refresh();
+  follow(selected.id);
+}
+
+// refresh redraws the header and the graph of the selected run
+function refresh() {
+  var id = selected.id;
+  json("/runs/" + encodeURIComponent(id)).then(function (run) {
+    if (!selected || selected.id !== id) {
+      return;
+    }
+    var actions = [{ name: "Retry", action: "retry" }];
+    if (run.status === "running") {
+      actions.unshift({ name: "Cancel", action: "cancel" });
+      actions.unshift(run.paused ? { name: "Resume", action: "resume" } : { name: "Pause", action: "pause" });
+    }
+    drawHeader(run.name, run.id, run.paused ? "paused" : run.status, actions);
+    drawGraph(run.steps || []);
+  }).catch(showError);
+}
+
+function scheduleRefresh() {
+  if (refreshTimer) {
+    return;
+  }
+  refreshTimer = setTimeout(function () {
+    refreshTimer = null;
+    refresh();
+    loadLists();
+  }, 300);
+}
+
+function drawHeader(name, id, status, actions) {
+  var title = document.getElementById("title");
+  title.textContent = name + " ";
+  title.appendChild(el("small", { text: id + " " }));
+  title.appendChild(badge(status));
+
+  var buttons = document.getElementById("actions");
+  buttons.textContent = "";
+  actions.forEach(function (item) {
+    buttons.appendChild(el("button", { text: item.name, onclick: function () { act(item.action); } }));
+  });
+}
+
+function drawFilter() {
+  var filter = document.getElementById("filter");
+  filter.textContent = "";
+  if (stepFilter) {
+    filter.appendChild(el("button", { text: "Step " + stepFilter + " ×", onclick: function () { stepFilter = null; show(); } }));
+  }
+}
+
+function act(action) {
+  if (action === "cancel" && !confirm("Cancel this run?")) {
+    return;
+  }
+  json("/runs/" + encodeURIComponent(selected.id) + "/" + action, { method: "POST" }).then(function (run) {
+    if (action === "retry") {
+      select("run", run.id);
+    } else {
+      scheduleRefresh();
+    }
+  }).catch(showError);
+}
+
+// drawGraph draws the steps in columns by how deep they are in the graph of
+// dependencies, colored by their status
+function drawGraph(steps) {
+  var byName = {};
+  steps.forEach(function (step) { byName[step.name] = step; });
+
+  var depths = {};
+  function depth(step, seen) {
+    if (depths[step.name] !== undefined) {
+      return depths[step.name];
+    }
+    var value = 0;
+    (step.depends_on || []).forEach(function (name) {
+      if (byName[name] && !seen[name]) {
+        seen[name] = true;
+        value = Math.max(value, depth(byName[name], seen) + 1);
+      }
+    });
+    depths[step.name] = value;
+    return value;
+  }
+
+  var columns = [];
+  steps.forEach(function (step) {
+    var column = depth(step, {});
+    (columns[column] = columns[column] || []).push(step);
+  });
+
+  var width = 180, height = 48, gapX = 60, gapY = 16, margin = 16;
+  var positions = {};
+  var rows = 0;
+  columns.forEach(function (column, x) {
+    (column || []).forEach(function (step, y) {
+      positions[step.name] = { x: margin + x * (width + gapX), y: margin + y * (height + gapY) };
+    });
+    rows = Math.max(rows, (column || []).length);
+  });
+
+  var graph = svg("svg", {
+    width: margin * 2 + columns.length * (width + gapX) - gapX,
+    height: margin * 2 + rows * (height + gapY) - gapY
+  });
+
+  steps.forEach(function (step) {
+    (step.depends_on || []).forEach(function (name) {
+      var from = positions[name], to = positions[step.name];
+      if (!from || !to) {
+        return;
+      }
+      var startX = from.x + width, startY = from.y + height / 2, endX = to.x, endY = to.y + height / 2;
+      graph.appendChild(svg("path", {
+        d: "M" + startX + "," + startY + " C" + (startX + gapX / 2) + "," + startY + " " + (endX - gapX / 2) + "," + endY + " " + endX + "," + endY,
+        fill: "none", stroke: "#8c959f", "stroke-width": 1.5
+      }));
+    });
+  });
+
+  steps.forEach(function (step) {
+    var position = positions[step.name];
+    var color = colors[step.status] || "#6e7781";
+    var node = svg("g", { "class": "node", transform: "translate(" + position.x + "," + position.y + ")" });
+    node.appendChild(svg("rect", {
+      width: width, height: height, rx: 6, fill: "#fff", stroke: color,
+      "stroke-width": stepFilter === step.name ? 3 : 1.5
+    }));
+    node.appendChild(svg("rect", { width: 6, height: height, rx: 3, fill: color }));
+    var name = svg("text", { x: 14, y: 19, "font-weight": 600 });
+    name.textContent = step.name.length > 22 ? step.name.slice(0, 21) + "…" : step.name;
+    var status = svg("text", { x: 14, y: 37, fill: color, "font-size": 12 });
+    status.textContent = step.status + " " + duration(step.duration);
+    var tooltip = svg("title");
+    tooltip.textContent = step.name;
+    node.appendChild(name);
+    node.appendChild(status);
+    node.appendChild(tooltip);
+    node.onclick = function () {
+      stepFilter = stepFilter === step.name ? null : step.name;
+      show();
+    };
+    graph.appendChild(node);
+  });
+
+  var container = document.getElementById("graph");
+  container.textContent = "";
+  container.appendChild(graph);
+}
+
+// follow reads the stream of the run, adding its output to the logs and
+// refreshing the graph on its events
+function follow(id) {
+  var controller = new AbortController();
+  stream = controller;
+  var logs = document.getElementById("logs");
+  var path = "/runs/" + encodeURIComponent(id) + "/stream" + (stepFilter ? "?step=" + encodeURIComponent(stepFilter) : "");
+
+  api(path, { signal: controller.signal }).then(function (response) {
+    var reader = response.body.getReader();
+    var decoder = new TextDecoder();
+    var buffer = "";
+
+    function read() {
+      return reader.read().then(function (chunk) {
+        if (chunk.done) {
+          return;
+        }
+        buffer += decoder.decode(chunk.value, { stream: true });
+        var messages = buffer.split("\n\n");
+        buffer = messages.pop();
+        messages.forEach(function (message) {
+          var kind = "", data = "";
+          message.split("\n").forEach(function (line) {
+            if (line.indexOf("event: ") === 0) {
+              kind = line.slice(7);
+            } else if (line.indexOf("data: ") === 0) {
+              data += line.slice(6);
+            }
+          });
+          if (!kind) {
+            return;
+          }
+          var body = JSON.parse(data);
+          var atBottom = logs.scrollTop + logs.clientHeight >= logs.scrollHeight - 4;
+          if (kind === "log") {
+            if (!stepFilter) {
+              logs.appendChild(el("span", { "class": "name", text: body.name + " | " }));
+            }
+            logs.appendChild(document.createTextNode(body.text + "\n"));
+          } else if (kind === "event") {
+            if (body.name.indexOf("run.") === 0 && body.name !== "run.requested" && body.name !== "run.started") {
+              logs.appendChild(el("span", { "class": "event", text: "==> " + body.name + (body.step ? " " + body.step.name : "") + "\n" }));
+            }
+            scheduleRefresh();
+          } else if (kind === "end") {
+            scheduleRefresh();
+          }
+          if (atBottom) {
+            logs.scrollTop = logs.scrollHeight;
+          }
+        });
+        return read();
+      });
+    }
+
+    return read();
+  }).catch(function (error) {
+    if (error.name !== "AbortError") {
+      showError(error);
+    }
+  });
+}
+
+function showError(error) {
+  document.getElementById("logs").appendChild(el("span", { "class": "event", text: "Error: " + error.message + "\n" }));
+}
+
+loadLists();
+setInterval(loadLists, 5000);
+</script>
+</body>
+</html>
+`
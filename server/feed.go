@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// feed keeps the last items added to it and lets readers wait for new ones
+type feed struct {
+	items []interface{}
+	max   int
+	// first is the index of the first item kept
+	first int
+	// changed is closed and replaced when items are added or the feed is
+	// closed
+	changed chan struct{}
+	closed  bool
+	signal  *sync.Mutex
+}
+
+func newFeed(max int) *feed {
+	return &feed{
+		max:     max,
+		changed: make(chan struct{}),
+		signal:  &sync.Mutex{},
+	}
+}
+
+func (f *feed) add(item interface{}) {
+	f.signal.Lock()
+	defer f.signal.Unlock()
+
+	f.items = append(f.items, item)
+	if len(f.items) > f.max {
+		dropped := len(f.items) - f.max
+		f.items = f.items[dropped:]
+		f.first += dropped
+	}
+
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// close marks the feed as done. No items are added after it's closed
+func (f *feed) close() {
+	f.signal.Lock()
+	defer f.signal.Unlock()
+
+	f.closed = true
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// since returns the items from the given index on, the index to read from
+// next time, a channel closed when there are more items and if there will
+// be no more items
+func (f *feed) since(idx int) ([]interface{}, int, <-chan struct{}, bool) {
+	f.signal.Lock()
+	defer f.signal.Unlock()
+
+	if idx < f.first {
+		idx = f.first
+	}
+
+	items := append([]interface{}{}, f.items[idx-f.first:]...)
+
+	return items, f.first + len(f.items), f.changed, f.closed
+}
+
+// read calls write with each item of the feed. With follow, it waits for
+// new items until the feed is closed or the context is done
+func (f *feed) read(ctx context.Context, follow bool, write func(items []interface{})) {
+	next := 0
+	for {
+		items, idx, changed, closed := f.since(next)
+		next = idx
+
+		write(items)
+		if !follow || closed {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
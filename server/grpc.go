@@ -0,0 +1,334 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// grpcService is the path prefix of the methods of the service in
+	// trackman.proto
+	grpcService = "/trackman.v1.Trackman/"
+
+	// maxGRPCMessage is the largest request message, which is mostly the
+	// workflow file
+	maxGRPCMessage = maxWorkflowSize + 1024*1024
+)
+
+// gRPC status codes
+const (
+	grpcOK                = 0
+	grpcCancelled         = 1
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnauthenticated   = 16
+)
+
+// grpcError is an error returned to gRPC clients with its status code
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+func newGRPCError(code int, format string, args ...interface{}) error {
+	return &grpcError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// isGRPC returns true for the requests of gRPC clients
+func isGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// serveGRPC serves the methods of trackman.proto. The status of the call is
+// sent in the trailers, as gRPC clients expect it
+func (s *Server) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc+proto")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	err := s.callGRPC(w, r)
+	code := grpcOK
+	if err != nil {
+		code = grpcInternal
+		var statusErr *grpcError
+		if errors.As(err, &statusErr) {
+			code = statusErr.code
+		}
+		// the message is percent encoded
+		w.Header().Set("Grpc-Message", url.PathEscape(err.Error()))
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+}
+
+func (s *Server) callGRPC(w http.ResponseWriter, r *http.Request) error {
+	if !s.authorized(r) {
+		return newGRPCError(grpcUnauthenticated, "invalid token")
+	}
+	method := strings.TrimPrefix(r.URL.Path, grpcService)
+	switch method {
+	case "SubmitWorkflow", "ListWorkflows", "GetWorkflow", "CancelWorkflow", "PauseWorkflow", "ResumeWorkflow", "WatchEvents":
+	default:
+		return newGRPCError(grpcUnimplemented, "unknown method %s", strings.TrimPrefix(r.URL.Path, "/"))
+	}
+
+	request, err := readGRPCMessage(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if method == "SubmitWorkflow" {
+		return s.submitWorkflow(w, r, request)
+	}
+	if method == "ListWorkflows" {
+		return s.listWorkflows(w, request)
+	}
+
+	var id string
+	var reason string
+	var steps []string
+	var follow bool
+	err = decodeProto(request, func(field *protoField) (err error) {
+		switch {
+		case field.number == 1:
+			id, err = field.string()
+		case field.number == 2 && method == "CancelWorkflow":
+			reason, err = field.string()
+		case field.number == 2 && method == "WatchEvents":
+			var step string
+			step, err = field.string()
+			steps = append(steps, step)
+		case field.number == 3 && method == "WatchEvents":
+			follow, err = field.bool()
+		}
+
+		return err
+	})
+	if err != nil {
+		return newGRPCError(grpcInvalidArgument, "invalid request: %s", err)
+	}
+
+	run := s.Run(id)
+	if run == nil {
+		return newGRPCError(grpcNotFound, "run %s not found", id)
+	}
+
+	switch method {
+	case "GetWorkflow":
+		return writeGRPCMessage(w, workflowMessage(run.view(true)))
+	case "CancelWorkflow":
+		if reason == "" {
+			reason = "cancelled through the API"
+		}
+		run.Cancel(reason)
+		return writeGRPCMessage(w, workflowMessage(run.view(false)))
+	case "PauseWorkflow":
+		run.Pause()
+		return writeGRPCMessage(w, workflowMessage(run.view(false)))
+	case "ResumeWorkflow":
+		run.Resume()
+		return writeGRPCMessage(w, workflowMessage(run.view(false)))
+	default:
+		return watchEvents(w, r, run, steps, follow)
+	}
+}
+
+// submitWorkflow runs the workflow of a SubmitWorkflowRequest
+func (s *Server) submitWorkflow(w http.ResponseWriter, r *http.Request, request []byte) error {
+	var name string
+	var file []byte
+	var signature []byte
+	variables := make(map[string]string)
+	err := decodeProto(request, func(field *protoField) (err error) {
+		switch field.number {
+		case 1:
+			name, err = field.string()
+		case 2:
+			file = field.data
+		case 3:
+			// map entries are messages with the key and the value
+			var key, value string
+			err = decodeProto(field.data, func(entry *protoField) (err error) {
+				switch entry.number {
+				case 1:
+					key, err = entry.string()
+				case 2:
+					value, err = entry.string()
+				}
+
+				return err
+			})
+			variables[key] = value
+		case 4:
+			signature = field.data
+		}
+
+		return err
+	})
+	if err != nil {
+		return newGRPCError(grpcInvalidArgument, "invalid request: %s", err)
+	}
+
+	run, err := s.Submit(r.Context(), name, file, variables, signature)
+	if err != nil {
+		return newGRPCError(grpcInvalidArgument, "%s", err)
+	}
+
+	return writeGRPCMessage(w, workflowMessage(run.view(false)))
+}
+
+// listWorkflows lists the runs, limited to a status if the
+// ListWorkflowsRequest has one
+func (s *Server) listWorkflows(w http.ResponseWriter, request []byte) error {
+	var status string
+	err := decodeProto(request, func(field *protoField) (err error) {
+		if field.number == 1 {
+			status, err = field.string()
+		}
+
+		return err
+	})
+	if err != nil {
+		return newGRPCError(grpcInvalidArgument, "invalid request: %s", err)
+	}
+
+	response := &protoEncoder{}
+	for _, run := range s.Runs() {
+		view := run.view(false)
+		if status != "" && view.Status != status {
+			continue
+		}
+
+		response.message(1, workflowMessage(view))
+	}
+
+	return writeGRPCMessage(w, response)
+}
+
+// watchEvents sends the events of the run as Event messages, like
+// streamRun does for Server-Sent Events
+func watchEvents(w http.ResponseWriter, r *http.Request, run *Run, steps []string, follow bool) error {
+	next := 0
+	for {
+		items, idx, changed, closed := run.events.since(next)
+		next = idx
+
+		for _, item := range items {
+			event := item.(*runEvent)
+			if len(steps) != 0 && !contains(steps, event.step) {
+				continue
+			}
+
+			message := &protoEncoder{}
+			message.string(1, event.name)
+			message.string(2, run.ID)
+			message.string(3, event.step)
+			message.timestamp(4, event.at)
+			message.bytes(5, event.body)
+			if err := writeGRPCMessage(w, message); err != nil {
+				return err
+			}
+		}
+
+		if closed || !follow {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return newGRPCError(grpcCancelled, "%s", r.Context().Err())
+		}
+	}
+}
+
+// workflowMessage returns the run as a Workflow message
+func workflowMessage(view *runView) *protoEncoder {
+	message := &protoEncoder{}
+	message.string(1, view.ID)
+	message.string(2, view.Name)
+	message.string(3, view.Status)
+	message.bool(4, view.Paused)
+	message.timestamp(5, view.SubmittedAt)
+	if view.FinishedAt != nil {
+		message.timestamp(6, *view.FinishedAt)
+	}
+	message.duration(7, view.Duration)
+	message.string(8, view.CancelReason)
+	message.strings(9, view.Errors)
+	for _, step := range view.Steps {
+		stepMessage := &protoEncoder{}
+		stepMessage.string(1, step.Name)
+		stepMessage.string(2, step.Stage)
+		stepMessage.string(3, step.Status)
+		stepMessage.timestamp(4, step.StartedAt)
+		stepMessage.timestamp(5, step.FinishedAt)
+		stepMessage.duration(6, step.Duration)
+		stepMessage.int(7, int64(step.ExitCode))
+		stepMessage.int(8, int64(step.Attempts))
+		stepMessage.strings(9, step.DependsOn)
+		stepMessage.string(10, step.Signal)
+		stepMessage.bool(11, step.OOMKilled)
+		stepMessage.bool(12, step.TimedOut)
+		stepMessage.bool(13, step.RolledBack)
+		stepMessage.optionalDouble(14, step.Progress)
+		message.message(10, stepMessage)
+	}
+
+	return message
+}
+
+// readGRPCMessage reads the message of a unary request, which is prefixed by
+// a compression flag and its length
+func readGRPCMessage(body io.Reader) ([]byte, error) {
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(body, prefix); err != nil {
+		return nil, newGRPCError(grpcInvalidArgument, "failed to read the request: %s", err)
+	}
+	if prefix[0] != 0 {
+		return nil, newGRPCError(grpcUnimplemented, "compressed messages are not supported")
+	}
+
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > maxGRPCMessage {
+		return nil, newGRPCError(grpcResourceExhausted, "the request is larger than %d bytes", maxGRPCMessage)
+	}
+
+	message := make([]byte, length)
+	if _, err := io.ReadFull(body, message); err != nil {
+		return nil, newGRPCError(grpcInvalidArgument, "failed to read the request: %s", err)
+	}
+
+	return message, nil
+}
+
+// writeGRPCMessage sends a message to the client straight away
+func writeGRPCMessage(w http.ResponseWriter, message *protoEncoder) error {
+	frame := make([]byte, 5, 5+len(message.buff))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message.buff)))
+	if _, err := w.Write(append(frame, message.buff...)); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	return nil
+}
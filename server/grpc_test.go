@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+// grpcResponse is what a gRPC call returned
+type grpcResponse struct {
+	messages [][]byte
+	status   string
+	message  string
+}
+
+// callGRPC calls a method of the server with HTTP/2 without TLS, like gRPC
+// clients do
+func callGRPC(t *testing.T, url string, token string, method string, request *protoEncoder) *grpcResponse {
+	t.Helper()
+
+	protocols := &http.Protocols{}
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}, Timeout: 10 * time.Second}
+
+	frame := make([]byte, 5)
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(request.buff)))
+	req, err := http.NewRequest(http.MethodPost, url+grpcService+method, bytes.NewReader(append(frame, request.buff...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	response := &grpcResponse{}
+	for {
+		if _, err = io.ReadFull(resp.Body, frame); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		message := make([]byte, binary.BigEndian.Uint32(frame[1:]))
+		if _, err = io.ReadFull(resp.Body, message); err != nil {
+			t.Fatal(err)
+		}
+		response.messages = append(response.messages, message)
+	}
+	// the trailers are read once the body is
+	_, _ = ioutil.ReadAll(resp.Body)
+	response.status = resp.Trailer.Get("Grpc-Status")
+	response.message = resp.Trailer.Get("Grpc-Message")
+
+	return response
+}
+
+func newGRPCTestServer(t *testing.T, token string) (*Server, string) {
+	t.Helper()
+
+	server, err := NewServer(&Options{
+		WorkflowOptions: func() *utils.WorkflowOptions {
+			return &utils.WorkflowOptions{Timeout: 10 * time.Second}
+		},
+		Token: token,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	httpServer := httptest.NewUnstartedServer(server)
+	httpServer.Config.Protocols = &http.Protocols{}
+	httpServer.Config.Protocols.SetHTTP1(true)
+	httpServer.Config.Protocols.SetUnencryptedHTTP2(true)
+	httpServer.Start()
+	t.Cleanup(httpServer.Close)
+
+	return server, httpServer.URL
+}
+
+// stringFields returns the string fields of a message with the number
+func stringFields(t *testing.T, message []byte, number int) []string {
+	t.Helper()
+
+	var values []string
+	err := decodeProto(message, func(field *protoField) error {
+		if field.number == number {
+			values = append(values, string(field.data))
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return values
+}
+
+func TestGRPCWatchEvents(t *testing.T) {
+	_, url := newGRPCTestServer(t, "")
+
+	variables := &protoEncoder{}
+	variables.string(1, "GREETING")
+	variables.string(2, "hello")
+	submit := &protoEncoder{}
+	submit.string(1, "greet")
+	submit.string(2, `
+version: 1
+steps:
+  - name: greet
+    command: echo {{ .Var.GREETING }}
+`)
+	submit.message(3, variables)
+
+	response := callGRPC(t, url, "", "SubmitWorkflow", submit)
+	if response.status != "0" || len(response.messages) != 1 {
+		t.Fatalf("SubmitWorkflow returned %d messages with status %s: %s", len(response.messages), response.status, response.message)
+	}
+	id := stringFields(t, response.messages[0], 1)[0]
+
+	watch := &protoEncoder{}
+	watch.string(1, id)
+	watch.bool(3, true)
+	response = callGRPC(t, url, "", "WatchEvents", watch)
+	if response.status != "0" {
+		t.Fatalf("WatchEvents returned status %s: %s", response.status, response.message)
+	}
+
+	var names []string
+	for _, message := range response.messages {
+		names = append(names, stringFields(t, message, 1)...)
+		if workflowID := stringFields(t, message, 2); len(workflowID) != 1 || workflowID[0] != id {
+			t.Errorf("the event is of %v, want %s", workflowID, id)
+		}
+	}
+	events := strings.Join(names, " ")
+	if !strings.Contains(events, utils.EventRunSuccess) || !strings.HasSuffix(events, utils.EventWorkflowSuccess) {
+		t.Errorf("the events are %s, want %s and %s last", events, utils.EventRunSuccess, utils.EventWorkflowSuccess)
+	}
+
+	get := &protoEncoder{}
+	get.string(1, id)
+	response = callGRPC(t, url, "", "GetWorkflow", get)
+	if response.status != "0" || len(response.messages) != 1 {
+		t.Fatalf("GetWorkflow returned %d messages with status %s: %s", len(response.messages), response.status, response.message)
+	}
+	if status := stringFields(t, response.messages[0], 3); len(status) != 1 || status[0] != utils.OutcomeSuccess {
+		t.Errorf("the status of the run is %v, want %s", status, utils.OutcomeSuccess)
+	}
+	if steps := stringFields(t, response.messages[0], 10); len(steps) != 1 || stringFields(t, []byte(steps[0]), 1)[0] != "greet" {
+		t.Errorf("the steps of the run are %q, want greet", steps)
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	_, url := newGRPCTestServer(t, "s3cr3t")
+
+	get := &protoEncoder{}
+	get.string(1, "missing")
+	tests := []struct {
+		token  string
+		method string
+		status string
+	}{
+		{token: "", method: "GetWorkflow", status: "16"},
+		{token: "s3cr3t", method: "GetWorkflow", status: "5"},
+		{token: "s3cr3t", method: "DeleteWorkflow", status: "12"},
+	}
+
+	for _, test := range tests {
+		response := callGRPC(t, url, test.token, test.method, get)
+		if response.status != test.status {
+			t.Errorf("%s with token %q returned status %s, want %s", test.method, test.token, response.status, test.status)
+		}
+	}
+
+	submit := &protoEncoder{}
+	submit.string(2, "steps: [")
+	if response := callGRPC(t, url, "s3cr3t", "SubmitWorkflow", submit); response.status != "3" || response.message == "" {
+		t.Errorf("an invalid workflow returned status %s with %q, want 3 with the error", response.status, response.message)
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protoEncoder writes the fields of a protobuf message. Like proto3, fields
+// with their zero value are left out
+type protoEncoder struct {
+	buff []byte
+}
+
+func (e *protoEncoder) tag(field int, wireType int) {
+	e.buff = binary.AppendUvarint(e.buff, uint64(field)<<3|uint64(wireType))
+}
+
+func (e *protoEncoder) uint(field int, value uint64) {
+	if value == 0 {
+		return
+	}
+
+	e.tag(field, wireVarint)
+	e.buff = binary.AppendUvarint(e.buff, value)
+}
+
+// int writes int32 and int64 fields. Negative numbers take 10 bytes
+func (e *protoEncoder) int(field int, value int64) {
+	e.uint(field, uint64(value))
+}
+
+func (e *protoEncoder) bool(field int, value bool) {
+	if value {
+		e.uint(field, 1)
+	}
+}
+
+func (e *protoEncoder) bytes(field int, value []byte) {
+	if len(value) == 0 {
+		return
+	}
+
+	e.tag(field, wireBytes)
+	e.buff = binary.AppendUvarint(e.buff, uint64(len(value)))
+	e.buff = append(e.buff, value...)
+}
+
+func (e *protoEncoder) string(field int, value string) {
+	e.bytes(field, []byte(value))
+}
+
+func (e *protoEncoder) strings(field int, values []string) {
+	for _, value := range values {
+		e.tag(field, wireBytes)
+		e.buff = binary.AppendUvarint(e.buff, uint64(len(value)))
+		e.buff = append(e.buff, value...)
+	}
+}
+
+// optionalDouble writes the field if it's set, even if it's 0
+func (e *protoEncoder) optionalDouble(field int, value *float64) {
+	if value == nil {
+		return
+	}
+
+	e.tag(field, wireFixed64)
+	e.buff = binary.LittleEndian.AppendUint64(e.buff, math.Float64bits(*value))
+}
+
+// message writes a message field, even if it's empty
+func (e *protoEncoder) message(field int, message *protoEncoder) {
+	e.tag(field, wireBytes)
+	e.buff = binary.AppendUvarint(e.buff, uint64(len(message.buff)))
+	e.buff = append(e.buff, message.buff...)
+}
+
+// timestamp writes a google.protobuf.Timestamp. Zero times are left out
+func (e *protoEncoder) timestamp(field int, value time.Time) {
+	if value.IsZero() {
+		return
+	}
+
+	timestamp := &protoEncoder{}
+	timestamp.int(1, value.Unix())
+	timestamp.int(2, int64(value.Nanosecond()))
+	e.message(field, timestamp)
+}
+
+// duration writes a google.protobuf.Duration
+func (e *protoEncoder) duration(field int, value time.Duration) {
+	if value == 0 {
+		return
+	}
+
+	duration := &protoEncoder{}
+	duration.int(1, int64(value/time.Second))
+	duration.int(2, int64(value%time.Second))
+	e.message(field, duration)
+}
+
+// protoField is a field read from a protobuf message. Varints are in value
+// and length delimited fields in data
+type protoField struct {
+	number   int
+	wireType int
+	value    uint64
+	data     []byte
+}
+
+func (f *protoField) string() (string, error) {
+	if f.wireType != wireBytes {
+		return "", fmt.Errorf("field %d is not a string", f.number)
+	}
+
+	return string(f.data), nil
+}
+
+func (f *protoField) bool() (bool, error) {
+	if f.wireType != wireVarint {
+		return false, fmt.Errorf("field %d is not a bool", f.number)
+	}
+
+	return f.value != 0, nil
+}
+
+// decodeProto calls read with each field of the message, in the order they
+// were written. Fixed size fields are skipped as none of the requests have
+// them
+func decodeProto(buff []byte, read func(field *protoField) error) error {
+	for len(buff) > 0 {
+		key, n := binary.Uvarint(buff)
+		if n <= 0 {
+			return fmt.Errorf("invalid field key")
+		}
+		buff = buff[n:]
+
+		field := &protoField{number: int(key >> 3), wireType: int(key & 7)}
+		switch field.wireType {
+		case wireVarint:
+			if field.value, n = binary.Uvarint(buff); n <= 0 {
+				return fmt.Errorf("invalid varint in field %d", field.number)
+			}
+			buff = buff[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(buff)
+			if n <= 0 || length > uint64(len(buff)-n) {
+				return fmt.Errorf("invalid length of field %d", field.number)
+			}
+			field.data = buff[n : n+int(length)]
+			buff = buff[n+int(length):]
+		case wireFixed64:
+			if len(buff) < 8 {
+				return fmt.Errorf("invalid field %d", field.number)
+			}
+			buff = buff[8:]
+			continue
+		case wireFixed32:
+			if len(buff) < 4 {
+				return fmt.Errorf("invalid field %d", field.number)
+			}
+			buff = buff[4:]
+			continue
+		default:
+			return fmt.Errorf("unsupported wire type %d of field %d", field.wireType, field.number)
+		}
+
+		if err := read(field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
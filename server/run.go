@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	// RunRunning is the status of a run that hasn't finished. Finished runs
+	// have the outcome of their workflow as their status
+	RunRunning = "running"
+
+	// maxLogLines is the number of output lines kept for each run
+	maxLogLines = 10000
+	// maxEvents is the number of events kept for each run
+	maxEvents = 1000
+)
+
+// Run is a workflow submitted to the server
+type Run struct {
+	ID          string
+	Name        string
+	SubmittedAt time.Time
+
+	workflow *utils.Workflow
+	// file, variables and signature are what the run was submitted with, to
+	// retry it
+	file      []byte
+	variables map[string]string
+	signature []byte
+	cancel    context.CancelFunc
+	logs      *runLogs
+	events    *feed
+	// stream has both the output and the events, in the order they happened
+	stream *feed
+	result *utils.WorkflowResult
+	done   chan struct{}
+	signal *sync.Mutex
+}
+
+// runView is how a run is shown by the API
+type runView struct {
+	ID           string              `json:"id"`
+	Name         string              `json:"name"`
+	Status       string              `json:"status"`
+	Paused       bool                `json:"paused,omitempty"`
+	SubmittedAt  time.Time           `json:"submitted_at"`
+	FinishedAt   *time.Time          `json:"finished_at,omitempty"`
+	Duration     time.Duration       `json:"duration,omitempty"`
+	CancelReason string              `json:"cancel_reason,omitempty"`
+	Errors       []string            `json:"errors,omitempty"`
+	Steps        []*utils.StepResult `json:"steps,omitempty"`
+}
+
+// Status returns running until the workflow is finished and its outcome after
+func (r *Run) Status() string {
+	r.signal.Lock()
+	defer r.signal.Unlock()
+
+	if r.result == nil {
+		return RunRunning
+	}
+
+	return r.result.Outcome
+}
+
+// Result returns the result of the workflow or nil if it's still running
+func (r *Run) Result() *utils.WorkflowResult {
+	r.signal.Lock()
+	defer r.signal.Unlock()
+
+	return r.result
+}
+
+// Done is closed once the workflow is finished
+func (r *Run) Done() <-chan struct{} {
+	return r.done
+}
+
+// Cancel stops the workflow for the reason without waiting for it. Steps
+// that are running are given the grace period of the workflow to stop
+func (r *Run) Cancel(reason string) {
+	go func() {
+		if _, err := r.workflow.Cancel(context.Background(), reason); err != nil {
+			// not running yet
+			r.cancel()
+		}
+	}()
+}
+
+// Pause stops the workflow from starting new steps, letting the running ones
+// finish
+func (r *Run) Pause() {
+	r.workflow.Pause()
+}
+
+// Resume lets a paused workflow start new steps again
+func (r *Run) Resume() {
+	r.workflow.ResumeDispatch()
+}
+
+func (r *Run) start(ctx context.Context) {
+	defer close(r.done)
+	defer r.logs.close()
+	defer r.events.close()
+	defer r.stream.close()
+
+	// errors running the workflow are also in its result
+	result, _ := r.workflow.Run(ctx)
+
+	r.signal.Lock()
+	defer r.signal.Unlock()
+
+	r.result = result
+}
+
+// view returns the run as shown by the API. The steps are only included if
+// asked for
+func (r *Run) view(withSteps bool) *runView {
+	view := &runView{
+		ID:          r.ID,
+		Name:        r.Name,
+		Status:      RunRunning,
+		SubmittedAt: r.SubmittedAt,
+	}
+
+	result := r.Result()
+	if result == nil {
+		view.Paused = r.workflow.Paused()
+		if withSteps {
+			view.Steps = r.workflow.Progress()
+		}
+
+		return view
+	}
+
+	view.Status = result.Outcome
+	view.FinishedAt = &result.FinishedAt
+	view.Duration = result.Duration
+	view.CancelReason = result.CancelReason
+	if withSteps {
+		view.Steps = result.Steps
+	}
+	if merr, ok := result.Errors.(*multierror.Error); ok {
+		for _, err := range merr.Errors {
+			view.Errors = append(view.Errors, err.Error())
+		}
+	} else if result.Errors != nil {
+		view.Errors = append(view.Errors, result.Errors.Error())
+	}
+
+	return view
+}
+
+// logLine is a line of output of a step
+type logLine struct {
+	step string
+	name string
+	text string
+}
+
+// runLogs keeps the last lines of the output of the steps of a run, and
+// adds them to its stream. It implements utils.OutputSink
+type runLogs struct {
+	*feed
+	stream *feed
+}
+
+func newRunLogs(stream *feed) *runLogs {
+	return &runLogs{feed: newFeed(maxLogLines), stream: stream}
+}
+
+func (l *runLogs) addLine(line *logLine) {
+	l.add(line)
+	l.stream.add(line)
+}
+
+// Writer implements utils.OutputSink
+func (l *runLogs) Writer(step string, name string) io.WriteCloser {
+	return &runLogWriter{
+		logs:   l,
+		step:   step,
+		name:   name,
+		buffer: &bytes.Buffer{},
+	}
+}
+
+type runLogWriter struct {
+	logs   *runLogs
+	step   string
+	name   string
+	buffer *bytes.Buffer
+}
+
+// Write implements io.Writer
+func (w *runLogWriter) Write(b []byte) (int, error) {
+	w.buffer.Write(b)
+
+	for {
+		idx := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+
+		line := string(w.buffer.Next(idx + 1))
+		w.logs.addLine(&logLine{step: w.step, name: w.name, text: strings.TrimRight(line, "\r\n")})
+	}
+
+	return len(b), nil
+}
+
+// Close implements io.Closer
+func (w *runLogWriter) Close() error {
+	if w.buffer.Len() != 0 {
+		w.logs.addLine(&logLine{step: w.step, name: w.name, text: w.buffer.String()})
+		w.buffer.Reset()
+	}
+
+	return nil
+}
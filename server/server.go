@@ -0,0 +1,422 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/history"
+	"github.com/cloud66-oss/trackman/notifiers"
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultMaxRuns is the number of finished runs kept by default
+	DefaultMaxRuns = 100
+
+	// maxWorkflowSize is the largest workflow file that can be submitted
+	maxWorkflowSize = 10 * 1024 * 1024
+)
+
+// Options configures a Server
+type Options struct {
+	// WorkflowOptions returns the options to run each submitted workflow with
+	WorkflowOptions func() *utils.WorkflowOptions
+	// Token is required as a bearer token by all requests if set
+	Token string
+	// MaxRuns is the number of finished runs kept. The oldest ones are
+	// forgotten first. Defaults to DefaultMaxRuns
+	MaxRuns int
+	// History is where the finished runs are kept, if anywhere. They are
+	// listed by /history
+	History history.RunStore
+	// Dashboard serves a web dashboard of the runs on /
+	Dashboard bool
+	Logger    *logrus.Logger
+}
+
+// Server runs submitted workflows and serves a REST API to follow and
+// cancel them. Runs are only kept in memory
+type Server struct {
+	options *Options
+	runs    []*Run
+	signal  *sync.Mutex
+	joiner  *sync.WaitGroup
+	closed  bool
+}
+
+// NewServer creates a new Server. It returns ErrNoOptions without workflow
+// options
+func NewServer(options *Options) (*Server, error) {
+	if options == nil || options.WorkflowOptions == nil {
+		return nil, utils.ErrNoOptions
+	}
+	if options.MaxRuns <= 0 {
+		options.MaxRuns = DefaultMaxRuns
+	}
+	if options.Logger == nil {
+		options.Logger = logrus.StandardLogger()
+	}
+
+	return &Server{
+		options: options,
+		signal:  &sync.Mutex{},
+		joiner:  &sync.WaitGroup{},
+	}, nil
+}
+
+// Submit loads the workflow and starts running it. The signature is only
+// needed if the workflows have to be signed
+func (s *Server) Submit(ctx context.Context, name string, buff []byte, variables map[string]string, signature []byte) (*Run, error) {
+	s.signal.Lock()
+	closed := s.closed
+	s.signal.Unlock()
+	if closed {
+		return nil, fmt.Errorf("server is shutting down")
+	}
+
+	if name == "" {
+		name = "workflow"
+	}
+
+	stream := newFeed(maxStreamItems)
+	logs := newRunLogs(stream)
+	events := newFeed(maxEvents)
+	options := s.options.WorkflowOptions()
+	options.Name = name
+	options.Variables = variables
+	options.Signature = signature
+	options.Output = logs
+
+	// the events of the run are kept for the API and passed on to the
+	// notifiers of the server
+	registry := options.Notifiers
+	if registry == nil {
+		registry = utils.NewNotifierRegistry()
+	}
+	options.Notifiers = utils.NewNotifierRegistry()
+	if err := options.Notifiers.Register("server", func(ctx context.Context, logger *logrus.Logger, event *utils.Event) error {
+		// the event is kept as it is now, with its secrets masked
+		body, err := json.Marshal(notifiers.NewWebhookEvent(event))
+		if err != nil {
+			return err
+		}
+
+		item := &runEvent{name: event.Name, at: event.At, body: json.RawMessage(event.Payload.Workflow.MaskSecrets(string(body)))}
+		if event.Payload.Spinner != nil {
+			item.step = event.Payload.Step.Name
+		}
+		events.add(item)
+		stream.add(item)
+
+		return registry.Notify(ctx, logger, event)
+	}); err != nil {
+		return nil, err
+	}
+
+	workflow, err := utils.LoadWorkflowFromBytes(ctx, options, buff)
+	if err != nil {
+		return nil, err
+	}
+	// there is no one to answer
+	for _, step := range workflow.Steps {
+		if step.AskToProceed {
+			return nil, fmt.Errorf("step %s asks to proceed, which isn't possible on the server", step.Name)
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	run := &Run{
+		ID:          workflow.SessionID(),
+		Name:        name,
+		SubmittedAt: time.Now(),
+		workflow:    workflow,
+		file:        buff,
+		variables:   variables,
+		signature:   signature,
+		cancel:      cancel,
+		logs:        logs,
+		events:      events,
+		stream:      stream,
+		done:        make(chan struct{}),
+		signal:      &sync.Mutex{},
+	}
+
+	s.signal.Lock()
+	s.runs = append(s.runs, run)
+	s.forgetRuns()
+	s.joiner.Add(1)
+	s.signal.Unlock()
+
+	s.options.Logger.WithField("run", run.ID).Infof("Running %s", name)
+
+	go func() {
+		defer s.joiner.Done()
+		defer cancel()
+
+		run.start(runCtx)
+		s.options.Logger.WithField("run", run.ID).Infof("Finished %s with %s", name, run.Status())
+	}()
+
+	return run, nil
+}
+
+// forgetRuns removes the oldest finished runs past MaxRuns
+func (s *Server) forgetRuns() {
+	finished := 0
+	for _, run := range s.runs {
+		if run.Result() != nil {
+			finished++
+		}
+	}
+
+	kept := s.runs[:0]
+	for _, run := range s.runs {
+		if finished > s.options.MaxRuns && run.Result() != nil {
+			finished--
+			continue
+		}
+
+		kept = append(kept, run)
+	}
+	s.runs = kept
+}
+
+// Runs returns all runs in the order they were submitted
+func (s *Server) Runs() []*Run {
+	s.signal.Lock()
+	defer s.signal.Unlock()
+
+	return append([]*Run{}, s.runs...)
+}
+
+// Run returns the run with the given id or nil
+func (s *Server) Run(id string) *Run {
+	s.signal.Lock()
+	defer s.signal.Unlock()
+
+	for _, run := range s.runs {
+		if run.ID == id {
+			return run
+		}
+	}
+
+	return nil
+}
+
+// Close stops accepting workflows, cancels the running ones and waits for
+// them to finish
+func (s *Server) Close() {
+	s.signal.Lock()
+	s.closed = true
+	runs := append([]*Run{}, s.runs...)
+	s.signal.Unlock()
+
+	for _, run := range runs {
+		run.Cancel("the server is stopping")
+	}
+
+	s.joiner.Wait()
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the dashboard asks for the token itself
+	if s.options.Dashboard && r.URL.Path == "/" {
+		s.serveDashboard(w, r)
+		return
+	}
+
+	// gRPC clients check the token with the status of the call
+	if isGRPC(r) {
+		s.serveGRPC(w, r)
+		return
+	}
+
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+		return
+	}
+
+	// paths are /runs, /runs/{id}, /runs/{id}/logs, /runs/{id}/stream, /runs/{id}/cancel, /runs/{id}/pause,
+	// /runs/{id}/resume, /runs/{id}/retry, /history and /history/{id}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] == "history" && len(parts) <= 2 {
+		s.serveHistory(w, r, parts[1:])
+		return
+	}
+	if parts[0] != "runs" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			s.listRuns(w, r)
+		case http.MethodPost:
+			s.submitRun(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+		}
+
+		return
+	}
+
+	run := s.Run(parts[1])
+	if run == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("run %s not found", parts[1]))
+		return
+	}
+
+	action := ""
+	if len(parts) == 3 {
+		action = parts[2]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, run.view(true))
+	case action == "logs" && r.Method == http.MethodGet:
+		s.streamLogs(w, r, run)
+	case action == "stream" && r.Method == http.MethodGet:
+		s.streamRun(w, r, run)
+	case action == "cancel" && r.Method == http.MethodPost:
+		run.Cancel("cancelled through the API")
+		writeJSON(w, http.StatusAccepted, run.view(false))
+	case action == "pause" && r.Method == http.MethodPost:
+		run.Pause()
+		writeJSON(w, http.StatusOK, run.view(false))
+	case action == "resume" && r.Method == http.MethodPost:
+		run.Resume()
+		writeJSON(w, http.StatusOK, run.view(false))
+	case action == "retry" && r.Method == http.MethodPost:
+		s.retryRun(w, r, run)
+	case action == "" || action == "logs" || action == "stream" || action == "cancel" || action == "pause" || action == "resume" || action == "retry":
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+	}
+}
+
+// authorized returns true if the request has the token of the server, or if
+// the server has no token
+func (s *Server) authorized(r *http.Request) bool {
+	if s.options.Token == "" {
+		return true
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.options.Token)) == 1
+}
+
+func (s *Server) listRuns(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+
+	views := make([]*runView, 0)
+	for _, run := range s.Runs() {
+		view := run.view(false)
+		if status != "" && view.Status != status {
+			continue
+		}
+
+		views = append(views, view)
+	}
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+// submitRun runs the workflow in the body of the request. Variables are
+// passed as set=key=value query parameters like the run command
+func (s *Server) submitRun(w http.ResponseWriter, r *http.Request) {
+	buff, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWorkflowSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	variables := make(map[string]string)
+	for _, pair := range r.URL.Query()["set"] {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid variable %s. use key=value", pair))
+			return
+		}
+
+		variables[parts[0]] = parts[1]
+	}
+
+	// signatures have new lines, so they are sent encoded
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get("X-Trackman-Signature"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid signature: %s", err))
+		return
+	}
+
+	run, err := s.Submit(r.Context(), r.URL.Query().Get("name"), buff, variables, signature)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, run.view(false))
+}
+
+// retryRun runs the workflow of the run again, with the same variables
+func (s *Server) retryRun(w http.ResponseWriter, r *http.Request, run *Run) {
+	retry, err := s.Submit(r.Context(), run.Name, run.file, run.variables, run.signature)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, retry.view(false))
+}
+
+// streamLogs writes the output of the steps of the run, each line prefixed
+// with the name of the step unless only one step is asked for. With
+// follow, new lines are written as they come until the run is finished
+func (s *Server) streamLogs(w http.ResponseWriter, r *http.Request, run *Run) {
+	step := r.URL.Query().Get("step")
+	follow := r.URL.Query().Get("follow") == "true"
+	flusher, _ := w.(http.Flusher)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	run.logs.read(r.Context(), follow, func(items []interface{}) {
+		for _, item := range items {
+			line := item.(*logLine)
+			switch {
+			case step == "":
+				fmt.Fprintf(w, "%s | %s\n", line.name, line.text)
+			case line.step == step:
+				fmt.Fprintln(w, line.text)
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	server, err := NewServer(&Options{
+		WorkflowOptions: func() *utils.WorkflowOptions {
+			return &utils.WorkflowOptions{Timeout: 10 * time.Second}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func waitForRun(t *testing.T, run *Run) {
+	t.Helper()
+
+	select {
+	case <-run.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("run %s didn't finish", run.ID)
+	}
+}
+
+// serve sends a request to the server and returns its response
+func serve(server *Server, method string, target string, body string, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+
+	return w
+}
+
+func decodeView(t *testing.T, w *httptest.ResponseRecorder) *runView {
+	t.Helper()
+
+	view := &runView{}
+	if err := json.NewDecoder(w.Body).Decode(view); err != nil {
+		t.Fatal(err)
+	}
+
+	return view
+}
+
+func TestAPI(t *testing.T) {
+	server := newTestServer(t)
+	server.options.Token = "s3cr3t"
+
+	if w := serve(server, http.MethodGet, "/runs", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("listing the runs without the token returned %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w := serve(server, http.MethodPost, "/runs?name=greet&set=WHO=world", `
+version: 1
+steps:
+  - name: greet
+    command: echo hello {{ .Var.WHO }}
+`, "s3cr3t")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("submitting the workflow returned %d: %s", w.Code, w.Body)
+	}
+	submitted := decodeView(t, w)
+	if submitted.Name != "greet" || submitted.Status != RunRunning {
+		t.Errorf("the submitted run is %s with %s, want greet with %s", submitted.Name, submitted.Status, RunRunning)
+	}
+	waitForRun(t, server.Run(submitted.ID))
+
+	w = serve(server, http.MethodGet, "/runs/"+submitted.ID, "", "s3cr3t")
+	if view := decodeView(t, w); view.Status != utils.OutcomeSuccess || len(view.Steps) != 1 || view.Steps[0].Status != utils.ResultSuccess {
+		t.Errorf("the run is %s with %d steps, want %s with greet %s", view.Status, len(view.Steps), utils.OutcomeSuccess, utils.ResultSuccess)
+	}
+
+	w = serve(server, http.MethodGet, "/runs/"+submitted.ID+"/logs?step=greet", "", "s3cr3t")
+	if logs := w.Body.String(); logs != "hello world\n" {
+		t.Errorf("the logs of greet are %q, want %q", logs, "hello world\n")
+	}
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		want   int
+		runs   int
+	}{
+		{method: http.MethodGet, target: "/runs", want: http.StatusOK, runs: 1},
+		{method: http.MethodGet, target: "/runs?status=" + utils.OutcomeSuccess, want: http.StatusOK, runs: 1},
+		{method: http.MethodGet, target: "/runs?status=" + utils.OutcomeFailed, want: http.StatusOK, runs: 0},
+		{method: http.MethodPost, target: "/runs?set=WHO", body: "version: 1", want: http.StatusBadRequest},
+		{method: http.MethodPost, target: "/runs", body: "steps: [", want: http.StatusBadRequest},
+		{method: http.MethodDelete, target: "/runs/" + submitted.ID, want: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, target: "/runs/" + submitted.ID + "/unknown", want: http.StatusNotFound},
+		{method: http.MethodGet, target: "/runs/missing", want: http.StatusNotFound},
+		{method: http.MethodGet, target: "/unknown", want: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		w := serve(server, test.method, test.target, test.body, "s3cr3t")
+		if w.Code != test.want {
+			t.Errorf("%s %s returned %d, want %d: %s", test.method, test.target, w.Code, test.want, w.Body)
+			continue
+		}
+		if test.want != http.StatusOK {
+			continue
+		}
+
+		var views []*runView
+		if err := json.NewDecoder(w.Body).Decode(&views); err != nil {
+			t.Fatal(err)
+		}
+		if len(views) != test.runs {
+			t.Errorf("%s %s returned %d runs, want %d", test.method, test.target, len(views), test.runs)
+		}
+	}
+}
+
+func TestCancelRun(t *testing.T) {
+	server := newTestServer(t)
+	run, err := server.Submit(context.Background(), "", []byte(`
+version: 1
+steps:
+  - name: sleep
+    command: sleep 10
+`), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the workflow is only cancelled once it runs
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if steps := run.view(true).Steps; len(steps) == 1 && steps[0].Status == utils.ResultRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the step didn't start")
+		}
+	}
+
+	if w := serve(server, http.MethodPost, "/runs/"+run.ID+"/cancel", "", ""); w.Code != http.StatusAccepted {
+		t.Fatalf("cancelling the run returned %d: %s", w.Code, w.Body)
+	}
+	waitForRun(t, run)
+
+	view := run.view(true)
+	if view.Status != utils.OutcomeCancelled || view.CancelReason != "cancelled through the API" {
+		t.Errorf("the run is %s for %q, want %s through the API", view.Status, view.CancelReason, utils.OutcomeCancelled)
+	}
+	if view.Steps[0].Status != utils.ResultCancelled {
+		t.Errorf("the step is %s, want %s", view.Steps[0].Status, utils.ResultCancelled)
+	}
+}
+
+func TestForgetRuns(t *testing.T) {
+	server := newTestServer(t)
+	server.options.MaxRuns = 2
+
+	var runs []*Run
+	for idx := 0; idx < 4; idx++ {
+		run, err := server.Submit(context.Background(), "", []byte(`
+version: 1
+steps:
+  - name: hello
+    command: echo hello
+`), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitForRun(t, run)
+		runs = append(runs, run)
+	}
+
+	// runs are forgotten when the next one is submitted
+	kept := server.Runs()
+	if len(kept) != 3 || kept[0] != runs[1] || kept[2] != runs[3] {
+		t.Fatalf("%d runs are kept, want the last 3", len(kept))
+	}
+	if server.Run(runs[0].ID) != nil {
+		t.Error("the oldest run is still kept")
+	}
+
+	server.Close()
+	if _, err := server.Submit(context.Background(), "", []byte("version: 1"), nil, nil); err == nil {
+		t.Error("a workflow was submitted after the server was closed")
+	}
+}
+
+func TestSubmitWithoutNotifiers(t *testing.T) {
+	server := newTestServer(t)
+	run, err := server.Submit(context.Background(), "", []byte(`
+version: 1
+steps:
+  - name: hello
+    command: echo hello
+`), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForRun(t, run)
+
+	if status := run.Status(); status != utils.OutcomeSuccess {
+		t.Errorf("the run is %s, want %s", status, utils.OutcomeSuccess)
+	}
+	if events, _, _, _ := run.events.since(0); len(events) == 0 {
+		t.Error("no events were kept for the run")
+	}
+}
+
+func TestNewServerWithoutOptions(t *testing.T) {
+	for _, options := range []*Options{nil, {Token: "s3cr3t"}} {
+		if _, err := NewServer(options); err != utils.ErrNoOptions {
+			t.Errorf("NewServer(%v) returned %v, want ErrNoOptions", options, err)
+		}
+	}
+}
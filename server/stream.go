@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxStreamItems is the number of log lines and events kept for each
+	// run to stream
+	maxStreamItems = maxLogLines + maxEvents
+
+	// streamKeepAlive is how often a comment is sent to idle streams, so
+	// proxies don't close them
+	streamKeepAlive = 15 * time.Second
+)
+
+// runEvent is an event of a run, kept as it's posted to webhooks
+type runEvent struct {
+	name string
+	step string
+	at   time.Time
+	body json.RawMessage
+}
+
+// streamLog is a line of output as it's sent in a stream
+type streamLog struct {
+	Step string `json:"step"`
+	Name string `json:"name"`
+	Text string `json:"text"`
+}
+
+// streamEnd is the last message of a stream
+type streamEnd struct {
+	Status string `json:"status"`
+}
+
+// streamRun sends the output of the steps of the run and its events as they
+// happen as Server-Sent Events, in the order they happened, until the run is
+// finished, or only the ones kept so far with follow=false. Lines of output
+// are log messages and events are event messages, and the stream ends with
+// an end message with the status of the run. They can be limited to some
+// steps with step, and to logs or events with only. Each message has an id,
+// so a client reconnecting with Last-Event-ID gets the messages it missed
+// that are still kept
+func (s *Server) streamRun(w http.ResponseWriter, r *http.Request, run *Run) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	steps := r.URL.Query()["step"]
+	follow := r.URL.Query().Get("follow") != "false"
+	only := r.URL.Query().Get("only")
+	if only != "" && only != "log" && only != "event" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid only %s. use log or event", only))
+		return
+	}
+
+	next := 0
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		id, err := strconv.Atoi(lastID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid Last-Event-ID %s", lastID))
+			return
+		}
+		next = id + 1
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx buffers responses otherwise
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		items, idx, changed, closed := run.stream.since(next)
+		first := idx - len(items)
+		next = idx
+
+		for offset, item := range items {
+			var kind, step string
+			var data interface{}
+			switch item := item.(type) {
+			case *logLine:
+				kind, step, data = "log", item.step, &streamLog{Step: item.step, Name: item.name, Text: item.text}
+			case *runEvent:
+				kind, step, data = "event", item.step, item.body
+			}
+			if (only != "" && only != kind) || (len(steps) != 0 && !contains(steps, step)) {
+				continue
+			}
+
+			writeMessage(w, first+offset, kind, data)
+		}
+
+		if closed || !follow {
+			writeMessage(w, next, "end", &streamEnd{Status: run.Status()})
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeMessage writes a Server-Sent Event. The data is sent as JSON, which
+// has no new lines
+func writeMessage(w http.ResponseWriter, id int, kind string, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, kind, body)
+}
+
+func contains(items []string, item string) bool {
+	for _, value := range items {
+		if value == item {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,33 @@
+package signing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestBlake2b512(t *testing.T) {
+	sequence := make([]byte, 256)
+	for idx := range sequence {
+		sequence[idx] = byte(idx)
+	}
+
+	tests := []struct {
+		name  string
+		value []byte
+		want  string
+	}{
+		{name: "empty", value: nil, want: "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		// RFC 7693, appendix A
+		{name: "abc", value: []byte("abc"), want: "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+		{name: "one block", value: bytes.Repeat([]byte("a"), 128), want: "fc6c71f688f43ea7d60817478808f3cac753e61571865c95adbc2d9122c943a76b92c2cb1047ef3fe7bf6e436ec1d0a99a9e5b216780bf7fed9d7ca91d3a8f3b"},
+		{name: "one block and a byte", value: bytes.Repeat([]byte("a"), 129), want: "55e6e0eb418149a8af92fd9ddc99254781b2f522a131b4f4d984404b71a00e1167b8124d5dcddd4c6977b299392335d6edd303da6d344d74bbef2d38101b232b"},
+		{name: "two blocks", value: sequence, want: "1ecc896f34d3f9cac484c73f75f6a5fb58ee6784be41b35f46067b9c65c63a6794d3d744112c653f73dd7deb6666204c5a9bfa5b46081fc10fdbe7884fa5cbf8"},
+	}
+
+	for _, test := range tests {
+		if got := hex.EncodeToString(blake2b512(test.value)); got != test.want {
+			t.Errorf("the hash of %s is %s, want %s", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package signing
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// GPG is a utils.SignatureVerifier checking detached GPG signatures with
+// gpg. The signatures are in a .sig file next to the workflow, as created by
+// gpg --detach-sign workflow.yml, armored or not
+type GPG struct {
+	keys [][]byte
+}
+
+// NewGPG creates a verifier trusting the public keys in the files, as
+// exported by gpg --export. Only these keys are trusted, not the ones in the
+// keyring of the user
+func NewGPG(keyFiles []string) (*GPG, error) {
+	if len(keyFiles) == 0 {
+		return nil, errors.New("gpg needs a public key")
+	}
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return nil, errors.New("gpg is needed to verify the signatures")
+	}
+
+	verifier := &GPG{}
+	for _, file := range keyFiles {
+		buff, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		verifier.keys = append(verifier.keys, buff)
+	}
+
+	return verifier, nil
+}
+
+// Verify implements utils.SignatureVerifier
+func (g *GPG) Verify(content []byte, signature []byte) error {
+	// a keyring of its own, so only the trusted keys are used
+	home, err := ioutil.TempDir("", "trackman-gpg")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(home)
+
+	files := map[string][]byte{
+		"workflow":  content,
+		"signature": signature,
+		"keys":      bytes.Join(g.keys, []byte("\n")),
+	}
+	for name, buff := range files {
+		if err = ioutil.WriteFile(filepath.Join(home, name), buff, 0600); err != nil {
+			return err
+		}
+	}
+
+	if output, err := g.gpg(home, "--import", filepath.Join(home, "keys")); err != nil {
+		return fmt.Errorf("failed to import the gpg keys: %s %s", err, output)
+	}
+
+	output, err := g.gpg(home, "--status-fd", "1", "--verify", filepath.Join(home, "signature"), filepath.Join(home, "workflow"))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %s", gpgStatus(output))
+	}
+	// a good signature by an expired or revoked key isn't valid
+	if !strings.Contains(output, "[GNUPG:] VALIDSIG ") {
+		return fmt.Errorf("invalid signature: %s", gpgStatus(output))
+	}
+
+	return nil
+}
+
+// Extension implements utils.SignatureVerifier
+func (g *GPG) Extension() string {
+	return ".sig"
+}
+
+func (g *GPG) gpg(home string, args ...string) (string, error) {
+	cmd := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--no-tty", "--quiet"}, args...)...)
+	output, err := cmd.CombinedOutput()
+
+	return string(output), err
+}
+
+// gpgStatus returns the status lines of gpg explaining why the signature
+// isn't valid
+func gpgStatus(output string) string {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, status := range []string{"BADSIG", "ERRSIG", "NO_PUBKEY", "EXPKEYSIG", "REVKEYSIG", "NODATA"} {
+			if strings.HasPrefix(line, "[GNUPG:] "+status) {
+				lines = append(lines, strings.TrimPrefix(line, "[GNUPG:] "))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return strings.TrimSpace(output)
+	}
+
+	return strings.Join(lines, ", ")
+}
@@ -0,0 +1,98 @@
+package signing
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testGPGKey creates a signing key in a keyring of its own and returns the
+// keyring and the file of the exported public key
+func testGPGKey(t *testing.T, name string) (string, string) {
+	t.Helper()
+
+	home, err := ioutil.TempDir("", "trackman-gpg-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(home) })
+
+	testGPG(t, home, "--passphrase", "", "--quick-gen-key", name+" <"+name+"@example.com>", "ed25519", "sign", "never")
+	key := filepath.Join(home, "key.gpg")
+	testGPG(t, home, "--output", key, "--export", name+"@example.com")
+
+	return home, key
+}
+
+// testGPGSign returns the detached signature of the content
+func testGPGSign(t *testing.T, home string, content []byte, armor bool) []byte {
+	t.Helper()
+
+	file := filepath.Join(home, "content")
+	if err := ioutil.WriteFile(file, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"--passphrase", "", "--output", file + ".sig", "--detach-sign", file}
+	if armor {
+		args = append([]string{"--armor"}, args...)
+	}
+	os.Remove(file + ".sig")
+	testGPG(t, home, args...)
+
+	signature, err := ioutil.ReadFile(file + ".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signature
+}
+
+func testGPG(t *testing.T, home string, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--no-tty", "--quiet", "--pinentry-mode", "loopback"}, args...)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("gpg %s failed: %s %s", strings.Join(args, " "), err, output)
+	}
+}
+
+func TestGPGVerify(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+
+	home, key := testGPGKey(t, "trusted")
+	otherHome, _ := testGPGKey(t, "other")
+	content := []byte("version: 1\nsteps:\n  - name: build\n    command: make\n")
+
+	verifier, err := NewGPG([]string{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		content   []byte
+		signature []byte
+		wantErr   string
+	}{
+		{name: "binary", content: content, signature: testGPGSign(t, home, content, false)},
+		{name: "armored", content: content, signature: testGPGSign(t, home, content, true)},
+		{name: "changed content", content: append([]byte("# "), content...), signature: testGPGSign(t, home, content, false), wantErr: "BADSIG"},
+		{name: "untrusted key", content: content, signature: testGPGSign(t, otherHome, content, false), wantErr: "NO_PUBKEY"},
+		{name: "not a signature", content: content, signature: []byte("not a signature"), wantErr: "invalid signature"},
+	}
+
+	for _, test := range tests {
+		err := verifier.Verify(test.content, test.signature)
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%s: %s", test.name, err)
+		case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+			t.Errorf("%s: got error %v, want %s", test.name, err, test.wantErr)
+		}
+	}
+}
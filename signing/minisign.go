@@ -0,0 +1,132 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// minisignLegacy signatures are of the file itself
+	minisignLegacy = "Ed"
+	// minisignHashed signatures are of the BLAKE2b-512 hash of the file
+	minisignHashed = "ED"
+	minisignKeyID  = 8
+)
+
+// Minisign is a utils.SignatureVerifier checking minisign signatures. The
+// signatures are in a .minisig file next to the workflow, as created by
+// minisign -Sm workflow.yml
+type Minisign struct {
+	keys []minisignKey
+}
+
+type minisignKey struct {
+	id  []byte
+	key ed25519.PublicKey
+}
+
+// NewMinisign creates a verifier trusting the public keys. Keys are given as
+// the base64 line of the public key file, like RWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y7GFO3,
+// or the whole file
+func NewMinisign(keys []string) (*Minisign, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("minisign needs a public key")
+	}
+
+	verifier := &Minisign{}
+	for _, key := range keys {
+		line, _ := minisignLines(key)
+		buff, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(buff) != 2+minisignKeyID+ed25519.PublicKeySize || string(buff[:2]) != minisignLegacy {
+			return nil, fmt.Errorf("invalid minisign public key %s", line)
+		}
+
+		verifier.keys = append(verifier.keys, minisignKey{
+			id:  buff[2 : 2+minisignKeyID],
+			key: ed25519.PublicKey(buff[2+minisignKeyID:]),
+		})
+	}
+
+	return verifier, nil
+}
+
+// Verify implements utils.SignatureVerifier
+func (m *Minisign) Verify(content []byte, signature []byte) error {
+	line, rest := minisignLines(string(signature))
+	buff, err := base64.StdEncoding.DecodeString(line)
+	if err != nil || len(buff) != 2+minisignKeyID+ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	}
+	algorithm, id, sig := string(buff[:2]), buff[2:2+minisignKeyID], buff[2+minisignKeyID:]
+
+	var key ed25519.PublicKey
+	for _, item := range m.keys {
+		if bytes.Equal(item.id, id) {
+			key = item.key
+		}
+	}
+	if key == nil {
+		return fmt.Errorf("signed with untrusted key %X", reverse(id))
+	}
+
+	switch algorithm {
+	case minisignLegacy:
+	case minisignHashed:
+		content = blake2b512(content)
+	default:
+		return fmt.Errorf("unsupported minisign signature algorithm %s", algorithm)
+	}
+	if !ed25519.Verify(key, content, sig) {
+		return errors.New("invalid signature")
+	}
+
+	// the trusted comment is signed with the signature
+	if rest != "" {
+		comment, global := minisignLines(rest)
+		globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(global))
+		if !strings.HasPrefix(comment, "trusted comment: ") || err != nil {
+			return errors.New("invalid minisign trusted comment")
+		}
+		signed := append(append([]byte{}, sig...), strings.TrimPrefix(comment, "trusted comment: ")...)
+		if !ed25519.Verify(key, signed, globalSig) {
+			return errors.New("invalid signature of the trusted comment")
+		}
+	}
+
+	return nil
+}
+
+// Extension implements utils.SignatureVerifier
+func (m *Minisign) Extension() string {
+	return ".minisig"
+}
+
+// minisignLines returns the first line of the value that isn't an untrusted
+// comment and the lines after it
+func minisignLines(value string) (string, string) {
+	value = strings.TrimSpace(value)
+	for _, line := range strings.SplitAfter(value, "\n") {
+		value = strings.TrimPrefix(value, line)
+		if strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+
+		return strings.TrimSpace(line), strings.TrimSpace(value)
+	}
+
+	return "", ""
+}
+
+// reverse returns the key id as minisign shows it
+func reverse(id []byte) []byte {
+	reversed := make([]byte, len(id))
+	for idx, b := range id {
+		reversed[len(id)-1-idx] = b
+	}
+
+	return reversed
+}
@@ -0,0 +1,91 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+var testKeyID = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+// testMinisignKey returns a key pair with its public key as minisign writes
+// it
+func testMinisignKey(seed byte, id []byte) (ed25519.PrivateKey, string) {
+	private := ed25519.NewKeyFromSeed([]byte(strings.Repeat(string(seed), ed25519.SeedSize)))
+	public := append(append([]byte(minisignLegacy), id...), private.Public().(ed25519.PublicKey)...)
+
+	return private, "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(public)
+}
+
+// testMinisignSignature signs the content like minisign -S, hashed unless
+// legacy is set
+func testMinisignSignature(private ed25519.PrivateKey, id []byte, content []byte, legacy bool, comment string) string {
+	algorithm := minisignHashed
+	if legacy {
+		algorithm = minisignLegacy
+	} else {
+		content = blake2b512(content)
+	}
+
+	sig := ed25519.Sign(private, content)
+	global := ed25519.Sign(private, append(append([]byte{}, sig...), comment...))
+
+	return "untrusted comment: signature\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(algorithm), id...), sig...)) + "\n" +
+		"trusted comment: " + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n"
+}
+
+func TestMinisignVerify(t *testing.T) {
+	private, public := testMinisignKey(1, testKeyID)
+	other, _ := testMinisignKey(2, testKeyID)
+	untrusted, _ := testMinisignKey(3, []byte{8, 7, 6, 5, 4, 3, 2, 1})
+	content := []byte("version: 1\nsteps:\n  - name: build\n    command: make\n")
+
+	verifier, err := NewMinisign([]string{public})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashed := testMinisignSignature(private, testKeyID, content, false, "timestamp:1")
+	tests := []struct {
+		name      string
+		content   []byte
+		signature string
+		wantErr   string
+	}{
+		{name: "hashed", content: content, signature: hashed},
+		{name: "legacy", content: content, signature: testMinisignSignature(private, testKeyID, content, true, "timestamp:1")},
+		{name: "changed content", content: append([]byte("# "), content...), signature: hashed, wantErr: "invalid signature"},
+		{name: "other key with the same id", content: content, signature: testMinisignSignature(other, testKeyID, content, false, "timestamp:1"), wantErr: "invalid signature"},
+		{name: "untrusted key", content: content, signature: testMinisignSignature(untrusted, []byte{8, 7, 6, 5, 4, 3, 2, 1}, content, false, "timestamp:1"), wantErr: "signed with untrusted key 0102030405060708"},
+		{name: "changed trusted comment", content: content, signature: strings.Replace(hashed, "timestamp:1", "timestamp:2", 1), wantErr: "invalid signature of the trusted comment"},
+		{name: "not a signature", content: content, signature: "untrusted comment: signature\nbm90IGEgc2lnbmF0dXJl\n", wantErr: "invalid minisign signature"},
+	}
+
+	for _, test := range tests {
+		err := verifier.Verify(test.content, []byte(test.signature))
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%s: %s", test.name, err)
+		case test.wantErr != "" && (err == nil || err.Error() != test.wantErr):
+			t.Errorf("%s: got error %v, want %s", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestNewMinisign(t *testing.T) {
+	_, public := testMinisignKey(1, testKeyID)
+	line := strings.Split(public, "\n")[1]
+
+	if _, err := NewMinisign([]string{line}); err != nil {
+		t.Errorf("the base64 line of the key is invalid: %s", err)
+	}
+	if _, err := NewMinisign(nil); err == nil {
+		t.Error("no keys are valid")
+	}
+	if _, err := NewMinisign([]string{line[:len(line)-4]}); err == nil {
+		t.Error("a truncated key is valid")
+	}
+}
@@ -0,0 +1,9 @@
+// Package sqlite is a minimal binding of the SQLite library, enough for the
+// stores keeping the history and the outbox in a database. It needs cgo and
+// trackman built with -tags sqlite, otherwise Open returns ErrNotSupported
+package sqlite
+
+import "errors"
+
+// ErrNotSupported is returned by Open when trackman was built without SQLite
+var ErrNotSupported = errors.New("SQLite is not supported by this build of trackman. Build it with cgo and -tags sqlite")
@@ -0,0 +1,164 @@
+//go:build sqlite && cgo
+// +build sqlite,cgo
+
+package sqlite
+
+/*
+#cgo LDFLAGS: -lsqlite3
+#include <stdlib.h>
+#include <sqlite3.h>
+
+// the values are copied by SQLite as the Go memory is freed after binding
+static int bind_text(sqlite3_stmt *stmt, int idx, const char *value, int length) {
+	return sqlite3_bind_text(stmt, idx, value, length, SQLITE_TRANSIENT);
+}
+
+static int bind_blob(sqlite3_stmt *stmt, int idx, const void *value, int length) {
+	return sqlite3_bind_blob(stmt, idx, value, length, SQLITE_TRANSIENT);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// busyTimeout is how long a statement waits for another process holding
+// the database, in milliseconds
+const busyTimeout = 5000
+
+// DB is a SQLite database. Its statements run one at a time
+type DB struct {
+	signal *sync.Mutex
+	handle *C.sqlite3
+}
+
+// Row is a row returned by a query
+type Row struct {
+	stmt *C.sqlite3_stmt
+}
+
+// Open opens the database in the file, creating it if needed
+func Open(path string) (*DB, error) {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	var handle *C.sqlite3
+	if rc := C.sqlite3_open_v2(cPath, &handle, C.SQLITE_OPEN_READWRITE|C.SQLITE_OPEN_CREATE|C.SQLITE_OPEN_FULLMUTEX, nil); rc != C.SQLITE_OK {
+		err := fmt.Errorf("failed to open %s: %s", path, C.GoString(C.sqlite3_errstr(rc)))
+		C.sqlite3_close(handle)
+		return nil, err
+	}
+	C.sqlite3_busy_timeout(handle, busyTimeout)
+
+	db := &DB{signal: &sync.Mutex{}, handle: handle}
+	// other processes can read while one writes
+	if err := db.Query("PRAGMA journal_mode = WAL", nil, func(row *Row) error { return nil }); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Exec runs the statement with the arguments
+func (db *DB) Exec(query string, args ...interface{}) error {
+	return db.Query(query, args, func(row *Row) error { return nil })
+}
+
+// Query runs the query with the arguments and calls scan with each row.
+// Arguments are strings, bytes, integers or nil
+func (db *DB) Query(query string, args []interface{}, scan func(row *Row) error) error {
+	db.signal.Lock()
+	defer db.signal.Unlock()
+
+	cQuery := C.CString(query)
+	defer C.free(unsafe.Pointer(cQuery))
+
+	var stmt *C.sqlite3_stmt
+	if rc := C.sqlite3_prepare_v2(db.handle, cQuery, -1, &stmt, nil); rc != C.SQLITE_OK {
+		return db.error(rc)
+	}
+	defer C.sqlite3_finalize(stmt)
+
+	for idx, arg := range args {
+		if err := db.bind(stmt, C.int(idx+1), arg); err != nil {
+			return err
+		}
+	}
+
+	for {
+		switch rc := C.sqlite3_step(stmt); rc {
+		case C.SQLITE_ROW:
+			if err := scan(&Row{stmt: stmt}); err != nil {
+				return err
+			}
+		case C.SQLITE_DONE:
+			return nil
+		default:
+			return db.error(rc)
+		}
+	}
+}
+
+// Close closes the database
+func (db *DB) Close() error {
+	db.signal.Lock()
+	defer db.signal.Unlock()
+
+	if rc := C.sqlite3_close(db.handle); rc != C.SQLITE_OK {
+		return db.error(rc)
+	}
+
+	return nil
+}
+
+func (db *DB) bind(stmt *C.sqlite3_stmt, idx C.int, arg interface{}) error {
+	var rc C.int
+	switch value := arg.(type) {
+	case nil:
+		rc = C.sqlite3_bind_null(stmt, idx)
+	case int:
+		rc = C.sqlite3_bind_int64(stmt, idx, C.sqlite3_int64(value))
+	case int64:
+		rc = C.sqlite3_bind_int64(stmt, idx, C.sqlite3_int64(value))
+	case string:
+		cValue := C.CString(value)
+		defer C.free(unsafe.Pointer(cValue))
+		rc = C.bind_text(stmt, idx, cValue, C.int(len(value)))
+	case []byte:
+		cValue := C.CBytes(value)
+		defer C.free(cValue)
+		rc = C.bind_blob(stmt, idx, cValue, C.int(len(value)))
+	default:
+		return fmt.Errorf("unsupported argument %d of type %T", idx, arg)
+	}
+	if rc != C.SQLITE_OK {
+		return db.error(rc)
+	}
+
+	return nil
+}
+
+func (db *DB) error(rc C.int) error {
+	return fmt.Errorf("sqlite: %s (%s)", C.GoString(C.sqlite3_errmsg(db.handle)), C.GoString(C.sqlite3_errstr(rc)))
+}
+
+// Int returns the column of the row as an integer
+func (r *Row) Int(column int) int64 {
+	return int64(C.sqlite3_column_int64(r.stmt, C.int(column)))
+}
+
+// Text returns the column of the row as a string
+func (r *Row) Text(column int) string {
+	text := C.sqlite3_column_text(r.stmt, C.int(column))
+	return C.GoStringN((*C.char)(unsafe.Pointer(text)), C.sqlite3_column_bytes(r.stmt, C.int(column)))
+}
+
+// Blob returns the column of the row as bytes
+func (r *Row) Blob(column int) []byte {
+	blob := C.sqlite3_column_blob(r.stmt, C.int(column))
+	return C.GoBytes(blob, C.sqlite3_column_bytes(r.stmt, C.int(column)))
+}
@@ -0,0 +1,45 @@
+//go:build !sqlite || !cgo
+// +build !sqlite !cgo
+
+package sqlite
+
+// DB is a SQLite database. It can't be opened in this build
+type DB struct{}
+
+// Row is a row returned by a query
+type Row struct{}
+
+// Open returns ErrNotSupported as trackman was built without SQLite
+func Open(path string) (*DB, error) {
+	return nil, ErrNotSupported
+}
+
+// Exec runs the statement with the arguments
+func (db *DB) Exec(query string, args ...interface{}) error {
+	return ErrNotSupported
+}
+
+// Query runs the query with the arguments and calls scan with each row
+func (db *DB) Query(query string, args []interface{}, scan func(row *Row) error) error {
+	return ErrNotSupported
+}
+
+// Close closes the database
+func (db *DB) Close() error {
+	return ErrNotSupported
+}
+
+// Int returns the column of the row as an integer
+func (r *Row) Int(column int) int64 {
+	return 0
+}
+
+// Text returns the column of the row as a string
+func (r *Row) Text(column int) string {
+	return ""
+}
+
+// Blob returns the column of the row as bytes
+func (r *Row) Blob(column int) []byte {
+	return nil
+}
@@ -0,0 +1,313 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	otlpTracesPath = "/v1/traces"
+
+	spanKindInternal = 1
+	statusCodeError  = 2
+)
+
+type ctxKey struct{}
+
+// OTLPOptions configures an OTLPTracer
+type OTLPOptions struct {
+	// Endpoint is the OTLP/HTTP endpoint of the collector, like http://localhost:4318
+	Endpoint string
+	Headers  map[string]string
+	// ServiceName is the service.name of the spans. Defaults to trackman
+	ServiceName string
+	// TraceParent is a W3C traceparent. When set, the workflow spans are
+	// children of it
+	TraceParent string
+	// FlushInterval is how often finished spans are sent. Defaults to 5 seconds
+	FlushInterval time.Duration
+	// Logger logs the spans that failed to be sent in the background.
+	// Defaults to the standard logger
+	Logger *logrus.Logger
+}
+
+// OTLPTracer is a utils.Tracer that exports spans to an OpenTelemetry
+// collector (or Jaeger, Tempo, ...) using OTLP/HTTP with JSON encoding
+type OTLPTracer struct {
+	options  *OTLPOptions
+	url      string
+	client   *http.Client
+	traceID  string
+	parentID string
+	finished []*otlpSpan
+	done     chan struct{}
+	joiner   *sync.WaitGroup
+	signal   *sync.Mutex
+}
+
+type otlpSpan struct {
+	tracer    *OTLPTracer
+	TraceID   string          `json:"traceId"`
+	SpanID    string          `json:"spanId"`
+	ParentID  string          `json:"parentSpanId,omitempty"`
+	Name      string          `json:"name"`
+	Kind      int             `json:"kind"`
+	StartTime string          `json:"startTimeUnixNano"`
+	EndTime   string          `json:"endTimeUnixNano"`
+	Attrs     []otlpAttribute `json:"attributes,omitempty"`
+	Events    []*otlpEvent    `json:"events,omitempty"`
+	Status    *otlpStatus     `json:"status,omitempty"`
+	signal    *sync.Mutex
+}
+
+type otlpEvent struct {
+	Time  string          `json:"timeUnixNano"`
+	Name  string          `json:"name"`
+	Attrs []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+// NewOTLPTracer creates a new OTLPTracer and starts sending finished spans
+// in the background. Close should be called once the workflow is done
+func NewOTLPTracer(options *OTLPOptions) (*OTLPTracer, error) {
+	if options.Endpoint == "" {
+		return nil, errors.New("tracing needs an endpoint")
+	}
+	if options.ServiceName == "" {
+		options.ServiceName = "trackman"
+	}
+	if options.FlushInterval == 0 {
+		options.FlushInterval = 5 * time.Second
+	}
+	if options.Logger == nil {
+		options.Logger = logrus.StandardLogger()
+	}
+
+	url := strings.TrimSuffix(options.Endpoint, "/")
+	if !strings.HasSuffix(url, otlpTracesPath) {
+		url += otlpTracesPath
+	}
+
+	tracer := &OTLPTracer{
+		options: options,
+		url:     url,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		done:    make(chan struct{}),
+		joiner:  &sync.WaitGroup{},
+		signal:  &sync.Mutex{},
+	}
+
+	if options.TraceParent != "" {
+		traceID, parentID, err := parseTraceParent(options.TraceParent)
+		if err != nil {
+			return nil, err
+		}
+
+		tracer.traceID = traceID
+		tracer.parentID = parentID
+	}
+
+	tracer.joiner.Add(1)
+	go tracer.flushLoop()
+
+	return tracer, nil
+}
+
+// Start implements utils.Tracer
+func (t *OTLPTracer) Start(ctx context.Context, name string, attributes map[string]string) (context.Context, utils.Span) {
+	span := &otlpSpan{
+		tracer:    t,
+		TraceID:   t.traceID,
+		ParentID:  t.parentID,
+		SpanID:    randomID(8),
+		Name:      name,
+		Kind:      spanKindInternal,
+		StartTime: unixNano(time.Now()),
+		Attrs:     toAttributes(attributes),
+		signal:    &sync.Mutex{},
+	}
+
+	if parent, ok := ctx.Value(ctxKey{}).(*otlpSpan); ok {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	}
+	if span.TraceID == "" {
+		span.TraceID = randomID(16)
+	}
+
+	return context.WithValue(ctx, ctxKey{}, span), span
+}
+
+// Close sends the remaining spans and stops the tracer
+func (t *OTLPTracer) Close() error {
+	close(t.done)
+	t.joiner.Wait()
+
+	return t.flush()
+}
+
+func (t *OTLPTracer) flushLoop() {
+	defer t.joiner.Done()
+
+	ticker := time.NewTicker(t.options.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.flush(); err != nil {
+				t.options.Logger.Errorf("Failed to send traces: %s", err)
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *OTLPTracer) finish(span *otlpSpan) {
+	t.signal.Lock()
+	defer t.signal.Unlock()
+
+	t.finished = append(t.finished, span)
+}
+
+func (t *OTLPTracer) flush() error {
+	t.signal.Lock()
+	spans := t.finished
+	t.finished = nil
+	t.signal.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": toAttributes(map[string]string{"service.name": t.options.ServiceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "trackman", "version": utils.Version},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	body = []byte(utils.MaskSecrets(string(body)))
+
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range t.options.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// AddEvent implements utils.Span
+func (s *otlpSpan) AddEvent(name string, attributes map[string]string) {
+	s.signal.Lock()
+	defer s.signal.Unlock()
+
+	s.Events = append(s.Events, &otlpEvent{
+		Time:  unixNano(time.Now()),
+		Name:  name,
+		Attrs: toAttributes(attributes),
+	})
+}
+
+// SetError implements utils.Span
+func (s *otlpSpan) SetError(err error) {
+	s.signal.Lock()
+	defer s.signal.Unlock()
+
+	s.Status = &otlpStatus{Code: statusCodeError, Message: err.Error()}
+}
+
+// End implements utils.Span
+func (s *otlpSpan) End() {
+	s.signal.Lock()
+	s.EndTime = unixNano(time.Now())
+	s.signal.Unlock()
+
+	s.tracer.finish(s)
+}
+
+// parseTraceParent returns the trace and parent span IDs of a W3C traceparent
+func parseTraceParent(value string) (string, string, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", fmt.Errorf("invalid traceparent %s", value)
+	}
+
+	return parts[1], parts[2], nil
+}
+
+func toAttributes(values map[string]string) []otlpAttribute {
+	attributes := make([]otlpAttribute, 0, len(values))
+	for key, value := range values {
+		if value == "" {
+			continue
+		}
+
+		attribute := otlpAttribute{Key: key}
+		attribute.Value.StringValue = value
+		attributes = append(attributes, attribute)
+	}
+
+	return attributes
+}
+
+func randomID(size int) string {
+	buf := make([]byte, size)
+	_, _ = rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
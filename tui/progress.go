@@ -0,0 +1,254 @@
+package tui
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/fatih/color"
+)
+
+const (
+	refreshInterval = 100 * time.Millisecond
+	defaultWidth    = 80
+	// maxOutputLines is the number of output lines kept for each step to
+	// show if it fails
+	maxOutputLines = 200
+)
+
+var frames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+var (
+	pendingColor = color.New(color.FgHiBlack)
+	runningColor = color.New(color.FgCyan)
+	successColor = color.New(color.FgGreen)
+	failedColor  = color.New(color.FgRed)
+	skippedColor = color.New(color.FgYellow)
+)
+
+// ProgressView is a live table of the steps of a workflow, redrawn in place
+// as they run. The output of the steps is collected and only shown for the
+// ones that fail
+type ProgressView struct {
+	out      io.Writer
+	workflow *utils.Workflow
+	width    int
+	outputs  map[string]*stepOutput
+	lines    int
+	frame    int
+	done     chan struct{}
+	joiner   *sync.WaitGroup
+	signal   *sync.Mutex
+}
+
+type stepOutput struct {
+	lines []string
+	last  string
+}
+
+// NewProgressView creates a new ProgressView drawing to out. It should be set
+// as the Output of the workflow so it can collect the output of the steps
+func NewProgressView(out io.Writer) *ProgressView {
+	width := defaultWidth
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		width = columns
+	}
+
+	return &ProgressView{
+		out:     out,
+		width:   width,
+		outputs: make(map[string]*stepOutput),
+		done:    make(chan struct{}),
+		joiner:  &sync.WaitGroup{},
+		signal:  &sync.Mutex{},
+	}
+}
+
+// Start starts drawing the steps of the workflow
+func (v *ProgressView) Start(workflow *utils.Workflow) {
+	v.workflow = workflow
+
+	v.joiner.Add(1)
+	go func() {
+		defer v.joiner.Done()
+
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+
+		for {
+			v.draw()
+
+			select {
+			case <-ticker.C:
+			case <-v.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop draws the final state of the steps and the output of the failed ones
+func (v *ProgressView) Stop() {
+	close(v.done)
+	v.joiner.Wait()
+	v.draw()
+
+	v.signal.Lock()
+	defer v.signal.Unlock()
+
+	for _, step := range v.workflow.Progress() {
+		if step.Status != utils.ResultFailed {
+			continue
+		}
+
+		output := v.outputs[step.Name]
+		if output == nil || len(output.lines) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(v.out, "\n%s\n", failedColor.Sprintf("--- %s ---", step.Name))
+		for _, line := range output.lines {
+			fmt.Fprintln(v.out, line)
+		}
+	}
+}
+
+// Writer implements utils.OutputSink
+func (v *ProgressView) Writer(step string, name string) io.WriteCloser {
+	return &progressWriter{
+		view:   v,
+		step:   step,
+		buffer: &bytes.Buffer{},
+	}
+}
+
+func (v *ProgressView) addLine(step string, line string) {
+	v.signal.Lock()
+	defer v.signal.Unlock()
+
+	output, ok := v.outputs[step]
+	if !ok {
+		output = &stepOutput{}
+		v.outputs[step] = output
+	}
+
+	output.lines = append(output.lines, line)
+	if len(output.lines) > maxOutputLines {
+		output.lines = output.lines[len(output.lines)-maxOutputLines:]
+	}
+	if strings.TrimSpace(line) != "" {
+		output.last = line
+	}
+}
+
+func (v *ProgressView) draw() {
+	steps := v.workflow.Progress()
+
+	v.signal.Lock()
+	defer v.signal.Unlock()
+
+	nameWidth := 0
+	for _, step := range steps {
+		if len(step.Name) > nameWidth {
+			nameWidth = len(step.Name)
+		}
+	}
+
+	buf := &strings.Builder{}
+	// go back to the top of the table to draw over it
+	if v.lines > 0 {
+		fmt.Fprintf(buf, "\033[%dA", v.lines)
+	}
+
+	for _, step := range steps {
+		icon, c := v.icon(step.Status)
+		line := fmt.Sprintf("%s %-*s  %-8s %8s", icon, nameWidth, step.Name, step.Status, elapsed(step))
+		if step.Progress != nil && step.Status == utils.ResultRunning {
+			line = fmt.Sprintf("%s %4.0f%%", line, *step.Progress*100)
+		}
+
+		// show what a running step is doing
+		if output, ok := v.outputs[step.Name]; ok && step.Status == utils.ResultRunning && output.last != "" {
+			line = fmt.Sprintf("%s  %s", line, output.last)
+		}
+		if runes := []rune(line); len(runes) > v.width {
+			line = string(runes[:v.width])
+		}
+
+		fmt.Fprintf(buf, "\033[2K%s\n", c.Sprint(line))
+	}
+
+	v.lines = len(steps)
+	v.frame++
+
+	_, _ = io.WriteString(v.out, buf.String())
+}
+
+func (v *ProgressView) icon(status string) (string, *color.Color) {
+	switch status {
+	case utils.ResultRunning:
+		return frames[v.frame%len(frames)], runningColor
+	case utils.ResultSuccess:
+		return "✔", successColor
+	case utils.ResultDegraded:
+		return "!", skippedColor
+	case utils.ResultFailed:
+		return "✖", failedColor
+	case utils.ResultCancelled:
+		return "✖", skippedColor
+	case utils.ResultSkipped, utils.ResultCached, utils.ResultDisabled:
+		return "-", skippedColor
+	default:
+		return "•", pendingColor
+	}
+}
+
+func elapsed(step *utils.StepResult) string {
+	switch {
+	case step.Duration != 0:
+		return step.Duration.Round(100 * time.Millisecond).String()
+	case !step.StartedAt.IsZero() && step.Status == utils.ResultRunning:
+		return time.Since(step.StartedAt).Round(100 * time.Millisecond).String()
+	default:
+		return ""
+	}
+}
+
+type progressWriter struct {
+	view   *ProgressView
+	step   string
+	buffer *bytes.Buffer
+}
+
+// Write implements io.Writer
+func (w *progressWriter) Write(b []byte) (int, error) {
+	w.buffer.Write(b)
+
+	for {
+		idx := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+
+		line := string(w.buffer.Next(idx + 1))
+		w.view.addLine(w.step, strings.TrimRight(line, "\r\n"))
+	}
+
+	return len(b), nil
+}
+
+// Close implements io.Closer
+func (w *progressWriter) Close() error {
+	if w.buffer.Len() != 0 {
+		w.view.addLine(w.step, w.buffer.String())
+		w.buffer.Reset()
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	defaultAdaptiveInterval  = 5 * time.Second
+	defaultAdaptiveLoad      = 1.0
+	defaultAdaptiveMaxMemory = 0.9
+)
+
+// AdaptiveConcurrency changes the concurrency of the workflow as it runs,
+// based on the load of the machine, between Min and Max. It's lowered while
+// the load average for each CPU is over TargetLoad or the memory in use is
+// over MaxMemory, and raised one step at a time while they are both well
+// under. The load is only known on Linux. Elsewhere the concurrency is Max
+type AdaptiveConcurrency struct {
+	// Min is the lowest the concurrency goes. Defaults to 1
+	Min int
+	// Max is the highest the concurrency goes, and where it starts
+	Max int
+	// Interval is how often the load is checked. Defaults to 5 seconds
+	Interval time.Duration
+	// TargetLoad is the 1 minute load average for each CPU to stay under.
+	// Defaults to 1
+	TargetLoad float64
+	// MaxMemory is the fraction of the memory in use to stay under, like
+	// 0.9. Defaults to 0.9
+	MaxMemory float64
+}
+
+func (a *AdaptiveConcurrency) validate() error {
+	if a.Min < 0 || a.Max < 1 || a.Min > a.Max {
+		return fmt.Errorf("invalid adaptive concurrency between %d and %d", a.Min, a.Max)
+	}
+	if a.Interval < 0 {
+		return fmt.Errorf("invalid adaptive concurrency interval %s", a.Interval)
+	}
+	if a.TargetLoad < 0 {
+		return fmt.Errorf("invalid adaptive concurrency target load %g", a.TargetLoad)
+	}
+	if a.MaxMemory < 0 || a.MaxMemory > 1 {
+		return fmt.Errorf("invalid adaptive concurrency max memory %g. it's a fraction like 0.9", a.MaxMemory)
+	}
+
+	return nil
+}
+
+// withDefaults returns a copy of the options with the defaults of the
+// values not set
+func (a *AdaptiveConcurrency) withDefaults() *AdaptiveConcurrency {
+	options := *a
+	if options.Min == 0 {
+		options.Min = 1
+	}
+	if options.Interval == 0 {
+		options.Interval = defaultAdaptiveInterval
+	}
+	if options.TargetLoad == 0 {
+		options.TargetLoad = defaultAdaptiveLoad
+	}
+	if options.MaxMemory == 0 {
+		options.MaxMemory = defaultAdaptiveMaxMemory
+	}
+
+	return &options
+}
+
+// systemLoad is the load of the machine
+type systemLoad struct {
+	// cpu is the 1 minute load average for each CPU
+	cpu float64
+	// memory is the fraction of the memory in use
+	memory float64
+}
+
+// concurrencyController holds back permits of the gatekeeper of the workflow,
+// sized for the Max concurrency, to lower the concurrency
+type concurrencyController struct {
+	options    *AdaptiveConcurrency
+	gatekeeper *semaphore.Weighted
+	logger     *logrus.Logger
+	// load reads the load of the machine, and returns false if it can't
+	load func() (*systemLoad, bool)
+
+	signal *sync.Mutex
+	// reserved are the permits held back and pending the ones being waited
+	// for. The concurrency is Max - wanted once they are all held
+	reserved int
+	pending  int
+	wanted   int
+	cancel   context.CancelFunc
+	joiner   *sync.WaitGroup
+}
+
+func newConcurrencyController(options *AdaptiveConcurrency, gatekeeper *semaphore.Weighted, logger *logrus.Logger) *concurrencyController {
+	return &concurrencyController{
+		options:    options.withDefaults(),
+		gatekeeper: gatekeeper,
+		logger:     logger,
+		load:       readSystemLoad,
+		signal:     &sync.Mutex{},
+		joiner:     &sync.WaitGroup{},
+	}
+}
+
+// start checks the load now and then every interval until stopped
+func (c *concurrencyController) start(ctx context.Context) {
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.check(ctx)
+
+	c.joiner.Add(1)
+	go func() {
+		defer c.joiner.Done()
+
+		ticker := time.NewTicker(c.options.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.check(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stop stops checking the load and gives back the permits held back, so the
+// next run starts from Max
+func (c *concurrencyController) stop() {
+	c.cancel()
+	c.joiner.Wait()
+
+	c.signal.Lock()
+	defer c.signal.Unlock()
+
+	c.gatekeeper.Release(int64(c.reserved))
+	c.reserved = 0
+	c.wanted = 0
+}
+
+// limit returns the concurrency the controller is going for
+func (c *concurrencyController) limit() int {
+	c.signal.Lock()
+	defer c.signal.Unlock()
+
+	return c.options.Max - c.wanted
+}
+
+// check lowers the concurrency by a quarter if the machine is overloaded, or
+// raises it by one if it's well under its targets
+func (c *concurrencyController) check(ctx context.Context) {
+	load, ok := c.load()
+	if !ok {
+		return
+	}
+
+	current := c.limit()
+	target := current
+	switch {
+	case load.cpu > c.options.TargetLoad || load.memory > c.options.MaxMemory:
+		step := current / 4
+		if step < 1 {
+			step = 1
+		}
+		target = current - step
+	case load.cpu < c.options.TargetLoad*0.8 && load.memory < c.options.MaxMemory-0.05:
+		target = current + 1
+	}
+	if target < c.options.Min {
+		target = c.options.Min
+	}
+	if target > c.options.Max {
+		target = c.options.Max
+	}
+	if target == current {
+		return
+	}
+
+	c.logger.Infof("Changing concurrency from %d to %d (load %.2f per CPU, %.0f%% of memory in use)", current, target, load.cpu, load.memory*100)
+	c.set(ctx, target)
+}
+
+// set holds back or gives back permits for the concurrency to be target.
+// Permits in use by steps are held back once the steps are done
+func (c *concurrencyController) set(ctx context.Context, target int) {
+	c.signal.Lock()
+	defer c.signal.Unlock()
+
+	c.wanted = c.options.Max - target
+	for c.reserved > 0 && c.reserved+c.pending > c.wanted {
+		c.gatekeeper.Release(1)
+		c.reserved--
+	}
+	for c.reserved+c.pending < c.wanted {
+		c.pending++
+		c.joiner.Add(1)
+		go c.reserve(ctx)
+	}
+}
+
+// reserve waits for a permit to hold back, and gives it back if it's not
+// wanted anymore
+func (c *concurrencyController) reserve(ctx context.Context) {
+	defer c.joiner.Done()
+
+	err := c.gatekeeper.Acquire(ctx, 1)
+
+	c.signal.Lock()
+	defer c.signal.Unlock()
+
+	c.pending--
+	if err != nil {
+		return
+	}
+	if c.reserved+c.pending >= c.wanted {
+		c.gatekeeper.Release(1)
+		return
+	}
+	c.reserved++
+}
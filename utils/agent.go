@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// AgentSelector picks the agents a step can run on. Empty attributes match
+// any agent
+type AgentSelector struct {
+	// OS is the operating system of the agent, like linux or darwin
+	OS string `yaml:"os" json:"os,omitempty"`
+	// Arch is the architecture of the agent, like amd64 or arm64
+	Arch string `yaml:"arch" json:"arch,omitempty"`
+	// Tags all have to be tags of the agent
+	Tags []string `yaml:"tags" json:"tags,omitempty"`
+}
+
+// String describes the agents the selector picks
+func (a *AgentSelector) String() string {
+	if a == nil {
+		a = &AgentSelector{}
+	}
+
+	parts := []string{"os=" + a.OS, "arch=" + a.Arch, "tags=" + strings.Join(a.Tags, ",")}
+	for idx, part := range parts {
+		if strings.HasSuffix(part, "=") {
+			parts[idx] += "*"
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// AgentTask is a command run on an agent
+type AgentTask struct {
+	ID       string         `json:"id"`
+	Workflow string         `json:"workflow"`
+	Step     string         `json:"step"`
+	Selector *AgentSelector `json:"selector"`
+	// Command is the executable and its arguments
+	Command []string `json:"command"`
+	// Env is added to the environment of the agent
+	Env     []string `json:"env"`
+	Workdir string   `json:"workdir"`
+	// GracePeriod is how long the command has to stop when it's cancelled
+	GracePeriod time.Duration `json:"grace_period"`
+	// Stdin is the input of the command
+	Stdin []byte `json:"stdin,omitempty"`
+}
+
+// AgentDispatcher runs the commands of the steps with an agent selector on
+// the agents
+type AgentDispatcher interface {
+	// Dispatch runs the task on an agent matching its selector and writes its
+	// output as it comes. It returns an AgentExitError if the command failed.
+	// The command is stopped if the context is done
+	Dispatch(ctx context.Context, task *AgentTask, stdout io.Writer, stderr io.Writer) error
+}
+
+// AgentExitError is returned when the command of a task exited with an error
+// on the agent
+type AgentExitError struct {
+	Agent    string
+	ExitCode int
+}
+
+// Error implements error
+func (e *AgentExitError) Error() string {
+	return fmt.Sprintf("exit status %d on agent %s", e.ExitCode, e.Agent)
+}
+
+// runOnAgent runs the command of the spinner on an agent instead of this
+// machine. The events are the same as running it locally
+func (s *Spinner) runOnAgent(ctx context.Context, cmdCtx context.Context, stdin io.Reader, stdout io.Writer, stderr io.Writer, output *outputChecker) error {
+	dispatcher := s.step.workflow.options.Agents
+	if dispatcher == nil {
+		s.push(ctx, NewEvent(s, EventRunError, nil))
+		return fmt.Errorf("no agents to run the step on. Use --agents-addr to let agents connect")
+	}
+
+	task := &AgentTask{
+		ID:          s.UUID,
+		Workflow:    s.step.workflow.options.Name,
+		Step:        s.step.Name,
+		Selector:    s.agent,
+		Command:     append([]string{s.cmd}, s.args...),
+		Env:         s.env,
+		Workdir:     s.workdir,
+		GracePeriod: s.gracePeriod,
+	}
+
+	if stdin != nil {
+		input, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			s.push(ctx, NewEvent(s, EventRunError, nil))
+			return err
+		}
+		task.Stdin = input
+	}
+
+	s.push(ctx, NewEvent(s, EventRunStarted, nil))
+	s.markStarted()
+
+	err := s.checkSuccess(dispatcher.Dispatch(cmdCtx, task, stdout, stderr), output)
+	if err == nil {
+		s.push(ctx, NewEvent(s, EventRunSuccess, nil))
+		return nil
+	}
+
+	if ctx.Err() == context.Canceled {
+		if s.service {
+			s.push(ctx, NewEvent(s, EventServiceStopped, nil))
+			return nil
+		}
+
+		s.push(ctx, NewEvent(s, EventRunCancelled, nil))
+
+		return fmt.Errorf("Cancelled")
+	}
+
+	if s.pastDeadline(cmdCtx) {
+		s.push(ctx, NewEvent(s, EventRunDeadline, &DeadlinePayload{Deadline: s.deadline}))
+
+		return fmt.Errorf("Stopped at its deadline %s", s.deadline.Format(time.RFC3339))
+	}
+
+	if cmdCtx.Err() == context.DeadlineExceeded {
+		s.push(ctx, NewEvent(s, EventRunTimeout, s.timeoutPayload()))
+		s.step.workflow.metrics().StepTimedOut(&s.step)
+		spanFromContext(ctx).AddEvent("timeout", map[string]string{
+			"trackman.timeout": s.timeout.String(),
+		})
+
+		return fmt.Errorf("Timed out after %s", s.timeout)
+	}
+
+	if code, ok := exitCode(err); ok {
+		s.push(ctx, NewEvent(s, EventRunFail, &RunFailPayload{ExitCode: code}))
+		return err
+	}
+
+	s.push(ctx, NewEvent(s, EventRunError, nil))
+
+	return err
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"io"
+	"strings"
+)
+
+const (
+	ansiText = iota
+	// ansiEscape is after an escape, ansiCSI in a control sequence like a
+	// color and ansiOSC in an operating system command like a window title
+	ansiEscape
+	ansiCSI
+	ansiOSC
+	// ansiOSCEscape is after an escape in an operating system command,
+	// which ends it if followed by a backslash
+	ansiOSCEscape
+)
+
+// noColorEnv are the environment variables turning colors off in most
+// programs
+var noColorEnv = []string{"NO_COLOR=1", "TERM=dumb", "CLICOLOR=0"}
+
+// colorForcingEnv are the environment variables turning colors on even if
+// the output is not a terminal
+var colorForcingEnv = []string{"FORCE_COLOR", "CLICOLOR_FORCE"}
+
+// ansiStripper removes ANSI escape codes, like colors and cursor movements,
+// from the output written to it. Codes split between writes are removed too
+type ansiStripper struct {
+	out   io.Writer
+	state int
+}
+
+func newANSIStripper(out io.Writer) *ansiStripper {
+	return &ansiStripper{out: out}
+}
+
+// Write implements io.Writer
+func (a *ansiStripper) Write(p []byte) (int, error) {
+	text := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch a.state {
+		case ansiText:
+			if b == 0x1b {
+				a.state = ansiEscape
+				continue
+			}
+			text = append(text, b)
+		case ansiEscape:
+			switch b {
+			case '[':
+				a.state = ansiCSI
+			case ']':
+				a.state = ansiOSC
+			default:
+				// a two byte code
+				a.state = ansiText
+			}
+		case ansiCSI:
+			// parameters and intermediate bytes until the final byte
+			if b >= 0x40 && b <= 0x7e {
+				a.state = ansiText
+			}
+		case ansiOSC:
+			switch b {
+			case 0x07:
+				a.state = ansiText
+			case 0x1b:
+				a.state = ansiOSCEscape
+			}
+		case ansiOSCEscape:
+			a.state = ansiOSC
+			if b == '\\' {
+				a.state = ansiText
+			}
+		}
+	}
+
+	if len(text) != 0 {
+		if _, err := a.out.Write(text); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// withoutColorForcing returns the environment variables without the ones
+// turning colors on
+func withoutColorForcing(env []string) []string {
+	result := make([]string, 0, len(env))
+	for _, item := range env {
+		if !contains(colorForcingEnv, strings.SplitN(item, "=", 2)[0]) {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,211 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Artifact is a file produced by a step and kept in the artifact store
+type Artifact struct {
+	Step string `json:"step"`
+	// Name is the path of the file relative to the workdir of the step
+	Name string `json:"name"`
+	// SessionID is the run the artifact was stored by
+	SessionID string      `json:"session_id"`
+	Size      int64       `json:"size"`
+	Mode      os.FileMode `json:"mode"`
+	SHA256    string      `json:"sha256"`
+}
+
+// ArtifactStore keeps the artifacts of the steps
+type ArtifactStore interface {
+	// Save copies the file to the store as the artifact
+	Save(artifact *Artifact, path string) error
+	// Open returns the content of the artifact
+	Open(artifact *Artifact) (io.ReadCloser, error)
+}
+
+// LocalArtifactStore is an ArtifactStore keeping the artifacts in a
+// directory, under a directory for each run and step
+type LocalArtifactStore struct {
+	dir string
+}
+
+// NewLocalArtifactStore creates a new LocalArtifactStore in the directory
+func NewLocalArtifactStore(dir string) *LocalArtifactStore {
+	return &LocalArtifactStore{dir: dir}
+}
+
+// Save implements ArtifactStore
+func (s *LocalArtifactStore) Save(artifact *Artifact, path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	return writeFile(s.path(artifact), source, artifact.Mode, "")
+}
+
+// Open implements ArtifactStore
+func (s *LocalArtifactStore) Open(artifact *Artifact) (io.ReadCloser, error) {
+	return os.Open(s.path(artifact))
+}
+
+func (s *LocalArtifactStore) path(artifact *Artifact) string {
+	return filepath.Join(s.dir, artifact.SessionID, artifact.Step, filepath.FromSlash(artifact.Name))
+}
+
+func validateArtifact(pattern string) error {
+	name := filepath.ToSlash(filepath.Clean(pattern))
+	if filepath.IsAbs(pattern) || name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("artifact %s isn't in the workdir of the step", pattern)
+	}
+
+	return validateGlob(pattern)
+}
+
+// collectArtifacts saves the files matching the artifacts of the step to the
+// artifact store after the step has successfully run
+func (s *Step) collectArtifacts() error {
+	if len(s.Artifacts) == 0 {
+		return nil
+	}
+
+	store := s.workflow.options.Artifacts
+	if store == nil {
+		return fmt.Errorf("no artifact store to keep the artifacts of %s in", s.Name)
+	}
+
+	workdir := s.Workdir
+	if workdir == "" {
+		workdir = "."
+	}
+
+	var artifacts []*Artifact
+	collected := make(map[string]bool)
+	for _, pattern := range s.Artifacts {
+		files, err := cacheFiles(workdir, pattern)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no files match artifact %s", pattern)
+		}
+
+		for _, file := range files {
+			if collected[file] {
+				continue
+			}
+			collected[file] = true
+
+			path := filepath.Join(workdir, file)
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			sum, err := fileHash(path)
+			if err != nil {
+				return err
+			}
+
+			artifact := &Artifact{
+				Step:      s.Name,
+				Name:      filepath.ToSlash(file),
+				SessionID: s.workflow.sessionID,
+				Size:      info.Size(),
+				Mode:      info.Mode().Perm(),
+				SHA256:    sum,
+			}
+			if err = store.Save(artifact, path); err != nil {
+				return fmt.Errorf("failed to save artifact %s: %s", file, err)
+			}
+
+			artifacts = append(artifacts, artifact)
+		}
+	}
+
+	s.update(func() { s.artifacts = artifacts })
+
+	return nil
+}
+
+// materializeArtifacts copies the artifacts of the steps the step needs to
+// its workdir, checking they haven't changed since they were saved
+func (s *Step) materializeArtifacts() error {
+	if len(s.NeedsArtifacts) == 0 {
+		return nil
+	}
+
+	store := s.workflow.options.Artifacts
+	if store == nil {
+		return fmt.Errorf("no artifact store to get the artifacts of %s from", s.Name)
+	}
+
+	workdir := s.Workdir
+	if workdir == "" {
+		workdir = "."
+	}
+
+	for _, name := range s.NeedsArtifacts {
+		step := s.workflow.findStepByName(name)
+		if step == nil {
+			return fmt.Errorf("invalid step name in needs_artifacts (%s)", name)
+		}
+
+		for _, artifact := range step.artifacts {
+			if err := materializeArtifact(store, artifact, filepath.Join(workdir, filepath.FromSlash(artifact.Name))); err != nil {
+				return fmt.Errorf("failed to get artifact %s of %s: %s", artifact.Name, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func materializeArtifact(store ArtifactStore, artifact *Artifact, path string) error {
+	reader, err := store.Open(artifact)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return writeFile(path, reader, artifact.Mode, artifact.SHA256)
+}
+
+// writeFile writes the content to the file with the given mode through a
+// temp file, creating its directory if needed. If a SHA-256 checksum is
+// given, the file is only written if the content matches it
+func writeFile(path string, content io.Reader, mode os.FileMode, checksum string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), content)
+	if err == nil {
+		err = file.Chmod(mode)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && checksum != "" && hex.EncodeToString(hash.Sum(nil)) != checksum {
+		err = fmt.Errorf("checksum mismatch")
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,272 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// CacheKey are the inputs of a step. If they haven't changed since the step
+// last succeeded, the step isn't run again. The command of the step is
+// always part of the key
+type CacheKey struct {
+	// Files are the files, directories or glob patterns the step reads,
+	// relative to the directory of the workflow
+	Files []string `yaml:"files" json:"files"`
+	// Env are the names of the environment variables the step uses
+	Env []string `yaml:"env" json:"env"`
+}
+
+// CacheEntry is the result of a successful step kept in the cache
+type CacheEntry struct {
+	Step      string            `json:"step"`
+	Key       string            `json:"key"`
+	SessionID string            `json:"session_id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Outputs   map[string]string `json:"outputs,omitempty"`
+	// Artifacts are the artifacts the step saved in the run it was cached
+	// from
+	Artifacts []*Artifact `json:"artifacts,omitempty"`
+}
+
+// StepCache keeps the results of the successful steps with a cache key
+type StepCache interface {
+	// Get returns the entry with the given key, or nil if there is none
+	Get(key string) (*CacheEntry, error)
+	// Put adds the entry to the cache
+	Put(entry *CacheEntry) error
+}
+
+// FileCache is a StepCache keeping each entry in a JSON file in a directory
+type FileCache struct {
+	dir string
+}
+
+// NewFileCache creates a new FileCache in the directory
+func NewFileCache(dir string) *FileCache {
+	return &FileCache{dir: dir}
+}
+
+// Get implements StepCache
+func (c *FileCache) Get(key string) (*CacheEntry, error) {
+	buff, err := ioutil.ReadFile(filepath.Join(c.dir, key+".json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entry *CacheEntry
+	if err = json.Unmarshal(buff, &entry); err != nil {
+		return nil, fmt.Errorf("invalid cache entry %s: %s", key, err)
+	}
+
+	return entry, nil
+}
+
+// Put implements StepCache
+func (c *FileCache) Put(entry *CacheEntry) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+
+	buff, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(c.dir, entry.Key+".json")
+	tempFile := filename + ".tmp"
+	if err = ioutil.WriteFile(tempFile, buff, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tempFile, filename)
+}
+
+func (k *CacheKey) validate() error {
+	for _, pattern := range k.Files {
+		if err := validateGlob(pattern); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreFromCache marks the step as done with the outputs it had the last
+// time it ran with the same cache key. It returns true if it did
+func (s *Step) restoreFromCache(ctx context.Context, spinner *Spinner) bool {
+	cache := s.workflow.options.Cache
+	if s.CacheKey == nil || cache == nil {
+		return false
+	}
+
+	key, err := s.cacheKey()
+	if err != nil {
+		s.logger.WithField(FldStep, s.Name).Warnf("Failed to calculate the cache key: %s", err)
+		return false
+	}
+	s.cacheKeyValue = key
+
+	entry, err := cache.Get(key)
+	if err != nil {
+		s.logger.WithField(FldStep, s.Name).Warnf("Failed to read the cache: %s", err)
+		return false
+	}
+	if entry == nil {
+		return false
+	}
+
+	for name, value := range entry.Outputs {
+		s.workflow.setOutput(s.Name, name, value)
+	}
+	s.update(func() {
+		s.artifacts = entry.Artifacts
+		s.cached = true
+	})
+	spinner.push(ctx, NewEvent(spinner, EventRunCacheHit, entry))
+
+	return true
+}
+
+// saveToCache keeps the outputs of the step after it has successfully run
+func (s *Step) saveToCache() {
+	cache := s.workflow.options.Cache
+	if s.cacheKeyValue == "" || cache == nil {
+		return
+	}
+
+	entry := &CacheEntry{
+		Step:      s.Name,
+		Key:       s.cacheKeyValue,
+		SessionID: s.workflow.sessionID,
+		CreatedAt: time.Now(),
+		Outputs:   s.workflow.maskedOutputs(s.Name),
+		Artifacts: s.artifacts,
+	}
+	if err := cache.Put(entry); err != nil {
+		s.logger.WithField(FldStep, s.Name).Warnf("Failed to save to the cache: %s", err)
+	}
+}
+
+// cacheKey returns the hash of the command of the step and its inputs
+func (s *Step) cacheKey() (string, error) {
+	hash := sha256.New()
+	write := func(kind string, value string) {
+		fmt.Fprintf(hash, "%s\x00%d\x00%s\x00", kind, len(value), value)
+	}
+
+	write("name", s.Name)
+	write("type", s.Type)
+	write("shell", s.Shell)
+	write("workdir", s.Workdir)
+	write("command", s.Command)
+	for _, arg := range s.Exec {
+		write("exec", arg)
+	}
+	for _, env := range s.MergedEnv() {
+		write("step_env", env)
+	}
+	for _, name := range s.CacheKey.Env {
+		write("env", name+"="+os.Getenv(name))
+	}
+
+	dir := s.workflow.options.Dir
+	if dir == "" {
+		dir = "."
+	}
+	for _, pattern := range s.CacheKey.Files {
+		files, err := cacheFiles(dir, pattern)
+		if err != nil {
+			return "", err
+		}
+
+		write("pattern", pattern)
+		for _, file := range files {
+			sum, err := fileHash(relativeTo(dir, file))
+			if err != nil {
+				return "", err
+			}
+
+			write("file", file)
+			write("hash", sum)
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// cacheFiles returns the files matching the pattern, sorted by name.
+// Directories match all the files in them. Relative patterns are matched in
+// the directory and return the names relative to it, so the key doesn't
+// depend on where the workflow is
+func cacheFiles(dir string, pattern string) ([]string, error) {
+	base := GlobBase(pattern)
+	if filepath.IsAbs(pattern) {
+		dir = ""
+	}
+
+	var files []string
+	err := filepath.Walk(filepath.Join(dir, base), func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if dir != "" {
+			if name, err = filepath.Rel(dir, name); err != nil {
+				return err
+			}
+		}
+		if base == pattern || MatchGlob(pattern, name) {
+			files = append(files, name)
+		}
+
+		return nil
+	})
+	if os.IsNotExist(err) {
+		// a missing file is part of the key as much as its content would be
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+func relativeTo(dir string, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+
+	return filepath.Join(dir, name)
+}
+
+func fileHash(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotRunning is returned when cancelling a workflow that isn't running
+var ErrNotRunning = errors.New("workflow is not running")
+
+// runControl cancels the current run of a workflow
+type runControl struct {
+	cancel context.CancelFunc
+	reason string
+	done   chan struct{}
+	result *WorkflowResult
+}
+
+// Cancel stops the running workflow for the reason: no more steps are
+// started and the running ones are asked to stop, and killed if they don't
+// within their grace period. The cleanup steps still run. It waits for the
+// workflow to finish, or for ctx to be done, and returns its result where
+// the stopped steps are cancelled rather than failed
+func (w *Workflow) Cancel(ctx context.Context, reason string) (*WorkflowResult, error) {
+	w.signal.Lock()
+	control := w.running
+	if control != nil && control.reason == "" {
+		control.reason = reason
+	}
+	w.signal.Unlock()
+
+	if control == nil {
+		return nil, ErrNotRunning
+	}
+
+	w.logger.Warnf("Cancelling the workflow: %s", reason)
+	control.cancel()
+
+	select {
+	case <-control.done:
+		return control.result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// startRun returns the context of a new run that can be cancelled with
+// Cancel
+func (w *Workflow) startRun(ctx context.Context) (context.Context, *runControl) {
+	ctx, cancel := context.WithCancel(ctx)
+	control := &runControl{cancel: cancel, done: make(chan struct{})}
+
+	w.signal.Lock()
+	w.running = control
+	w.signal.Unlock()
+
+	return ctx, control
+}
+
+// cancelReason returns why the run was cancelled, or empty if it wasn't
+func (w *Workflow) cancelReason(control *runControl) string {
+	w.signal.Lock()
+	defer w.signal.Unlock()
+
+	return control.reason
+}
+
+// finishRun records the result of the run and lets Cancel return it
+func (w *Workflow) finishRun(control *runControl, result *WorkflowResult) {
+	w.signal.Lock()
+	w.running = nil
+	control.result = result
+	w.signal.Unlock()
+
+	control.cancel()
+	close(control.done)
+}
+
+// cancelWaitingSteps marks the steps that were waiting to run or to be
+// retried as cancelled, once no step of the cancelled run is running
+func (w *Workflow) cancelWaitingSteps() {
+	w.signal.Lock()
+	defer w.signal.Unlock()
+
+	for _, step := range w.Steps {
+		if step.status.Done() || step.status == StepRunning {
+			continue
+		}
+
+		if step.status == StepRetrying {
+			step.finishedAt = time.Now()
+		}
+		_ = step.transition(StepCancelled)
+	}
+}
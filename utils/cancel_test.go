@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCancelRetryingStep(t *testing.T) {
+	options := &WorkflowOptions{
+		Output:  NewOutputMultiplexer(&lockedBuffer{}, &MultiplexerOptions{NoColor: true, Raw: true}),
+		Timeout: 10 * time.Second,
+	}
+	w, err := LoadWorkflowFromBytes(context.Background(), options, []byte(`
+version: 1
+steps:
+  - name: flaky
+    command: "false"
+    retry:
+      max_attempts: 3
+      delay: 1m
+  - name: deploy
+    command: "true"
+    depends_on:
+      - flaky
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		_, _ = w.Run(context.Background())
+	}()
+	flaky := w.Steps[0]
+	for flaky.Status() != StepRetrying {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := w.Cancel(ctx, "testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Outcome != OutcomeCancelled {
+		t.Errorf("the outcome is %s, want %s", result.Outcome, OutcomeCancelled)
+	}
+	for _, step := range result.Steps {
+		if step.Status != ResultCancelled {
+			t.Errorf("%s is %s, want %s", step.Name, step.Status, ResultCancelled)
+		}
+	}
+	transitions := flaky.Transitions()
+	if last := transitions[len(transitions)-1]; last.From != StepRetrying || last.To != StepCancelled {
+		t.Errorf("flaky last went from %s to %s, want from %s to %s", last.From, last.To, StepRetrying, StepCancelled)
+	}
+}
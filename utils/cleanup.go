@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// allSteps returns the steps of the workflow followed by its cleanup steps
+func (w *Workflow) allSteps() []*Step {
+	steps := make([]*Step, 0, len(w.Steps)+len(w.Cleanup))
+	steps = append(steps, w.Steps...)
+
+	return append(steps, w.Cleanup...)
+}
+
+// runCleanup runs the cleanup steps one after the other once the other steps
+// are done. They run even if the workflow failed or was cancelled, and a
+// failed cleanup step doesn't stop the ones after it
+func (w *Workflow) runCleanup(ctx context.Context) error {
+	if len(w.Cleanup) == 0 {
+		return nil
+	}
+
+	// cleanup should finish even if the workflow was cancelled
+	ctx = context.WithoutCancel(ctx)
+	w.logger.Info("Running cleanup steps")
+
+	var errors error
+	for _, step := range w.Cleanup {
+		w.logger.WithField(FldStep, step.Name).Trace("Preparing to run")
+
+		if step.ShowCommand {
+			w.logger.WithField(FldStep, step.Name).Info(step.Command)
+		}
+
+		err := w.runCleanupStep(ctx, step)
+		if err == nil {
+			continue
+		}
+
+		step.update(func() { step.err = err })
+		w.logger.WithField(FldStep, step.Name).Error(err)
+		if !step.ContinueOnFail {
+			errors = multierror.Append(errors, &StepError{Step: step.Name, Err: err})
+		}
+	}
+
+	return errors
+}
+
+// runCleanupStep runs a cleanup step with its locks, including any retries
+func (w *Workflow) runCleanupStep(ctx context.Context, step *Step) error {
+	for {
+		if err := step.moveTo(StepQueued); err != nil {
+			return err
+		}
+
+		// cleanup steps don't go through the dispatcher, so they wait for
+		// their locks here
+		for !step.acquireLocks(ctx) {
+			time.Sleep(globalLockPoll)
+		}
+
+		w.metrics().StepStarted(step)
+		err := step.Run(ctx)
+		step.releaseLocks()
+		w.metrics().StepFinished(step, step.result())
+
+		if step.Status() != StepRetrying {
+			return err
+		}
+
+		time.Sleep(time.Until(step.retryAt))
+	}
+}
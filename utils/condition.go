@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// conditionContext is what a step's when condition is rendered against
+type conditionContext struct {
+	Metadata map[string]string
+	Var      map[string]string
+	Env      map[string]string
+	Steps    map[string]*conditionStep
+	Outputs  map[string]map[string]string
+}
+
+// conditionStep is what a when condition sees of a step
+type conditionStep struct {
+	Status   string
+	ExitCode int
+	Failed   bool
+	Skipped  bool
+	Cached   bool
+}
+
+func newConditionContext(step *Step) *conditionContext {
+	env := make(map[string]string)
+	for _, pair := range os.Environ() {
+		parts := strings.SplitN(pair, "=", 2)
+		env[parts[0]] = parts[1]
+	}
+
+	// workflow and step env vars override the OS ones
+	for _, pair := range step.MergedEnv() {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	// the other steps can be running, so they are read under the lock
+	step.workflow.signal.Lock()
+	steps := make(map[string]*conditionStep, len(step.workflow.Steps)+len(step.workflow.Cleanup))
+	for _, item := range step.workflow.allSteps() {
+		result := item.result()
+		steps[item.Name] = &conditionStep{
+			Status:   result.Status,
+			ExitCode: result.ExitCode,
+			Failed:   result.Error != nil,
+			Skipped:  item.skipped,
+			Cached:   item.cached,
+		}
+	}
+	step.workflow.signal.Unlock()
+
+	return &conditionContext{
+		Metadata: step.MergedMetadata(),
+		Var:      step.Var(),
+		Env:      env,
+		Steps:    steps,
+		Outputs:  step.Outputs(),
+	}
+}
+
+// evaluateCondition renders the step's when condition and returns its
+// boolean value. The condition renders to true or false, or to an
+// expression like 0 == 0 && env.DEPLOY == 'true'. A step with no condition
+// should always run
+func (s *Step) evaluateCondition(ctx context.Context) (bool, error) {
+	if strings.TrimSpace(s.When) == "" {
+		return true, nil
+	}
+
+	conditionContext := newConditionContext(s)
+	value, err := renderTemplate("when", s.When, conditionContext, s.workflow.TextTemplates)
+	if err != nil {
+		return false, err
+	}
+
+	value = strings.TrimSpace(value)
+	if result, err := strconv.ParseBool(value); err == nil {
+		return result, nil
+	}
+
+	result, err := evaluateExpression(value, conditionContext.Env)
+	if err != nil {
+		return false, fmt.Errorf("when condition for step %s should be true, false or an expression but is %q: %s", s.Name, value, err)
+	}
+
+	return result, nil
+}
+
+// pushSkipped sends the event of the step being skipped
+func (s *Step) pushSkipped(ctx context.Context) {
+	spinner := newSpinnerForSkip(*s)
+	spinner.push(ctx, NewEvent(spinner, EventRunSkipped, nil))
+}
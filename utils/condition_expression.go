@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// conditionOperators are the operators of when expressions, longest first so
+// <= is never read as <
+var conditionOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"}
+
+// conditionExpression evaluates a rendered when condition like
+// 0 == 0 && env.DEPLOY == 'true'. Values are numbers, quoted strings,
+// true, false, env.NAME for an environment variable or any other word
+type conditionExpression struct {
+	tokens []string
+	pos    int
+	env    map[string]string
+}
+
+// conditionValue is an operand of a when expression
+type conditionValue struct {
+	text   string
+	quoted bool
+}
+
+// evaluateExpression returns the value of the when expression, with env as
+// the environment variables it can use
+func evaluateExpression(expression string, env map[string]string) (bool, error) {
+	tokens, err := tokenizeCondition(expression)
+	if err != nil {
+		return false, err
+	}
+	if len(tokens) == 0 {
+		return false, fmt.Errorf("empty expression")
+	}
+
+	parser := &conditionExpression{tokens: tokens, env: env}
+	result, err := parser.or()
+	if err != nil {
+		return false, err
+	}
+	if parser.pos != len(tokens) {
+		return false, fmt.Errorf("unexpected %s", tokens[parser.pos])
+	}
+
+	return result, nil
+}
+
+func tokenizeCondition(expression string) ([]string, error) {
+	var tokens []string
+	for idx := 0; idx < len(expression); {
+		char := expression[idx]
+		if char == ' ' || char == '\t' || char == '\r' || char == '\n' {
+			idx++
+			continue
+		}
+
+		if char == '\'' || char == '"' {
+			end := strings.IndexByte(expression[idx+1:], char)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string %s", expression[idx:])
+			}
+
+			tokens = append(tokens, expression[idx:idx+end+2])
+			idx += end + 2
+			continue
+		}
+
+		if operator := conditionOperator(expression[idx:]); operator != "" {
+			tokens = append(tokens, operator)
+			idx += len(operator)
+			continue
+		}
+
+		start := idx
+		for idx < len(expression) && !strings.ContainsRune(" \t\r\n'\"&|=!<>()", rune(expression[idx])) {
+			idx++
+		}
+		if idx == start {
+			return nil, fmt.Errorf("unexpected %c", char)
+		}
+		tokens = append(tokens, expression[start:idx])
+	}
+
+	return tokens, nil
+}
+
+// conditionOperator returns the operator the value starts with, if any
+func conditionOperator(value string) string {
+	for _, operator := range conditionOperators {
+		if strings.HasPrefix(value, operator) {
+			return operator
+		}
+	}
+
+	return ""
+}
+
+func (c *conditionExpression) peek() string {
+	if c.pos >= len(c.tokens) {
+		return ""
+	}
+
+	return c.tokens[c.pos]
+}
+
+func (c *conditionExpression) or() (bool, error) {
+	result, err := c.and()
+	if err != nil {
+		return false, err
+	}
+
+	for c.peek() == "||" {
+		c.pos++
+		right, err := c.and()
+		if err != nil {
+			return false, err
+		}
+		result = result || right
+	}
+
+	return result, nil
+}
+
+func (c *conditionExpression) and() (bool, error) {
+	result, err := c.not()
+	if err != nil {
+		return false, err
+	}
+
+	for c.peek() == "&&" {
+		c.pos++
+		right, err := c.not()
+		if err != nil {
+			return false, err
+		}
+		result = result && right
+	}
+
+	return result, nil
+}
+
+func (c *conditionExpression) not() (bool, error) {
+	if c.peek() != "!" {
+		return c.comparison()
+	}
+
+	c.pos++
+	result, err := c.not()
+	return !result, err
+}
+
+func (c *conditionExpression) comparison() (bool, error) {
+	if c.peek() == "(" {
+		c.pos++
+		result, err := c.or()
+		if err != nil {
+			return false, err
+		}
+		if c.peek() != ")" {
+			return false, fmt.Errorf("missing )")
+		}
+		c.pos++
+
+		return result, nil
+	}
+
+	left, err := c.operand()
+	if err != nil {
+		return false, err
+	}
+
+	operator := c.peek()
+	switch operator {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		result, err := strconv.ParseBool(left.text)
+		if err != nil || left.quoted {
+			return false, fmt.Errorf("%s should be true or false", left.text)
+		}
+
+		return result, nil
+	}
+
+	c.pos++
+	right, err := c.operand()
+	if err != nil {
+		return false, err
+	}
+
+	return compareCondition(left, operator, right)
+}
+
+func (c *conditionExpression) operand() (*conditionValue, error) {
+	token := c.peek()
+	if token == "" {
+		return nil, fmt.Errorf("missing value at the end")
+	}
+	if conditionOperator(token) == token {
+		return nil, fmt.Errorf("unexpected %s", token)
+	}
+	c.pos++
+
+	switch {
+	case token[0] == '\'' || token[0] == '"':
+		return &conditionValue{text: token[1 : len(token)-1], quoted: true}, nil
+	case strings.HasPrefix(token, "env."):
+		return &conditionValue{text: c.env[strings.TrimPrefix(token, "env.")], quoted: true}, nil
+	default:
+		return &conditionValue{text: token}, nil
+	}
+}
+
+// compareCondition compares the values as numbers if they both are, and as
+// strings otherwise
+func compareCondition(left *conditionValue, operator string, right *conditionValue) (bool, error) {
+	leftNumber, leftErr := strconv.ParseFloat(left.text, 64)
+	rightNumber, rightErr := strconv.ParseFloat(right.text, 64)
+	if leftErr == nil && rightErr == nil {
+		switch operator {
+		case "==":
+			return leftNumber == rightNumber, nil
+		case "!=":
+			return leftNumber != rightNumber, nil
+		case "<":
+			return leftNumber < rightNumber, nil
+		case "<=":
+			return leftNumber <= rightNumber, nil
+		case ">":
+			return leftNumber > rightNumber, nil
+		default:
+			return leftNumber >= rightNumber, nil
+		}
+	}
+
+	switch operator {
+	case "==":
+		return left.text == right.text, nil
+	case "!=":
+		return left.text != right.text, nil
+	default:
+		return false, fmt.Errorf("%s can only compare numbers, not %s and %s", operator, left.text, right.text)
+	}
+}
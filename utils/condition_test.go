@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvaluateExpression(t *testing.T) {
+	env := map[string]string{"DEPLOY": "true", "REGION": "eu-west-1"}
+	tests := []struct {
+		expression string
+		want       bool
+	}{
+		{expression: "0 == 0 && env.DEPLOY == 'true'", want: true},
+		{expression: "1 == 0 && env.DEPLOY == 'true'", want: false},
+		{expression: "1 == 0 || env.REGION == \"eu-west-1\"", want: true},
+		{expression: "!(env.REGION != 'eu-west-1')", want: true},
+		{expression: "2 > 10", want: false},
+		{expression: "10 >= 2.5", want: true},
+		{expression: "env.MISSING == ''", want: true},
+		{expression: "succeeded == succeeded", want: true},
+		{expression: "true && !false", want: true},
+	}
+
+	for _, test := range tests {
+		got, err := evaluateExpression(test.expression, env)
+		if err != nil {
+			t.Errorf("%s failed: %s", test.expression, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s is %t, want %t", test.expression, got, test.want)
+		}
+	}
+
+	for _, expression := range []string{"", "0 ==", "(1 == 1", "'a' < 'b'", "deploy", "'true'", "1 == 1 1", "'open"} {
+		if _, err := evaluateExpression(expression, env); err == nil {
+			t.Errorf("%q didn't fail", expression)
+		}
+	}
+}
+
+func TestWhenExpression(t *testing.T) {
+	out := runForOutput(t, `
+version: 1
+env:
+  - DEPLOY=true
+steps:
+  - name: build
+    command: echo built
+  - name: deploy
+    command: echo deployed
+    depends_on:
+      - build
+    when: "{{ .Steps.build.ExitCode }} == 0 && env.DEPLOY == 'true'"
+  - name: rollback
+    command: echo rolled back
+    depends_on:
+      - build
+    when: "{{ .Steps.build.Status }} != success"
+  - name: unparsable
+    command: echo 'unterminated
+    when: "false"
+`)
+
+	if !strings.Contains(out, "deployed") {
+		t.Errorf("deploy didn't run in %q", out)
+	}
+	if strings.Contains(out, "rolled back") {
+		t.Errorf("rollback ran in %q", out)
+	}
+}
+
+func TestWhenExpressionError(t *testing.T) {
+	options := &WorkflowOptions{
+		Output:  NewOutputMultiplexer(&lockedBuffer{}, &MultiplexerOptions{NoColor: true, Raw: true}),
+		Timeout: 10 * time.Second,
+	}
+	w, err := LoadWorkflowFromBytes(context.Background(), options, []byte(`
+version: 1
+steps:
+  - name: deploy
+    command: echo deployed
+    when: "maybe"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := w.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Failed()) != 1 {
+		t.Error("an invalid condition didn't fail the step")
+	}
+}
+
+func TestWhenExpressionOfRunningStep(t *testing.T) {
+	out := &lockedBuffer{}
+	options := &WorkflowOptions{
+		Output:      NewOutputMultiplexer(out, &MultiplexerOptions{NoColor: true, Raw: true}),
+		Timeout:     10 * time.Second,
+		Concurrency: 2,
+	}
+	w, err := LoadWorkflowFromBytes(context.Background(), options, []byte(`
+version: 1
+steps:
+  - name: slow
+    command: sleep 2
+  - name: wait
+    command: sleep 0.5
+  - name: check
+    command: echo slow is still running
+    depends_on:
+      - wait
+    when: "{{ .Steps.slow.Status }} == running"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "slow is still running") {
+		t.Errorf("check didn't see slow running in %q", out)
+	}
+}
@@ -1,9 +1,59 @@
 package utils
 
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
 // CtxKey is a context key
 type CtxKey struct{ int }
 
 var (
 	// CtxSpinner is the key to a spinner on the context
 	CtxSpinner = CtxKey{1}
+	// CtxSpan is the key to the current tracing span on the context
+	CtxSpan = CtxKey{2}
+	// CtxEvent is the key to the event being logged on the context
+	CtxEvent = CtxKey{3}
+	// CtxLogger is the key to the logger of the workflows on the context
+	CtxLogger = CtxKey{4}
+	// CtxSink is the key to the sink of the output of the steps on the context
+	CtxSink = CtxKey{5}
+	// CtxNotifier is the key to the notifier of the workflows on the context
+	CtxNotifier = CtxKey{6}
 )
+
+// WithLogger returns a context with the logger of the workflows loaded with
+// it. Their steps log with it too, unless the workflow or the step has a
+// logger of its own
+func WithLogger(ctx context.Context, logger *logrus.Logger) context.Context {
+	return context.WithValue(ctx, CtxLogger, logger)
+}
+
+// WithSink returns a context with the sink the output of the steps goes to,
+// for the workflows loaded with it without an Output in their options
+func WithSink(ctx context.Context, sink OutputSink) context.Context {
+	return context.WithValue(ctx, CtxSink, sink)
+}
+
+// WithNotifier returns a context with the notifier the events go to, for
+// the workflows loaded with it without Notifiers in their options
+func WithNotifier(ctx context.Context, notifier Notifier) context.Context {
+	return context.WithValue(ctx, CtxNotifier, notifier)
+}
+
+func loggerFromContext(ctx context.Context) *logrus.Logger {
+	logger, _ := ctx.Value(CtxLogger).(*logrus.Logger)
+	return logger
+}
+
+func sinkFromContext(ctx context.Context) OutputSink {
+	sink, _ := ctx.Value(CtxSink).(OutputSink)
+	return sink
+}
+
+func notifierFromContext(ctx context.Context) Notifier {
+	notifier, _ := ctx.Value(CtxNotifier).(Notifier)
+	return notifier
+}
@@ -0,0 +1,100 @@
+package utils
+
+import "time"
+
+// analyzeCriticalPath works out the critical path through the given steps
+// (the chain of steps that determined how long they took) and the slack of
+// each step: how much longer it could have taken without making the whole
+// run longer. Durations of the steps are used, so time spent waiting to run
+// isn't counted
+func (r *WorkflowResult) analyzeCriticalPath(steps []*StepResult) {
+	byName := make(map[string]*StepResult, len(steps))
+	dependents := make(map[string][]*StepResult, len(steps))
+	for _, step := range steps {
+		byName[step.Name] = step
+		for _, name := range step.DependsOn {
+			dependents[name] = append(dependents[name], step)
+		}
+	}
+
+	// earliest each step could have finished after the ones it depends on
+	earliestFinish := make(map[*StepResult]time.Duration, len(steps))
+	var finish func(step *StepResult) time.Duration
+	finish = func(step *StepResult) time.Duration {
+		if value, ok := earliestFinish[step]; ok {
+			return value
+		}
+
+		earliestFinish[step] = step.Duration + step.earliestStart(byName, finish)
+		return earliestFinish[step]
+	}
+
+	var last *StepResult
+	var total time.Duration
+	for _, step := range steps {
+		if value := finish(step); last == nil || value > total {
+			last, total = step, value
+		}
+	}
+	if last == nil {
+		return
+	}
+
+	// latest each step could have finished without delaying the ones
+	// depending on it
+	latestFinish := make(map[*StepResult]time.Duration, len(steps))
+	var latest func(step *StepResult) time.Duration
+	latest = func(step *StepResult) time.Duration {
+		if value, ok := latestFinish[step]; ok {
+			return value
+		}
+
+		value := total
+		for _, dependent := range dependents[step.Name] {
+			if start := latest(dependent) - dependent.Duration; start < value {
+				value = start
+			}
+		}
+
+		latestFinish[step] = value
+		return value
+	}
+
+	for _, step := range steps {
+		step.Slack = latest(step) - finish(step)
+	}
+
+	// walk back from the step that finished last through the steps that
+	// held it up
+	var path []string
+	for step := last; step != nil; {
+		if step.Duration != 0 {
+			path = append([]string{step.Name}, path...)
+		}
+
+		var next *StepResult
+		for _, name := range step.DependsOn {
+			prior, ok := byName[name]
+			if ok && finish(prior) == finish(step)-step.Duration && (next == nil || finish(prior) > finish(next)) {
+				next = prior
+			}
+		}
+		step = next
+	}
+
+	r.CriticalPath = path
+	r.CriticalPathDuration = total
+}
+
+func (s *StepResult) earliestStart(byName map[string]*StepResult, finish func(*StepResult) time.Duration) time.Duration {
+	var start time.Duration
+	for _, name := range s.DependsOn {
+		if prior, ok := byName[name]; ok {
+			if value := finish(prior); value > start {
+				start = value
+			}
+		}
+	}
+
+	return start
+}
@@ -0,0 +1,271 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// OverlapSkip skips a scheduled run if the previous one is still running.
+	// This is the default
+	OverlapSkip = "skip"
+	// OverlapQueue runs a scheduled run once the previous one is finished.
+	// Only one run is queued
+	OverlapQueue = "queue"
+	// OverlapCancelPrevious cancels the previous run if it's still running
+	OverlapCancelPrevious = "cancel-previous"
+
+	// maxCronYears is how far ahead the next time of a cron schedule is looked
+	// for, so schedules like Feb 30 don't loop forever
+	maxCronYears = 5
+)
+
+var overlapPolicies = []string{"", OverlapSkip, OverlapQueue, OverlapCancelPrevious}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var cronWeekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ScheduleDefinition is when a workflow runs on a schedule
+type ScheduleDefinition struct {
+	// Cron is a cron expression with minute, hour, day of month, month and
+	// day of week fields, or a macro like @daily
+	Cron string `yaml:"cron" json:"cron"`
+	// Timezone is the IANA name of the time zone of the cron expression.
+	// Defaults to the local time zone
+	Timezone string `yaml:"timezone" json:"timezone"`
+	// Jitter delays each run by a random time up to it, so many workflows
+	// on the same schedule don't start at once
+	Jitter time.Duration `yaml:"jitter" json:"jitter"`
+	// Overlap is what happens when the previous run is still running: skip,
+	// queue or cancel-previous
+	Overlap string `yaml:"overlap" json:"overlap"`
+}
+
+// CronSchedule is a parsed cron expression
+type CronSchedule struct {
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	// anyDay and anyWeekday are set for fields starting with *. If neither
+	// is set, a day matches if either its day of month or day of week does
+	anyDay     bool
+	anyWeekday bool
+	location   *time.Location
+}
+
+// Validate checks the cron expression, time zone and policies of the schedule
+func (s *ScheduleDefinition) Validate() error {
+	if strings.TrimSpace(s.Cron) == "" {
+		return fmt.Errorf("schedule has no cron expression")
+	}
+	if _, err := s.CronSchedule(); err != nil {
+		return err
+	}
+	if s.Jitter < 0 {
+		return fmt.Errorf("schedule jitter can't be negative")
+	}
+	if !contains(overlapPolicies, s.Overlap) {
+		return fmt.Errorf("invalid schedule overlap %s. Valid values are skip, queue and cancel-previous", s.Overlap)
+	}
+
+	return nil
+}
+
+// CronSchedule parses the cron expression of the schedule in its time zone
+func (s *ScheduleDefinition) CronSchedule() (*CronSchedule, error) {
+	location := time.Local
+	if s.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(s.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid schedule timezone %s: %s", s.Timezone, err)
+		}
+	}
+
+	return ParseCron(s.Cron, location)
+}
+
+// OverlapPolicy returns the overlap policy of the schedule
+func (s *ScheduleDefinition) OverlapPolicy() string {
+	if s.Overlap == "" {
+		return OverlapSkip
+	}
+
+	return s.Overlap
+}
+
+// ParseCron parses a cron expression with minute, hour, day of month, month
+// and day of week fields, or one of the @yearly, @monthly, @weekly, @daily
+// and @hourly macros. Times are in the given location
+func ParseCron(expression string, location *time.Location) (*CronSchedule, error) {
+	spec := strings.TrimSpace(expression)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %s. It needs 5 fields", expression)
+	}
+
+	schedule := &CronSchedule{
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+		location:   location,
+	}
+
+	var err error
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid minute in cron expression %s: %s", expression, err)
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid hour in cron expression %s: %s", expression, err)
+	}
+	if schedule.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid day of month in cron expression %s: %s", expression, err)
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("invalid month in cron expression %s: %s", expression, err)
+	}
+	// 7 is also sunday
+	if schedule.weekdays, err = parseCronField(fields[4], 0, 7, cronWeekdays); err != nil {
+		return nil, fmt.Errorf("invalid day of week in cron expression %s: %s", expression, err)
+	}
+	if schedule.weekdays[7] {
+		schedule.weekdays[0] = true
+	}
+
+	return schedule, nil
+}
+
+// parseCronField parses a comma separated list of *, values, ranges and
+// steps like */5 or 1-10/2. names are the names of the values from min on
+func parseCronField(field string, min int, max int, names []string) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[idx+1:]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step %s", part[idx+1:])
+			}
+			part = part[:idx]
+		}
+
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			var err error
+			if from, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return nil, err
+			}
+
+			to = from
+			if len(bounds) == 2 {
+				if to, err = parseCronValue(bounds[1], min, max, names); err != nil {
+					return nil, err
+				}
+			} else if step != 1 {
+				// 5/10 is from 5 on
+				to = max
+			}
+			if to < from {
+				return nil, fmt.Errorf("invalid range %s", part)
+			}
+		}
+
+		for value := from; value <= to; value += step {
+			values[value] = true
+		}
+	}
+
+	return values, nil
+}
+
+func parseCronValue(value string, min int, max int, names []string) (int, error) {
+	for idx, name := range names {
+		if strings.EqualFold(value, name) {
+			return min + idx, nil
+		}
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		return 0, fmt.Errorf("invalid value %s", value)
+	}
+
+	return number, nil
+}
+
+// Next returns the first time of the schedule after the given time, or the
+// zero time if there isn't one in the next few years
+func (c *CronSchedule) Next(after time.Time) time.Time {
+	t := after.In(c.location)
+	// start from the next whole minute
+	t = t.Add(time.Duration(-t.Second())*time.Second - time.Duration(t.Nanosecond()) + time.Minute)
+
+	limit := t.Year() + maxCronYears
+	for t.Year() <= limit {
+		switch {
+		case !c.months[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.location)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.location)
+		case !c.hours[t.Hour()]:
+			// moving in absolute time is safe when clocks change
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case !c.minutes[t.Minute()] || repeatedWallClock(t):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// repeatedWallClock returns true if the clock already showed the time of t
+// as it went back, like 01:30 happens twice when daylight saving time ends.
+// Schedules only run the first time
+func repeatedWallClock(t time.Time) bool {
+	_, offset := t.Zone()
+	// clocks go back by 2 hours at most
+	_, earlier := t.Add(-2 * time.Hour).Zone()
+	if earlier <= offset {
+		return false
+	}
+
+	previous := t.Add(-time.Duration(earlier-offset) * time.Second)
+
+	return previous.Day() == t.Day() && previous.Hour() == t.Hour() && previous.Minute() == t.Minute()
+}
+
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	day := c.days[t.Day()]
+	weekday := c.weekdays[int(t.Weekday())]
+
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04:05", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return parsed
+	}
+
+	tests := []struct {
+		cron  string
+		after string
+		want  string
+	}{
+		{cron: "*/15 * * * *", after: "2026-01-01 10:07:30", want: "2026-01-01 10:15:00"},
+		// the next time is always after the given one
+		{cron: "*/15 * * * *", after: "2026-01-01 10:15:00", want: "2026-01-01 10:30:00"},
+		{cron: "5/20 * * * *", after: "2026-01-01 10:26:00", want: "2026-01-01 10:45:00"},
+		{cron: "0 9-17/4 * * *", after: "2026-01-01 13:00:00", want: "2026-01-01 17:00:00"},
+		{cron: "0,30 8 * * *", after: "2026-01-01 08:10:00", want: "2026-01-01 08:30:00"},
+		{cron: "0 9 * * mon-fri", after: "2026-01-02 09:00:00", want: "2026-01-05 09:00:00"},
+		// 7 is sunday too
+		{cron: "0 12 * * 7", after: "2026-01-01 00:00:00", want: "2026-01-04 12:00:00"},
+		// a day matches if either its day of month or day of week does
+		{cron: "0 0 13 * fri", after: "2026-01-02 00:00:00", want: "2026-01-09 00:00:00"},
+		{cron: "0 0 13 * *", after: "2026-01-02 00:00:00", want: "2026-01-13 00:00:00"},
+		{cron: "@monthly", after: "2026-01-31 23:59:00", want: "2026-02-01 00:00:00"},
+		{cron: "@yearly", after: "2026-06-01 00:00:00", want: "2027-01-01 00:00:00"},
+		{cron: "0 0 29 feb *", after: "2026-03-01 00:00:00", want: "2028-02-29 00:00:00"},
+		{cron: "0 0 31 * *", after: "2026-04-01 00:00:00", want: "2026-05-31 00:00:00"},
+	}
+
+	for _, test := range tests {
+		schedule, err := ParseCron(test.cron, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := schedule.Next(at(test.after)); !got.Equal(at(test.want)) {
+			t.Errorf("next of %s after %s is %s, want %s", test.cron, test.after, got, test.want)
+		}
+	}
+}
+
+func TestCronNextNever(t *testing.T) {
+	schedule, err := ParseCron("0 0 30 feb *", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next := schedule.Next(time.Now()); !next.IsZero() {
+		t.Errorf("february 30 is at %s", next)
+	}
+}
+
+func TestCronNextClockChanges(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %s", err)
+	}
+
+	tests := []struct {
+		cron  string
+		after time.Time
+		want  time.Time
+	}{
+		// 02:30 doesn't exist on 2026-03-08, when clocks go forward
+		{cron: "30 2 * * *", after: time.Date(2026, 3, 8, 0, 0, 0, 0, location), want: time.Date(2026, 3, 9, 2, 30, 0, 0, location)},
+		{cron: "0 * * * *", after: time.Date(2026, 3, 8, 1, 30, 0, 0, location), want: time.Date(2026, 3, 8, 3, 0, 0, 0, location)},
+		// 01:30 happens twice on 2026-11-01, when clocks go back
+		{cron: "30 1 * * *", after: time.Date(2026, 11, 1, 0, 0, 0, 0, location), want: time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC)},
+		{cron: "30 1 * * *", after: time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC), want: time.Date(2026, 11, 2, 1, 30, 0, 0, location)},
+	}
+
+	for _, test := range tests {
+		schedule, err := ParseCron(test.cron, location)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := schedule.Next(test.after); !got.Equal(test.want) {
+			t.Errorf("next of %s after %s is %s, want %s", test.cron, test.after, got, test.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, cron := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"10-5 * * * *",
+		"* * * foo *",
+		"@often",
+	} {
+		if _, err := ParseCron(cron, time.UTC); err == nil {
+			t.Errorf("%q is a valid cron expression", cron)
+		}
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	tests := []struct {
+		schedule ScheduleDefinition
+		valid    bool
+	}{
+		{schedule: ScheduleDefinition{Cron: "@daily"}, valid: true},
+		{schedule: ScheduleDefinition{Cron: "0 3 * * *", Timezone: "UTC", Jitter: time.Minute, Overlap: OverlapQueue}, valid: true},
+		{schedule: ScheduleDefinition{}, valid: false},
+		{schedule: ScheduleDefinition{Cron: "@daily", Timezone: "Nowhere/Town"}, valid: false},
+		{schedule: ScheduleDefinition{Cron: "@daily", Jitter: -time.Minute}, valid: false},
+		{schedule: ScheduleDefinition{Cron: "@daily", Overlap: "later"}, valid: false},
+	}
+
+	for _, test := range tests {
+		if err := test.schedule.Validate(); (err == nil) != test.valid {
+			t.Errorf("%+v is valid: %v, want %v", test.schedule, err == nil, test.valid)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DeadlineFail fails steps that would start after their deadline
+	DeadlineFail = "fail"
+	// DeadlineSkip skips steps that would start after their deadline
+	DeadlineSkip = "skip"
+)
+
+// parseDeadline returns the time of a deadline for a workflow started at
+// start. A deadline is an RFC 3339 time, a time of day like 02:00 or
+// 02:00 UTC (the first one after start) or an offset from start like +45m
+func parseDeadline(value string, start time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "+") {
+		offset, err := time.ParseDuration(value[1:])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid deadline %s: %s", value, err)
+		}
+
+		return start.Add(offset), nil
+	}
+
+	if deadline, err := time.Parse(time.RFC3339, value); err == nil {
+		return deadline, nil
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return time.Time{}, fmt.Errorf("invalid deadline %s. Use a time like 2006-01-02T15:04:05Z, 15:04, 15:04 UTC or +1h", value)
+	}
+
+	location := time.Local
+	if len(fields) == 2 {
+		var err error
+		if location, err = time.LoadLocation(fields[1]); err != nil {
+			return time.Time{}, fmt.Errorf("invalid deadline %s: %s", value, err)
+		}
+	}
+
+	var clock time.Time
+	var err error
+	if clock, err = time.Parse("15:04", fields[0]); err != nil {
+		if clock, err = time.Parse("15:04:05", fields[0]); err != nil {
+			return time.Time{}, fmt.Errorf("invalid deadline %s. Use a time like 2006-01-02T15:04:05Z, 15:04, 15:04 UTC or +1h", value)
+		}
+	}
+
+	day := start.In(location)
+	deadline := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, location)
+	if !deadline.After(start) {
+		deadline = deadline.AddDate(0, 0, 1)
+	}
+
+	return deadline, nil
+}
+
+func (s *Step) validateDeadline() error {
+	if _, err := parseDeadline(s.Deadline, time.Now()); err != nil {
+		return err
+	}
+
+	switch s.OnDeadline {
+	case "", DeadlineFail, DeadlineSkip:
+		return nil
+	default:
+		return fmt.Errorf("invalid on_deadline %s. Valid values are %s and %s", s.OnDeadline, DeadlineFail, DeadlineSkip)
+	}
+}
+
+// checkDeadline gives the deadline of the step to its spinner and returns
+// false if it has passed, after skipping or failing the step
+func (s *Step) checkDeadline(ctx context.Context, spinner *Spinner) (bool, error) {
+	deadline, err := parseDeadline(s.Deadline, s.workflow.startedAt)
+	if err != nil {
+		return false, err
+	}
+	spinner.deadline = deadline
+
+	if time.Now().Before(deadline) {
+		return true, nil
+	}
+
+	spinner.push(ctx, NewEvent(spinner, EventRunDeadlineMissed, &DeadlinePayload{Deadline: deadline}))
+	if s.OnDeadline == DeadlineSkip {
+		s.update(func() { s.skipped = true })
+		spinner.push(ctx, NewEvent(spinner, EventRunSkipped, nil))
+		return false, nil
+	}
+
+	return false, fmt.Errorf("deadline %s passed before the step started", deadline.Format(time.RFC3339))
+}
+
+// commandContext returns the context of the command of the spinner, ending
+// at its timeout or its deadline
+func (s *Spinner) commandContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.service {
+		return context.WithCancel(ctx)
+	}
+	if !s.deadline.IsZero() && time.Until(s.deadline) < s.timeout {
+		return context.WithDeadline(ctx, s.deadline)
+	}
+
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+// timeoutPayload returns the data of the timeout of the command
+func (s *Spinner) timeoutPayload() *TimeoutPayload {
+	return &TimeoutPayload{Elapsed: time.Since(s.startedAt), Limit: s.timeout}
+}
+
+// pastDeadline returns true if the command was stopped at its deadline
+// rather than its timeout
+func (s *Spinner) pastDeadline(cmdCtx context.Context) bool {
+	return cmdCtx.Err() == context.DeadlineExceeded && !s.deadline.IsZero() && !time.Now().Before(s.deadline)
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// debugInput reads the answers to the debug prompts
+var debugInput = bufio.NewReader(os.Stdin)
+
+// debug shows the step as it's going to run and asks what to do with it. It
+// returns the spinner to run the step with, or false if it shouldn't run
+func (s *Step) debug(ctx context.Context, spinner *Spinner) (*Spinner, bool, error) {
+	s.workflow.debugSignal.Lock()
+	defer s.workflow.debugSignal.Unlock()
+
+	for {
+		s.printForDebug()
+		fmt.Print("[r]un, [s]kip, [e]dit the command or [a]bort? ")
+
+		answer, err := debugInput.ReadString('\n')
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read the answer: %s", err)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "", "r", "run":
+			return spinner, true, nil
+		case "s", "skip":
+			s.update(func() { s.skipped = true })
+			spinner.push(ctx, NewEvent(spinner, EventRunSkipped, nil))
+
+			return nil, false, nil
+		case "a", "abort":
+			s.update(func() { s.skipped = true })
+			s.workflow.logger.WithField(FldStep, s.Name).Info("Stopping execution")
+			s.workflow.stop(ctx)
+
+			return nil, false, nil
+		case "e", "edit":
+			if s.Foreach != nil || s.Type == StepTypeWorkflow || s.StopStep != "" {
+				fmt.Println("The command of this step can't be edited")
+				continue
+			}
+
+			fmt.Print("Command: ")
+			command, err := debugInput.ReadString('\n')
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to read the command: %s", err)
+			}
+			if command = strings.TrimSpace(command); command == "" {
+				continue
+			}
+
+			s.Command = command
+			s.Exec = nil
+			if spinner, err = NewSpinnerForStep(ctx, *s); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+}
+
+// printForDebug prints the resolved attributes of the step
+func (s *Step) printForDebug() {
+	fmt.Printf("\n--- %s ---\n", s.Name)
+	if s.Command != "" {
+		fmt.Printf("Command: %s\n", s.workflow.MaskSecrets(s.Command))
+	}
+	if s.Workdir != "" {
+		fmt.Printf("Workdir: %s\n", s.Workdir)
+	}
+	if env := s.MergedEnv(); len(env) != 0 {
+		fmt.Println("Env:")
+		for _, value := range env {
+			fmt.Printf("  %s\n", s.workflow.MaskSecrets(value))
+		}
+	}
+	printMapForDebug(s.workflow, "Variables", s.Var())
+	printMapForDebug(s.workflow, "Metadata", s.MergedMetadata())
+}
+
+func printMapForDebug(w *Workflow, title string, values map[string]string) {
+	if len(values) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Printf("%s:\n", title)
+	for _, key := range keys {
+		fmt.Printf("  %s=%s\n", key, w.MaskSecrets(values[key]))
+	}
+}
@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// DependencySuccess runs the step only if the step it depends on
+	// succeeded, was skipped or was cached
+	DependencySuccess = "success"
+	// DependencyFailure runs the step only if the step it depends on failed
+	DependencyFailure = "failure"
+	// DependencyAny runs the step once the step it depends on is done, even
+	// if it failed the workflow
+	DependencyAny = "any"
+)
+
+// Dependency is a step another step depends on. In a workflow it's either
+// the name of the step, or the step and the status it has to finish with:
+//
+//	depends_on:
+//	- build
+//	- step: test
+//	  status: failure
+type Dependency struct {
+	Step string `yaml:"step" json:"step"`
+	// Status is success, failure or any. Without it the step runs once the
+	// step it depends on is done, and a failure stops the workflow first
+	Status string `yaml:"status" json:"status,omitempty"`
+}
+
+// Dependencies are the steps a step depends on. A single dependency doesn't
+// have to be in a list
+type Dependencies []*Dependency
+
+// dependency has no custom (un)marshalling so the methods below can use it
+type dependency Dependency
+
+// UnmarshalYAML reads the name of a step or a step and a status
+func (d *Dependency) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		d.Step = name
+		return nil
+	}
+
+	return unmarshal((*dependency)(d))
+}
+
+// MarshalYAML writes dependencies without a status as the name of the step
+func (d *Dependency) MarshalYAML() (interface{}, error) {
+	if d.Status == "" {
+		return d.Step, nil
+	}
+
+	return (*dependency)(d), nil
+}
+
+// UnmarshalJSON reads the name of a step or a step and a status
+func (d *Dependency) UnmarshalJSON(buff []byte) error {
+	var name string
+	if err := json.Unmarshal(buff, &name); err == nil {
+		d.Step = name
+		return nil
+	}
+
+	return json.Unmarshal(buff, (*dependency)(d))
+}
+
+// MarshalJSON writes dependencies without a status as the name of the step
+func (d *Dependency) MarshalJSON() ([]byte, error) {
+	if d.Status == "" {
+		return json.Marshal(d.Step)
+	}
+
+	return json.Marshal((*dependency)(d))
+}
+
+// UnmarshalYAML reads a list of dependencies or a single one
+func (d *Dependencies) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []*Dependency
+	if err := unmarshal(&list); err == nil {
+		*d = list
+		return nil
+	}
+
+	single := &Dependency{}
+	if err := unmarshal(single); err != nil {
+		return err
+	}
+	*d = Dependencies{single}
+
+	return nil
+}
+
+// Names returns the names of the steps
+func (d Dependencies) Names() []string {
+	if len(d) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(d))
+	for idx, dependency := range d {
+		names[idx] = dependency.Step
+	}
+
+	return names
+}
+
+// find returns the dependency on the step with the name, or nil
+func (d Dependencies) find(name string) *Dependency {
+	for _, dependency := range d {
+		if dependency.Step == name {
+			return dependency
+		}
+	}
+
+	return nil
+}
+
+// String returns the step and its status if it has one
+func (d *Dependency) String() string {
+	if d.Status == "" {
+		return d.Step
+	}
+
+	return fmt.Sprintf("%s (%s)", d.Step, d.Status)
+}
+
+func (d *Dependency) validate() error {
+	switch d.Status {
+	case "", DependencySuccess, DependencyFailure, DependencyAny:
+		return nil
+	default:
+		return fmt.Errorf("invalid status %s for %s. Valid values are %s, %s and %s", d.Status, d.Step, DependencySuccess, DependencyFailure, DependencyAny)
+	}
+}
+
+// runsOnFailure returns true if the step can still run once a failure is
+// stopping the workflow, because it depends on a step with the failure or
+// any status
+func (s *Step) runsOnFailure() bool {
+	for _, dependency := range s.DependsOn {
+		if dependency.Status == DependencyFailure || dependency.Status == DependencyAny {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unmetDependency returns the first step the step depends on that didn't
+// finish with the status the step needs, or nil if all did
+func (s *Step) unmetDependency() *Dependency {
+	for _, priorStep := range s.dependsOn {
+		dependency := s.DependsOn.find(priorStep.Name)
+		if dependency == nil {
+			continue
+		}
+
+		failed := priorStep.err != nil
+		switch dependency.Status {
+		case DependencySuccess:
+			if failed {
+				return dependency
+			}
+		case DependencyFailure:
+			if !failed {
+				return dependency
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// StepTypeProcess runs the command of the step as a process. This is the default
+	StepTypeProcess = "process"
+	// StepTypeDocker runs the command of the step in a Docker container
+	StepTypeDocker = "docker"
+
+	dockerBinary = "docker"
+
+	// dockerRemoveTimeout is how long removing the container of a stopped
+	// step can take
+	dockerRemoveTimeout = 30 * time.Second
+)
+
+var dockerPullPolicies = []string{"", "always", "missing", "never"}
+
+// DockerOptions configures the container a docker step runs in
+type DockerOptions struct {
+	Image string `yaml:"image" json:"image"`
+	// Volumes are mounted in the container as host:container[:options]
+	Volumes []string `yaml:"volumes" json:"volumes"`
+	Network string   `yaml:"network" json:"network"`
+	// Pull is when the image is pulled: always, missing or never
+	Pull string `yaml:"pull" json:"pull"`
+	// Workdir is the work directory inside the container
+	Workdir string `yaml:"workdir" json:"workdir"`
+}
+
+func (d *DockerOptions) validate() error {
+	if d == nil || strings.TrimSpace(d.Image) == "" {
+		return fmt.Errorf("docker steps need an image")
+	}
+	if !contains(dockerPullPolicies, d.Pull) {
+		return fmt.Errorf("invalid pull policy %s. Valid values are always, missing and never", d.Pull)
+	}
+
+	return nil
+}
+
+func (d *DockerOptions) enrich(ctx context.Context, step *Step) error {
+	var err error
+
+	attributes := []*string{&d.Image, &d.Network, &d.Workdir}
+	for idx := range d.Volumes {
+		attributes = append(attributes, &d.Volumes[idx])
+	}
+
+	for _, attribute := range attributes {
+		if *attribute, err = step.parseAttribute(ctx, *attribute); err != nil {
+			return err
+		}
+		if *attribute, err = ExpandEnvVars(ctx, *attribute); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commandParts wraps the parts of the command with the docker run command.
+// The environment of the step is passed to the container by name so the
+// values are not visible in the arguments of the process. Interactive
+// containers read the stdin of the command
+func (d *DockerOptions) commandParts(name string, env []string, parts []string, interactive bool) []string {
+	args := []string{dockerBinary, "run", "--rm", "--name", name}
+	if interactive {
+		// keeps stdin open for the input of the step
+		args = append(args, "--interactive")
+	}
+	if d.Pull != "" {
+		args = append(args, "--pull", d.Pull)
+	}
+	if d.Network != "" {
+		args = append(args, "--network", d.Network)
+	}
+	if d.Workdir != "" {
+		args = append(args, "--workdir", d.Workdir)
+	}
+	for _, volume := range d.Volumes {
+		args = append(args, "--volume", volume)
+	}
+	for _, item := range env {
+		args = append(args, "--env", strings.SplitN(item, "=", 2)[0])
+	}
+
+	args = append(args, d.Image)
+
+	return append(args, parts...)
+}
+
+// removeContainer removes the container of a docker step stopped before it
+// finished. The docker CLI can be killed before the container stops, which
+// then keeps running
+func removeContainer(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerRemoveTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, dockerBinary, "rm", "--force", name).CombinedOutput()
+	if err != nil && !strings.Contains(string(out), "No such container") {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+var (
+	// ErrInvalidWorkflow is matched by the errors of workflows that can't be
+	// loaded, with errors.Is
+	ErrInvalidWorkflow = errors.New("invalid workflow")
+	// ErrEmptyWorkflow is returned when loading a workflow with nothing in it
+	ErrEmptyWorkflow = errors.New("empty workflow")
+	// ErrNoOptions is returned when running a workflow that wasn't loaded
+	// with LoadWorkflow or one of the LoadWorkflowFrom functions, and when
+	// creating a server or scheduler without workflow options
+	ErrNoOptions = errors.New("workflow has no options. Load it before running it")
+)
+
+// ParseError is returned when a workflow can't be read or its templates,
+// matrices, stages or includes can't be applied
+type ParseError struct {
+	Err error
+}
+
+// Error implements error
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error the workflow couldn't be read for
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches ErrInvalidWorkflow
+func (e *ParseError) Is(target error) bool {
+	return target == ErrInvalidWorkflow
+}
+
+// ValidationError holds all the problems found in a workflow, like unknown
+// attributes, an UnsupportedVersionError or a CycleError. errors.As finds
+// each of them
+type ValidationError struct {
+	Errors []error
+}
+
+// Error implements error
+func (e *ValidationError) Error() string {
+	return multierror.ListFormatFunc(e.Errors)
+}
+
+// Unwrap returns the problems found
+func (e *ValidationError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is matches ErrInvalidWorkflow
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidWorkflow
+}
+
+// validationError returns the problems in errors as a ValidationError, or
+// nil if there are none
+func validationError(errors error) error {
+	if errors == nil {
+		return nil
+	}
+	if merr, ok := errors.(*multierror.Error); ok {
+		return &ValidationError{Errors: merr.Errors}
+	}
+
+	return &ValidationError{Errors: []error{errors}}
+}
+
+// UnsupportedVersionError is the problem of a workflow with a version other
+// than 1 or 2
+type UnsupportedVersionError struct {
+	Got string
+}
+
+// Error implements error
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("invalid workflow version %s", e.Got)
+}
+
+// CycleError is the problem of steps depending on each other. Steps are in
+// the order they depend on each other, starting and ending with the same one
+type CycleError struct {
+	Steps []string
+}
+
+// Error implements error
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("circular dependency between steps %s", strings.Join(e.Steps, " -> "))
+}
+
+// SignatureError is returned when a workflow that has to be signed isn't, or
+// its signature isn't valid
+type SignatureError struct {
+	// Name is the workflow or include, like workflow deploy
+	Name string
+	// Err is why the signature isn't valid. It's nil if there is none
+	Err error
+}
+
+// Error implements error
+func (e *SignatureError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s isn't signed", e.Name)
+	}
+
+	return fmt.Sprintf("failed to verify the signature of %s: %s", e.Name, e.Err)
+}
+
+// Unwrap returns why the signature isn't valid
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
+
+// StepError is the error of a step that failed the workflow, like a
+// SuccessCheckError, an AgentExitError or an *exec.ExitError
+type StepError struct {
+	Step string
+	Err  error
+}
+
+// Error implements error
+func (e *StepError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error of the step
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// PreflightError is returned when a preflight check of a step failed, so the
+// workflow didn't run
+type PreflightError struct {
+	Step string
+	Err  error
+}
+
+// Error implements error
+func (e *PreflightError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error of the preflight check
+func (e *PreflightError) Unwrap() error {
+	return e.Err
+}
+
+// TransitionError is returned when a step can't go from its status to
+// another, like running a step that is done
+type TransitionError struct {
+	Step string
+	From StepStatus
+	To   StepStatus
+}
+
+// Error implements error
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("step %s can't go from %s to %s", e.Step, e.From, e.To)
+}
+
+// WorkflowTimeoutError is in the errors of a run that timed out
+type WorkflowTimeoutError struct {
+	Limit time.Duration
+}
+
+// Error implements error
+func (e *WorkflowTimeoutError) Error() string {
+	return fmt.Sprintf("workflow timed out after %s", e.Limit)
+}
+
+// RunError holds the errors of a run, like StepErrors and a
+// WorkflowTimeoutError. errors.As finds each of them
+type RunError struct {
+	Errors []error
+}
+
+// Error implements error
+func (e *RunError) Error() string {
+	return multierror.ListFormatFunc(e.Errors)
+}
+
+// Unwrap returns the errors of the run
+func (e *RunError) Unwrap() []error {
+	return e.Errors
+}
+
+// Err returns the errors of the run as a RunError, or nil if there are none
+func (r *WorkflowResult) Err() error {
+	if r.Errors == nil {
+		return nil
+	}
+	if merr, ok := r.Errors.(*multierror.Error); ok {
+		if len(merr.Errors) == 0 {
+			return nil
+		}
+
+		return &RunError{Errors: merr.Errors}
+	}
+
+	return &RunError{Errors: []error{r.Errors}}
+}
@@ -1,6 +1,14 @@
 package utils
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventSchemaVersion is the version of the events and their data as sent to
+// the notifiers. It changes when a field is removed or changes its meaning
+const EventSchemaVersion = "1"
 
 const (
 	// EventRunRequested run requested
@@ -19,23 +27,157 @@ const (
 	EventRunTimeout = "run.timeout"
 	// EventRunningProbe announces probing
 	EventRunningProbe = "run.probing"
+	// EventRunRetry failed run is going to be retried
+	EventRunRetry = "run.retry"
+	// EventRunSkipped run skipped because of its condition
+	EventRunSkipped = "run.skipped"
+	// EventRunCacheHit run skipped because its cache key matched a previous run
+	EventRunCacheHit = "run.cache.hit"
+	// EventRunCancelled run stopped because the workflow was cancelled
+	EventRunCancelled = "run.cancelled"
+	// EventRunUsage command of the run exited, with the resources it used
+	EventRunUsage = "run.usage"
+	// EventRunProgress run printed a line matching the progress_regex of its step
+	EventRunProgress = "run.progress"
+	// EventRunStalled run printed no output and used no CPU for its stall_after
+	EventRunStalled = "run.stalled"
+	// EventRunLimitExceeded run killed for going over a resource limit
+	EventRunLimitExceeded = "run.limit.exceeded"
+	// EventRunOutputTruncated run wrote more output than the limit of its step
+	EventRunOutputTruncated = "run.output.truncated"
+	// EventRunDegraded quorum step met its quorum but some of its steps failed
+	EventRunDegraded = "run.degraded"
+	// EventRunGenerated run added the steps it printed to the workflow
+	EventRunGenerated = "run.generated"
+	// EventRunDeadline run stopped at the deadline of its step
+	EventRunDeadline = "run.deadline"
+	// EventRunDeadlineMissed run not started because the deadline of its step passed
+	EventRunDeadlineMissed = "run.deadline.missed"
+	// EventServiceReady the probe of a service step passed
+	EventServiceReady = "run.service.ready"
+	// EventServiceStopped service stopped by the workflow
+	EventServiceStopped = "run.service.stopped"
+	// EventWorkflowStarted workflow started running
+	EventWorkflowStarted = "workflow.started"
+	// EventWorkflowSuccess workflow finished without stopping for errors
+	EventWorkflowSuccess = "workflow.success"
+	// EventWorkflowTimeout workflow ran for longer than its timeout and is stopping
+	EventWorkflowTimeout = "workflow.timeout"
+	// EventWorkflowPaused workflow stopped starting new steps
+	EventWorkflowPaused = "workflow.paused"
+	// EventWorkflowResumed paused workflow started new steps again
+	EventWorkflowResumed = "workflow.resumed"
+	// EventWorkflowFail workflow failed or was cancelled
+	EventWorkflowFail = "workflow.fail"
+	// EventRollbackStarted rolling back the successful steps of a failed workflow
+	EventRollbackStarted = "workflow.rollback.started"
+	// EventRollbackFinished rollback of the workflow finished
+	EventRollbackFinished = "workflow.rollback.finished"
 )
 
+// EventData is the data of an event. Each event has its own type of data,
+// or none:
+//
+//	run.fail                    *RunFailPayload
+//	run.timeout                 *TimeoutPayload
+//	run.wait.error              *ErrorPayload
+//	run.retry                   *RetryAttempt
+//	run.usage                   *ResourceUsage
+//	run.stalled                 *StalledPayload
+//	run.progress                *ProgressPayload
+//	run.limit.exceeded          *LimitExceeded
+//	run.output.truncated        *OutputTruncated
+//	run.degraded                *QuorumPayload
+//	run.generated               *GeneratedPayload
+//	run.deadline                *DeadlinePayload
+//	run.deadline.missed         *DeadlinePayload
+//	run.cache.hit               *CacheEntry
+//	workflow.success            *WorkflowResult
+//	workflow.fail               *WorkflowResult
+//	workflow.timeout            *TimeoutPayload
+//	workflow.rollback.finished  *RollbackResult
+type EventData interface {
+	eventData()
+}
+
+// RunFailPayload is the data of a command that exited with an error
+type RunFailPayload struct {
+	ExitCode int `json:"exit_code"`
+	// Signal is the signal that killed the command, if any
+	Signal string `json:"signal,omitempty"`
+	// Outcome is the outcome of a failed sub-workflow
+	Outcome string `json:"outcome,omitempty"`
+	// OOMKilled is set if the command was killed for running out of memory
+	OOMKilled bool `json:"oom_killed,omitempty"`
+	// Quorum is the quorum a failed quorum step didn't meet
+	Quorum *QuorumPayload `json:"quorum,omitempty"`
+}
+
+// TimeoutPayload is the data of a step or a workflow that timed out
+type TimeoutPayload struct {
+	Elapsed time.Duration `json:"elapsed"`
+	Limit   time.Duration `json:"limit"`
+}
+
+// DeadlinePayload is the data of a step that missed its deadline
+type DeadlinePayload struct {
+	Deadline time.Time `json:"deadline"`
+}
+
+// ErrorPayload is the data of a command that failed for another reason
+// than its exit code
+type ErrorPayload struct {
+	Error string `json:"error"`
+}
+
+func (*RunFailPayload) eventData()   {}
+func (*TimeoutPayload) eventData()   {}
+func (*DeadlinePayload) eventData()  {}
+func (*ErrorPayload) eventData()     {}
+func (*RetryAttempt) eventData()     {}
+func (*LimitExceeded) eventData()    {}
+func (*CacheEntry) eventData()       {}
+func (*WorkflowResult) eventData()   {}
+func (*RollbackResult) eventData()   {}
+func (*QuorumPayload) eventData()    {}
+func (*GeneratedPayload) eventData() {}
+func (*OutputTruncated) eventData()  {}
+func (*ResourceUsage) eventData()    {}
+func (*StalledPayload) eventData()   {}
+func (*ProgressPayload) eventData()  {}
+
 // Event is a simple event
 type Event struct {
-	Name    string
+	Name string
+	// At is when the event happened
+	At      time.Time
 	Payload Payload
 }
 
 // NewEvent creates a new event
-func NewEvent(spinner *Spinner, name string, extras interface{}) *Event {
+func NewEvent(spinner *Spinner, name string, extras EventData) *Event {
 	return &Event{
 		Name: name,
+		At:   time.Now(),
 		Payload: Payload{
 			EventUUID: uuid.New().String(),
+			Workflow:  spinner.step.workflow,
 			Spinner:   spinner,
 			Step:      spinner.step,
 			Extras:    extras,
 		},
 	}
 }
+
+// NewWorkflowEvent creates a new event about the workflow as a whole
+func NewWorkflowEvent(workflow *Workflow, name string, extras EventData) *Event {
+	return &Event{
+		Name: name,
+		At:   time.Now(),
+		Payload: Payload{
+			EventUUID: uuid.New().String(),
+			Workflow:  workflow,
+			Extras:    extras,
+		},
+	}
+}
@@ -0,0 +1,225 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// OverflowBlock waits for room in the buffer of a notifier that is full
+	OverflowBlock = "block"
+	// OverflowDropOldest drops the oldest event in the buffer of a notifier
+	// that is full
+	OverflowDropOldest = "drop_oldest"
+
+	defaultEventBuffer = 100
+)
+
+var overflowPolicies = []string{OverflowBlock, OverflowDropOldest}
+
+// EventBusOptions sends the events to the notifiers in the background, so a
+// slow notifier doesn't slow the steps down. Each notifier gets the events
+// in the order they happened, and all of them are sent before Run returns
+type EventBusOptions struct {
+	// BufferSize is the number of events held for each notifier. Defaults
+	// to 100
+	BufferSize int
+	// Overflow is what happens to an event for a notifier whose buffer is
+	// full: OverflowBlock or OverflowDropOldest. Defaults to OverflowBlock
+	Overflow string
+	// Synchronous are the names of the notifiers still called as the events
+	// happen, like console, whose logs should be in order with the output of
+	// the steps
+	Synchronous []string
+}
+
+func (o *EventBusOptions) validate() error {
+	if o.BufferSize < 0 {
+		return fmt.Errorf("invalid event buffer size %d", o.BufferSize)
+	}
+	if o.Overflow != "" && !contains(overflowPolicies, o.Overflow) {
+		return fmt.Errorf("invalid event overflow %s. Valid values are %s", o.Overflow, strings.Join(overflowPolicies, ", "))
+	}
+
+	return nil
+}
+
+// eventBus sends events to each notifier from a goroutine of its own, through
+// a buffer
+type eventBus struct {
+	registry *NotifierRegistry
+	options  *EventBusOptions
+	// signal is held by the senders and taken by drain, so no events are
+	// sent while the buffers are drained
+	signal       *sync.RWMutex
+	queuesSignal *sync.Mutex
+	queues       map[*subscription]*eventQueue
+}
+
+// eventQueue holds the events for a notifier until its goroutine sends them
+type eventQueue struct {
+	subscription *subscription
+	size         int
+	overflow     string
+	signal       *sync.Mutex
+	changed      *sync.Cond
+	events       []*queuedEvent
+	closed       bool
+	done         chan struct{}
+}
+
+type queuedEvent struct {
+	ctx    context.Context
+	logger *logrus.Logger
+	event  *Event
+}
+
+func newEventBus(registry *NotifierRegistry, options *EventBusOptions) *eventBus {
+	return &eventBus{
+		registry:     registry,
+		options:      options,
+		signal:       &sync.RWMutex{},
+		queuesSignal: &sync.Mutex{},
+		queues:       make(map[*subscription]*eventQueue),
+	}
+}
+
+// publish sends the event to the synchronous notifiers and waits for them,
+// and adds it to the buffers of the others. Only the errors of the
+// synchronous notifiers are returned, the others are logged
+func (b *eventBus) publish(ctx context.Context, logger *logrus.Logger, event *Event) error {
+	b.signal.RLock()
+	defer b.signal.RUnlock()
+
+	var synchronous []*subscription
+	for _, item := range b.registry.subscribers(event) {
+		if contains(b.options.Synchronous, item.name) {
+			synchronous = append(synchronous, item)
+			continue
+		}
+
+		// the event is sent after the step is done with its context
+		queued := &queuedEvent{ctx: context.WithoutCancel(ctx), logger: logger, event: event}
+		if dropped := b.queue(item).push(queued); dropped != nil {
+			logger.Warnf("Dropped the %s event for notifier %s as its buffer is full", dropped.event.Name, item.name)
+		}
+	}
+
+	return notifyAll(ctx, logger, event, synchronous)
+}
+
+// queue returns the buffer of the notifier, starting its goroutine if needed
+func (b *eventBus) queue(item *subscription) *eventQueue {
+	b.queuesSignal.Lock()
+	defer b.queuesSignal.Unlock()
+
+	if queue, ok := b.queues[item]; ok {
+		return queue
+	}
+
+	size := b.options.BufferSize
+	if size == 0 {
+		size = defaultEventBuffer
+	}
+	overflow := b.options.Overflow
+	if overflow == "" {
+		overflow = OverflowBlock
+	}
+
+	queue := &eventQueue{
+		subscription: item,
+		size:         size,
+		overflow:     overflow,
+		signal:       &sync.Mutex{},
+		done:         make(chan struct{}),
+	}
+	queue.changed = sync.NewCond(queue.signal)
+	b.queues[item] = queue
+	go queue.deliver()
+
+	return queue
+}
+
+// drain waits for all events in the buffers to be sent, or for ctx to be
+// done. Events sent after it start new buffers
+func (b *eventBus) drain(ctx context.Context) error {
+	b.signal.Lock()
+	defer b.signal.Unlock()
+
+	b.queuesSignal.Lock()
+	queues := b.queues
+	b.queues = make(map[*subscription]*eventQueue)
+	b.queuesSignal.Unlock()
+
+	for _, queue := range queues {
+		queue.close()
+	}
+	for _, queue := range queues {
+		select {
+		case <-queue.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// push adds the event to the buffer. It returns the event dropped to make
+// room for it, if any
+func (q *eventQueue) push(item *queuedEvent) *queuedEvent {
+	q.signal.Lock()
+	defer q.signal.Unlock()
+
+	for q.overflow == OverflowBlock && len(q.events) >= q.size {
+		q.changed.Wait()
+	}
+
+	var dropped *queuedEvent
+	if len(q.events) >= q.size {
+		dropped = q.events[0]
+		q.events = q.events[1:]
+	}
+	q.events = append(q.events, item)
+	q.changed.Broadcast()
+
+	return dropped
+}
+
+// close lets the goroutine of the buffer stop once it's empty
+func (q *eventQueue) close() {
+	q.signal.Lock()
+	defer q.signal.Unlock()
+
+	q.closed = true
+	q.changed.Broadcast()
+}
+
+// deliver sends the events in the buffer to the notifier one at a time,
+// until it's closed and empty
+func (q *eventQueue) deliver() {
+	defer close(q.done)
+
+	for {
+		q.signal.Lock()
+		for len(q.events) == 0 && !q.closed {
+			q.changed.Wait()
+		}
+		if len(q.events) == 0 {
+			q.signal.Unlock()
+			return
+		}
+		item := q.events[0]
+		q.events = q.events[1:]
+		q.changed.Broadcast()
+		q.signal.Unlock()
+
+		if err := q.subscription.notifier(item.ctx, item.logger, item.event); err != nil {
+			item.logger.Errorf("notifier %s: %s", q.subscription.name, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testNotifier records the events it gets. Once blocked, it waits for
+// release before returning from the next event
+type testNotifier struct {
+	signal   *sync.Mutex
+	names    []string
+	received chan string
+	release  chan struct{}
+	blocked  bool
+}
+
+func newTestNotifier(blocked bool) *testNotifier {
+	return &testNotifier{
+		signal:   &sync.Mutex{},
+		received: make(chan string, 100),
+		release:  make(chan struct{}),
+		blocked:  blocked,
+	}
+}
+
+func (n *testNotifier) notify(ctx context.Context, logger *logrus.Logger, event *Event) error {
+	n.signal.Lock()
+	n.names = append(n.names, event.Name)
+	n.signal.Unlock()
+
+	n.received <- event.Name
+	if n.blocked {
+		<-n.release
+	}
+
+	return nil
+}
+
+func (n *testNotifier) events() string {
+	n.signal.Lock()
+	defer n.signal.Unlock()
+
+	return strings.Join(n.names, " ")
+}
+
+func newTestEventBus(t *testing.T, options *EventBusOptions, notifiers map[string]*testNotifier) (*eventBus, *lockedBuffer) {
+	t.Helper()
+
+	registry := NewNotifierRegistry()
+	for name, notifier := range notifiers {
+		if err := registry.Register(name, notifier.notify); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return newEventBus(registry, options), &lockedBuffer{}
+}
+
+func publishEvents(t *testing.T, bus *eventBus, out *lockedBuffer, from int, to int) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(out)
+	for idx := from; idx < to; idx++ {
+		if err := bus.publish(context.Background(), logger, &Event{Name: fmt.Sprintf("e%d", idx)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func waitForEvent(t *testing.T, notifier *testNotifier, name string) {
+	t.Helper()
+
+	select {
+	case received := <-notifier.received:
+		if received != name {
+			t.Fatalf("the notifier got %s, want %s", received, name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the notifier didn't get %s", name)
+	}
+}
+
+func TestEventBusOrder(t *testing.T) {
+	console := newTestNotifier(false)
+	slow := newTestNotifier(true)
+	bus, out := newTestEventBus(t, &EventBusOptions{Synchronous: []string{"console"}}, map[string]*testNotifier{"console": console, "slow": slow})
+
+	publishEvents(t, bus, out, 0, 5)
+	// synchronous notifiers get the events before publish returns
+	if events := console.events(); events != "e0 e1 e2 e3 e4" {
+		t.Errorf("console got %s, want e0 to e4", events)
+	}
+
+	waitForEvent(t, slow, "e0")
+	close(slow.release)
+	if err := bus.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if events := slow.events(); events != "e0 e1 e2 e3 e4" {
+		t.Errorf("the slow notifier got %s, want e0 to e4 in order", events)
+	}
+
+	// events published after drain are sent by a new buffer
+	publishEvents(t, bus, out, 5, 7)
+	if err := bus.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if events := slow.events(); events != "e0 e1 e2 e3 e4 e5 e6" {
+		t.Errorf("the slow notifier got %s after draining again, want e0 to e6", events)
+	}
+}
+
+func TestEventBusDropOldest(t *testing.T) {
+	slow := newTestNotifier(true)
+	bus, out := newTestEventBus(t, &EventBusOptions{BufferSize: 2, Overflow: OverflowDropOldest}, map[string]*testNotifier{"slow": slow})
+
+	publishEvents(t, bus, out, 0, 1)
+	waitForEvent(t, slow, "e0")
+	// e1 and e2 are dropped for e3 and e4 while the notifier is busy with e0
+	publishEvents(t, bus, out, 1, 5)
+
+	close(slow.release)
+	if err := bus.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if events := slow.events(); events != "e0 e3 e4" {
+		t.Errorf("the notifier got %s, want e0 e3 e4", events)
+	}
+	for _, name := range []string{"e1", "e2"} {
+		if !strings.Contains(out.String(), fmt.Sprintf("Dropped the %s event for notifier slow", name)) {
+			t.Errorf("dropping %s wasn't logged: %s", name, out.String())
+		}
+	}
+}
+
+func TestEventBusBlock(t *testing.T) {
+	slow := newTestNotifier(true)
+	bus, out := newTestEventBus(t, &EventBusOptions{BufferSize: 1, Overflow: OverflowBlock}, map[string]*testNotifier{"slow": slow})
+
+	publishEvents(t, bus, out, 0, 1)
+	waitForEvent(t, slow, "e0")
+	publishEvents(t, bus, out, 1, 2)
+
+	// the buffer holds e1, so e2 waits for room
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		publishEvents(t, bus, out, 2, 3)
+	}()
+	select {
+	case <-published:
+		t.Fatal("e2 was published while the buffer was full")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the drain is only done once all the events are sent
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	close(slow.release)
+	<-published
+	if err := bus.drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if events := slow.events(); events != "e0 e1 e2" {
+		t.Errorf("the notifier got %s, want e0 e1 e2", events)
+	}
+}
+
+func TestEventBusDrainTimeout(t *testing.T) {
+	slow := newTestNotifier(true)
+	bus, out := newTestEventBus(t, &EventBusOptions{}, map[string]*testNotifier{"slow": slow})
+	defer close(slow.release)
+
+	publishEvents(t, bus, out, 0, 2)
+	waitForEvent(t, slow, "e0")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := bus.drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("draining a stuck notifier returned %v, want %s", err, context.DeadlineExceeded)
+	}
+}
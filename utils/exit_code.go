@@ -0,0 +1,40 @@
+package utils
+
+const (
+	// ExitSuccess the workflow finished, even if some steps were allowed to
+	// fail
+	ExitSuccess = 0
+	// ExitStepFailed a step failed the workflow
+	ExitStepFailed = 1
+	// ExitInvalid the workflow, its variables or the configuration are
+	// invalid so it didn't run
+	ExitInvalid = 2
+	// ExitTimeout the workflow or one of its failed steps timed out
+	ExitTimeout = 3
+	// ExitCancelled the workflow was cancelled, like with Ctrl-C
+	ExitCancelled = 4
+	// ExitError the workflow couldn't run, like when a preflight check
+	// fails or the state file can't be read
+	ExitError = 5
+)
+
+// ExitCode returns the exit code of trackman for the result
+func (r *WorkflowResult) ExitCode() int {
+	switch r.Outcome {
+	case OutcomeCancelled:
+		return ExitCancelled
+	case OutcomeFailed:
+		if r.TimedOut {
+			return ExitTimeout
+		}
+		for _, step := range r.Failed() {
+			if step.TimedOut {
+				return ExitTimeout
+			}
+		}
+
+		return ExitStepFailed
+	default:
+		return ExitSuccess
+	}
+}
@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Foreach runs the command of a step once for each of its items. Only one
+// of Items, Glob and Lines should be set
+type Foreach struct {
+	// Items is a list of items
+	Items []string `yaml:"items" json:"items"`
+	// Glob is a file pattern. Each matching file is an item. Relative
+	// patterns are relative to the work directory of the step
+	Glob string `yaml:"glob" json:"glob"`
+	// Lines is split into lines with each line as an item. It's usually the
+	// output of another step like {{ .Outputs.list.services }}
+	Lines string `yaml:"lines" json:"lines"`
+}
+
+// Item returns the item the command of a foreach step is running for
+func (s *Step) Item() string {
+	return s.item
+}
+
+func (f *Foreach) validate() error {
+	set := 0
+	for _, isSet := range []bool{len(f.Items) != 0, f.Glob != "", f.Lines != ""} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("foreach needs one of items, glob or lines")
+	}
+
+	return nil
+}
+
+func (f *Foreach) enrich(ctx context.Context, step *Step) error {
+	var err error
+
+	attributes := []*string{&f.Glob, &f.Lines}
+	for idx := range f.Items {
+		attributes = append(attributes, &f.Items[idx])
+	}
+
+	for _, attribute := range attributes {
+		if *attribute, err = step.parseAttribute(ctx, *attribute); err != nil {
+			return err
+		}
+		if *attribute, err = ExpandEnvVars(ctx, *attribute); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// items returns the items to run the command of the step for
+func (f *Foreach) items(step *Step) ([]string, error) {
+	switch {
+	case f.Glob != "":
+		pattern := f.Glob
+		if !filepath.IsAbs(pattern) && step.Workdir != "" {
+			pattern = filepath.Join(step.Workdir, pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if filepath.IsAbs(f.Glob) || step.Workdir == "" {
+			return matches, nil
+		}
+
+		// items should be relative to the work directory like the pattern
+		items := make([]string, 0, len(matches))
+		for _, match := range matches {
+			item, err := filepath.Rel(step.Workdir, match)
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, item)
+		}
+
+		return items, nil
+	case f.Lines != "":
+		var items []string
+		for _, line := range strings.Split(f.Lines, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				items = append(items, line)
+			}
+		}
+
+		return items, nil
+	default:
+		return f.Items, nil
+	}
+}
+
+// runForeach runs the command of the step once for each item, one after the
+// other, and stops at the first one that fails
+func (s *Step) runForeach(ctx context.Context) error {
+	items, err := s.Foreach.items(s)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		s.logger.WithField(FldStep, s.Name).Info("No items to run for")
+		return nil
+	}
+
+	for idx, item := range items {
+		instance := *s
+		instance.item = item
+		instance.Name = fmt.Sprintf("%s[%d]", s.Name, idx+1)
+		// the command was rendered without the item when the step was enriched
+		if len(s.foreachExec) != 0 {
+			exec, err := instance.renderExec(ctx, s.foreachExec)
+			if err != nil {
+				return err
+			}
+			instance.setExec(exec)
+		} else {
+			if instance.Command, err = instance.parseAttribute(ctx, s.foreachCommand); err != nil {
+				return err
+			}
+			if instance.Command, err = ExpandEnvVars(ctx, instance.Command); err != nil {
+				return err
+			}
+		}
+
+		spinner, err := NewSpinnerForStep(ctx, instance)
+		if err != nil {
+			return err
+		}
+
+		if err = spinner.Run(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"gopkg.in/yaml.v2"
+)
+
+// GeneratedPayload is the data of the run.generated event of a step that
+// added steps to the workflow
+type GeneratedPayload struct {
+	// Steps are the names of the steps added
+	Steps []string `json:"steps"`
+}
+
+func (s *Step) validateGenerate() error {
+	if s.Type == StepTypeWorkflow || s.Type == StepTypeQuorum {
+		return fmt.Errorf("%s steps can't generate steps", s.Type)
+	}
+	if s.Foreach != nil || s.Agent != nil || s.StopStep != "" || s.runsInBackground() {
+		return fmt.Errorf("steps generating steps can't have foreach, an agent or stop_step, or run in the background")
+	}
+
+	return nil
+}
+
+// parseGeneratedSteps parses the steps printed by a generate step: a list of
+// steps, or a document with them under steps, in YAML or JSON. Unknown
+// attributes are reported as errors
+func parseGeneratedSteps(output string) ([]*Step, error) {
+	if strings.TrimSpace(output) == "" {
+		return nil, nil
+	}
+
+	var document interface{}
+	if err := yaml.Unmarshal([]byte(output), &document); err != nil {
+		return nil, err
+	}
+
+	var steps []*Step
+	var err error
+	switch document.(type) {
+	case []interface{}:
+		err = yaml.UnmarshalStrict([]byte(output), &steps)
+	case map[interface{}]interface{}:
+		generated := struct {
+			Steps []*Step `yaml:"steps"`
+		}{}
+		err = yaml.UnmarshalStrict([]byte(output), &generated)
+		steps = generated.Steps
+	default:
+		return nil, fmt.Errorf("not a list of steps")
+	}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		// report all errors at once
+		var errors error
+		for _, message := range typeErr.Errors {
+			errors = multierror.Append(errors, fmt.Errorf("%s", message))
+		}
+
+		return nil, validationError(errors)
+	}
+
+	return steps, err
+}
+
+// generateSteps adds the steps printed by the step to the workflow
+func (s *Step) generateSteps(ctx context.Context, spinner *Spinner, output string) error {
+	steps, err := parseGeneratedSteps(output)
+	if err == nil && len(steps) == 0 {
+		s.logger.WithField(FldStep, s.Name).Info("Generated no steps")
+		return nil
+	}
+
+	var names []string
+	if err == nil {
+		names, err = s.workflow.addSteps(steps, s)
+	}
+	if err != nil {
+		// the errors are listed with the error of the step
+		s.logger.WithField(FldStep, s.Name).Error("Generated invalid steps")
+		return err
+	}
+
+	spinner.push(ctx, NewEvent(spinner, EventRunGenerated, &GeneratedPayload{Steps: names}))
+
+	return nil
+}
+
+// AddSteps adds steps to the workflow while it runs, for workflows whose
+// steps depend on what's found as they run. The steps are checked like the
+// ones of the workflow file: they can depend on the steps of the workflow
+// and on each other, use its templates and have a matrix. The steps already
+// in the workflow can't depend on them
+func (w *Workflow) AddSteps(ctx context.Context, steps []*Step) error {
+	_, err := w.addSteps(steps, nil)
+
+	return err
+}
+
+// addSteps checks the steps and adds them to the workflow under the lock of
+// the dispatcher, so it picks them up. If they were generated by a step, they
+// are in its stage, and the steps depending on it wait for them too. It
+// returns the names of the steps added
+func (w *Workflow) addSteps(steps []*Step, generator *Step) ([]string, error) {
+	// templates and matrices are applied like they are for the workflow file
+	generated := &Workflow{Templates: w.Templates, Steps: steps}
+	if err := generated.applyTemplates(); err != nil {
+		return nil, err
+	}
+	if err := generated.expandMatrices(); err != nil {
+		return nil, err
+	}
+	steps = generated.Steps
+
+	w.signal.Lock()
+	defer w.signal.Unlock()
+
+	// the steps are checked as part of the workflow without changing it
+	candidate := *w
+	candidate.Steps = append(append([]*Step{}, w.Steps...), steps...)
+	for _, step := range steps {
+		step.workflow = w
+		step.dependsOn = nil
+		for _, priorStepName := range step.DependsOn.Names() {
+			if priorStep := candidate.findStepByName(priorStepName); priorStep != nil {
+				step.dependsOn = append(step.dependsOn, priorStep)
+			}
+		}
+		if generator != nil {
+			step.stage = generator.stage
+			step.include = generator.include
+		}
+	}
+
+	names := make(map[string]bool, len(candidate.Steps)+len(w.Cleanup))
+	for _, step := range w.allSteps() {
+		names[step.Name] = true
+	}
+
+	var errors error
+	for idx, step := range steps {
+		stepID := fmt.Sprintf("generated step %d (%s)", idx+1, step.Name)
+		if err := candidate.validateStep(stepID, step, names); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+	if err := candidate.validateLocks(); err != nil {
+		errors = multierror.Append(errors, err)
+	}
+	if err := candidate.validateGlobalLocks(); err != nil {
+		errors = multierror.Append(errors, err)
+	}
+	if errors != nil {
+		return nil, validationError(errors)
+	}
+	for _, step := range steps {
+		if err := w.setupStepLogger(step); err != nil {
+			return nil, err
+		}
+	}
+
+	// the steps depending on the generator can't run before the steps it
+	// generated. they are put back if that makes a cycle
+	previous := make(map[*Step][]*Step)
+	if generator != nil {
+		for _, step := range w.Steps {
+			for _, priorStep := range step.dependsOn {
+				if priorStep == generator {
+					previous[step] = step.dependsOn
+					step.dependsOn = append(append([]*Step{}, step.dependsOn...), steps...)
+					break
+				}
+			}
+		}
+	}
+	if cycle := candidate.findCycle(); cycle != nil {
+		for step, dependsOn := range previous {
+			step.dependsOn = dependsOn
+		}
+
+		return nil, cycleError(cycle)
+	}
+
+	added := make([]string, 0, len(steps))
+	for _, step := range steps {
+		w.setupStepLocks(step)
+		added = append(added, step.Name)
+	}
+
+	w.Steps = candidate.Steps
+	w.markQuorums()
+	w.order = w.scheduleOrder()
+	w.dispatch.Broadcast()
+
+	return added, nil
+}
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// GraphDOT is the Graphviz DOT format
+	GraphDOT = "dot"
+	// GraphMermaid is the Mermaid flowchart format
+	GraphMermaid = "mermaid"
+)
+
+var graphColors = map[string]string{
+	ResultSuccess:   "#2da44e",
+	ResultFailed:    "#cf222e",
+	ResultSkipped:   "#9a6700",
+	ResultCached:    "#8250df",
+	ResultDisabled:  "#6e7781",
+	ResultNotRun:    "#6e7781",
+	ResultCancelled: "#bc4c00",
+	ResultRunning:   "#0969da",
+	ResultDegraded:  "#bf8700",
+}
+
+// ExportGraph returns the dependency graph of the steps in the given format.
+// If a result is given, the steps are annotated with their status and
+// duration in it
+func (w *Workflow) ExportGraph(format string, result *WorkflowResult) (string, error) {
+	switch format {
+	case GraphDOT:
+		return w.dotGraph(result), nil
+	case GraphMermaid:
+		return w.mermaidGraph(result), nil
+	default:
+		return "", fmt.Errorf("invalid graph format %s. Valid values are dot and mermaid", format)
+	}
+}
+
+// graphLabel returns the lines of the label of the step and its status if
+// there is one
+func graphLabel(step *Step, result *WorkflowResult) ([]string, string) {
+	lines := []string{step.Name}
+	if result == nil {
+		return lines, ""
+	}
+
+	stepResult := result.Step(step.Name)
+	if stepResult == nil {
+		return lines, ""
+	}
+
+	status := stepResult.Status
+	if stepResult.Duration != 0 {
+		status = fmt.Sprintf("%s %s", status, stepResult.Duration.Round(time.Millisecond))
+	}
+
+	return append(lines, status), stepResult.Status
+}
+
+func (w *Workflow) dotGraph(result *WorkflowResult) string {
+	quote := func(value string) string {
+		return fmt.Sprintf("\"%s\"", strings.Replace(value, "\"", "\\\"", -1))
+	}
+
+	buf := &strings.Builder{}
+	fmt.Fprintln(buf, "digraph trackman {")
+	fmt.Fprintln(buf, "  rankdir=LR;")
+	fmt.Fprintln(buf, "  node [shape=box, style=rounded];")
+
+	// steps of the same stage are drawn together
+	var stages []string
+	byStage := make(map[string][]*Step)
+	for _, step := range w.Steps {
+		if _, ok := byStage[step.stage]; !ok {
+			stages = append(stages, step.stage)
+		}
+		byStage[step.stage] = append(byStage[step.stage], step)
+	}
+
+	for idx, stage := range stages {
+		indent := "  "
+		if stage != "" {
+			fmt.Fprintf(buf, "  subgraph cluster_%d {\n    label=%s;\n", idx, quote(stage))
+			indent = "    "
+		}
+
+		for _, step := range byStage[stage] {
+			lines, status := graphLabel(step, result)
+			for kdx := range lines {
+				lines[kdx] = strings.Replace(lines[kdx], "\"", "\\\"", -1)
+			}
+
+			attributes := fmt.Sprintf("label=\"%s\"", strings.Join(lines, "\\n"))
+			if color, ok := graphColors[status]; ok {
+				attributes = fmt.Sprintf("%s, color=%s", attributes, quote(color))
+			}
+			if step.Disabled {
+				attributes += ", style=\"rounded,dashed\""
+			}
+
+			fmt.Fprintf(buf, "%s%s [%s];\n", indent, quote(step.Name), attributes)
+		}
+
+		if stage != "" {
+			fmt.Fprintln(buf, "  }")
+		}
+	}
+
+	for _, step := range w.Steps {
+		for _, priorStep := range step.dependsOn {
+			// dependencies on a status are labelled with it
+			if dependency := step.DependsOn.find(priorStep.Name); dependency != nil && dependency.Status != "" {
+				fmt.Fprintf(buf, "  %s -> %s [label=%s, style=dashed];\n", quote(priorStep.Name), quote(step.Name), quote(dependency.Status))
+				continue
+			}
+
+			fmt.Fprintf(buf, "  %s -> %s;\n", quote(priorStep.Name), quote(step.Name))
+		}
+	}
+
+	fmt.Fprintln(buf, "}")
+
+	return buf.String()
+}
+
+func (w *Workflow) mermaidGraph(result *WorkflowResult) string {
+	// step names can have characters mermaid doesn't allow in ids
+	ids := make(map[*Step]string, len(w.Steps))
+	for idx, step := range w.Steps {
+		ids[step] = fmt.Sprintf("step%d", idx+1)
+	}
+
+	buf := &strings.Builder{}
+	fmt.Fprintln(buf, "flowchart LR")
+
+	statuses := make(map[string][]string)
+	for _, step := range w.Steps {
+		lines, status := graphLabel(step, result)
+		for kdx := range lines {
+			lines[kdx] = strings.Replace(lines[kdx], "\"", "#quot;", -1)
+		}
+
+		fmt.Fprintf(buf, "  %s[\"%s\"]\n", ids[step], strings.Join(lines, "<br/>"))
+		if status != "" {
+			statuses[status] = append(statuses[status], ids[step])
+		}
+	}
+
+	for _, step := range w.Steps {
+		for _, priorStep := range step.dependsOn {
+			if dependency := step.DependsOn.find(priorStep.Name); dependency != nil && dependency.Status != "" {
+				fmt.Fprintf(buf, "  %s -.->|%s| %s\n", ids[priorStep], dependency.Status, ids[step])
+				continue
+			}
+
+			fmt.Fprintf(buf, "  %s --> %s\n", ids[priorStep], ids[step])
+		}
+	}
+
+	for _, status := range []string{ResultSuccess, ResultFailed, ResultSkipped, ResultCached, ResultDisabled, ResultNotRun, ResultRunning, ResultCancelled, ResultDegraded} {
+		if len(statuses[status]) == 0 {
+			continue
+		}
+
+		class := strings.Replace(status, "_", "", -1)
+		fmt.Fprintf(buf, "  classDef %s stroke:%s,stroke-width:2px\n", class, graphColors[status])
+		fmt.Fprintf(buf, "  class %s %s\n", strings.Join(statuses[status], ","), class)
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// HookPreRun hooks run before the command
+	HookPreRun = "pre_run"
+	// HookPostRun hooks run after the command succeeds
+	HookPostRun = "post_run"
+	// HookOnFailure hooks run after the command fails
+	HookOnFailure = "on_failure"
+)
+
+// Hook is a command that runs around a step or the workflow
+type Hook struct {
+	Command string         `yaml:"command" json:"command"`
+	Workdir string         `yaml:"workdir" json:"workdir"`
+	Timeout *time.Duration `yaml:"timeout" json:"timeout"`
+}
+
+// Hooks are the commands that run before, after or on failure of a step or
+// the workflow
+type Hooks struct {
+	PreRun    []Hook `yaml:"pre_run" json:"pre_run"`
+	PostRun   []Hook `yaml:"post_run" json:"post_run"`
+	OnFailure []Hook `yaml:"on_failure" json:"on_failure"`
+}
+
+func (h *Hooks) byKind(kind string) []Hook {
+	if h == nil {
+		return nil
+	}
+
+	switch kind {
+	case HookPreRun:
+		return h.PreRun
+	case HookPostRun:
+		return h.PostRun
+	case HookOnFailure:
+		return h.OnFailure
+	default:
+		return nil
+	}
+}
+
+func (h *Hooks) validate() error {
+	if h == nil {
+		return nil
+	}
+
+	for _, kind := range []string{HookPreRun, HookPostRun, HookOnFailure} {
+		for _, hook := range h.byKind(kind) {
+			if strings.TrimSpace(hook.Command) == "" {
+				return fmt.Errorf("has a %s hook with no command", kind)
+			}
+		}
+	}
+
+	return nil
+}
+
+// enrich renders the hooks with the given parser and expands the environment
+// variables in them
+func (h *Hooks) enrich(ctx context.Context, parse func(context.Context, string) (string, error)) error {
+	if h == nil {
+		return nil
+	}
+
+	var err error
+	for _, hooks := range [][]Hook{h.PreRun, h.PostRun, h.OnFailure} {
+		for idx := range hooks {
+			for _, attribute := range []*string{&hooks[idx].Command, &hooks[idx].Workdir} {
+				if *attribute, err = parse(ctx, *attribute); err != nil {
+					return err
+				}
+				if *attribute, err = ExpandEnvVars(ctx, *attribute); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// runHooks runs the hooks of the given kind one after the other, stopping at
+// the first one that fails
+func (s *Step) runHooks(ctx context.Context, hooks *Hooks, kind string) error {
+	for idx := range hooks.byKind(kind) {
+		spinner, err := NewSpinnerForHook(ctx, *s, &hooks.byKind(kind)[idx], kind)
+		if err != nil {
+			return err
+		}
+
+		if err = spinner.Run(ctx); err != nil {
+			return fmt.Errorf("%s hook failed: %s", kind, err)
+		}
+	}
+
+	return nil
+}
+
+// runCompletionHooks runs the post_run or on_failure hooks of the step. Their
+// errors are logged but don't change the outcome of the step
+func (s *Step) runCompletionHooks(ctx context.Context, err error) {
+	switch {
+	case s.Hooks == nil, s.status == StepRetrying, s.Disabled, s.skipped, s.cached:
+		return
+	case err != nil || s.err != nil:
+		// failure hooks can clean up after a cancelled step
+		err = s.runHooks(context.WithoutCancel(ctx), s.Hooks, HookOnFailure)
+	default:
+		err = s.runHooks(ctx, s.Hooks, HookPostRun)
+	}
+
+	if err != nil {
+		s.logger.WithField(FldStep, s.Name).Error(err)
+	}
+}
+
+// hookStep is the step workflow hooks run as
+func (w *Workflow) hookStep() *Step {
+	return &Step{
+		Name:     "workflow",
+		workflow: w,
+		logger:   w.logger,
+	}
+}
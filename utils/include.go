@@ -0,0 +1,304 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// maxIncludeDepth stops includes going too deep
+	maxIncludeDepth = 10
+	includeTimeout  = 30 * time.Second
+)
+
+// Include imports the steps of another workflow file
+type Include struct {
+	// File is the path or the http(s) URL of the workflow file. Relative
+	// paths are relative to the file including it
+	File string `yaml:"file" json:"file"`
+	// As is prefixed to the names of the included steps. Defaults to the
+	// name of the file without its extension
+	As string `yaml:"as" json:"as"`
+	// Variables override the variables of the included workflow
+	Variables map[string]string `yaml:"variables" json:"variables"`
+	// Checksum is the SHA-256 of the file in hex. Remote files need one
+	// unless their signatures are verified
+	Checksum string `yaml:"checksum" json:"checksum"`
+
+	parent *Include
+	// defaults are the variables defined in the included workflow
+	defaults map[string]string
+	// resolved are the variables the included steps use
+	resolved map[string]string
+}
+
+// includeSource is where a workflow file was read from
+type includeSource struct {
+	dir string
+	url *url.URL
+	// remote configures how remote files are fetched
+	remote *RemoteOptions
+	// verifier checks the signatures of the files if set
+	verifier SignatureVerifier
+}
+
+// location returns where the file of the include should be read from
+func (s *includeSource) location(file string) (string, *includeSource, error) {
+	if parsed, err := url.Parse(file); err == nil && isRemote(parsed) {
+		return parsed.String(), &includeSource{url: parsed, remote: s.remote, verifier: s.verifier}, nil
+	}
+
+	if s.url != nil {
+		if filepath.IsAbs(file) {
+			return "", nil, fmt.Errorf("remote workflow %s can't include local file %s", s.url, file)
+		}
+
+		resolved := s.url.ResolveReference(&url.URL{Path: file})
+		return resolved.String(), &includeSource{url: resolved, remote: s.remote, verifier: s.verifier}, nil
+	}
+
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(s.dir, file)
+	}
+
+	return file, &includeSource{dir: filepath.Dir(file), remote: s.remote, verifier: s.verifier}, nil
+}
+
+func (s *includeSource) read(location string) ([]byte, error) {
+	if s.url == nil {
+		return ioutil.ReadFile(location)
+	}
+
+	remote := s.remote
+	if remote == nil {
+		remote = &RemoteOptions{}
+	}
+
+	buff, err := fetchURL(context.Background(), s.url, remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %s", location, err)
+	}
+
+	return buff, nil
+}
+
+// verify checks the signature next to the included file
+func (s *includeSource) verify(location string, buff []byte) error {
+	signature, err := s.signature(location, s.verifier.Extension())
+	if err != nil {
+		return fmt.Errorf("include %s isn't signed: %s", location, err)
+	}
+
+	return verifySignature(s.verifier, fmt.Sprintf("include %s", location), buff, signature)
+}
+
+// signature reads the detached signature next to the file at the location
+func (s *includeSource) signature(location string, extension string) ([]byte, error) {
+	if s.url == nil {
+		return ioutil.ReadFile(location + extension)
+	}
+
+	// the signature of a url with a query is next to its path
+	signatureURL := *s.url
+	signatureURL.Path += extension
+	signatureURL.RawPath = ""
+
+	return (&includeSource{url: &signatureURL, remote: s.remote}).read(signatureURL.String())
+}
+
+// name is the default namespace of the included steps
+func (i *Include) name(location string) string {
+	if i.As != "" {
+		return i.As
+	}
+
+	base := path.Base(filepath.ToSlash(location))
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// resolveIncludes adds the steps of the included workflow files to the
+// workflow. Included steps come before the steps of the workflow, with their
+// names prefixed with the namespace of the include
+func (w *Workflow) resolveIncludes(source *includeSource) error {
+	if len(w.Include) == 0 {
+		return nil
+	}
+
+	steps, err := w.includeSteps(source, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	w.Steps = append(steps, w.Steps...)
+
+	return nil
+}
+
+// includeSteps returns the steps of the includes of the workflow. parents
+// are the locations of the files including this one, to find cycles
+func (w *Workflow) includeSteps(source *includeSource, parent *Include, parents []string) ([]*Step, error) {
+	if len(parents) >= maxIncludeDepth {
+		return nil, fmt.Errorf("includes are nested more than %d levels deep", maxIncludeDepth)
+	}
+
+	var steps []*Step
+	for idx, include := range w.Include {
+		if strings.TrimSpace(include.File) == "" {
+			return nil, fmt.Errorf("include %d has no file", idx+1)
+		}
+
+		location, includedSource, err := source.location(include.File)
+		if err != nil {
+			return nil, err
+		}
+		if contains(parents, location) {
+			return nil, fmt.Errorf("circular include of %s", location)
+		}
+
+		// remote files can change under the workflow, so their content is
+		// pinned with a checksum or a signature
+		if includedSource.url != nil && include.Checksum == "" && includedSource.verifier == nil {
+			return nil, fmt.Errorf("remote include %s needs a checksum or a signature", location)
+		}
+
+		buff, err := includedSource.read(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to include %s: %s", include.File, err)
+		}
+		if include.Checksum != "" {
+			checksum := strings.TrimPrefix(strings.ToLower(include.Checksum), "sha256:")
+			if actual := hashHex(buff); actual != checksum {
+				return nil, fmt.Errorf("checksum of include %s is %s instead of %s", location, actual, checksum)
+			}
+		}
+		if includedSource.verifier != nil {
+			if err = includedSource.verify(location, buff); err != nil {
+				return nil, err
+			}
+		}
+
+		var included *Workflow
+		if err = yaml.UnmarshalStrict(buff, &included); err != nil {
+			return nil, fmt.Errorf("failed to include %s: %s", include.File, err)
+		}
+		if included == nil {
+			continue
+		}
+		// only the steps of an included workflow are used
+		if len(included.Cleanup) != 0 || included.Hooks != nil {
+			return nil, fmt.Errorf("failed to include %s: included workflows can't have cleanup steps or hooks", include.File)
+		}
+		if included.Version == "2" {
+			if err = included.flattenStages(); err != nil {
+				return nil, fmt.Errorf("failed to include %s: %s", include.File, err)
+			}
+		}
+		if err = included.applyTemplates(); err != nil {
+			return nil, fmt.Errorf("failed to include %s: %s", include.File, err)
+		}
+		if err = included.expandMatrices(); err != nil {
+			return nil, fmt.Errorf("failed to include %s: %s", include.File, err)
+		}
+
+		include.parent = parent
+		include.defaults = included.Variables
+		w.includes = append(w.includes, include)
+
+		// includes of the included file come first so its steps can depend on them
+		nested, err := included.includeSteps(includedSource, include, append(parents, location))
+		if err != nil {
+			return nil, err
+		}
+		w.includes = append(w.includes, included.includes...)
+		// the output of the included steps is redacted like the rest
+		w.Redact = append(w.Redact, included.Redact...)
+
+		namespace := include.name(location)
+		for _, step := range append(nested, included.Steps...) {
+			step.Name = fmt.Sprintf("%s.%s", namespace, step.Name)
+			for _, dependency := range step.DependsOn {
+				dependency.Step = fmt.Sprintf("%s.%s", namespace, dependency.Step)
+			}
+			for kdx, priorStepName := range step.NeedsArtifacts {
+				step.NeedsArtifacts[kdx] = fmt.Sprintf("%s.%s", namespace, priorStepName)
+			}
+			if step.stage != "" {
+				step.stage = fmt.Sprintf("%s.%s", namespace, step.stage)
+			}
+		}
+		// the nested steps already have the attributes of their workflow
+		for _, step := range included.Steps {
+			step.include = include
+			step.Env = append(append([]string{}, included.Env...), step.Env...)
+			if step.Shell == "" {
+				step.Shell = included.Shell
+			}
+			for key, value := range included.Metadata {
+				if _, ok := step.Metadata[key]; ok {
+					continue
+				}
+				if step.Metadata == nil {
+					step.Metadata = make(map[string]string)
+				}
+				step.Metadata[key] = value
+			}
+		}
+
+		steps = append(steps, nested...)
+		steps = append(steps, included.Steps...)
+	}
+
+	return steps, nil
+}
+
+// enrichIncludes works out the variables of the included steps. The
+// variables passed to an include can use the variables of the file
+// including it
+func (w *Workflow) enrichIncludes(ctx context.Context) error {
+	for _, include := range w.includes {
+		resolved := make(map[string]string, len(include.defaults)+len(include.Variables))
+		for key, value := range include.defaults {
+			expanded, err := ExpandEnvVars(ctx, value)
+			if err != nil {
+				return err
+			}
+
+			resolved[key] = expanded
+		}
+
+		data := &includeData{Var: w.Variables, Metadata: w.Metadata}
+		if include.parent != nil {
+			data.Var = include.parent.resolved
+		}
+
+		for key, value := range include.Variables {
+			rendered, err := renderTemplate("include", value, data, w.TextTemplates)
+			if err != nil {
+				return err
+			}
+			if rendered, err = ExpandEnvVars(ctx, rendered); err != nil {
+				return err
+			}
+
+			resolved[key] = rendered
+		}
+
+		include.resolved = resolved
+	}
+
+	return nil
+}
+
+// includeData is what the variables passed to an include are rendered with
+type includeData struct {
+	Var      map[string]string
+	Metadata map[string]string
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const includedWorkflow = `
+version: 1
+steps:
+  - name: push
+    command: echo push
+`
+
+func loadIncluding(t *testing.T, files map[string]string) (*Workflow, error) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "trackman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	options := &WorkflowOptions{Timeout: 10 * time.Second}
+	return LoadWorkflowFromFile(context.Background(), options, filepath.Join(dir, "workflow.yml"))
+}
+
+func TestRemoteIncludeChecksum(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, includedWorkflow)
+	}))
+	defer server.Close()
+
+	sum := sha256.Sum256([]byte(includedWorkflow))
+	tests := []struct {
+		checksum string
+		err      string
+	}{
+		{checksum: "", err: "needs a checksum or a signature"},
+		{checksum: strings.Repeat("0", 64), err: "checksum of include"},
+		{checksum: hex.EncodeToString(sum[:])},
+		{checksum: "sha256:" + strings.ToUpper(hex.EncodeToString(sum[:]))},
+	}
+
+	for _, test := range tests {
+		w, err := loadIncluding(t, map[string]string{
+			"workflow.yml": fmt.Sprintf(`
+version: 1
+include:
+  - file: %s/deploy.yml
+    checksum: "%s"
+steps:
+  - name: migrate
+    command: echo migrate
+`, server.URL, test.checksum),
+		})
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("checksum %q failed with %v, want %s", test.checksum, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("checksum %q failed: %s", test.checksum, err)
+			continue
+		}
+		if len(w.Steps) != 2 || w.Steps[0].Name != "deploy.push" {
+			t.Errorf("checksum %q included %d steps", test.checksum, len(w.Steps))
+		}
+	}
+}
+
+func TestIncludeWithCleanupOrHooks(t *testing.T) {
+	for _, section := range []string{
+		"cleanup:\n  - name: drop\n    command: echo drop\n",
+		"hooks:\n  on_failure:\n    - command: echo failed\n",
+	} {
+		_, err := loadIncluding(t, map[string]string{
+			"deploy.yml": includedWorkflow + section,
+			"workflow.yml": `
+version: 1
+include:
+  - file: deploy.yml
+steps:
+  - name: migrate
+    command: echo migrate
+`,
+		})
+		if err == nil || !strings.Contains(err.Error(), "can't have cleanup steps or hooks") {
+			t.Errorf("including a workflow with %q failed with %v", section, err)
+		}
+	}
+}
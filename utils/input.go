@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Input is what the command of a step reads from its stdin. Only one of its
+// attributes can be set
+type Input struct {
+	// Text is given to the command as is
+	Text string `yaml:"text" json:"text"`
+	// File is read, relative to the work directory of the step
+	File string `yaml:"file" json:"file"`
+	// Output is an output of a step this one depends on, as step.output
+	Output string `yaml:"output" json:"output"`
+}
+
+func (i *Input) validate() error {
+	set := 0
+	for _, value := range []string{i.Text, i.File, i.Output} {
+		if value != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("input needs one of text, file or output")
+	}
+
+	if i.Output != "" {
+		if _, _, err := i.outputName(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *Input) enrich(ctx context.Context, step *Step) error {
+	var err error
+
+	if i.Text, err = step.parseAttribute(ctx, i.Text); err != nil {
+		return err
+	}
+	if i.File, err = step.parseAttribute(ctx, i.File); err != nil {
+		return err
+	}
+	if i.File, err = ExpandEnvVars(ctx, i.File); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// outputName returns the step and the name of the output used as input
+func (i *Input) outputName() (string, string, error) {
+	dot := strings.LastIndex(i.Output, ".")
+	if dot <= 0 || dot == len(i.Output)-1 {
+		return "", "", fmt.Errorf("invalid input output %s. Use step.output", i.Output)
+	}
+
+	return i.Output[:dot], i.Output[dot+1:], nil
+}
+
+// open returns the reader of the input of the step
+func (i *Input) open(step *Step) (io.ReadCloser, error) {
+	switch {
+	case i.File != "":
+		file := i.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(step.Workdir, file)
+		}
+
+		return os.Open(file)
+	case i.Output != "":
+		stepName, name, err := i.outputName()
+		if err != nil {
+			return nil, err
+		}
+
+		value, ok := step.workflow.Outputs()[stepName][name]
+		if !ok {
+			return nil, fmt.Errorf("no output %s for the input of %s", i.Output, step.Name)
+		}
+
+		return ioutil.NopCloser(strings.NewReader(value)), nil
+	default:
+		return ioutil.NopCloser(strings.NewReader(i.Text)), nil
+	}
+}
@@ -3,7 +3,6 @@ package utils
 import (
 	"bufio"
 	"fmt"
-	"log"
 	"os"
 	"strings"
 )
@@ -16,7 +15,8 @@ func confirm(s string, tries int) bool {
 
 		res, err := r.ReadString('\n')
 		if err != nil {
-			log.Fatal(err)
+			// without an answer, like when stdin is closed, it's a no
+			return false
 		}
 
 		// Empty input (i.e. "\n")
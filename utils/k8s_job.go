@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+)
+
+const (
+	// StepTypeK8sJob runs the command of the step as a Kubernetes Job
+	StepTypeK8sJob = "k8s-job"
+
+	envK8sJob = "TRACKMAN_K8S_JOB"
+)
+
+// k8sJobScript creates the job, streams the logs of its pod and waits for it
+// to finish. The job is deleted if it succeeds or the step is stopped, and
+// kept for debugging if it fails
+const k8sJobScript = `job=%s
+kctl() { kubectl %s "$@"; }
+cleanup() { kctl delete job "$job" --ignore-not-found --wait=false >/dev/null 2>&1; }
+trap 'cleanup; exit 143' TERM INT
+printf '%%s' "$%s" | kctl create -f - >/dev/null || exit 1
+kctl logs -f "job/$job" --pod-running-timeout=%s
+while :; do
+  status=$(kctl get job "$job" -o 'jsonpath={.status.succeeded}/{.status.failed}') || exit 1
+  case "$status" in
+    [1-9]*/*) cleanup; exit 0 ;;
+    */[1-9]*) echo "job $job failed" >&2; exit 1 ;;
+  esac
+  sleep 2
+done
+`
+
+// K8sJobOptions configures the Kubernetes Job a k8s-job step runs as
+type K8sJobOptions struct {
+	// Context is the kubectl context to use. Defaults to the current one
+	Context   string `yaml:"context" json:"context"`
+	Namespace string `yaml:"namespace" json:"namespace"`
+	// Image runs the command of the step in a container of this image
+	Image string `yaml:"image" json:"image"`
+	// PodSpec is used as the spec of the pod instead of Image and the command
+	PodSpec map[string]interface{} `yaml:"pod_spec" json:"pod_spec"`
+}
+
+func (k *K8sJobOptions) validate(command string) error {
+	if k == nil || (strings.TrimSpace(k.Image) == "" && k.PodSpec == nil) {
+		return fmt.Errorf("k8s-job steps need an image or a pod_spec")
+	}
+	if k.Image != "" && k.PodSpec != nil {
+		return fmt.Errorf("k8s-job steps can't have both an image and a pod_spec")
+	}
+	if k.Image != "" && strings.TrimSpace(command) == "" {
+		return fmt.Errorf("k8s-job steps with an image need a command")
+	}
+
+	return nil
+}
+
+func (k *K8sJobOptions) enrich(ctx context.Context, step *Step) error {
+	var err error
+
+	for _, attribute := range []*string{&k.Context, &k.Namespace, &k.Image} {
+		if *attribute, err = step.parseAttribute(ctx, *attribute); err != nil {
+			return err
+		}
+		if *attribute, err = ExpandEnvVars(ctx, *attribute); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// manifest returns the Job as JSON
+func (k *K8sJobOptions) manifest(name string, step *Step, parts []string) (string, error) {
+	var podSpec map[string]interface{}
+	if k.PodSpec != nil {
+		podSpec = jsonCompatible(k.PodSpec).(map[string]interface{})
+	} else {
+		var env []map[string]string
+		for _, item := range step.MergedEnv() {
+			pair := strings.SplitN(item, "=", 2)
+			if len(pair) != 2 {
+				continue
+			}
+
+			env = append(env, map[string]string{"name": pair[0], "value": pair[1]})
+		}
+
+		podSpec = map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{
+					"name":    "step",
+					"image":   k.Image,
+					"command": parts,
+					"env":     env,
+				},
+			},
+		}
+	}
+
+	if _, ok := podSpec["restartPolicy"]; !ok {
+		podSpec["restartPolicy"] = "Never"
+	}
+
+	job := map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata": map[string]interface{}{
+			"name": name,
+			"labels": map[string]string{
+				"app.kubernetes.io/managed-by": "trackman",
+				"trackman/session-id":          step.workflow.sessionID,
+			},
+		},
+		"spec": map[string]interface{}{
+			// retries are left to the retry policy of the step
+			"backoffLimit": 0,
+			"template": map[string]interface{}{
+				"spec": podSpec,
+			},
+		},
+	}
+
+	buf, err := json.Marshal(job)
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+// commandParts returns the command that runs the job, and the environment
+// variable holding its manifest
+func (k *K8sJobOptions) commandParts(name string, step *Step, timeout time.Duration) ([]string, string, error) {
+	var parts []string
+	if k.PodSpec == nil {
+		var err error
+		if parts, err = step.stepCommandParts(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	manifest, err := k.manifest(name, step, parts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var flags []string
+	if k.Context != "" {
+		flags = append(flags, "--context", k.Context)
+	}
+	if k.Namespace != "" {
+		flags = append(flags, "--namespace", k.Namespace)
+	}
+
+	script := fmt.Sprintf(k8sJobScript, name, shellquote.Join(flags...), envK8sJob, timeout)
+
+	return []string{defaultShell, "-c", script}, fmt.Sprintf("%s=%s", envK8sJob, manifest), nil
+}
+
+// jsonCompatible converts the maps read from YAML to ones that can be
+// marshalled as JSON
+func jsonCompatible(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			converted[fmt.Sprint(key)] = jsonCompatible(item)
+		}
+
+		return converted
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			converted[key] = jsonCompatible(item)
+		}
+
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(typed))
+		for idx, item := range typed {
+			converted[idx] = jsonCompatible(item)
+		}
+
+		return converted
+	default:
+		return value
+	}
+}
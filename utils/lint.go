@@ -0,0 +1,327 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// defaultLongRunning is how long a step has to take to be long-running
+const defaultLongRunning = time.Minute
+
+// LintRule is a check of the workflow for things that are valid but are
+// likely to be mistakes
+type LintRule struct {
+	// ID is the name of the rule used to enable or disable it
+	ID string
+	// Description says what the rule checks
+	Description string
+	// Severity is the default severity of the findings of the rule
+	Severity string
+
+	check func(w *Workflow, options *LintOptions) []*LintFinding
+}
+
+// LintFinding is something a rule found in the workflow
+type LintFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	// Step is the name of the step the finding is about, if any
+	Step    string `json:"step,omitempty"`
+	Message string `json:"message"`
+}
+
+// String returns the finding as it's printed
+func (f *LintFinding) String() string {
+	if f.Step == "" {
+		return fmt.Sprintf("%s: %s (%s)", f.Severity, f.Message, f.Rule)
+	}
+
+	return fmt.Sprintf("%s: %s: %s (%s)", f.Severity, f.Step, f.Message, f.Rule)
+}
+
+// LintOptions are the options of LintWorkflow
+type LintOptions struct {
+	// Disable are the IDs of the rules not to run
+	Disable []string
+	// Enable are the IDs of the only rules to run. All rules run if empty
+	Enable []string
+	// Severities overrides the severities of the rules by ID
+	Severities map[string]string
+	// LongRunning is how long a step has to take to need a timeout.
+	// Defaults to a minute
+	LongRunning time.Duration
+	// Durations are how long the steps took before by name, like in the
+	// last run of the history
+	Durations map[string]time.Duration
+}
+
+// LintRules returns all the rules of the linter
+func LintRules() []*LintRule {
+	return []*LintRule{
+		{
+			ID:          "dead-end",
+			Description: "step with no dependents and no outputs",
+			Severity:    SeverityInfo,
+			check:       lintDeadEnds,
+		},
+		{
+			ID:          "unused-variable",
+			Description: "variable defined but never used",
+			Severity:    SeverityWarning,
+			check:       lintUnusedVariables,
+		},
+		{
+			ID:          "duplicate-command",
+			Description: "steps running the same command",
+			Severity:    SeverityWarning,
+			check:       lintDuplicateCommands,
+		},
+		{
+			ID:          "missing-timeout",
+			Description: "long-running step without a timeout",
+			Severity:    SeverityWarning,
+			check:       lintMissingTimeouts,
+		},
+	}
+}
+
+func (o *LintOptions) validate(rules []*LintRule) error {
+	known := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		known[rule.ID] = true
+	}
+
+	for _, ids := range [][]string{o.Disable, o.Enable} {
+		for _, id := range ids {
+			if !known[id] {
+				return fmt.Errorf("unknown lint rule %s", id)
+			}
+		}
+	}
+
+	for id, severity := range o.Severities {
+		if !known[id] {
+			return fmt.Errorf("unknown lint rule %s", id)
+		}
+		if severityLevel(severity) < 0 {
+			return fmt.Errorf("invalid severity %s for %s. Valid values are %s", severity, id, strings.Join(severities, ", "))
+		}
+	}
+
+	return nil
+}
+
+// enabled returns true if the rule should run
+func (o *LintOptions) enabled(rule *LintRule) bool {
+	for _, id := range o.Disable {
+		if id == rule.ID {
+			return false
+		}
+	}
+	if len(o.Enable) == 0 {
+		return true
+	}
+	for _, id := range o.Enable {
+		if id == rule.ID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LintWorkflow runs the enabled rules on the workflow and returns their
+// findings, the most severe first
+func LintWorkflow(w *Workflow, options *LintOptions) ([]*LintFinding, error) {
+	if options == nil {
+		options = &LintOptions{}
+	}
+
+	rules := LintRules()
+	if err := options.validate(rules); err != nil {
+		return nil, err
+	}
+
+	var findings []*LintFinding
+	for _, rule := range rules {
+		if !options.enabled(rule) {
+			continue
+		}
+
+		severity := rule.Severity
+		if override, ok := options.Severities[rule.ID]; ok {
+			severity = override
+		}
+
+		for _, finding := range rule.check(w, options) {
+			finding.Rule = rule.ID
+			finding.Severity = severity
+			findings = append(findings, finding)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityLevel(findings[i].Severity) > severityLevel(findings[j].Severity)
+	})
+
+	return findings, nil
+}
+
+// LintFailed returns true if any of the findings is at least as severe as
+// the given severity
+func LintFailed(findings []*LintFinding, severity string) bool {
+	level := severityLevel(severity)
+	for _, finding := range findings {
+		if severityLevel(finding.Severity) >= level {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lintDeadEnds finds the steps no other step needs and whose work isn't
+// kept as outputs or artifacts
+func lintDeadEnds(w *Workflow, options *LintOptions) []*LintFinding {
+	needed := make(map[string]bool)
+	for _, step := range append(append([]*Step{}, w.Steps...), w.Cleanup...) {
+		for _, names := range [][]string{step.DependsOn.Names(), step.NeedsArtifacts, {step.StopStep}} {
+			for _, name := range names {
+				needed[name] = true
+			}
+		}
+	}
+
+	var findings []*LintFinding
+	for _, step := range w.Steps {
+		if step.Disabled || needed[step.Name] || len(step.OutputDefinitions) != 0 || len(step.Artifacts) != 0 {
+			continue
+		}
+
+		findings = append(findings, &LintFinding{
+			Step:    step.Name,
+			Message: "no step depends on it and it has no outputs",
+		})
+	}
+
+	return findings
+}
+
+// lintUnusedVariables finds the variables of the workflow no attribute uses
+func lintUnusedVariables(w *Workflow, options *LintOptions) []*LintFinding {
+	buff, err := yaml.Marshal(struct {
+		Metadata map[string]string `yaml:"metadata"`
+		Env      []string          `yaml:"env"`
+		Include  []*Include        `yaml:"include"`
+		Steps    []*Step           `yaml:"steps"`
+		Cleanup  []*Step           `yaml:"cleanup"`
+		Hooks    *Hooks            `yaml:"hooks"`
+	}{w.Metadata, w.Env, w.Include, w.Steps, w.Cleanup, w.Hooks})
+	if err != nil {
+		return nil
+	}
+	source := string(buff)
+
+	var findings []*LintFinding
+	for _, name := range sortedKeys(w.Variables) {
+		used := regexp.MustCompile(`\.Var\.` + regexp.QuoteMeta(name) + `\b|\.Var\s+"` + regexp.QuoteMeta(name) + `"`)
+		if used.MatchString(source) {
+			continue
+		}
+
+		findings = append(findings, &LintFinding{
+			Message: fmt.Sprintf("variable %s is never used", name),
+		})
+	}
+
+	return findings
+}
+
+// lintDuplicateCommands finds the steps running the same command as an
+// earlier one. The combinations of a matrix are expected to share theirs
+func lintDuplicateCommands(w *Workflow, options *LintOptions) []*LintFinding {
+	var findings []*LintFinding
+	for _, steps := range [][]*Step{w.Steps, w.Cleanup} {
+		first := make(map[string]*Step)
+		for _, step := range steps {
+			command := strings.Join(strings.Fields(step.Command), " ")
+			if command == "" || step.Type == StepTypeWorkflow {
+				continue
+			}
+
+			prior, ok := first[command]
+			if !ok {
+				first[command] = step
+				continue
+			}
+			if prior.matrix != nil && prior.matrix == step.matrix {
+				continue
+			}
+
+			findings = append(findings, &LintFinding{
+				Step:    step.Name,
+				Message: fmt.Sprintf("runs the same command as %s", prior.Name),
+			})
+		}
+	}
+
+	return findings
+}
+
+// lintMissingTimeouts finds the long-running steps that only have the
+// default timeout. Steps are long-running if they took longer than
+// LongRunning before or run somewhere else, like in a container
+func lintMissingTimeouts(w *Workflow, options *LintOptions) []*LintFinding {
+	if w.Timeout != nil {
+		return nil
+	}
+
+	longRunning := options.LongRunning
+	if longRunning == 0 {
+		longRunning = defaultLongRunning
+	}
+
+	var findings []*LintFinding
+	for _, steps := range [][]*Step{w.Steps, w.Cleanup} {
+		for _, step := range steps {
+			if step.Timeout != nil || step.Deadline != "" || step.runsInBackground() || step.StopStep != "" {
+				continue
+			}
+
+			var reason string
+			if took, ok := options.Durations[step.Name]; ok && took >= longRunning {
+				reason = fmt.Sprintf("took %s before", took.Round(time.Second))
+			} else {
+				switch step.Type {
+				case StepTypeDocker, StepTypeK8sJob, StepTypeSSH, StepTypeWorkflow:
+					reason = fmt.Sprintf("is a %s step", step.Type)
+				}
+			}
+			if reason == "" {
+				continue
+			}
+
+			findings = append(findings, &LintFinding{
+				Step:    step.Name,
+				Message: fmt.Sprintf("has no timeout but %s", reason),
+			})
+		}
+	}
+
+	return findings
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
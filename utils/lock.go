@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"golang.org/x/sync/semaphore"
+)
+
+// globalLockPoll is how often the steps waiting for a global lock try to
+// take it again, as it's released by other processes
+const globalLockPoll = time.Second
+
+// LockProvider holds named locks shared with the workflows run by other
+// processes, on the same machine or across machines
+type LockProvider interface {
+	// TryLock takes the lock with the given name if no one holds it and
+	// returns the function releasing it, or nil if it's held. It doesn't
+	// wait for the lock
+	TryLock(ctx context.Context, name string) (func() error, error)
+}
+
+// validateLocks checks the groups of the workflow and the steps using them
+func (w *Workflow) validateLocks() error {
+	var errors error
+
+	for name, limit := range w.Groups {
+		if limit < 1 {
+			errors = multierror.Append(errors, fmt.Errorf("group %s has an invalid limit %d", name, limit))
+		}
+	}
+
+	for _, step := range w.allSteps() {
+		if step.Group == "" {
+			continue
+		}
+		if _, ok := w.Groups[step.Group]; !ok {
+			errors = multierror.Append(errors, fmt.Errorf("step %s is in an undefined group %s", step.Name, step.Group))
+		}
+	}
+
+	return errors
+}
+
+// validateGlobalLocks checks there is a lock provider for the steps with a
+// global lock
+func (w *Workflow) validateGlobalLocks() error {
+	if w.options.Locks != nil {
+		return nil
+	}
+
+	var errors error
+	for _, step := range w.allSteps() {
+		if step.GlobalLock != "" {
+			errors = multierror.Append(errors, fmt.Errorf("step %s has a global lock but there is no lock provider", step.Name))
+		}
+	}
+
+	return errors
+}
+
+// setupLocks creates a semaphore for each group and gives the steps the
+// ones of the locks and groups they use
+func (w *Workflow) setupLocks() {
+	w.lockSemaphores = make(map[string]*semaphore.Weighted)
+	w.groupSemaphores = make(map[string]*semaphore.Weighted, len(w.Groups))
+	for name, limit := range w.Groups {
+		w.groupSemaphores[name] = semaphore.NewWeighted(int64(limit))
+	}
+
+	for _, step := range w.allSteps() {
+		w.setupStepLocks(step)
+	}
+}
+
+// setupStepLocks gives the step the semaphores of its lock and group. The
+// semaphore of a lock is created for the first step using it
+func (w *Workflow) setupStepLocks(step *Step) {
+	if step.Lock != "" {
+		if _, ok := w.lockSemaphores[step.Lock]; !ok {
+			w.lockSemaphores[step.Lock] = semaphore.NewWeighted(1)
+		}
+		step.locks = append(step.locks, w.lockSemaphores[step.Lock])
+	}
+	if step.Group != "" {
+		step.locks = append(step.locks, w.groupSemaphores[step.Group])
+	}
+}
+
+// acquireLocks takes the locks and group slots of the step, and then its
+// global lock, if they are all free. It doesn't wait for them
+func (s *Step) acquireLocks(ctx context.Context) bool {
+	for idx, lock := range s.locks {
+		if lock.TryAcquire(1) {
+			continue
+		}
+
+		for _, acquired := range s.locks[:idx] {
+			acquired.Release(1)
+		}
+
+		return false
+	}
+
+	if s.GlobalLock != "" && !s.acquireGlobalLock(ctx) {
+		for _, acquired := range s.locks {
+			acquired.Release(1)
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// acquireGlobalLock takes the global lock of the step from the lock
+// provider. Waiting for it is logged once
+func (s *Step) acquireGlobalLock(ctx context.Context) bool {
+	unlock, err := s.workflow.options.Locks.TryLock(ctx, s.GlobalLock)
+	if err == nil && unlock != nil {
+		s.globalUnlock = unlock
+		s.waitingForLock = false
+		return true
+	}
+
+	if !s.waitingForLock {
+		s.waitingForLock = true
+		if err != nil {
+			s.logger.WithField(FldStep, s.Name).Warnf("Failed to take global lock %s, trying again: %s", s.GlobalLock, err)
+		} else {
+			s.logger.WithField(FldStep, s.Name).Infof("Waiting for global lock %s", s.GlobalLock)
+		}
+	}
+
+	return false
+}
+
+func (s *Step) releaseLocks() {
+	for _, lock := range s.locks {
+		lock.Release(1)
+	}
+
+	if s.globalUnlock != nil {
+		if err := s.globalUnlock(); err != nil {
+			s.logger.WithField(FldStep, s.Name).Warnf("Failed to release global lock %s: %s", s.GlobalLock, err)
+		}
+		s.globalUnlock = nil
+	}
+}
+
+// pollGlobalLocks wakes up the dispatcher regularly until done, so the steps
+// waiting for a global lock try to take it again
+func (w *Workflow) pollGlobalLocks(done <-chan struct{}) {
+	ticker := time.NewTicker(globalLockPoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.notify()
+		case <-done:
+			return
+		}
+	}
+}
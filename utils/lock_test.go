@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCleanupStepLocks(t *testing.T) {
+	options := &WorkflowOptions{
+		Output:  NewOutputMultiplexer(&lockedBuffer{}, &MultiplexerOptions{NoColor: true, Raw: true}),
+		Timeout: 10 * time.Second,
+	}
+	w, err := LoadWorkflowFromBytes(context.Background(), options, []byte(`
+version: 1
+groups:
+  db: 1
+steps:
+  - name: migrate
+    command: echo migrate
+    lock: schema
+    group: db
+cleanup:
+  - name: drop
+    command: echo drop
+    lock: schema
+    group: db
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, cleanup := w.Steps[0], w.Cleanup[0]
+	if len(cleanup.locks) != 2 {
+		t.Fatalf("the cleanup step has %d locks, want 2", len(cleanup.locks))
+	}
+	for idx := range step.locks {
+		if step.locks[idx] != cleanup.locks[idx] {
+			t.Errorf("lock %d of the cleanup step isn't shared with the other steps", idx)
+		}
+	}
+
+	result, err := w.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Failed()) != 0 {
+		t.Errorf("%d steps failed", len(result.Failed()))
+	}
+	if !step.acquireLocks(context.Background()) {
+		t.Error("the locks weren't released after the run")
+	}
+}
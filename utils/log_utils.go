@@ -1,13 +1,12 @@
 package utils
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -63,18 +62,7 @@ func NewLoggingContext(workflow *Workflow, step *Step) *LoggingContext {
 
 // Parse parses the given value within this context.
 func (l *LoggingContext) parse(value string) (string, error) {
-	temp, err := template.New("filename").Parse(value)
-	if err != nil {
-		return "", err
-	}
-
-	var tpl bytes.Buffer
-	err = temp.Execute(&tpl, l)
-	if err != nil {
-		return "", err
-	}
-
-	return tpl.String(), nil
+	return renderTemplate("filename", value, l, l.Workflow != nil && l.Workflow.TextTemplates)
 }
 
 // DefaultLogDefinition returns a LogDefintion based on the given base
@@ -102,6 +90,20 @@ func DefaultLogDefinition(baseDefinition *LogDefinition) *LogDefinition {
 		definition.Level = viper.GetString("log-level")
 	}
 
+	// the defaults of the command line for library users without them
+	if definition.Type == "" {
+		definition.Type = "stdout"
+	}
+	if definition.Destination == "" {
+		definition.Destination = "trackman.log"
+	}
+	if definition.Format == "" {
+		definition.Format = "text"
+	}
+	if definition.Level == "" {
+		definition.Level = "info"
+	}
+
 	return definition
 }
 
@@ -111,6 +113,11 @@ func NewLogger(baseDefinition *LogDefinition, loggingContext *LoggingContext) (*
 	definition := DefaultLogDefinition(baseDefinition)
 
 	logger := logrus.New()
+	hook := &secretsHook{}
+	if loggingContext != nil && loggingContext.Workflow != nil {
+		hook.redactions = loggingContext.Workflow.redactions
+	}
+	logger.AddHook(hook)
 
 	if definition.Type == "stdout" {
 		logger.SetOutput(os.Stdout)
@@ -149,10 +156,82 @@ func NewLogger(baseDefinition *LogDefinition, loggingContext *LoggingContext) (*
 	if definition.Format == "text" {
 		logger.Formatter = &logrus.TextFormatter{}
 	} else if definition.Format == "json" {
-		logger.Formatter = &logrus.JSONFormatter{}
+		logger.Formatter = &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}
+		logger.AddHook(&structuredHook{loggingContext: loggingContext})
 	} else {
 		return nil, fmt.Errorf("invalid log format %s", definition.Format)
 	}
 
 	return logger, nil
 }
+
+// structuredHook adds the details of the workflow, step and event being
+// logged as fields so structured logs can be searched by them
+type structuredHook struct {
+	loggingContext *LoggingContext
+}
+
+// Levels implements logrus.Hook
+func (h *structuredHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook
+func (h *structuredHook) Fire(entry *logrus.Entry) error {
+	if h.loggingContext != nil {
+		if h.loggingContext.Workflow != nil && h.loggingContext.Workflow.sessionID != "" {
+			entry.Data[FldSessionID] = h.loggingContext.Workflow.sessionID
+		}
+		if h.loggingContext.Step != nil {
+			if _, ok := entry.Data[FldStep]; !ok {
+				entry.Data[FldStep] = h.loggingContext.Step.Name
+			}
+		}
+	}
+
+	if entry.Context == nil {
+		return nil
+	}
+
+	if spinner, ok := entry.Context.Value(CtxSpinner).(*Spinner); ok {
+		entry.Data[FldUUID] = spinner.UUID
+	}
+	if event, ok := entry.Context.Value(CtxEvent).(*Event); ok {
+		entry.Data[FldEvent] = event.Name
+		entry.Data[FldEventUUID] = event.Payload.EventUUID
+		if event.Payload.Spinner != nil {
+			entry.Data[FldUUID] = event.Payload.Spinner.UUID
+		}
+		if status, ok := eventStatuses[event.Name]; ok {
+			entry.Data[FldStatus] = status.String()
+		}
+
+		switch extras := event.Payload.Extras.(type) {
+		case *RunFailPayload:
+			if extras.Quorum == nil && extras.Outcome == "" {
+				entry.Data[FldExitCode] = extras.ExitCode
+			}
+		case *RetryAttempt:
+			entry.Data[FldAttempt] = extras.Attempt
+		}
+	}
+
+	return nil
+}
+
+// eventStatuses are the statuses of the run of a step its events show in
+// structured logs. A failed run can still be retried
+var eventStatuses = map[string]StepStatus{
+	EventRunRequested: StepQueued,
+	EventRunStarted:   StepRunning,
+	EventRunningProbe: StepRunning,
+	EventRunSuccess:   StepSucceeded,
+	EventRunCacheHit:  StepSucceeded,
+	EventRunError:     StepFailed,
+	EventRunFail:      StepFailed,
+	EventRunWaitError: StepFailed,
+	EventRunTimeout:   StepTimedOut,
+	EventRunRetry:     StepRetrying,
+	EventRunSkipped:   StepSkipped,
+	EventRunCancelled: StepCancelled,
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestStructuredLogStatus(t *testing.T) {
+	tests := []struct {
+		event *Event
+		want  map[string]interface{}
+	}{
+		{
+			event: &Event{Name: EventRunFail, Payload: Payload{Extras: &RunFailPayload{ExitCode: 3}}},
+			want:  map[string]interface{}{FldStatus: "failed", FldExitCode: float64(3)},
+		},
+		{
+			event: &Event{Name: EventRunRetry, Payload: Payload{Extras: &RetryAttempt{Attempt: 2}}},
+			want:  map[string]interface{}{FldStatus: "retrying", FldAttempt: float64(2)},
+		},
+		{
+			event: &Event{Name: EventRunSuccess},
+			want:  map[string]interface{}{FldStatus: "succeeded"},
+		},
+	}
+
+	for _, test := range tests {
+		out := &lockedBuffer{}
+		logger := logrus.New()
+		logger.Out = out
+		logger.Formatter = &logrus.JSONFormatter{}
+		logger.AddHook(&structuredHook{})
+
+		logger.WithContext(context.WithValue(context.Background(), CtxEvent, test.event)).Info("event")
+
+		var line map[string]interface{}
+		if err := json.Unmarshal([]byte(out.String()), &line); err != nil {
+			t.Fatal(err)
+		}
+		for field, value := range test.want {
+			if line[field] != value {
+				t.Errorf("%s of %s is %v, want %v", field, test.event.Name, line[field], value)
+			}
+		}
+		if _, ok := line[FldExitCode]; ok && test.event.Name != EventRunFail {
+			t.Errorf("%s has an exit code", test.event.Name)
+		}
+	}
+}
@@ -10,6 +10,22 @@ import (
 const (
 	// FldStep is a logger field
 	FldStep = "Step"
+	// FldStage is a logger field
+	FldStage = "Stage"
+	// FldSessionID is a logger field
+	FldSessionID = "SessionID"
+	// FldUUID is a logger field
+	FldUUID = "UUID"
+	// FldEvent is a logger field
+	FldEvent = "Event"
+	// FldEventUUID is a logger field
+	FldEventUUID = "EventUUID"
+	// FldStatus is a logger field
+	FldStatus = "Status"
+	// FldExitCode is a logger field
+	FldExitCode = "ExitCode"
+	// FldAttempt is a logger field
+	FldAttempt = "Attempt"
 )
 
 // LogWriter implements io.Writer so it can be used to dump a process output
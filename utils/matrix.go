@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MatrixDefinition fans a step out into a step for each combination of its
+// values
+type MatrixDefinition struct {
+	// Values are the values of each matrix variable
+	Values map[string][]string `yaml:"values" json:"values"`
+	// MaxParallel is the maximum number of steps of the matrix that can run
+	// at the same time. 0 means no limit other than the concurrency of the
+	// workflow
+	MaxParallel int `yaml:"max_parallel" json:"max_parallel"`
+}
+
+// matrixGroup holds the steps a matrix step was expanded into
+type matrixGroup struct {
+	maxParallel int
+	steps       []*Step
+}
+
+// full returns true if no more steps of the matrix can start
+func (g *matrixGroup) full() bool {
+	if g.maxParallel <= 0 {
+		return false
+	}
+
+	running := 0
+	for _, step := range g.steps {
+		if step.status == StepQueued || step.status == StepRunning {
+			running++
+		}
+	}
+
+	return running >= g.maxParallel
+}
+
+// Matrix returns the matrix values of the step. It's empty for steps without
+// a matrix
+func (s *Step) Matrix() map[string]string {
+	return s.matrixValues
+}
+
+func (m *MatrixDefinition) validate() error {
+	if len(m.Values) == 0 {
+		return fmt.Errorf("has a matrix with no values")
+	}
+	for name, values := range m.Values {
+		if len(values) == 0 {
+			return fmt.Errorf("has no values for matrix variable %s", name)
+		}
+	}
+	if m.MaxParallel < 0 {
+		return fmt.Errorf("has an invalid matrix max_parallel %d", m.MaxParallel)
+	}
+
+	return nil
+}
+
+// combinations returns all combinations of the values in the order of the
+// variable names
+func (m *MatrixDefinition) combinations() []map[string]string {
+	names := make([]string, 0, len(m.Values))
+	for name := range m.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	combinations := []map[string]string{{}}
+	for _, name := range names {
+		var next []map[string]string
+		for _, combination := range combinations {
+			for _, value := range m.Values[name] {
+				item := make(map[string]string, len(combination)+1)
+				for key, existing := range combination {
+					item[key] = existing
+				}
+				item[name] = value
+
+				next = append(next, item)
+			}
+		}
+		combinations = next
+	}
+
+	return combinations
+}
+
+// matrixStepName returns the name of a step expanded from a matrix, made of
+// the matrix values in the order of their names
+func matrixStepName(name string, values map[string]string) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := []string{name}
+	for _, key := range keys {
+		parts = append(parts, values[key])
+	}
+
+	return strings.Join(parts, "-")
+}
+
+// expandMatrices replaces the steps with a matrix with a step for each
+// combination of the matrix values. Steps depending on a matrix step depend
+// on all the steps it's expanded into
+func (w *Workflow) expandMatrices() error {
+	expanded := make(map[string][]string)
+
+	expand := func(steps []*Step) ([]*Step, error) {
+		var result []*Step
+		for _, step := range steps {
+			if step.MatrixDefinition == nil {
+				result = append(result, step)
+				continue
+			}
+
+			if err := step.MatrixDefinition.validate(); err != nil {
+				return nil, fmt.Errorf("step %s %s", step.Name, err)
+			}
+
+			group := &matrixGroup{maxParallel: step.MatrixDefinition.MaxParallel}
+			for _, values := range step.MatrixDefinition.combinations() {
+				instance, err := copyStep(step)
+				if err != nil {
+					return nil, err
+				}
+
+				instance.Name = matrixStepName(step.Name, values)
+				instance.stage = step.stage
+				instance.line = step.line
+				instance.include = step.include
+				instance.matrixValues = values
+				instance.matrix = group
+				instance.MatrixDefinition = nil
+
+				group.steps = append(group.steps, instance)
+				expanded[step.Name] = append(expanded[step.Name], instance.Name)
+			}
+
+			result = append(result, group.steps...)
+		}
+
+		return result, nil
+	}
+
+	var err error
+	if w.Steps, err = expand(w.Steps); err != nil {
+		return err
+	}
+	if w.Cleanup, err = expand(w.Cleanup); err != nil {
+		return err
+	}
+	if len(expanded) == 0 {
+		return nil
+	}
+
+	expandNames := func(names []string) []string {
+		var result []string
+		for _, name := range names {
+			if instances, ok := expanded[name]; ok {
+				result = append(result, instances...)
+				continue
+			}
+
+			result = append(result, name)
+		}
+
+		return result
+	}
+
+	for _, step := range w.allSteps() {
+		var dependencies Dependencies
+		for _, dependency := range step.DependsOn {
+			for _, name := range expandNames([]string{dependency.Step}) {
+				dependencies = append(dependencies, &Dependency{Step: name, Status: dependency.Status})
+			}
+		}
+		step.DependsOn = dependencies
+		step.NeedsArtifacts = expandNames(step.NeedsArtifacts)
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package utils
+
+// MetricsCollector receives measurements of workflows and steps as they run.
+// Implementations should be safe to call from multiple goroutines
+type MetricsCollector interface {
+	// WorkflowStarted is called when a workflow starts running
+	WorkflowStarted(workflow *Workflow)
+	// WorkflowFinished is called with the result of the workflow once it is done
+	WorkflowFinished(workflow *Workflow, result *WorkflowResult)
+	// StepStarted is called before every attempt of a step
+	StepStarted(step *Step)
+	// StepFinished is called after every attempt of a step. The status of the
+	// result is running when the step is going to be retried
+	StepFinished(step *Step, result *StepResult)
+	// StepTimedOut is called when a step or its probe times out
+	StepTimedOut(step *Step)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) WorkflowStarted(*Workflow)                   {}
+func (noopMetrics) WorkflowFinished(*Workflow, *WorkflowResult) {}
+func (noopMetrics) StepStarted(*Step)                           {}
+func (noopMetrics) StepFinished(*Step, *StepResult)             {}
+func (noopMetrics) StepTimedOut(*Step)                          {}
+
+func (w *Workflow) metrics() MetricsCollector {
+	if w.options.Metrics == nil {
+		return noopMetrics{}
+	}
+
+	return w.options.Metrics
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+var prefixColors = []color.Attribute{
+	color.FgCyan,
+	color.FgYellow,
+	color.FgGreen,
+	color.FgMagenta,
+	color.FgBlue,
+	color.FgHiCyan,
+	color.FgHiYellow,
+	color.FgHiGreen,
+	color.FgHiMagenta,
+	color.FgHiBlue,
+}
+
+// OutputSink receives the output of the steps as they run
+type OutputSink interface {
+	// Writer returns a writer for the output of a spinner of the given step.
+	// The writer is closed once the spinner is done
+	Writer(step string, name string) io.WriteCloser
+}
+
+// MultiplexerOptions configures an OutputMultiplexer
+type MultiplexerOptions struct {
+	// NoColor turns off colored prefixes. Colors are also off when the
+	// output is not a terminal
+	NoColor bool
+	// Raw writes the output of the steps as is, without any prefixes
+	Raw bool
+}
+
+// OutputMultiplexer combines the output of the steps into one writer, with
+// each line prefixed with the name of the step it came from
+type OutputMultiplexer struct {
+	out     io.Writer
+	options *MultiplexerOptions
+	colors  map[string]*color.Color
+	width   int
+	signal  *sync.Mutex
+}
+
+// NewOutputMultiplexer creates a new OutputMultiplexer writing to out
+func NewOutputMultiplexer(out io.Writer, options *MultiplexerOptions) *OutputMultiplexer {
+	if options == nil {
+		options = &MultiplexerOptions{}
+	}
+
+	return &OutputMultiplexer{
+		out:     out,
+		options: options,
+		colors:  make(map[string]*color.Color),
+		signal:  &sync.Mutex{},
+	}
+}
+
+// Writer implements OutputSink
+func (m *OutputMultiplexer) Writer(step string, name string) io.WriteCloser {
+	m.signal.Lock()
+	defer m.signal.Unlock()
+
+	if _, ok := m.colors[step]; !ok {
+		c := color.New(prefixColors[len(m.colors)%len(prefixColors)])
+		if m.options.NoColor {
+			c.DisableColor()
+		}
+
+		m.colors[step] = c
+	}
+	if len(name) > m.width {
+		m.width = len(name)
+	}
+
+	return &multiplexedWriter{
+		multiplexer: m,
+		step:        step,
+		name:        name,
+		buffer:      &bytes.Buffer{},
+	}
+}
+
+func (m *OutputMultiplexer) writeLine(step string, name string, line []byte) {
+	m.signal.Lock()
+	defer m.signal.Unlock()
+
+	if m.options.Raw {
+		_, _ = m.out.Write(line)
+		return
+	}
+
+	// pad to the longest name so far so the lines are aligned
+	prefix := m.colors[step].Sprint(name + strings.Repeat(" ", m.width-len(name)) + " |")
+	_, _ = fmt.Fprintf(m.out, "%s %s", prefix, line)
+}
+
+type multiplexedWriter struct {
+	multiplexer *OutputMultiplexer
+	step        string
+	name        string
+	buffer      *bytes.Buffer
+}
+
+// Write implements io.Writer. Only whole lines are written so lines of
+// different steps don't mix
+func (w *multiplexedWriter) Write(b []byte) (int, error) {
+	w.buffer.Write(b)
+
+	for {
+		idx := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+
+		w.multiplexer.writeLine(w.step, w.name, w.buffer.Next(idx+1))
+	}
+
+	return len(b), nil
+}
+
+// Close implements io.Closer
+func (w *multiplexedWriter) Close() error {
+	if w.buffer.Len() != 0 {
+		w.multiplexer.writeLine(w.step, w.name, append(w.buffer.Bytes(), '\n'))
+		w.buffer.Reset()
+	}
+
+	return nil
+}
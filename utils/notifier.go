@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+)
+
+// Notifier is called with the events of a workflow
+type Notifier func(ctx context.Context, logger *logrus.Logger, event *Event) error
+
+type subscription struct {
+	name     string
+	notifier Notifier
+	filter   *NotificationFilter
+}
+
+// NotificationFilter limits the events sent to a notifier. Empty fields
+// match all events
+type NotificationFilter struct {
+	// Events are the names of the events, or globs like run.*
+	Events []string `yaml:"events" json:"events"`
+	// Steps are globs of the names of the steps, like prod-*. Events of the
+	// workflow itself don't match filters with steps
+	Steps []string `yaml:"steps" json:"steps"`
+	// Severity is the least severity of the events. See EventSeverity
+	Severity string `yaml:"severity" json:"severity"`
+}
+
+// NotificationRoute sends the events of a workflow matching the filter to
+// the notifier with the name
+type NotificationRoute struct {
+	Notifier           string `yaml:"notifier" json:"notifier"`
+	NotificationFilter `yaml:",inline"`
+}
+
+// eventSeverities are the severities of the events that aren't info
+var eventSeverities = map[string]string{
+	EventRunError:           SeverityError,
+	EventRunFail:            SeverityError,
+	EventRunWaitError:       SeverityError,
+	EventRunTimeout:         SeverityError,
+	EventRunLimitExceeded:   SeverityError,
+	EventRunDeadline:        SeverityError,
+	EventWorkflowFail:       SeverityError,
+	EventWorkflowTimeout:    SeverityError,
+	EventRunRetry:           SeverityWarning,
+	EventRunDegraded:        SeverityWarning,
+	EventRunCancelled:       SeverityWarning,
+	EventRunDeadlineMissed:  SeverityWarning,
+	EventRunOutputTruncated: SeverityWarning,
+	EventRunStalled:         SeverityWarning,
+	EventWorkflowPaused:     SeverityWarning,
+}
+
+// EventSeverity returns how severe the event with the name is: error for
+// failures and timeouts, warning for retries, degraded quorums,
+// cancellations, missed deadlines, stalls and pauses, and info for the others
+func EventSeverity(name string) string {
+	if severity, ok := eventSeverities[name]; ok {
+		return severity
+	}
+
+	return SeverityInfo
+}
+
+func (f *NotificationFilter) validate() error {
+	for _, pattern := range append(append([]string{}, f.Events...), f.Steps...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %s", pattern, err)
+		}
+	}
+	if f.Severity != "" && severityLevel(f.Severity) < 0 {
+		return fmt.Errorf("invalid severity %s. Valid values are %s", f.Severity, strings.Join(severities, ", "))
+	}
+
+	return nil
+}
+
+// matches returns true if the event passes the filter
+func (f *NotificationFilter) matches(event *Event) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Events) != 0 && !matchesAny(f.Events, event.Name) {
+		return false
+	}
+	if len(f.Steps) != 0 && (event.Payload.Spinner == nil || !matchesAny(f.Steps, event.Payload.Spinner.Name)) {
+		return false
+	}
+	if f.Severity != "" && severityLevel(EventSeverity(event.Name)) < severityLevel(f.Severity) {
+		return false
+	}
+
+	return true
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// routed returns true if the notifications of the workflow of the event let
+// it be sent to the notifier. Notifiers without routes get all events
+func routed(event *Event, notifier string) bool {
+	if event.Payload.Workflow == nil {
+		return true
+	}
+
+	found := false
+	for _, route := range event.Payload.Workflow.Notifications {
+		if route.Notifier != notifier {
+			continue
+		}
+
+		found = true
+		if route.matches(event) {
+			return true
+		}
+	}
+
+	return !found
+}
+
+// NotifierRegistry holds all notifiers of a workflow and sends each event to
+// the ones subscribed to it
+type NotifierRegistry struct {
+	subscriptions []*subscription
+	signal        *sync.RWMutex
+}
+
+// NewNotifierRegistry creates a new empty NotifierRegistry
+func NewNotifierRegistry() *NotifierRegistry {
+	return &NotifierRegistry{
+		signal: &sync.RWMutex{},
+	}
+}
+
+// Register adds a notifier with the given name. If events are given, the
+// notifier only receives those events, otherwise it receives all events
+func (r *NotifierRegistry) Register(name string, notifier Notifier, events ...string) error {
+	return r.RegisterWithFilter(name, notifier, &NotificationFilter{Events: events})
+}
+
+// RegisterWithFilter adds a notifier with the given name that only receives
+// the events matching the filter
+func (r *NotifierRegistry) RegisterWithFilter(name string, notifier Notifier, filter *NotificationFilter) error {
+	if err := filter.validate(); err != nil {
+		return fmt.Errorf("notifier %s has an %s", name, err)
+	}
+
+	r.signal.Lock()
+	defer r.signal.Unlock()
+
+	for _, item := range r.subscriptions {
+		if item.name == name {
+			return fmt.Errorf("notifier %s is already registered", name)
+		}
+	}
+
+	r.subscriptions = append(r.subscriptions, &subscription{
+		name:     name,
+		notifier: notifier,
+		filter:   filter,
+	})
+
+	return nil
+}
+
+// Unregister removes the notifier with the given name
+func (r *NotifierRegistry) Unregister(name string) {
+	r.signal.Lock()
+	defer r.signal.Unlock()
+
+	for idx, item := range r.subscriptions {
+		if item.name == name {
+			r.subscriptions = append(r.subscriptions[:idx], r.subscriptions[idx+1:]...)
+			return
+		}
+	}
+}
+
+// Notify sends the event to all notifiers subscribed to it, and that the
+// notifications of its workflow route it to, at the same time and waits for
+// all of them to finish
+func (r *NotifierRegistry) Notify(ctx context.Context, logger *logrus.Logger, event *Event) error {
+	return notifyAll(ctx, logger, event, r.subscribers(event))
+}
+
+// subscribers returns the notifiers the event is sent to
+func (r *NotifierRegistry) subscribers(event *Event) []*subscription {
+	r.signal.RLock()
+	defer r.signal.RUnlock()
+
+	var subscribers []*subscription
+	for _, item := range r.subscriptions {
+		if item.filter.matches(event) && routed(event, item.name) {
+			subscribers = append(subscribers, item)
+		}
+	}
+
+	return subscribers
+}
+
+// notifyAll sends the event to the notifiers at the same time and waits for
+// all of them to finish
+func notifyAll(ctx context.Context, logger *logrus.Logger, event *Event, subscribers []*subscription) error {
+	var errors error
+	errorsSignal := &sync.Mutex{}
+	joiner := sync.WaitGroup{}
+	for _, item := range subscribers {
+		joiner.Add(1)
+		go func(item *subscription) {
+			defer joiner.Done()
+
+			if err := item.notifier(ctx, logger, event); err != nil {
+				errorsSignal.Lock()
+				errors = multierror.Append(errors, fmt.Errorf("notifier %s: %s", item.name, err))
+				errorsSignal.Unlock()
+			}
+		}(item)
+	}
+	joiner.Wait()
+
+	return errors
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxCapturedOutput is the most output of a command, or of an output file,
+// captured for the outputs or the generated steps of a step
+const maxCapturedOutput = 4 << 20
+
+// OutputDefinition defines a value captured from a step that later steps can use
+type OutputDefinition struct {
+	Name string `yaml:"name" json:"name"`
+	// File is read for the value of the output. If empty, the output of the
+	// step's command is used
+	File string `yaml:"file" json:"file"`
+}
+
+// Outputs returns the captured outputs of all steps that have run so far
+// by step name and output name
+func (w *Workflow) Outputs() map[string]map[string]string {
+	w.outputsSignal.Lock()
+	defer w.outputsSignal.Unlock()
+
+	result := make(map[string]map[string]string, len(w.outputs))
+	for stepName, outputs := range w.outputs {
+		result[stepName] = make(map[string]string, len(outputs))
+		for name, value := range outputs {
+			result[stepName][name] = value
+		}
+	}
+
+	return result
+}
+
+func (w *Workflow) setOutput(stepName string, name string, value string) {
+	w.outputsSignal.Lock()
+	defer w.outputsSignal.Unlock()
+
+	if w.outputs[stepName] == nil {
+		w.outputs[stepName] = make(map[string]string)
+	}
+	w.outputs[stepName][name] = value
+}
+
+// maskedOutputs returns the outputs of the step with their secrets masked,
+// to be kept in the state file and the cache
+func (w *Workflow) maskedOutputs(stepName string) map[string]string {
+	w.outputsSignal.Lock()
+	defer w.outputsSignal.Unlock()
+
+	if len(w.outputs[stepName]) == 0 {
+		return nil
+	}
+
+	result := make(map[string]string, len(w.outputs[stepName]))
+	for name, value := range w.outputs[stepName] {
+		result[name] = w.MaskSecrets(value)
+	}
+
+	return result
+}
+
+// Outputs returns the captured outputs of all steps that have run so far
+func (s *Step) Outputs() map[string]map[string]string {
+	return s.workflow.Outputs()
+}
+
+// capturesStdout returns true if the step generates steps or any of its
+// outputs uses the output of the command
+func (s *Step) capturesStdout() bool {
+	if s.Generate {
+		return true
+	}
+	for _, output := range s.OutputDefinitions {
+		if output.File == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// collectOutputs stores the outputs of the step after it has successfully run
+func (s *Step) collectOutputs(ctx context.Context, stdout string) error {
+	for _, output := range s.OutputDefinitions {
+		if output.File == "" {
+			s.workflow.setOutput(s.Name, output.Name, strings.TrimSpace(stdout))
+			continue
+		}
+
+		filename := output.File
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(s.Workdir, filename)
+		}
+
+		info, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxCapturedOutput {
+			return fmt.Errorf("output file %s is over %dM, the most an output can be", output.File, maxCapturedOutput>>20)
+		}
+
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		s.workflow.setOutput(s.Name, output.Name, strings.TrimSpace(string(content)))
+	}
+
+	return nil
+}
+
+// capturedOutput keeps the output of a command up to maxCapturedOutput and
+// drops the rest, so a step printing a lot can't use up the memory
+type capturedOutput struct {
+	buffer bytes.Buffer
+	over   bool
+}
+
+func (c *capturedOutput) Write(p []byte) (int, error) {
+	if room := maxCapturedOutput - c.buffer.Len(); len(p) > room {
+		c.buffer.Write(p[:room])
+		c.over = true
+
+		return len(p), nil
+	}
+
+	return c.buffer.Write(p)
+}
+
+// String returns the output, or an error if it was over the limit
+func (c *capturedOutput) String() (string, error) {
+	if c.over {
+		return "", fmt.Errorf("the output of the command is over %dM, the most that can be captured", maxCapturedOutput>>20)
+	}
+
+	return c.buffer.String(), nil
+}
@@ -0,0 +1,290 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// OutputTruncateTail keeps the start of the output and drops the rest
+	OutputTruncateTail = "truncate_tail"
+	// OutputTruncateHead drops the start of the output and keeps its end
+	OutputTruncateHead = "truncate_head"
+	// OutputSpill keeps the start of the output and writes the rest to a file
+	OutputSpill = "spill"
+)
+
+var outputPolicies = []string{OutputTruncateTail, OutputTruncateHead, OutputSpill}
+
+// OutputLimit caps the output of each run of a step that is shown, logged and
+// written to its log files. stdout and stderr count towards the same limit.
+// Outputs captured for later steps and success_output see all of it
+type OutputLimit struct {
+	// Bytes is the most output, like 512K or 10M. Units are powers of 1024
+	Bytes string `yaml:"bytes" json:"bytes"`
+	// Lines is the most lines of output
+	Lines int `yaml:"lines" json:"lines"`
+	// Policy is what happens to the output past the limit:
+	// OutputTruncateTail, OutputTruncateHead or OutputSpill. Defaults to
+	// OutputTruncateTail
+	Policy string `yaml:"policy" json:"policy"`
+	// File is where OutputSpill writes the output past the limit. Defaults to
+	// a file in the temp directory
+	File string `yaml:"file" json:"file"`
+}
+
+// OutputTruncated is the payload of EventRunOutputTruncated
+type OutputTruncated struct {
+	Policy string `json:"policy"`
+	// Bytes and Lines are how much of the output went past the limit
+	Bytes int64 `json:"bytes"`
+	Lines int   `json:"lines"`
+	// File is where the output past the limit was written with OutputSpill
+	File string `json:"file,omitempty"`
+}
+
+func (l *OutputLimit) validate() error {
+	if l.Bytes == "" && l.Lines == 0 {
+		return fmt.Errorf("output_limit needs bytes or lines")
+	}
+	if l.Bytes != "" {
+		if _, err := parseMemory(l.Bytes); err != nil {
+			return fmt.Errorf("invalid output_limit bytes %s. Use a size like 512K or 10M", l.Bytes)
+		}
+	}
+	if l.Lines < 0 {
+		return fmt.Errorf("invalid output_limit lines %d", l.Lines)
+	}
+	if l.Policy != "" && !contains(outputPolicies, l.Policy) {
+		return fmt.Errorf("invalid output_limit policy %s. Valid values are %s", l.Policy, strings.Join(outputPolicies, ", "))
+	}
+	if l.File != "" && l.Policy != OutputSpill {
+		return fmt.Errorf("output_limit file is only used with the %s policy", OutputSpill)
+	}
+
+	return nil
+}
+
+// outputLimiter applies an OutputLimit to the stdout and stderr of a run
+type outputLimiter struct {
+	policy   string
+	maxBytes int64
+	maxLines int
+	file     string
+	signal   *sync.Mutex
+	// written and lines are the output let through so far
+	written int64
+	lines   int
+	// held is the end of the output kept by OutputTruncateHead until the
+	// command is done
+	held      []*heldOutput
+	heldBytes int64
+	heldLines int
+	spill     *os.File
+	// notice is the stream the note about the truncation is written to
+	notice    io.Writer
+	truncated *OutputTruncated
+}
+
+type heldOutput struct {
+	writer io.Writer
+	data   []byte
+}
+
+// limitedWriter is a stream of the output going through an outputLimiter
+type limitedWriter struct {
+	limiter *outputLimiter
+	writer  io.Writer
+}
+
+// newOutputLimiter creates a limiter for the limit. file is where the output
+// is spilled if the limit doesn't have one
+func newOutputLimiter(limit *OutputLimit, file string) *outputLimiter {
+	limiter := &outputLimiter{
+		policy:   limit.Policy,
+		maxLines: limit.Lines,
+		file:     limit.File,
+		signal:   &sync.Mutex{},
+	}
+	if limiter.policy == "" {
+		limiter.policy = OutputTruncateTail
+	}
+	if limiter.file == "" {
+		limiter.file = file
+	}
+	if limit.Bytes != "" {
+		// checked by validate
+		limiter.maxBytes, _ = parseMemory(limit.Bytes)
+	}
+
+	return limiter
+}
+
+// writer returns the stream going through the limiter to w
+func (l *outputLimiter) writer(w io.Writer) io.Writer {
+	return &limitedWriter{limiter: l, writer: w}
+}
+
+// Write implements io.Writer. The output past the limit isn't an error for
+// the command, so all of p is always reported as written
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	l := w.limiter
+	l.signal.Lock()
+	defer l.signal.Unlock()
+
+	if l.policy == OutputTruncateHead {
+		l.hold(w.writer, p)
+		return len(p), nil
+	}
+
+	keep := l.room(p)
+	if keep > 0 {
+		if _, err := w.writer.Write(p[:keep]); err != nil {
+			return 0, err
+		}
+		l.written += int64(keep)
+		l.lines += bytes.Count(p[:keep], []byte("\n"))
+	}
+	if rest := p[keep:]; len(rest) != 0 {
+		l.exceeded(w.writer, rest)
+	}
+
+	return len(p), nil
+}
+
+// room returns how much of p fits under the limit
+func (l *outputLimiter) room(p []byte) int {
+	keep := len(p)
+	if l.maxBytes > 0 && int64(keep) > l.maxBytes-l.written {
+		keep = int(l.maxBytes - l.written)
+	}
+	if l.maxLines > 0 {
+		left := l.maxLines - l.lines
+		for idx := 0; idx < keep; idx++ {
+			if left == 0 {
+				return idx
+			}
+			if p[idx] == '\n' {
+				left--
+			}
+		}
+	}
+
+	return keep
+}
+
+// exceeded drops or spills the output past the limit
+func (l *outputLimiter) exceeded(w io.Writer, p []byte) {
+	if l.truncated == nil {
+		l.truncated = &OutputTruncated{Policy: l.policy}
+		l.notice = w
+		if l.policy == OutputSpill {
+			l.openSpill()
+		}
+	}
+	l.truncated.Bytes += int64(len(p))
+	l.truncated.Lines += bytes.Count(p, []byte("\n"))
+
+	if l.spill != nil {
+		if _, err := l.spill.Write(p); err != nil {
+			// the rest is dropped
+			l.spill.Close()
+			l.spill = nil
+			l.truncated.File = ""
+		}
+	}
+}
+
+func (l *outputLimiter) openSpill() {
+	if err := os.MkdirAll(filepath.Dir(l.file), 0755); err != nil {
+		return
+	}
+	file, err := os.OpenFile(l.file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return
+	}
+
+	l.spill = file
+	l.truncated.File = l.file
+}
+
+// hold keeps p as the end of the output, dropping the start of the output
+// held so far that doesn't fit under the limit anymore
+func (l *outputLimiter) hold(w io.Writer, p []byte) {
+	l.held = append(l.held, &heldOutput{writer: w, data: append([]byte(nil), p...)})
+	l.heldBytes += int64(len(p))
+	l.heldLines += bytes.Count(p, []byte("\n"))
+
+	for l.maxBytes > 0 && l.heldBytes > l.maxBytes {
+		l.dropHeld(int(l.heldBytes - l.maxBytes))
+	}
+	for l.maxLines > 0 && l.heldLines > l.maxLines {
+		// drop the first line
+		first := l.held[0]
+		if idx := bytes.IndexByte(first.data, '\n'); idx >= 0 {
+			l.dropHeld(idx + 1)
+		} else {
+			l.dropHeld(len(first.data))
+		}
+	}
+}
+
+// dropHeld drops up to n bytes from the start of the first held output
+func (l *outputLimiter) dropHeld(n int) {
+	first := l.held[0]
+	if n > len(first.data) {
+		n = len(first.data)
+	}
+	if l.truncated == nil {
+		l.truncated = &OutputTruncated{Policy: l.policy}
+		l.notice = first.writer
+	}
+
+	dropped := first.data[:n]
+	lines := bytes.Count(dropped, []byte("\n"))
+	l.truncated.Bytes += int64(n)
+	l.truncated.Lines += lines
+	l.heldBytes -= int64(n)
+	l.heldLines -= lines
+
+	first.data = first.data[n:]
+	if len(first.data) == 0 {
+		l.held = l.held[1:]
+	}
+}
+
+// close writes the output held back and a note about the truncation, if any.
+// It returns what went past the limit, or nil if the output fit
+func (l *outputLimiter) close() *OutputTruncated {
+	l.signal.Lock()
+	defer l.signal.Unlock()
+
+	if l.spill != nil {
+		l.spill.Close()
+		l.spill = nil
+	}
+
+	if l.truncated != nil && l.policy == OutputTruncateHead {
+		// the note comes before the end of the output
+		fmt.Fprintf(l.notice, "[%d bytes of output before this were dropped]\n", l.truncated.Bytes)
+	}
+	for _, item := range l.held {
+		_, _ = item.writer.Write(item.data)
+	}
+	l.held = nil
+
+	if l.truncated != nil && l.policy != OutputTruncateHead {
+		note := "dropped"
+		if l.truncated.File != "" {
+			note = "written to " + l.truncated.File
+		}
+		fmt.Fprintf(l.notice, "[%d bytes of output past the limit were %s]\n", l.truncated.Bytes, note)
+	}
+
+	return l.truncated
+}
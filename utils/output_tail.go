@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"strings"
+	"sync"
+)
+
+// outputTail keeps the last lines of the output of a step
+type outputTail struct {
+	max     int
+	lines   []string
+	partial string
+	signal  *sync.Mutex
+}
+
+func newOutputTail(max int) *outputTail {
+	return &outputTail{max: max, signal: &sync.Mutex{}}
+}
+
+// Write implements io.Writer
+func (t *outputTail) Write(p []byte) (int, error) {
+	t.signal.Lock()
+	defer t.signal.Unlock()
+
+	lines := strings.Split(t.partial+string(p), "\n")
+	t.partial = lines[len(lines)-1]
+	t.lines = append(t.lines, lines[:len(lines)-1]...)
+	if len(t.lines) > t.max {
+		t.lines = append([]string(nil), t.lines[len(t.lines)-t.max:]...)
+	}
+
+	return len(p), nil
+}
+
+// Lines returns the last lines written, with the last one even if it
+// doesn't end with a new line
+func (t *outputTail) Lines() []string {
+	t.signal.Lock()
+	defer t.signal.Unlock()
+
+	lines := append([]string(nil), t.lines...)
+	if t.partial != "" {
+		lines = append(lines, t.partial)
+	}
+	if len(lines) > t.max {
+		lines = lines[len(lines)-t.max:]
+	}
+
+	return lines
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCapturedOutputLimit(t *testing.T) {
+	tests := []struct {
+		command string
+		failed  bool
+	}{
+		{command: "yes a | head -c 2000", failed: false},
+		{command: "yes a | head -c 5000000", failed: true},
+	}
+
+	for _, test := range tests {
+		options := &WorkflowOptions{
+			Output:  NewOutputMultiplexer(&lockedBuffer{}, &MultiplexerOptions{NoColor: true, Raw: true}),
+			Timeout: 10 * time.Second,
+		}
+		w, err := LoadWorkflowFromBytes(context.Background(), options, []byte(`
+version: 1
+steps:
+  - name: print
+    shell: sh
+    command: "`+test.command+`"
+    outputs:
+      - name: value
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := w.Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if failed := len(result.Failed()) != 0; failed != test.failed {
+			t.Errorf("%s failed is %v, want %v", test.command, failed, test.failed)
+		}
+		if value := w.Outputs()["print"]["value"]; !test.failed && value != strings.TrimSpace(strings.Repeat("a\n", 1000)) {
+			t.Errorf("%s has the output %q", test.command, value)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	// ParameterString is a parameter taking any value
+	ParameterString = "string"
+	// ParameterInt is a parameter taking a whole number
+	ParameterInt = "int"
+	// ParameterBool is a parameter taking true or false
+	ParameterBool = "bool"
+	// ParameterEnum is a parameter taking one of its values
+	ParameterEnum = "enum"
+	// ParameterSecret is a parameter taking any value, masked like secrets
+	ParameterSecret = "secret"
+)
+
+var parameterTypes = []string{ParameterString, ParameterInt, ParameterBool, ParameterEnum, ParameterSecret}
+
+// Parameter is an input of the workflow with a type. Its value is set like a
+// variable, or read from its environment variable, and is checked before the
+// workflow runs. Steps use it as a variable
+type Parameter struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description" json:"description"`
+	// Type is string, int, bool, enum or secret. Defaults to string
+	Type string `yaml:"type" json:"type"`
+	// Values are the values an enum parameter can take
+	Values []string `yaml:"values" json:"values"`
+	// Default is used when no value is given
+	Default *string `yaml:"default" json:"default"`
+	// Required parameters need a value when they have no default
+	Required bool `yaml:"required" json:"required"`
+	// Env is the environment variable the value is read from when it isn't
+	// set as a variable
+	Env string `yaml:"env" json:"env"`
+}
+
+func (p *Parameter) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("has no name")
+	}
+	if p.Type != "" && !contains(parameterTypes, p.Type) {
+		return fmt.Errorf("has an invalid type %s. Valid types are %s", p.Type, strings.Join(parameterTypes, ", "))
+	}
+	if p.Type == ParameterEnum && len(p.Values) == 0 {
+		return fmt.Errorf("is an enum without values")
+	}
+	if p.Type != ParameterEnum && len(p.Values) != 0 {
+		return fmt.Errorf("can only have values if it's an enum")
+	}
+	if p.Default != nil {
+		if _, err := p.convert(*p.Default); err != nil {
+			return fmt.Errorf("has an invalid default: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// convert checks the value is valid for the type of the parameter and
+// returns it the way steps see it, like true for a bool set to yes
+func (p *Parameter) convert(value string) (string, error) {
+	switch p.Type {
+	case ParameterInt:
+		number, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return "", fmt.Errorf("%s is not a whole number", value)
+		}
+
+		return strconv.Itoa(number), nil
+	case ParameterBool:
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "true", "yes", "1":
+			return "true", nil
+		case "false", "no", "0":
+			return "false", nil
+		}
+
+		return "", fmt.Errorf("%s is not true or false", value)
+	case ParameterEnum:
+		if !contains(p.Values, value) {
+			return "", fmt.Errorf("%s is not one of %s", value, strings.Join(p.Values, ", "))
+		}
+	}
+
+	return value, nil
+}
+
+// lookup returns the value of the parameter from the variables, its
+// environment variable, the variables of the profile or its default, and
+// false if it has none of them
+func (p *Parameter) lookup(variables map[string]string, profile map[string]string) (string, bool) {
+	if value, ok := variables[p.Name]; ok {
+		return value, true
+	}
+	if p.Env != "" {
+		if value, ok := os.LookupEnv(p.Env); ok {
+			return value, true
+		}
+	}
+	if value, ok := profile[p.Name]; ok {
+		return value, true
+	}
+	if p.Default != nil {
+		return *p.Default, true
+	}
+
+	return "", false
+}
+
+func (w *Workflow) findParameter(name string) *Parameter {
+	for _, parameter := range w.Parameters {
+		if parameter.Name == name {
+			return parameter
+		}
+	}
+
+	return nil
+}
+
+// validateParameters checks the definitions of the parameters of the
+// workflow
+func (w *Workflow) validateParameters() error {
+	var errors error
+
+	names := make(map[string]bool, len(w.Parameters))
+	for idx, parameter := range w.Parameters {
+		parameterID := fmt.Sprintf("parameter %d (%s)", idx+1, parameter.Name)
+		if err := parameter.validate(); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("%s %s", parameterID, err))
+		}
+		if names[parameter.Name] {
+			errors = multierror.Append(errors, fmt.Errorf("%s is defined more than once", parameterID))
+		}
+		if _, ok := w.Variables[parameter.Name]; ok && parameter.Name != "" {
+			errors = multierror.Append(errors, fmt.Errorf("%s is also a variable", parameterID))
+		}
+		names[parameter.Name] = true
+	}
+
+	return errors
+}
+
+// resolveParameters sets the parameters as variables, from the variables
+// given in the options, their environment variables, the profile or their
+// defaults. All missing and invalid values are returned in a ValidationError
+func (w *Workflow) resolveParameters() error {
+	var errors error
+
+	var profile map[string]string
+	if overlay := w.Profiles[w.profile]; overlay != nil {
+		profile = overlay.Variables
+	}
+	for _, parameter := range w.Parameters {
+		value, ok := parameter.lookup(w.options.Variables, profile)
+		if parameter.Required && value == "" {
+			errors = multierror.Append(errors, fmt.Errorf("parameter %s is required", parameter.Name))
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		converted, err := parameter.convert(value)
+		if err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("parameter %s is invalid: %s", parameter.Name, err))
+			continue
+		}
+		if parameter.Type == ParameterSecret && converted != "" {
+			AddSecretMask(converted)
+		}
+
+		if w.Variables == nil {
+			w.Variables = make(map[string]string, len(w.Parameters))
+		}
+		w.Variables[parameter.Name] = converted
+	}
+
+	return validationError(errors)
+}
@@ -0,0 +1,48 @@
+package utils
+
+import "context"
+
+// Pause stops the workflow from starting new steps until ResumeDispatch is
+// called. The steps that are running are left to finish. Pausing a workflow
+// before it runs makes it start paused
+func (w *Workflow) Pause() {
+	if !w.setPaused(true) {
+		return
+	}
+
+	w.logger.Warn("Paused. The running steps finish, but no new steps start until resumed")
+	w.push(context.Background(), NewWorkflowEvent(w, EventWorkflowPaused, nil))
+}
+
+// ResumeDispatch lets a paused workflow start new steps again
+func (w *Workflow) ResumeDispatch() {
+	if !w.setPaused(false) {
+		return
+	}
+
+	w.logger.Info("Resumed")
+	w.push(context.Background(), NewWorkflowEvent(w, EventWorkflowResumed, nil))
+}
+
+// Paused returns true if the workflow doesn't start new steps
+func (w *Workflow) Paused() bool {
+	w.signal.Lock()
+	defer w.signal.Unlock()
+
+	return w.paused
+}
+
+// setPaused returns false if the workflow already was paused or not
+func (w *Workflow) setPaused(paused bool) bool {
+	w.signal.Lock()
+	defer w.signal.Unlock()
+
+	if w.paused == paused {
+		return false
+	}
+
+	w.paused = paused
+	w.dispatch.Broadcast()
+
+	return true
+}
@@ -3,7 +3,8 @@ package utils
 // Payload is what's sent over to a notifier
 type Payload struct {
 	EventUUID string
+	Workflow  *Workflow
 	Spinner   *Spinner
 	Step      Step
-	Extras    interface{}
+	Extras    EventData
 }
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// executionPlan groups the steps into stages. All steps in a stage depend
+// only on steps from the previous stages and can run in parallel
+func (w *Workflow) executionPlan() ([][]*Step, error) {
+	var stages [][]*Step
+	planned := make(map[*Step]bool, len(w.Steps))
+
+	for len(planned) < len(w.Steps) {
+		var stage []*Step
+		for idx, step := range w.Steps {
+			if planned[step] {
+				continue
+			}
+
+			ready := true
+			for _, priorStep := range step.dependsOn {
+				if !planned[priorStep] {
+					ready = false
+					break
+				}
+			}
+
+			if ready {
+				stage = append(stage, w.Steps[idx])
+			}
+		}
+
+		if len(stage) == 0 {
+			return nil, fmt.Errorf("unable to plan the workflow. check depends_on for circular dependencies")
+		}
+
+		for _, step := range stage {
+			planned[step] = true
+		}
+		stages = append(stages, stage)
+	}
+
+	return stages, nil
+}
+
+// DryRun logs the execution plan of the workflow and the commands each step
+// would run without running any of them
+func (w *Workflow) DryRun(ctx context.Context) error {
+	if w.options == nil {
+		return ErrNoOptions
+	}
+
+	w.logger.Infof("Dry run of Workflow with Session ID %s", w.sessionID)
+	w.logProfileChanges()
+
+	stages, err := w.executionPlan()
+	if err != nil {
+		return err
+	}
+
+	for _, step := range w.allSteps() {
+		if err = step.EnrichStep(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, preflight := range w.preflights(ctx) {
+		w.logger.WithField(FldStep, fmt.Sprintf("%s.preflight", preflight.step.Name)).Infof("Preflight: %s", preflight.Command)
+	}
+
+	for idx, stage := range stages {
+		for _, step := range stage {
+			logger := w.logger.WithField(FldStep, step.Name)
+			if step.stage != "" {
+				logger = logger.WithField(FldStage, step.stage)
+			}
+			if step.Disabled {
+				logger.Infof("Stage %d: disabled", idx+1)
+				continue
+			}
+
+			logger.Infof("Stage %d: %s", idx+1, step.Command)
+			if len(step.DependsOn) != 0 {
+				var dependencies []string
+				for _, dependency := range step.DependsOn {
+					dependencies = append(dependencies, dependency.String())
+				}
+				logger.Infof("Depends on: %s", strings.Join(dependencies, ", "))
+			}
+			if step.Workdir != "" {
+				logger.Infof("Workdir: %s", step.Workdir)
+			}
+			if step.When != "" {
+				logger.Infof("When: %s", step.When)
+			}
+			if step.Service {
+				logger.Info("Service: runs until the workflow is done")
+			}
+			if step.Probe != nil {
+				logger.Infof("Probe: %s", step.Probe)
+			}
+			if step.Rollback != "" {
+				logger.Infof("Rollback: %s", step.Rollback)
+			}
+		}
+	}
+
+	for _, step := range w.Cleanup {
+		logger := w.logger.WithField(FldStep, step.Name)
+		if step.Disabled {
+			logger.Info("Cleanup: disabled")
+			continue
+		}
+
+		logger.Infof("Cleanup: %s", step.Command)
+		if step.When != "" {
+			logger.Infof("When: %s", step.When)
+		}
+	}
+
+	return nil
+}
+
+// logProfileChanges logs the values changed by the profile the workflow was
+// loaded with, or by each of its profiles without one
+func (w *Workflow) logProfileChanges() {
+	names := w.profileNames()
+	if w.profile != "" {
+		names = []string{w.profile}
+	}
+
+	for _, name := range names {
+		changes, err := w.ProfileChanges(name)
+		if err != nil {
+			continue
+		}
+		if w.profile != "" {
+			w.logger.Infof("Profile %s", name)
+		} else {
+			w.logger.Infof("Profile %s, when selected", name)
+		}
+		for _, change := range changes {
+			w.logger.Infof("  %s", change)
+		}
+		if len(changes) == 0 {
+			w.logger.Info("  changes nothing")
+		}
+	}
+}
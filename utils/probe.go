@@ -1,10 +1,135 @@
 package utils
 
-// Probe defines a checker for a Step's health
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Probe defines a checker for a Step's health. It runs a command, or checks
+// an HTTP URL or a TCP address
 type Probe struct {
-	Command string `yaml:"command" json:"command"`
-	Workdir string `yaml:"workdir" json:"workdir"`
+	Command string         `yaml:"command" json:"command"`
+	HTTP    string         `yaml:"http" json:"http"`
+	TCP     string         `yaml:"tcp" json:"tcp"`
+	Workdir string         `yaml:"workdir" json:"workdir"`
+	Timeout *time.Duration `yaml:"timeout" json:"timeout"`
+	// Interval is the time between the probes of a service
+	Interval *time.Duration `yaml:"interval" json:"interval"`
 
 	cmd  string
 	args []string
 }
+
+// String returns what the probe checks
+func (p *Probe) String() string {
+	switch {
+	case p.HTTP != "":
+		return fmt.Sprintf("http %s", p.HTTP)
+	case p.TCP != "":
+		return fmt.Sprintf("tcp %s", p.TCP)
+	default:
+		return p.Command
+	}
+}
+
+func (p *Probe) validate() error {
+	set := 0
+	for _, value := range []string{p.Command, p.HTTP, p.TCP} {
+		if strings.TrimSpace(value) != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("probe needs one of command, http or tcp")
+	}
+
+	if p.Interval != nil && *p.Interval <= 0 {
+		return fmt.Errorf("invalid probe interval %s", *p.Interval)
+	}
+
+	return nil
+}
+
+func (p *Probe) enrich(ctx context.Context, step *Step) error {
+	var err error
+
+	for _, attribute := range []*string{&p.Command, &p.HTTP, &p.TCP, &p.Workdir} {
+		if *attribute, err = step.parseAttribute(ctx, *attribute); err != nil {
+			return err
+		}
+		if *attribute, err = ExpandEnvVars(ctx, *attribute); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// run runs the probe once with the spinner
+func (p *Probe) run(ctx context.Context, spinner *Spinner) error {
+	if p.Command != "" {
+		return spinner.Run(ctx)
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, spinner.timeout)
+	defer cancel()
+
+	spinner.push(ctx, NewEvent(spinner, EventRunRequested, nil))
+	if err := p.check(checkCtx, spinner); err != nil {
+		spinner.push(ctx, NewEvent(spinner, EventRunError, nil))
+		return err
+	}
+	spinner.push(ctx, NewEvent(spinner, EventRunSuccess, nil))
+
+	return nil
+}
+
+// check returns nil if the probe passes. Unlike run, the output of a probe
+// command is only part of the error
+func (p *Probe) check(ctx context.Context, spinner *Spinner) error {
+	switch {
+	case p.HTTP != "":
+		req, err := http.NewRequest(http.MethodGet, p.HTTP, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("%s returned %s", p.HTTP, resp.Status)
+		}
+	case p.TCP != "":
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", p.TCP)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+	default:
+		cmd := exec.CommandContext(ctx, spinner.cmd, spinner.args...)
+		cmd.Env = append(os.Environ(), spinner.env...)
+		cmd.Dir = spinner.workdir
+
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			if message := strings.TrimSpace(string(output)); message != "" {
+				return fmt.Errorf("%s: %s", err, message)
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
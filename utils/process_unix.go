@@ -0,0 +1,85 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const defaultShell = "/bin/sh"
+
+// StartProcess starts the command in its own process group so stopping it
+// also stops any process it has started. When cancelled, the group is asked
+// to stop and is killed if it doesn't within the grace period. The function
+// it returns has to be called once cmd.Wait returns, so a group that's gone
+// isn't killed, as its ID could be used by another one by then
+func StartProcess(cmd *exec.Cmd, gracePeriod time.Duration) (func(), error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	var signal sync.Mutex
+	var kill *time.Timer
+	cmd.Cancel = func() error {
+		pgid := -cmd.Process.Pid
+		signal.Lock()
+		kill = time.AfterFunc(gracePeriod, func() {
+			_ = syscall.Kill(pgid, syscall.SIGKILL)
+		})
+		signal.Unlock()
+
+		return syscall.Kill(pgid, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = gracePeriod
+
+	if err := cmd.Start(); err != nil {
+		return func() {}, err
+	}
+
+	return func() {
+		signal.Lock()
+		defer signal.Unlock()
+
+		// processes left in the group keep its ID from being used again, so
+		// they are still killed at the end of the grace period
+		if kill != nil && syscall.Kill(-cmd.Process.Pid, 0) == syscall.ESRCH {
+			kill.Stop()
+		}
+	}, nil
+}
+
+// exitSignal returns the description of the signal that killed the command,
+// like killed for SIGKILL, if any
+func exitSignal(err error) string {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return ""
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+
+	return status.Signal().String()
+}
+
+// maxRSS returns the most memory the command held at once in bytes. It's
+// counted in kilobytes, except on macOS
+func maxRSS(state *os.ProcessState) int64 {
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	if runtime.GOOS == "darwin" {
+		return int64(usage.Maxrss)
+	}
+
+	return int64(usage.Maxrss) * 1024
+}
@@ -0,0 +1,223 @@
+//go:build windows
+// +build windows
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	defaultShell = "cmd"
+
+	processSetQuota         = 0x0100
+	processSuspendResume    = 0x0800
+	createSuspended         = 0x00000004
+	ctrlBreakEvent          = 1
+	jobObjectExtendedLimit  = 9
+	jobObjectKillOnJobClose = 0x00002000
+)
+
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObject          = kernel32.NewProc("CreateJobObjectW")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+	procGenerateConsoleCtrlEvent = kernel32.NewProc("GenerateConsoleCtrlEvent")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+
+	ntdll               = syscall.NewLazyDLL("ntdll.dll")
+	procNtResumeProcess = ntdll.NewProc("NtResumeProcess")
+)
+
+// jobObjectLimits is JOBOBJECT_EXTENDED_LIMIT_INFORMATION. Only LimitFlags
+// is set
+type jobObjectLimits struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+	IoCounters              [6]uint64
+	ProcessMemoryLimit      uintptr
+	JobMemoryLimit          uintptr
+	PeakProcessMemoryUsed   uintptr
+	PeakJobMemoryUsed       uintptr
+}
+
+// processJob is the job object holding a command and the processes it
+// starts, so they can be killed together
+type processJob struct {
+	signal *sync.Mutex
+	handle syscall.Handle
+}
+
+// StartProcess starts the command in a job object and its own process group.
+// When cancelled, the command is sent a Ctrl+Break and all the processes of
+// the job are killed if it doesn't stop within the grace period. The command
+// starts suspended and only runs once it's in the job, so all the processes
+// it starts are in the job too. The function it returns has to be called once
+// cmd.Wait returns, so nothing is killed after the command is gone
+func StartProcess(cmd *exec.Cmd, gracePeriod time.Duration) (func(), error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP | createSuspended
+	if isCmdShell(cmd.Args) {
+		cmd.SysProcAttr.CmdLine = cmdShellLine(cmd.Args)
+	}
+
+	job := &processJob{signal: &sync.Mutex{}}
+	var signal sync.Mutex
+	var kill *time.Timer
+	cmd.Cancel = func() error {
+		signal.Lock()
+		kill = time.AfterFunc(gracePeriod, func() {
+			if !job.terminate() {
+				_ = cmd.Process.Kill()
+			}
+		})
+		signal.Unlock()
+
+		// processes without a console can't be sent Ctrl+Break
+		r, _, _ := procGenerateConsoleCtrlEvent.Call(ctrlBreakEvent, uintptr(cmd.Process.Pid))
+		if r == 0 && !job.terminate() {
+			return cmd.Process.Kill()
+		}
+
+		return nil
+	}
+	cmd.WaitDelay = gracePeriod
+
+	if err := cmd.Start(); err != nil {
+		return func() {}, err
+	}
+
+	process, err := syscall.OpenProcess(syscall.SYNCHRONIZE|syscall.PROCESS_TERMINATE|processSetQuota|processSuspendResume, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return func() {}, err
+	}
+
+	// only the command itself is killed if the job can't be made
+	_ = job.assign(process)
+
+	if r, _, _ := procNtResumeProcess.Call(uintptr(process)); r != 0 {
+		syscall.CloseHandle(process)
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return func() {}, fmt.Errorf("failed to resume the process (status 0x%x)", r)
+	}
+	go job.closeOnExit(process)
+
+	return func() {
+		signal.Lock()
+		defer signal.Unlock()
+
+		if kill != nil {
+			kill.Stop()
+		}
+	}, nil
+}
+
+// assign creates the job and adds the process to it. The processes of the
+// job are killed when it's closed, after the process exited or when trackman
+// stops
+func (j *processJob) assign(process syscall.Handle) error {
+	handle, _, err := procCreateJobObject.Call(0, 0)
+	if handle == 0 {
+		return err
+	}
+
+	limits := jobObjectLimits{LimitFlags: jobObjectKillOnJobClose}
+	if r, _, err := procSetInformationJobObject.Call(handle, jobObjectExtendedLimit, uintptr(unsafe.Pointer(&limits)), unsafe.Sizeof(limits)); r == 0 {
+		syscall.CloseHandle(syscall.Handle(handle))
+		return err
+	}
+	if r, _, err := procAssignProcessToJobObject.Call(handle, uintptr(process)); r == 0 {
+		syscall.CloseHandle(syscall.Handle(handle))
+		return err
+	}
+
+	j.signal.Lock()
+	j.handle = syscall.Handle(handle)
+	j.signal.Unlock()
+
+	return nil
+}
+
+// closeOnExit waits for the process to exit and closes its handle and the
+// job
+func (j *processJob) closeOnExit(process syscall.Handle) {
+	_, _ = syscall.WaitForSingleObject(process, syscall.INFINITE)
+	syscall.CloseHandle(process)
+
+	j.signal.Lock()
+	defer j.signal.Unlock()
+	if j.handle != 0 {
+		syscall.CloseHandle(j.handle)
+		j.handle = 0
+	}
+}
+
+// terminate kills all the processes of the job. It returns false if there's
+// no job
+func (j *processJob) terminate() bool {
+	j.signal.Lock()
+	defer j.signal.Unlock()
+
+	if j.handle == 0 {
+		return false
+	}
+	r, _, _ := procTerminateJobObject.Call(uintptr(j.handle), 1)
+
+	return r != 0
+}
+
+// isCmdShell returns true if the arguments run a command with cmd.exe, as
+// returned by shellArgs
+func isCmdShell(args []string) bool {
+	if len(args) < 3 {
+		return false
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0])))
+
+	return name == "cmd" && strings.EqualFold(args[len(args)-2], "/C")
+}
+
+// cmdShellLine builds the command line of cmd.exe. cmd doesn't unquote its
+// arguments like other programs, so the command is passed as is, in the
+// quotes /S strips
+func cmdShellLine(args []string) string {
+	escaped := make([]string, 0, len(args))
+	for _, arg := range args[:len(args)-1] {
+		escaped = append(escaped, syscall.EscapeArg(arg))
+	}
+
+	return strings.Join(escaped, " ") + ` "` + args[len(args)-1] + `"`
+}
+
+// exitSignal returns the name of the signal that killed the command. Commands
+// aren't killed by signals on Windows
+func exitSignal(err error) string {
+	return ""
+}
+
+// maxRSS isn't known on Windows once the command exited
+func maxRSS(state *os.ProcessState) int64 {
+	return 0
+}
@@ -0,0 +1,277 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// Profile overlays the values of the workflow for an environment, like
+// staging or production. Its values replace the ones of the workflow when
+// it's selected
+type Profile struct {
+	// Variables replace the variables of the workflow with the same name
+	Variables map[string]string `yaml:"variables" json:"variables"`
+	// Env replaces the environment variables of the workflow with the same
+	// name, and adds the others
+	Env []string `yaml:"env" json:"env"`
+	// Timeout replaces the timeout of the workflow
+	Timeout *time.Duration `yaml:"timeout" json:"timeout"`
+	// Steps overlay the steps by name
+	Steps map[string]*ProfileStep `yaml:"steps" json:"steps"`
+}
+
+// ProfileStep overlays the values of a step for a profile
+type ProfileStep struct {
+	// Env replaces the environment variables of the step with the same name,
+	// and adds the others
+	Env []string `yaml:"env" json:"env"`
+	// Timeout replaces the timeout of the step
+	Timeout *time.Duration `yaml:"timeout" json:"timeout"`
+}
+
+// ProfileChange is a value of the workflow changed by a profile
+type ProfileChange struct {
+	// Value is what changed, like variables.region or steps.deploy.timeout
+	Value string `json:"value"`
+	// From is the value without the profile, empty if it had none
+	From string `json:"from"`
+	// To is the value with the profile
+	To string `json:"to"`
+}
+
+// String implements fmt.Stringer
+func (c *ProfileChange) String() string {
+	if c.From == "" {
+		return fmt.Sprintf("%s: %s", c.Value, c.To)
+	}
+
+	return fmt.Sprintf("%s: %s -> %s", c.Value, c.From, c.To)
+}
+
+func (p *Profile) validate(w *Workflow) error {
+	var errors error
+
+	if p.Timeout != nil && *p.Timeout <= 0 {
+		errors = multierror.Append(errors, fmt.Errorf("has an invalid timeout %s", *p.Timeout))
+	}
+	for _, env := range p.Env {
+		if !strings.Contains(env, "=") {
+			errors = multierror.Append(errors, fmt.Errorf("has an invalid env %s. use key=value", env))
+		}
+	}
+	for name, step := range p.Steps {
+		if w.findStepByName(name) == nil && w.findCleanupStep(name) == nil {
+			errors = multierror.Append(errors, fmt.Errorf("has an unknown step %s", name))
+			continue
+		}
+		if step == nil {
+			continue
+		}
+		if step.Timeout != nil && *step.Timeout <= 0 {
+			errors = multierror.Append(errors, fmt.Errorf("has an invalid timeout %s for step %s", *step.Timeout, name))
+		}
+		for _, env := range step.Env {
+			if !strings.Contains(env, "=") {
+				errors = multierror.Append(errors, fmt.Errorf("has an invalid env %s for step %s. use key=value", env, name))
+			}
+		}
+	}
+
+	return errors
+}
+
+// validateProfiles checks the profiles of the workflow
+func (w *Workflow) validateProfiles() error {
+	var errors error
+
+	for _, name := range w.profileNames() {
+		profile := w.Profiles[name]
+		if profile == nil {
+			continue
+		}
+		if err := profile.validate(w); err != nil {
+			for _, problem := range err.(*multierror.Error).Errors {
+				errors = multierror.Append(errors, fmt.Errorf("profile %s %s", name, problem))
+			}
+		}
+	}
+
+	return errors
+}
+
+// applyProfile overlays the workflow with the profile with the name, and
+// keeps the values it changed. It fails if the workflow has no such profile
+func (w *Workflow) applyProfile(name string) error {
+	profile, ok := w.Profiles[name]
+	if !ok {
+		return validationError(fmt.Errorf("profile %s is not defined", name))
+	}
+
+	w.profile = name
+	w.profileChanges = w.changesOf(profile)
+	if profile == nil {
+		return nil
+	}
+
+	if len(profile.Variables) != 0 && w.Variables == nil {
+		w.Variables = make(map[string]string, len(profile.Variables))
+	}
+	for key, value := range profile.Variables {
+		w.Variables[key] = value
+	}
+	w.Env = overlayEnv(w.Env, profile.Env)
+	if profile.Timeout != nil {
+		w.Timeout = profile.Timeout
+	}
+	for name, overlay := range profile.Steps {
+		step := w.findStepByName(name)
+		if step == nil {
+			step = w.findCleanupStep(name)
+		}
+		if step == nil || overlay == nil {
+			continue
+		}
+
+		step.Env = overlayEnv(step.Env, overlay.Env)
+		if overlay.Timeout != nil {
+			step.Timeout = overlay.Timeout
+		}
+	}
+
+	return nil
+}
+
+// Profile returns the name of the profile the workflow was loaded with, or
+// empty if it has none
+func (w *Workflow) Profile() string {
+	return w.profile
+}
+
+// ProfileChanges returns the values of the workflow the profile with the
+// name changes. For the profile the workflow was loaded with, they are the
+// values it changed
+func (w *Workflow) ProfileChanges(name string) ([]*ProfileChange, error) {
+	if name != "" && name == w.profile {
+		return w.profileChanges, nil
+	}
+
+	profile, ok := w.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %s is not defined", name)
+	}
+
+	return w.changesOf(profile), nil
+}
+
+// changesOf returns the values of the workflow the profile would change, in
+// the order of the workflow
+func (w *Workflow) changesOf(profile *Profile) []*ProfileChange {
+	if profile == nil {
+		return nil
+	}
+
+	var changes []*ProfileChange
+	for _, key := range sortedKeys(profile.Variables) {
+		from, ok := w.Variables[key]
+		if parameter := w.findParameter(key); !ok && parameter != nil && parameter.Default != nil {
+			from = *parameter.Default
+		}
+		if from != profile.Variables[key] {
+			changes = append(changes, &ProfileChange{Value: "variables." + key, From: from, To: profile.Variables[key]})
+		}
+	}
+	changes = append(changes, envChanges("env", w.Env, profile.Env)...)
+	if profile.Timeout != nil && (w.Timeout == nil || *w.Timeout != *profile.Timeout) {
+		changes = append(changes, &ProfileChange{Value: "timeout", From: durationString(w.Timeout), To: profile.Timeout.String()})
+	}
+	for _, step := range append(append([]*Step{}, w.Steps...), w.Cleanup...) {
+		overlay := profile.Steps[step.Name]
+		if overlay == nil {
+			continue
+		}
+
+		changes = append(changes, envChanges("steps."+step.Name+".env", step.Env, overlay.Env)...)
+		if overlay.Timeout != nil && (step.Timeout == nil || *step.Timeout != *overlay.Timeout) {
+			changes = append(changes, &ProfileChange{Value: "steps." + step.Name + ".timeout", From: durationString(step.Timeout), To: overlay.Timeout.String()})
+		}
+	}
+
+	return changes
+}
+
+// profileNames returns the names of the profiles in order
+func (w *Workflow) profileNames() []string {
+	names := make([]string, 0, len(w.Profiles))
+	for name := range w.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (w *Workflow) findCleanupStep(name string) *Step {
+	for _, step := range w.Cleanup {
+		if step.Name == name {
+			return step
+		}
+	}
+
+	return nil
+}
+
+// overlayEnv replaces the environment variables of env with the ones of
+// overlay with the same name, and adds the others
+func overlayEnv(env []string, overlay []string) []string {
+	if len(overlay) == 0 {
+		return env
+	}
+
+	result := append([]string{}, env...)
+	for _, item := range overlay {
+		key := strings.SplitN(item, "=", 2)[0]
+		replaced := false
+		for idx, existing := range result {
+			if strings.SplitN(existing, "=", 2)[0] == key {
+				result[idx] = item
+				replaced = true
+			}
+		}
+		if !replaced {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+// envChanges returns the environment variables of env the overlay changes
+func envChanges(prefix string, env []string, overlay []string) []*ProfileChange {
+	var changes []*ProfileChange
+	for _, item := range overlay {
+		parts := strings.SplitN(item, "=", 2)
+		from := ""
+		for _, existing := range env {
+			if existingParts := strings.SplitN(existing, "=", 2); existingParts[0] == parts[0] && len(existingParts) == 2 {
+				from = existingParts[1]
+			}
+		}
+		if to := parts[len(parts)-1]; from != to {
+			changes = append(changes, &ProfileChange{Value: prefix + "." + parts[0], From: from, To: to})
+		}
+	}
+
+	return changes
+}
+
+func durationString(duration *time.Duration) string {
+	if duration == nil {
+		return ""
+	}
+
+	return duration.String()
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// StepTypeQuorum succeeds if enough of the steps it depends on succeed
+const StepTypeQuorum = "quorum"
+
+// Quorum configures a quorum step
+type Quorum struct {
+	// MinSuccess is how many of the steps the quorum step depends on have to
+	// succeed, like 2, or a percentage of them, like 50%
+	MinSuccess string `yaml:"min_success" json:"min_success"`
+}
+
+// QuorumPayload is the data of the run.degraded event of a quorum step, and
+// of its run.fail event if it failed
+type QuorumPayload struct {
+	// Required is how many steps had to succeed
+	Required  int      `json:"required"`
+	Succeeded []string `json:"succeeded"`
+	Failed    []string `json:"failed"`
+}
+
+// required returns how many of the given number of steps have to succeed
+func (q *Quorum) required(steps int) (int, error) {
+	value := strings.TrimSpace(q.MinSuccess)
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return 0, fmt.Errorf("invalid min_success %s. Use a percentage over 0%% and up to 100%%", q.MinSuccess)
+		}
+
+		return int(math.Ceil(float64(steps) * percent / 100)), nil
+	}
+
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("invalid min_success %s. Use a number of steps or a percentage like 50%%", q.MinSuccess)
+	}
+	if count > steps {
+		return 0, fmt.Errorf("min_success %d is more than the %d steps it depends on", count, steps)
+	}
+
+	return count, nil
+}
+
+func (s *Step) validateQuorum() error {
+	if s.Quorum == nil {
+		return fmt.Errorf("quorum steps need a quorum")
+	}
+	if s.Command != "" || s.Probe != nil || s.Foreach != nil || s.Agent != nil || s.Retry != nil {
+		return fmt.Errorf("quorum steps can't have a command, probe, foreach, agent or retry")
+	}
+	if len(s.DependsOn) == 0 {
+		return fmt.Errorf("quorum steps need to depend on some steps")
+	}
+	for _, dependency := range s.DependsOn {
+		if dependency.Status != "" {
+			return fmt.Errorf("quorum steps can't depend on the status of %s", dependency.Step)
+		}
+	}
+
+	_, err := s.Quorum.required(len(s.DependsOn))
+
+	return err
+}
+
+// runQuorum checks enough of the steps the step depends on succeeded. It's
+// degraded if the quorum is met but some of them failed. Skipped and
+// disabled steps count as neither
+func (s *Step) runQuorum(ctx context.Context, spinner *Spinner) error {
+	spinner.push(ctx, NewEvent(spinner, EventRunRequested, nil))
+
+	required, err := s.Quorum.required(len(s.dependsOn))
+	if err != nil {
+		spinner.push(ctx, NewEvent(spinner, EventRunError, nil))
+		return err
+	}
+
+	payload := &QuorumPayload{Required: required}
+	for _, priorStep := range s.dependsOn {
+		switch {
+		case priorStep.err != nil:
+			payload.Failed = append(payload.Failed, priorStep.Name)
+		case !priorStep.skipped && !priorStep.Disabled:
+			payload.Succeeded = append(payload.Succeeded, priorStep.Name)
+		}
+	}
+
+	if len(payload.Succeeded) < required {
+		spinner.push(ctx, NewEvent(spinner, EventRunFail, &RunFailPayload{Quorum: payload}))
+		return fmt.Errorf("only %d of %d steps succeeded, %d needed", len(payload.Succeeded), len(s.dependsOn), required)
+	}
+
+	if len(payload.Failed) != 0 {
+		s.update(func() { s.degraded = true })
+		spinner.push(ctx, NewEvent(spinner, EventRunDegraded, payload))
+
+		return nil
+	}
+
+	spinner.push(ctx, NewEvent(spinner, EventRunSuccess, nil))
+
+	return nil
+}
+
+// markQuorums lets the steps quorum steps depend on fail without stopping
+// the workflow, so the quorum steps decide if it fails
+func (w *Workflow) markQuorums() {
+	for _, step := range w.Steps {
+		if step.Type != StepTypeQuorum {
+			continue
+		}
+
+		for _, priorStep := range step.dependsOn {
+			priorStep.inQuorum = true
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit limits how often steps start, on top of the concurrency of the
+// workflow. Starts are spread over the interval, with up to burst of them
+// at once after a quiet period
+type RateLimit struct {
+	// Starts is the number of steps that can start in each interval
+	Starts int `yaml:"starts" json:"starts"`
+	// Interval defaults to a second
+	Interval time.Duration `yaml:"interval" json:"interval"`
+	// Burst is the number of steps that can start at once. Defaults to 1
+	Burst int `yaml:"burst" json:"burst"`
+}
+
+// ParseRateLimit parses a rate limit like 5/10s, or 5/s for 5 steps a
+// second, with an optional burst like 5/s:5
+func ParseRateLimit(value string) (*RateLimit, error) {
+	invalid := fmt.Errorf("invalid rate limit %s. use starts/interval like 5/s or 5/10s:5", value)
+
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return nil, invalid
+	}
+	starts, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, invalid
+	}
+
+	limit := &RateLimit{Starts: starts}
+	interval := parts[1]
+	if idx := strings.Index(interval, ":"); idx != -1 {
+		if limit.Burst, err = strconv.Atoi(interval[idx+1:]); err != nil {
+			return nil, invalid
+		}
+		interval = interval[:idx]
+	}
+	// 5/s is 5/1s
+	if interval != "" && (interval[0] < '0' || interval[0] > '9') {
+		interval = "1" + interval
+	}
+	if limit.Interval, err = time.ParseDuration(interval); err != nil {
+		return nil, invalid
+	}
+
+	if err = limit.validate(); err != nil {
+		return nil, err
+	}
+
+	return limit, nil
+}
+
+func (r *RateLimit) validate() error {
+	if r.Starts < 1 {
+		return fmt.Errorf("rate limit needs starts of at least 1")
+	}
+	if r.Interval < 0 {
+		return fmt.Errorf("rate limit has an invalid interval %s", r.Interval)
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("rate limit has an invalid burst %d", r.Burst)
+	}
+
+	return nil
+}
+
+// String implements fmt.Stringer
+func (r *RateLimit) String() string {
+	return fmt.Sprintf("%d/%s", r.Starts, r.interval())
+}
+
+func (r *RateLimit) interval() time.Duration {
+	if r.Interval == 0 {
+		return time.Second
+	}
+
+	return r.Interval
+}
+
+// rateLimiter is a token bucket. It's full when created, and a token is
+// added every interval divided by the starts
+type rateLimiter struct {
+	every  time.Duration
+	burst  float64
+	tokens float64
+	last   time.Time
+	signal *sync.Mutex
+}
+
+func newRateLimiter(limit *RateLimit) *rateLimiter {
+	burst := limit.Burst
+	if burst == 0 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		every:  limit.interval() / time.Duration(limit.Starts),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		signal: &sync.Mutex{},
+	}
+}
+
+// wait takes a token, waiting for it if there is none. The token is given
+// back if the context is done first
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.signal.Lock()
+	now := time.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.every)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	delay := time.Duration(0)
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens * float64(l.every))
+	}
+	l.signal.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.signal.Lock()
+		l.tokens++
+		l.signal.Unlock()
+
+		return ctx.Err()
+	}
+}
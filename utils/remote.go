@@ -0,0 +1,256 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RemoteOptions configures how workflows and their includes are fetched from
+// URLs and git repositories
+type RemoteOptions struct {
+	// Headers are added to the http(s) requests, like Authorization. git
+	// sends them to https repositories too
+	Headers map[string]string
+	// Checksum is the SHA-256 of the workflow file in hex. The workflow isn't
+	// loaded if it's different. Includes aren't checked
+	Checksum string
+	// S3 configures the s3:// sources. Missing options are taken from the
+	// AWS environment variables
+	S3 *S3Options
+	// Timeout of each request. Defaults to 30 seconds
+	Timeout time.Duration
+}
+
+// S3Options configures how workflows are read from S3
+type S3Options struct {
+	Region string
+	// Endpoint overrides the endpoint of the region, like for a VPC endpoint
+	// or an S3 compatible store. Buckets are in the path of the endpoint
+	Endpoint    string
+	Credentials AWSCredentials
+}
+
+// LoadWorkflowFromURL loads a workflow from an https:// or s3:// URL, or from
+// a git repository like git::https://github.com/org/repo.git//deploy.yml?ref=v1.
+// Relative includes are read from the same place as the workflow
+func LoadWorkflowFromURL(ctx context.Context, options *WorkflowOptions, location string, remote *RemoteOptions) (*Workflow, error) {
+	options = options.withDefaults(ctx)
+	if remote == nil {
+		remote = &RemoteOptions{}
+	}
+
+	var buff []byte
+	var source *includeSource
+	// path is where the workflow was read from
+	var path string
+	if strings.HasPrefix(location, "git::") {
+		dir, file, err := cloneGit(ctx, strings.TrimPrefix(location, "git::"), remote)
+		if dir != "" {
+			// the repository is only needed to read the workflow and its includes
+			defer os.RemoveAll(dir)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if buff, err = ioutil.ReadFile(file); err != nil {
+			return nil, err
+		}
+		source = &includeSource{dir: filepath.Dir(file), remote: remote}
+		path = file
+	} else {
+		parsed, err := url.Parse(location)
+		if err != nil {
+			return nil, err
+		}
+		if !isRemote(parsed) {
+			return nil, fmt.Errorf("unsupported workflow url %s. Valid urls start with https://, http://, s3:// or git::", location)
+		}
+
+		if buff, err = fetchURL(ctx, parsed, remote); err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %s", location, err)
+		}
+		source = &includeSource{url: parsed, remote: remote}
+		path = location
+	}
+
+	if remote.Checksum != "" {
+		checksum := strings.TrimPrefix(strings.ToLower(remote.Checksum), "sha256:")
+		if actual := hashHex(buff); actual != checksum {
+			return nil, fmt.Errorf("checksum of %s is %s instead of %s", location, actual, checksum)
+		}
+	}
+
+	if options.Verifier != nil && len(options.Signature) == 0 {
+		signature, err := source.signature(path, options.Verifier.Extension())
+		if err != nil {
+			return nil, fmt.Errorf("workflow %s isn't signed: %s", location, err)
+		}
+		options.Signature = signature
+	}
+
+	return loadWorkflow(ctx, options, buff, source)
+}
+
+// isRemote returns true for the URLs of the workflows that can be fetched
+func isRemote(location *url.URL) bool {
+	return location.Scheme == "https" || location.Scheme == "http" || location.Scheme == "s3"
+}
+
+// fetchURL returns the content of the file at the http(s) or s3 URL
+func fetchURL(ctx context.Context, location *url.URL, remote *RemoteOptions) ([]byte, error) {
+	timeout := remote.Timeout
+	if timeout == 0 {
+		timeout = includeTimeout
+	}
+
+	var req *http.Request
+	var err error
+	if location.Scheme == "s3" {
+		req, err = s3Request(ctx, location, remote.S3)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, location.String(), nil)
+		for name, value := range remote.Headers {
+			req.Header.Set(name, value)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// s3Request returns the request to get the object at the s3://bucket/key
+// URL. Requests without credentials are sent unsigned, for public buckets
+func s3Request(ctx context.Context, location *url.URL, options *S3Options) (*http.Request, error) {
+	resolved := S3Options{}
+	if options != nil {
+		resolved = *options
+	}
+	if resolved.Region == "" {
+		resolved.Region = os.Getenv("AWS_REGION")
+	}
+	if resolved.Region == "" {
+		resolved.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if resolved.Region == "" {
+		resolved.Region = "us-east-1"
+	}
+	if resolved.Credentials.AccessKeyID == "" {
+		resolved.Credentials = AWSCredentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+
+	bucket := location.Host
+	key := strings.TrimPrefix(location.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid s3 url %s. Use s3://bucket/key", location)
+	}
+
+	var object *url.URL
+	var err error
+	if resolved.Endpoint != "" {
+		object, err = url.Parse(strings.TrimSuffix(resolved.Endpoint, "/"))
+		if err != nil {
+			return nil, err
+		}
+		object.Path += "/" + bucket + "/" + key
+	} else {
+		object = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, resolved.Region), Path: "/" + key}
+	}
+	// the path is signed as it's sent
+	object.RawPath = awsEscapePath(object.Path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, object.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if resolved.Credentials.AccessKeyID != "" {
+		req.Header.Set("X-Amz-Content-Sha256", hashHex(nil))
+		SignAWSRequest(req, nil, &resolved.Credentials, resolved.Region, "s3", time.Now())
+	}
+
+	return req, nil
+}
+
+// cloneGit checks out the repository of the git source, like
+// https://github.com/org/repo.git//deploy.yml?ref=v1, into a temporary
+// directory and returns it with the path of the workflow file in it. The ref
+// can be a branch, a tag or a commit and defaults to the default branch
+func cloneGit(ctx context.Context, source string, remote *RemoteOptions) (string, string, error) {
+	ref := "HEAD"
+	if idx := strings.LastIndex(source, "?"); idx != -1 {
+		query, err := url.ParseQuery(source[idx+1:])
+		if err != nil {
+			return "", "", fmt.Errorf("invalid git source %s: %s", source, err)
+		}
+		if value := query.Get("ref"); value != "" {
+			ref = value
+		}
+		source = source[:idx]
+	}
+
+	// the file is after // in the source, skipping the one of the scheme
+	start := 0
+	if idx := strings.Index(source, "://"); idx != -1 {
+		start = idx + 3
+	}
+	idx := strings.Index(source[start:], "//")
+	if idx == -1 {
+		return "", "", fmt.Errorf("invalid git source %s. Add the path of the workflow in the repository after //, like repo.git//deploy.yml", source)
+	}
+	repository := source[:start+idx]
+	file := filepath.Clean(filepath.FromSlash(source[start+idx+2:]))
+	if filepath.IsAbs(file) || file == ".." || strings.HasPrefix(file, ".."+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("invalid workflow path %s in git source", file)
+	}
+
+	dir, err := ioutil.TempDir("", "trackman-git")
+	if err != nil {
+		return "", "", err
+	}
+
+	var options []string
+	for name, value := range remote.Headers {
+		options = append(options, "-c", fmt.Sprintf("http.extraHeader=%s: %s", name, value))
+	}
+	commands := [][]string{
+		{"init", "-q"},
+		append(options, "fetch", "-q", "--depth", "1", repository, ref),
+		{"checkout", "-q", "FETCH_HEAD"},
+	}
+	for _, args := range commands {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		// fail instead of asking for credentials
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return dir, "", fmt.Errorf("failed to get %s from %s: %s %s", ref, repository, err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	return dir, filepath.Join(dir, file), nil
+}
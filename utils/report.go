@@ -0,0 +1,302 @@
+package utils
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	// ReportJSON is a JSON report of the workflow result
+	ReportJSON = "json"
+	// ReportJUnit is a JUnit XML report with a test case for each step
+	ReportJUnit = "junit"
+)
+
+type jsonReport struct {
+	*WorkflowResult
+	// ExitCode is the exit code of trackman for the result
+	ExitCode int               `json:"exit_code"`
+	Steps    []*jsonStepReport `json:"steps"`
+	Errors   []string          `json:"errors,omitempty"`
+}
+
+type jsonStepReport struct {
+	*StepResult
+	Retries int    `json:"retries"`
+	Error   string `json:"error,omitempty"`
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name       string          `xml:"name,attr"`
+	ClassName  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitMessage   `xml:"failure,omitempty"`
+	Skipped    *junitMessage   `xml:"skipped,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+}
+
+// WriteReport writes a summary of the result in the given format. Secrets
+// are masked in it
+func (r *WorkflowResult) WriteReport(w io.Writer, format string) error {
+	masked := newMaskingWriter(w, r.redactions)
+
+	var err error
+	switch format {
+	case ReportJSON:
+		err = r.writeJSONReport(masked)
+	case ReportJUnit:
+		err = r.writeJUnitReport(masked)
+	default:
+		return fmt.Errorf("invalid report format %s", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	return masked.Flush()
+}
+
+func (r *WorkflowResult) writeJSONReport(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(r.jsonReport())
+}
+
+func (r *WorkflowResult) jsonReport() *jsonReport {
+	report := &jsonReport{WorkflowResult: r, ExitCode: r.ExitCode()}
+	if merr, ok := r.Errors.(*multierror.Error); ok {
+		for _, err := range merr.Errors {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	} else if r.Errors != nil {
+		report.Errors = append(report.Errors, r.Errors.Error())
+	}
+
+	for _, step := range r.Steps {
+		stepReport := &jsonStepReport{StepResult: step}
+		if step.Attempts > 1 {
+			stepReport.Retries = step.Attempts - 1
+		}
+		if step.Error != nil {
+			stepReport.Error = step.Error.Error()
+		}
+
+		report.Steps = append(report.Steps, stepReport)
+	}
+
+	return report
+}
+
+func (r *WorkflowResult) writeJUnitReport(w io.Writer) error {
+	suite := r.junitSuite()
+	report := &junitTestSuites{
+		Name:     "trackman",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}
+
+	return writeJUnit(w, report)
+}
+
+// junitSuite returns the test suite of the workflow, with a test case for
+// each step
+func (r *WorkflowResult) junitSuite() junitTestSuite {
+	suite := junitTestSuite{
+		Name:      r.SessionID,
+		Tests:     len(r.Steps),
+		Time:      seconds(r.Duration.Seconds()),
+		Timestamp: r.StartedAt.Format("2006-01-02T15:04:05"),
+	}
+
+	for _, step := range r.Steps {
+		className := step.Stage
+		if className == "" {
+			className = "trackman"
+		}
+
+		testCase := junitTestCase{
+			Name:      step.Name,
+			ClassName: className,
+			Time:      seconds(step.Duration.Seconds()),
+			Properties: []junitProperty{
+				{Name: "attempts", Value: strconv.Itoa(step.Attempts)},
+				{Name: "exit_code", Value: strconv.Itoa(step.ExitCode)},
+			},
+		}
+		if step.Signal != "" {
+			testCase.Properties = append(testCase.Properties, junitProperty{Name: "signal", Value: step.Signal})
+		}
+		if usage := step.Usage; usage != nil {
+			testCase.Properties = append(testCase.Properties,
+				junitProperty{Name: "max_rss", Value: strconv.FormatInt(usage.MaxRSS, 10)},
+				junitProperty{Name: "user_time", Value: seconds(usage.UserTime.Seconds())},
+				junitProperty{Name: "system_time", Value: seconds(usage.SystemTime.Seconds())},
+				junitProperty{Name: "wall_time", Value: seconds(usage.WallTime.Seconds())},
+			)
+		}
+
+		switch step.Status {
+		case ResultFailed:
+			message := "failed"
+			if step.Error != nil {
+				message = step.Error.Error()
+			}
+
+			testCase.Failure = &junitMessage{Message: message, Type: step.failureType()}
+			suite.Failures++
+		case ResultSkipped, ResultCached, ResultDisabled, ResultNotRun, ResultRunning, ResultCancelled:
+			testCase.Skipped = &junitMessage{Message: step.Status}
+			suite.Skipped++
+		}
+
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	return suite
+}
+
+func writeJUnit(w io.Writer, report *junitTestSuites) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(value float64) string {
+	return strconv.FormatFloat(value, 'f', 3, 64)
+}
+
+// failureType returns why the failed step failed as the type of its JUnit
+// failure
+func (r *StepResult) failureType() string {
+	switch {
+	case r.OOMKilled:
+		return "out of memory"
+	case r.TimedOut:
+		return "timeout"
+	case r.Signal != "":
+		return fmt.Sprintf("signal %s", r.Signal)
+	default:
+		return fmt.Sprintf("exit code %d", r.ExitCode)
+	}
+}
+
+type jsonSetReport struct {
+	*WorkflowSetResult
+	// ExitCode is the exit code of trackman for the result
+	ExitCode  int                      `json:"exit_code"`
+	Workflows []*jsonSetWorkflowReport `json:"workflows"`
+}
+
+type jsonSetWorkflowReport struct {
+	*WorkflowSetRunResult
+	Result *jsonReport `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// WriteReport writes a summary of the results of the workflows in the given
+// format. JUnit reports have a test suite for each workflow that ran.
+// Secrets are masked in it
+func (r *WorkflowSetResult) WriteReport(w io.Writer, format string) error {
+	var maskers []*masker
+	for _, item := range r.Workflows {
+		if item.Result != nil {
+			maskers = append(maskers, item.Result.redactions)
+		}
+	}
+	masked := newMaskingWriter(w, maskers...)
+
+	var err error
+	switch format {
+	case ReportJSON:
+		report := &jsonSetReport{WorkflowSetResult: r, ExitCode: r.ExitCode()}
+		for _, item := range r.Workflows {
+			workflowReport := &jsonSetWorkflowReport{WorkflowSetRunResult: item}
+			if item.Result != nil {
+				workflowReport.Result = item.Result.jsonReport()
+			}
+			if item.Error != nil {
+				workflowReport.Error = item.Error.Error()
+			}
+
+			report.Workflows = append(report.Workflows, workflowReport)
+		}
+
+		encoder := json.NewEncoder(masked)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	case ReportJUnit:
+		report := &junitTestSuites{
+			Name: "trackman",
+			Time: seconds(r.Duration.Seconds()),
+		}
+		for _, item := range r.Workflows {
+			if item.Result == nil {
+				continue
+			}
+
+			suite := item.Result.junitSuite()
+			suite.Name = item.Name
+			report.Tests += suite.Tests
+			report.Failures += suite.Failures
+			report.Skipped += suite.Skipped
+			report.Suites = append(report.Suites, suite)
+		}
+
+		err = writeJUnit(masked, report)
+	default:
+		return fmt.Errorf("invalid report format %s", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	return masked.Flush()
+}
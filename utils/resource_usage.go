@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// ResourceUsage is what the command of a step used, as counted by the
+// operating system when it exited. It includes the processes the command
+// started and waited for
+type ResourceUsage struct {
+	// MaxRSS is the most memory the command held at once, in bytes. 0 where
+	// it isn't known, like on Windows
+	MaxRSS int64 `json:"max_rss"`
+	// UserTime is the CPU time spent running the command
+	UserTime time.Duration `json:"user_time"`
+	// SystemTime is the CPU time spent in the kernel for the command
+	SystemTime time.Duration `json:"system_time"`
+	// WallTime is how long the command ran
+	WallTime time.Duration `json:"wall_time"`
+}
+
+func newResourceUsage(state *os.ProcessState, startedAt time.Time) *ResourceUsage {
+	if state == nil {
+		return nil
+	}
+
+	return &ResourceUsage{
+		MaxRSS:     maxRSS(state),
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+		WallTime:   time.Since(startedAt),
+	}
+}
+
+// CPUTime returns the user and system CPU time of the command
+func (u *ResourceUsage) CPUTime() time.Duration {
+	return u.UserTime + u.SystemTime
+}
+
+// String implements fmt.Stringer
+func (u *ResourceUsage) String() string {
+	usage := fmt.Sprintf("%s of CPU in %s", u.CPUTime().Round(time.Millisecond), u.WallTime.Round(time.Millisecond))
+	if u.MaxRSS != 0 {
+		usage += fmt.Sprintf(", %.1f MB of memory", float64(u.MaxRSS)/(1024*1024))
+	}
+
+	return usage
+}
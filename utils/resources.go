@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ResourceLimits constrains the resources the process of a step can use
+type ResourceLimits struct {
+	// Nice is the niceness of the processes of the step, from -20 to 19
+	Nice *int `yaml:"nice" json:"nice"`
+	// CPUs are the CPUs the step can run on
+	CPUs []int `yaml:"cpus" json:"cpus"`
+	// Memory is the most memory the step can use, like 512M or 2G. Steps
+	// using more are killed
+	Memory string `yaml:"memory" json:"memory"`
+	// OpenFiles is the most files the step can have open at once
+	OpenFiles uint64 `yaml:"open_files" json:"open_files"`
+}
+
+// LimitExceeded is the payload of EventRunLimitExceeded
+type LimitExceeded struct {
+	Resource string `json:"resource"`
+	Limit    string `json:"limit"`
+}
+
+// killedByOOM returns true if the command was killed with SIGKILL, or the
+// shell running it exited as if it was, while the OOM killer killed a
+// process. before is the count of oomKills before the command started
+func killedByOOM(err error, before int64) bool {
+	if before < 0 {
+		return false
+	}
+
+	code, _ := exitCode(err)
+	if exitSignal(err) != syscall.SIGKILL.String() && code != 128+int(syscall.SIGKILL) {
+		return false
+	}
+
+	return oomKills() > before
+}
+
+func (r *ResourceLimits) validate() error {
+	if r.Nice != nil && (*r.Nice < -20 || *r.Nice > 19) {
+		return fmt.Errorf("invalid nice %d. Use a value from -20 to 19", *r.Nice)
+	}
+	for _, cpu := range r.CPUs {
+		if cpu < 0 {
+			return fmt.Errorf("invalid cpu %d", cpu)
+		}
+	}
+	if r.Memory != "" {
+		if _, err := parseMemory(r.Memory); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseMemory returns the number of bytes of a size like 512M, 2G or 1024.
+// Units are powers of 1024
+func parseMemory(value string) (int64, error) {
+	size := strings.ToUpper(strings.TrimSpace(value))
+	size = strings.TrimSuffix(strings.TrimSuffix(size, "B"), "I")
+
+	multiplier := int64(1)
+	for idx, unit := range "KMGT" {
+		if strings.HasSuffix(size, string(unit)) {
+			size = strings.TrimSuffix(size, string(unit))
+			multiplier = int64(1) << (10 * uint(idx+1))
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(size, 64)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("invalid memory %s. Use a size like 512M or 2G", value)
+	}
+
+	return int64(number * float64(multiplier)), nil
+}
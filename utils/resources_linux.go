@@ -0,0 +1,249 @@
+//go:build linux
+// +build linux
+
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/hashicorp/go-multierror"
+	"golang.org/x/sys/unix"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// resourceGroup enforces the resource limits of a step on its process. The
+// memory limit uses a cgroup v2 created for the step, in the cgroup of
+// trackman or the one in TRACKMAN_CGROUP
+type resourceGroup struct {
+	limits *ResourceLimits
+	name   string
+	// dir is the cgroup of the step
+	dir    string
+	cgroup *os.File
+	// errors are the limits that couldn't be set on the thread starting the
+	// process
+	errors *multierror.Error
+}
+
+func newResourceGroup(limits *ResourceLimits, name string) *resourceGroup {
+	return &resourceGroup{limits: limits, name: name}
+}
+
+// prepare sets up what has to be in place before the process starts
+func (r *resourceGroup) prepare(cmd *exec.Cmd) error {
+	if r.limits.Memory == "" {
+		return nil
+	}
+
+	memory, err := parseMemory(r.limits.Memory)
+	if err != nil {
+		return err
+	}
+
+	if _, err = os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("memory limits need cgroups v2")
+	}
+
+	parent := os.Getenv("TRACKMAN_CGROUP")
+	if parent == "" {
+		if parent, err = ownCgroup(); err != nil {
+			return err
+		}
+	} else if !strings.HasPrefix(parent, cgroupRoot) {
+		parent = filepath.Join(cgroupRoot, parent)
+	}
+
+	if err = enableMemoryController(parent); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(parent, r.name)
+	if err = os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	r.dir = dir
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatInt(memory, 10)), 0644); err != nil {
+		return err
+	}
+	// swapping would let the step go over its limit. Not all kernels have it
+	_ = ioutil.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0"), 0644)
+
+	if r.cgroup, err = os.Open(dir); err != nil {
+		return err
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(r.cgroup.Fd())
+
+	return nil
+}
+
+// start starts the process with its niceness and CPUs. They are set on a
+// thread of its own the process is forked from, so the process has them
+// before it runs and all the processes it starts inherit them
+func (r *resourceGroup) start(start func() error) error {
+	if r.limits.Nice == nil && len(r.limits.CPUs) == 0 {
+		return start()
+	}
+
+	result := make(chan error)
+	go func() {
+		// the thread ends with the goroutine as it's never unlocked, so the
+		// limits don't apply to anything else in trackman
+		runtime.LockOSThread()
+
+		r.limitThread()
+		result <- start()
+	}()
+
+	return <-result
+}
+
+// limitThread sets the niceness and CPUs of the calling thread
+func (r *resourceGroup) limitThread() {
+	if r.limits.Nice != nil {
+		// on Linux, the priority of a process is the one of its thread
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, *r.limits.Nice); err != nil {
+			r.errors = multierror.Append(r.errors, fmt.Errorf("failed to set nice: %s", err))
+		}
+	}
+
+	if len(r.limits.CPUs) != 0 {
+		set := &unix.CPUSet{}
+		for _, cpu := range r.limits.CPUs {
+			set.Set(cpu)
+		}
+		if err := unix.SchedSetaffinity(0, set); err != nil {
+			r.errors = multierror.Append(r.errors, fmt.Errorf("failed to set the cpus: %s", err))
+		}
+	}
+}
+
+// apply sets the open files limit of the started process and returns the
+// limits that couldn't be set. Unlike the other limits, it's set once the
+// process runs: the processes it starts before then keep the limit of
+// trackman
+func (r *resourceGroup) apply(pid int) error {
+	errors := r.errors
+
+	if r.limits.OpenFiles != 0 {
+		limit := &syscall.Rlimit{Cur: r.limits.OpenFiles, Max: r.limits.OpenFiles}
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), syscall.RLIMIT_NOFILE, uintptr(unsafe.Pointer(limit)), 0, 0, 0)
+		if errno != 0 {
+			errors = multierror.Append(errors, fmt.Errorf("failed to set the open files: %s", errno))
+		}
+	}
+
+	return errors.ErrorOrNil()
+}
+
+// exceeded returns the limit the process was killed for going over, if any
+func (r *resourceGroup) exceeded() *LimitExceeded {
+	if r.dir == "" {
+		return nil
+	}
+
+	if readOOMKills(r.dir) > 0 {
+		return &LimitExceeded{Resource: "memory", Limit: r.limits.Memory}
+	}
+
+	return nil
+}
+
+// oomKills returns how many processes the OOM killer killed in the cgroup
+// of trackman and the cgroups in it, or -1 if it can't tell
+func oomKills() int64 {
+	dir, err := ownCgroup()
+	if err != nil {
+		return -1
+	}
+
+	return readOOMKills(dir)
+}
+
+// readOOMKills returns the oom_kill count of the cgroup, or -1 if it can't
+// be read
+func readOOMKills(dir string) int64 {
+	file, err := os.Open(filepath.Join(dir, "memory.events"))
+	if err != nil {
+		return -1
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			count, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return -1
+			}
+
+			return count
+		}
+	}
+
+	return -1
+}
+
+// close removes the cgroup of the step. It stays if processes of the step
+// are still running
+func (r *resourceGroup) close() {
+	if r.cgroup != nil {
+		r.cgroup.Close()
+	}
+	if r.dir != "" {
+		_ = os.Remove(r.dir)
+	}
+}
+
+// ownCgroup returns the directory of the cgroup v2 of this process
+func ownCgroup() (string, error) {
+	content, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return filepath.Join(cgroupRoot, strings.TrimPrefix(line, "0::")), nil
+		}
+	}
+
+	return "", fmt.Errorf("no cgroup v2 found for trackman")
+}
+
+// enableMemoryController lets the cgroups in parent have memory limits
+func enableMemoryController(parent string) error {
+	control := filepath.Join(parent, "cgroup.subtree_control")
+	content, err := ioutil.ReadFile(control)
+	if err != nil {
+		return err
+	}
+	for _, controller := range strings.Fields(string(content)) {
+		if controller == "memory" {
+			return nil
+		}
+	}
+
+	if err = ioutil.WriteFile(control, []byte("+memory"), 0644); err != nil {
+		return fmt.Errorf("failed to enable the memory controller in %s: %s. Set TRACKMAN_CGROUP to a cgroup delegated to trackman", parent, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResourcesBeforeStart(t *testing.T) {
+	output := runForOutput(t, `
+version: 1
+steps:
+  - name: limited
+    shell: sh
+    command: nice && grep Cpus_allowed_list /proc/self/status
+    resources:
+      nice: 7
+      cpus: [0]
+`)
+
+	// nice and grep are started by the shell of the step at once
+	if !strings.Contains(output, "7\n") {
+		t.Errorf("the niceness of the processes of the step isn't 7 in %q", output)
+	}
+	if !strings.Contains(output, "Cpus_allowed_list:\t0\n") {
+		t.Errorf("the processes of the step don't run on cpu 0 only in %q", output)
+	}
+}
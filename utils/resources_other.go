@@ -0,0 +1,43 @@
+//go:build !linux
+// +build !linux
+
+package utils
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// resourceGroup doesn't enforce resource limits outside Linux. Steps run
+// without them
+type resourceGroup struct {
+	limits *ResourceLimits
+}
+
+func newResourceGroup(limits *ResourceLimits, name string) *resourceGroup {
+	return &resourceGroup{limits: limits}
+}
+
+func (r *resourceGroup) prepare(cmd *exec.Cmd) error {
+	return fmt.Errorf("resource limits are not supported on %s", runtime.GOOS)
+}
+
+func (r *resourceGroup) start(start func() error) error {
+	return start()
+}
+
+func (r *resourceGroup) apply(pid int) error {
+	return nil
+}
+
+func (r *resourceGroup) exceeded() *LimitExceeded {
+	return nil
+}
+
+func (r *resourceGroup) close() {}
+
+// oomKills can't tell how many processes the OOM killer killed outside Linux
+func oomKills() int64 {
+	return -1
+}
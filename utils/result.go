@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// OutcomeSuccess all steps ran successfully
+	OutcomeSuccess = "success"
+	// OutcomePartial some steps failed but were allowed to continue
+	OutcomePartial = "partial"
+	// OutcomeDegraded quorum steps met their quorum but some of their steps
+	// failed
+	OutcomeDegraded = "degraded"
+	// OutcomeFailed the workflow failed
+	OutcomeFailed = "failed"
+	// OutcomeStopped the workflow was stopped before running all steps
+	OutcomeStopped = "stopped"
+	// OutcomeCancelled the workflow was cancelled while running
+	OutcomeCancelled = "cancelled"
+)
+
+const (
+	// ResultSuccess step ran successfully
+	ResultSuccess = "success"
+	// ResultFailed step ran and failed
+	ResultFailed = "failed"
+	// ResultSkipped step was skipped because of its when condition
+	ResultSkipped = "skipped"
+	// ResultCached step didn't run because its cache key matched a previous
+	// successful run
+	ResultCached = "cached"
+	// ResultDisabled step is disabled
+	ResultDisabled = "disabled"
+	// ResultRunning step is running or waiting to be retried
+	ResultRunning = "running"
+	// ResultNotRun step never ran because the workflow was stopped
+	ResultNotRun = "not_run"
+	// ResultCancelled step was stopped because the workflow was cancelled
+	ResultCancelled = "cancelled"
+	// ResultDegraded quorum step met its quorum but some of its steps failed
+	ResultDegraded = "degraded"
+)
+
+// WorkflowResult holds the outcome of a workflow run
+type WorkflowResult struct {
+	SessionID  string        `json:"session_id"`
+	Outcome    string        `json:"outcome"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Duration   time.Duration `json:"duration"`
+	Steps      []*StepResult `json:"steps"`
+	// CriticalPath are the names of the steps that determined how long the
+	// workflow took, in the order they ran. Cleanup steps are not included
+	CriticalPath []string `json:"critical_path"`
+	// CriticalPathDuration is the total duration of the critical path
+	CriticalPathDuration time.Duration `json:"critical_path_duration"`
+	// CancelReason is why the workflow was cancelled with Cancel
+	CancelReason string `json:"cancel_reason,omitempty"`
+	// TimedOut is set if the workflow ran for longer than its timeout
+	TimedOut bool `json:"timed_out,omitempty"`
+	// Errors holds all errors that stopped the workflow
+	Errors error `json:"-"`
+
+	// redactions are the redact patterns of the workflow
+	redactions *masker
+}
+
+// StepResult holds the outcome of a single step in a workflow run
+type StepResult struct {
+	Name       string        `json:"name"`
+	Stage      string        `json:"stage,omitempty"`
+	Status     string        `json:"status"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Duration   time.Duration `json:"duration"`
+	ExitCode   int           `json:"exit_code"`
+	Attempts   int           `json:"attempts"`
+	// RolledBack is set if the rollback command of the step ran successfully
+	RolledBack bool     `json:"rolled_back,omitempty"`
+	DependsOn  []string `json:"depends_on,omitempty"`
+	// Slack is how much longer the step could have taken without making the
+	// workflow take longer
+	Slack time.Duration  `json:"slack"`
+	Error error          `json:"-"`
+	Log   *LogDefinition `json:"log"`
+	// Artifacts are the files the step saved to the artifact store
+	Artifacts []*Artifact `json:"artifacts,omitempty"`
+	// Output is the end of the output of a failed step when OutputTail is
+	// set in the options of the workflow
+	Output []string `json:"output,omitempty"`
+	// Signal is the signal that killed the command of the step, if any
+	Signal string `json:"signal,omitempty"`
+	// OOMKilled is set if the command was killed for running out of memory
+	OOMKilled bool `json:"oom_killed,omitempty"`
+	// TimedOut is set if the command ran for longer than its timeout
+	TimedOut bool `json:"timed_out,omitempty"`
+	// OutputTruncated is set if the output of the command went past the
+	// output_limit of the step
+	OutputTruncated *OutputTruncated `json:"output_truncated,omitempty"`
+	// Usage is what the command of a process step used the last time it
+	// ran
+	Usage *ResourceUsage `json:"usage,omitempty"`
+	// Progress is the last progress read from the output of a step with a
+	// progress_regex, from 0 to 1
+	Progress *float64 `json:"progress,omitempty"`
+}
+
+// Failed returns the results of all failed steps
+func (r *WorkflowResult) Failed() []*StepResult {
+	var failed []*StepResult
+	for _, step := range r.Steps {
+		if step.Status == ResultFailed {
+			failed = append(failed, step)
+		}
+	}
+
+	return failed
+}
+
+// FailedSteps returns the steps that failed but were allowed to continue
+// because of continue_on_fail, like the failed steps in the result of Run
+func (w *Workflow) FailedSteps() []*Step {
+	failed := make(map[string]bool)
+	for _, step := range w.result(context.Background(), time.Time{}, nil).Failed() {
+		failed[step.Name] = true
+	}
+
+	var steps []*Step
+	for idx, step := range w.Steps {
+		if step.ContinueOnFail && failed[step.Name] {
+			steps = append(steps, w.Steps[idx])
+		}
+	}
+
+	return steps
+}
+
+// degraded returns true if any quorum step is degraded
+func (r *WorkflowResult) degraded() bool {
+	for _, step := range r.Steps {
+		if step.Status == ResultDegraded {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Step returns the result of the step with the given name or nil
+func (r *WorkflowResult) Step(name string) *StepResult {
+	for _, step := range r.Steps {
+		if step.Name == name {
+			return step
+		}
+	}
+
+	return nil
+}
+
+func (w *Workflow) result(ctx context.Context, startedAt time.Time, errors error) *WorkflowResult {
+	result := &WorkflowResult{
+		SessionID:  w.sessionID,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+		Errors:     errors,
+		redactions: w.redactions,
+	}
+	result.Duration = result.FinishedAt.Sub(result.StartedAt)
+
+	for _, step := range w.allSteps() {
+		result.Steps = append(result.Steps, step.result())
+	}
+	result.analyzeCriticalPath(result.Steps[:len(w.Steps)])
+
+	switch {
+	case ctx.Err() != nil:
+		result.Outcome = OutcomeCancelled
+	case errors != nil:
+		result.Outcome = OutcomeFailed
+	case w.shouldStop(ctx):
+		result.Outcome = OutcomeStopped
+	case result.degraded():
+		result.Outcome = OutcomeDegraded
+	case len(result.Failed()) != 0:
+		result.Outcome = OutcomePartial
+	default:
+		result.Outcome = OutcomeSuccess
+	}
+
+	return result
+}
+
+func (s *Step) result() *StepResult {
+	result := &StepResult{
+		Name:       s.Name,
+		Stage:      s.stage,
+		StartedAt:  s.startedAt,
+		FinishedAt: s.finishedAt,
+		ExitCode:   s.exitCode,
+		Attempts:   s.attempts,
+		RolledBack: s.rolledBack,
+		DependsOn:  s.DependsOn.Names(),
+		Error:      s.err,
+		Log:        DefaultLogDefinition(s.Logger),
+		Artifacts:  s.artifacts,
+		Signal:     s.signal,
+		OOMKilled:  s.oomKilled,
+		TimedOut:   s.timedOut,
+
+		OutputTruncated: s.outputTruncated,
+		Usage:           s.usage,
+		Progress:        s.progress.current(),
+	}
+
+	if !s.finishedAt.IsZero() {
+		result.Duration = s.finishedAt.Sub(s.startedAt)
+	}
+
+	switch {
+	case s.Disabled:
+		result.Status = ResultDisabled
+	case s.status == StepRunning || s.status == StepRetrying:
+		result.Status = ResultRunning
+	case !s.status.Done():
+		result.Status = ResultNotRun
+	case s.status == StepSkipped:
+		result.Status = ResultSkipped
+	case s.cached:
+		result.Status = ResultCached
+	case s.status == StepCancelled:
+		result.Status = ResultCancelled
+	case s.status == StepFailed || s.status == StepTimedOut:
+		result.Status = ResultFailed
+		if s.tail != nil {
+			result.Output = s.tail.Lines()
+		}
+	case s.degraded:
+		result.Status = ResultDegraded
+	default:
+		result.Status = ResultSuccess
+	}
+
+	return result
+}
+
+// Progress returns the status of each step of the workflow as it runs
+func (w *Workflow) Progress() []*StepResult {
+	w.signal.Lock()
+	defer w.signal.Unlock()
+
+	steps := w.allSteps()
+	results := make([]*StepResult, 0, len(steps))
+	for _, step := range steps {
+		results = append(results, step.result())
+	}
+
+	return results
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFailedSteps(t *testing.T) {
+	options := &WorkflowOptions{
+		Output:  NewOutputMultiplexer(&lockedBuffer{}, &MultiplexerOptions{NoColor: true, Raw: true}),
+		Timeout: 10 * time.Second,
+	}
+	w, err := LoadWorkflowFromBytes(context.Background(), options, []byte(`
+version: 1
+steps:
+  - name: flaky
+    command: "false"
+    continue_on_fail: true
+  - name: fine
+    command: "true"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := w.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Outcome != OutcomePartial {
+		t.Errorf("the outcome is %s, want %s", result.Outcome, OutcomePartial)
+	}
+	failed := w.FailedSteps()
+	if len(failed) != 1 || failed[0].Name != "flaky" {
+		t.Errorf("the failed steps are %v, want flaky", failed)
+	}
+}
+
+func TestProgressWhileRunning(t *testing.T) {
+	options := &WorkflowOptions{
+		Output:  NewOutputMultiplexer(&lockedBuffer{}, &MultiplexerOptions{NoColor: true, Raw: true}),
+		Timeout: 10 * time.Second,
+	}
+	w, err := LoadWorkflowFromBytes(context.Background(), options, []byte(`
+version: 1
+steps:
+  - name: fails
+    command: "false"
+    continue_on_fail: true
+  - name: skipped
+    command: "true"
+    when: "false"
+  - name: retried
+    command: "false"
+    continue_on_fail: true
+    retry:
+      max_attempts: 2
+      delay: 10ms
+  - name: fine
+    command: "true"
+    depends_on:
+      - fails
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := w.Run(context.Background()); err != nil {
+			t.Error(err)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			w.Progress()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// BackoffConstant waits the same delay between all attempts
+	BackoffConstant = "constant"
+	// BackoffLinear increases the delay linearly with each attempt
+	BackoffLinear = "linear"
+	// BackoffExponential doubles the delay with each attempt
+	BackoffExponential = "exponential"
+)
+
+const defaultRetryDelay = 1 * time.Second
+
+// RetryPolicy defines how a failed step should be retried
+type RetryPolicy struct {
+	MaxAttempts int            `yaml:"max_attempts" json:"max_attempts"`
+	Backoff     string         `yaml:"backoff" json:"backoff"`
+	Delay       *time.Duration `yaml:"delay" json:"delay"`
+	MaxDelay    *time.Duration `yaml:"max_delay" json:"max_delay"`
+	ExitCodes   []int          `yaml:"exit_codes" json:"exit_codes"`
+}
+
+// RetryAttempt is sent with EventRunRetry to describe the next attempt
+type RetryAttempt struct {
+	Attempt     int           `json:"attempt"`
+	MaxAttempts int           `json:"max_attempts"`
+	Delay       time.Duration `json:"delay"`
+}
+
+func (r *RetryPolicy) validate() error {
+	switch r.Backoff {
+	case "", BackoffConstant, BackoffLinear, BackoffExponential:
+	default:
+		return fmt.Errorf("invalid retry backoff %s", r.Backoff)
+	}
+
+	if r.MaxAttempts < 0 {
+		return fmt.Errorf("invalid retry max_attempts %d", r.MaxAttempts)
+	}
+
+	return nil
+}
+
+// shouldRetry returns true if the given error after the given number of
+// attempts qualifies for another attempt
+func (r *RetryPolicy) shouldRetry(attempts int, err error) bool {
+	if attempts >= r.MaxAttempts {
+		return false
+	}
+
+	// no exit codes means retry on any failure
+	if len(r.ExitCodes) == 0 {
+		return true
+	}
+
+	code, ok := exitCode(err)
+	if !ok {
+		return false
+	}
+
+	for _, retryCode := range r.ExitCodes {
+		if retryCode == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// delay returns how long to wait before the given attempt
+func (r *RetryPolicy) delay(attempt int) time.Duration {
+	base := defaultRetryDelay
+	if r.Delay != nil {
+		base = *r.Delay
+	}
+
+	var result time.Duration
+	switch r.Backoff {
+	case BackoffLinear:
+		result = scaleDelay(base, int64(attempt-1))
+	case BackoffExponential:
+		// doubled one attempt at a time so it stops growing once it can't
+		result = base
+		for idx := 2; idx < attempt && result < math.MaxInt64; idx++ {
+			result = scaleDelay(result, 2)
+		}
+	default:
+		result = base
+	}
+
+	if r.MaxDelay != nil && result > *r.MaxDelay {
+		result = *r.MaxDelay
+	}
+
+	return result
+}
+
+// scaleDelay multiplies the delay by the factor, or returns the longest
+// delay if that overflows
+func scaleDelay(delay time.Duration, factor int64) time.Duration {
+	if delay <= 0 || factor <= 0 {
+		return 0
+	}
+	if int64(delay) > math.MaxInt64/factor {
+		return math.MaxInt64
+	}
+
+	return delay * time.Duration(factor)
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	second := time.Second
+	minute := time.Minute
+	tests := []struct {
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{policy: RetryPolicy{}, attempt: 5, want: defaultRetryDelay},
+		{policy: RetryPolicy{Backoff: BackoffConstant, Delay: &second}, attempt: 3, want: second},
+		{policy: RetryPolicy{Backoff: BackoffLinear, Delay: &second}, attempt: 2, want: second},
+		{policy: RetryPolicy{Backoff: BackoffLinear, Delay: &second}, attempt: 4, want: 3 * second},
+		{policy: RetryPolicy{Backoff: BackoffExponential, Delay: &second}, attempt: 2, want: second},
+		{policy: RetryPolicy{Backoff: BackoffExponential, Delay: &second}, attempt: 5, want: 8 * second},
+		{policy: RetryPolicy{Backoff: BackoffExponential, Delay: &second, MaxDelay: &minute}, attempt: 10, want: minute},
+		{policy: RetryPolicy{Backoff: BackoffExponential, Delay: &second, MaxDelay: &minute}, attempt: 100, want: minute},
+		{policy: RetryPolicy{Backoff: BackoffExponential, Delay: &minute}, attempt: 1000, want: math.MaxInt64},
+		{policy: RetryPolicy{Backoff: BackoffLinear, Delay: &minute, MaxDelay: &minute}, attempt: math.MaxInt32, want: minute},
+	}
+
+	for _, test := range tests {
+		if got := test.policy.delay(test.attempt); got != test.want {
+			t.Errorf("%s backoff of attempt %d is %s, want %s", test.policy.Backoff, test.attempt, got, test.want)
+		}
+	}
+}
+
+func TestRetryRendersOnce(t *testing.T) {
+	out := &lockedBuffer{}
+	options := &WorkflowOptions{
+		Output:  NewOutputMultiplexer(out, &MultiplexerOptions{NoColor: true, Raw: true}),
+		Timeout: 10 * time.Second,
+	}
+	w, err := LoadWorkflowFromBytes(context.Background(), options, []byte(`
+version: 1
+steps:
+  - name: flaky
+    shell: sh
+    command: echo 'rendered {{ "{{ .Literal }}" }}'; exit 1
+    retry:
+      max_attempts: 3
+      delay: 1ms
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := w.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Failed()) != 1 {
+		t.Fatalf("the step didn't fail: %q", out.String())
+	}
+
+	if got := strings.Count(out.String(), "rendered {{ .Literal }}"); got != 3 {
+		t.Errorf("the command was rendered once for %d of 3 attempts in %q", got, out.String())
+	}
+}
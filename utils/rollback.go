@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// HookRollback is the kind of the spinner running the rollback of a step
+const HookRollback = "rollback"
+
+// RollbackResult is the payload of EventRollbackFinished
+type RollbackResult struct {
+	// RolledBack are the steps rolled back successfully
+	RolledBack []string `json:"rolled_back"`
+	// Failed are the steps that failed to roll back
+	Failed []string `json:"failed"`
+}
+
+// rollbackSteps returns the successful steps with a rollback command, with
+// each step coming before the steps it depends on
+func (w *Workflow) rollbackSteps() ([]*Step, error) {
+	stages, err := w.executionPlan()
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []*Step
+	for idx := len(stages) - 1; idx >= 0; idx-- {
+		for kdx := len(stages[idx]) - 1; kdx >= 0; kdx-- {
+			step := stages[idx][kdx]
+			if step.Rollback != "" && step.result().Status == ResultSuccess {
+				steps = append(steps, step)
+			}
+		}
+	}
+
+	return steps, nil
+}
+
+// rollback runs the rollback commands of the steps that succeeded in the
+// reverse order of their dependencies. A failed rollback doesn't stop the
+// ones after it
+func (w *Workflow) rollback(ctx context.Context) error {
+	steps, err := w.rollbackSteps()
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+
+	// rollback should finish even if the workflow was cancelled
+	ctx = context.WithoutCancel(ctx)
+	w.logger.Info("Rolling back")
+	w.push(ctx, NewWorkflowEvent(w, EventRollbackStarted, nil))
+
+	result := &RollbackResult{}
+	var errors error
+	for _, step := range steps {
+		spinner, err := NewSpinnerForHook(ctx, *step, &Hook{Command: step.Rollback}, HookRollback)
+		if err == nil {
+			err = spinner.Run(ctx)
+		}
+		if err != nil {
+			result.Failed = append(result.Failed, step.Name)
+			errors = multierror.Append(errors, fmt.Errorf("rollback of %s failed: %s", step.Name, err))
+			w.logger.WithField(FldStep, step.Name).Errorf("Rollback failed: %s", err)
+			continue
+		}
+
+		step.update(func() { step.rolledBack = true })
+		result.RolledBack = append(result.RolledBack, step.Name)
+	}
+
+	if err = w.saveState(ctx); err != nil {
+		w.logger.Errorf("Failed to save state: %s", err)
+	}
+
+	w.push(ctx, NewWorkflowEvent(w, EventRollbackFinished, result))
+
+	return errors
+}
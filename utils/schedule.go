@@ -0,0 +1,61 @@
+package utils
+
+import "sort"
+
+// scheduleOrder returns the steps in the order the scheduler should consider
+// them: higher priority first, then (if enabled) the ones with the longest
+// chain of steps depending on them, then in the order of the workflow file
+func (w *Workflow) scheduleOrder() []*Step {
+	order := make([]*Step, len(w.Steps))
+	copy(order, w.Steps)
+
+	var pathLengths map[*Step]int
+	if w.options.CriticalPathFirst {
+		pathLengths = w.pathLengths()
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		if order[i].Priority != order[j].Priority {
+			return order[i].Priority > order[j].Priority
+		}
+
+		return pathLengths[order[i]] > pathLengths[order[j]]
+	})
+
+	return order
+}
+
+// pathLengths returns the number of steps in the longest chain of steps
+// starting with each step and followed by the steps depending on it
+func (w *Workflow) pathLengths() map[*Step]int {
+	dependents := make(map[*Step][]*Step, len(w.Steps))
+	for _, step := range w.Steps {
+		for _, priorStep := range step.dependsOn {
+			dependents[priorStep] = append(dependents[priorStep], step)
+		}
+	}
+
+	lengths := make(map[*Step]int, len(w.Steps))
+	var length func(step *Step) int
+	length = func(step *Step) int {
+		if value, ok := lengths[step]; ok {
+			return value
+		}
+
+		longest := 0
+		for _, dependent := range dependents[step] {
+			if value := length(dependent); value > longest {
+				longest = value
+			}
+		}
+
+		lengths[step] = longest + 1
+		return lengths[step]
+	}
+
+	for _, step := range w.Steps {
+		length(step)
+	}
+
+	return lengths
+}
@@ -0,0 +1,382 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SecretMask replaces the secrets in the logs, output and events
+const SecretMask = "***"
+
+// minSecretMask is the length of the shortest value that is masked. Shorter
+// values, like the lines of a secret with a single character on them, would
+// mask every place they appear in the output
+const minSecretMask = 4
+
+// maxMaskedLine is how much output without a new line is held back to mask
+// the secrets in it, before it's written as is
+const maxMaskedLine = 64 * 1024
+
+// SecretProvider gets the value of the secrets used in the workflows
+type SecretProvider interface {
+	// Secret returns the value of the secret with the given name
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// secretMasker holds the values of all secrets read by this process and the
+// redact patterns of the process. They are masked wherever a workflow could
+// show them. The redact patterns of a workflow are only masked for it
+var secretMasker = newMasker()
+
+type masker struct {
+	values   map[string]bool
+	replacer *strings.Replacer
+	patterns []*regexp.Regexp
+	signal   *sync.RWMutex
+}
+
+// AddSecretMask masks the value in the logs, output and events from now on.
+// Each line of a value with multiple lines is masked on its own too, as the
+// output is masked line by line. Values shorter than 4 characters are not
+// masked
+func AddSecretMask(value string) {
+	secretMasker.add(value)
+}
+
+// AddRedactPattern masks the matches of the regular expression in the logs,
+// output and events of all workflows from now on. If the expression has
+// groups, only what they match is masked, like the value in password=(\S+)
+func AddRedactPattern(pattern string) error {
+	return secretMasker.addRedactPattern(pattern)
+}
+
+// MaskSecrets returns the value with the secrets and the matches of the
+// redact patterns replaced with SecretMask
+func MaskSecrets(value string) string {
+	return secretMasker.mask(value)
+}
+
+func newMasker() *masker {
+	return &masker{signal: &sync.RWMutex{}}
+}
+
+// newRedactions returns a masker of the redact patterns
+func newRedactions(patterns []string) (*masker, error) {
+	redactions := newMasker()
+	for _, pattern := range patterns {
+		if err := redactions.addRedactPattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	return redactions, nil
+}
+
+// MaskSecrets returns the value with the secrets and the matches of the
+// redact patterns of the process and of the workflow replaced with
+// SecretMask
+func (w *Workflow) MaskSecrets(value string) string {
+	if w == nil {
+		return MaskSecrets(value)
+	}
+
+	return maskWith(value, w.redactions)
+}
+
+// MaskSecrets returns the value with the secrets and the matches of the
+// redact patterns of the process and of the workflow of the result replaced
+// with SecretMask
+func (r *WorkflowResult) MaskSecrets(value string) string {
+	if r == nil {
+		return MaskSecrets(value)
+	}
+
+	return maskWith(value, r.redactions)
+}
+
+// maskWith masks the secrets and the redact patterns of the process, and
+// then the ones of the maskers
+func maskWith(value string, maskers ...*masker) string {
+	value = secretMasker.mask(value)
+	for _, item := range maskers {
+		if item != nil {
+			value = item.mask(value)
+		}
+	}
+
+	return value
+}
+
+func (m *masker) addRedactPattern(pattern string) error {
+	expression, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid redact pattern %s: %s", pattern, err)
+	}
+
+	m.addPattern(expression)
+
+	return nil
+}
+
+func (m *masker) add(value string) {
+	values := []string{value}
+	if strings.ContainsAny(value, "\r\n") {
+		values = append(values, strings.FieldsFunc(value, func(r rune) bool {
+			return r == '\r' || r == '\n'
+		})...)
+	}
+
+	// values in JSON, like the webhook payloads, are escaped
+	for _, item := range values {
+		if escaped, err := json.Marshal(item); err == nil {
+			values = append(values, string(escaped[1:len(escaped)-1]))
+		}
+	}
+
+	m.signal.Lock()
+	defer m.signal.Unlock()
+
+	if m.values == nil {
+		m.values = make(map[string]bool)
+	}
+	for _, item := range values {
+		if len(strings.TrimSpace(item)) >= minSecretMask {
+			m.values[item] = true
+		}
+	}
+
+	// longer values first so a secret containing another one is masked whole
+	sorted := make([]string, 0, len(m.values))
+	for item := range m.values {
+		sorted = append(sorted, item)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+
+		return sorted[i] < sorted[j]
+	})
+
+	pairs := make([]string, 0, len(sorted)*2)
+	for _, item := range sorted {
+		pairs = append(pairs, item, SecretMask)
+	}
+	m.replacer = strings.NewReplacer(pairs...)
+}
+
+func (m *masker) addPattern(expression *regexp.Regexp) {
+	m.signal.Lock()
+	defer m.signal.Unlock()
+
+	for _, item := range m.patterns {
+		if item.String() == expression.String() {
+			return
+		}
+	}
+
+	m.patterns = append(m.patterns, expression)
+}
+
+func (m *masker) mask(value string) string {
+	m.signal.RLock()
+	defer m.signal.RUnlock()
+
+	if m.replacer != nil {
+		value = m.replacer.Replace(value)
+	}
+	for _, expression := range m.patterns {
+		value = redact(expression, value)
+	}
+
+	return value
+}
+
+func (m *masker) empty() bool {
+	if m == nil {
+		return true
+	}
+
+	m.signal.RLock()
+	defer m.signal.RUnlock()
+
+	return m.replacer == nil && len(m.patterns) == 0
+}
+
+// redact masks the matches of the expression in the value, or only the
+// parts matching its groups if it has any
+func redact(expression *regexp.Regexp, value string) string {
+	matches := expression.FindAllStringSubmatchIndex(value, -1)
+	if len(matches) == 0 {
+		return value
+	}
+
+	buf := &strings.Builder{}
+	last := 0
+	for _, match := range matches {
+		ranges := match[:2]
+		if len(match) > 2 {
+			ranges = match[2:]
+		}
+
+		for idx := 0; idx < len(ranges); idx += 2 {
+			// groups that didn't match are -1 and nested groups are masked
+			// with the group around them
+			if ranges[idx] < last || ranges[idx+1] <= ranges[idx] {
+				continue
+			}
+
+			buf.WriteString(value[last:ranges[idx]])
+			buf.WriteString(SecretMask)
+			last = ranges[idx+1]
+		}
+	}
+	buf.WriteString(value[last:])
+
+	return buf.String()
+}
+
+// maskingWriter masks the secrets in the output written to it, and the
+// matches of the redact patterns of its maskers. The output is written a
+// line at a time so secrets aren't split between writes
+type maskingWriter struct {
+	out     io.Writer
+	maskers []*masker
+	buffer  []byte
+}
+
+func newMaskingWriter(out io.Writer, maskers ...*masker) *maskingWriter {
+	return &maskingWriter{out: out, maskers: maskers}
+}
+
+// empty returns true if there is nothing to mask
+func (w *maskingWriter) empty() bool {
+	for _, item := range w.maskers {
+		if !item.empty() {
+			return false
+		}
+	}
+
+	return secretMasker.empty()
+}
+
+// Write implements io.Writer
+func (w *maskingWriter) Write(b []byte) (int, error) {
+	if len(w.buffer) == 0 && w.empty() {
+		return w.out.Write(b)
+	}
+
+	w.buffer = append(w.buffer, b...)
+	end := bytes.LastIndexAny(w.buffer, "\r\n")
+	if end == -1 && len(w.buffer) < maxMaskedLine {
+		return len(b), nil
+	}
+	if end == -1 {
+		end = len(w.buffer) - 1
+	}
+
+	lines := w.buffer[:end+1]
+	if _, err := io.WriteString(w.out, maskWith(string(lines), w.maskers...)); err != nil {
+		return 0, err
+	}
+	w.buffer = append(w.buffer[:0], w.buffer[end+1:]...)
+
+	return len(b), nil
+}
+
+// Flush writes the output held back
+func (w *maskingWriter) Flush() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+
+	_, err := io.WriteString(w.out, maskWith(string(w.buffer), w.maskers...))
+	w.buffer = w.buffer[:0]
+
+	return err
+}
+
+// secretsHook masks the secrets in the messages and fields of the logs, and
+// the matches of the redact patterns of the workflow they are for
+type secretsHook struct {
+	redactions *masker
+}
+
+// Levels implements logrus.Hook
+func (h *secretsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook
+func (h *secretsHook) Fire(entry *logrus.Entry) error {
+	if secretMasker.empty() && h.redactions.empty() {
+		return nil
+	}
+
+	entry.Message = maskWith(entry.Message, h.redactions)
+
+	// the fields can be shared with other entries, so they are copied
+	data := make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		switch item := value.(type) {
+		case string:
+			value = maskWith(item, h.redactions)
+		case error:
+			value = maskWith(item.Error(), h.redactions)
+		case fmt.Stringer:
+			value = maskWith(item.String(), h.redactions)
+		}
+
+		data[key] = value
+	}
+	entry.Data = data
+
+	return nil
+}
+
+// secret returns the value of the secret from the secret provider of the
+// workflow. Values are read once per run and masked from then on
+func (w *Workflow) secret(ctx context.Context, name string) (string, error) {
+	if w.options.Secrets == nil {
+		return "", fmt.Errorf("no secret provider to get secret %s from", name)
+	}
+
+	w.secretsSignal.Lock()
+	defer w.secretsSignal.Unlock()
+
+	if value, ok := w.secrets[name]; ok {
+		return value, nil
+	}
+
+	value, err := w.options.Secrets.Secret(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret %s: %s", name, err)
+	}
+
+	AddSecretMask(value)
+	if w.secrets == nil {
+		w.secrets = make(map[string]string)
+	}
+	w.secrets[name] = value
+
+	return value, nil
+}
+
+// templateFuncs are the functions of the templates of the workflow and its
+// steps
+func (w *Workflow) templateFuncs(ctx context.Context) map[string]interface{} {
+	return map[string]interface{}{
+		"secret": func(name string) (string, error) {
+			return w.secret(ctx, name)
+		},
+	}
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a buffer the output of steps can be written to at once
+type lockedBuffer struct {
+	buffer bytes.Buffer
+	signal sync.Mutex
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.signal.Lock()
+	defer b.signal.Unlock()
+
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.signal.Lock()
+	defer b.signal.Unlock()
+
+	return b.buffer.String()
+}
+
+func runForOutput(t *testing.T, workflow string) string {
+	t.Helper()
+
+	out := &lockedBuffer{}
+	options := &WorkflowOptions{
+		Output:  NewOutputMultiplexer(out, &MultiplexerOptions{NoColor: true, Raw: true}),
+		Timeout: 10 * time.Second,
+	}
+	w, err := LoadWorkflowFromBytes(context.Background(), options, []byte(workflow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return out.String()
+}
+
+func TestRedactPatternsOfWorkflows(t *testing.T) {
+	redacted := runForOutput(t, `
+version: 1
+redact: ['hunter\d']
+steps:
+  - name: print
+    command: echo password hunter2
+`)
+	if strings.Contains(redacted, "hunter2") || !strings.Contains(redacted, "password "+SecretMask) {
+		t.Errorf("the pattern of the workflow wasn't masked in %q", redacted)
+	}
+
+	other := runForOutput(t, `
+version: 1
+steps:
+  - name: print
+    command: echo password hunter2
+`)
+	if !strings.Contains(other, "password hunter2") {
+		t.Errorf("the pattern of another workflow was masked in %q", other)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    string
+	}{
+		{pattern: `s3cr\w+`, value: "a s3cret b", want: "a *** b"},
+		{pattern: `password=(\S+)`, value: "password=abc x", want: "password=*** x"},
+		{pattern: `(a)|(b)`, value: "abc", want: "******c"},
+		{pattern: `nothing`, value: "value", want: "value"},
+	}
+
+	for _, test := range tests {
+		redactions, err := newRedactions([]string{test.pattern})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := redactions.mask(test.value); got != test.want {
+			t.Errorf("%s on %q is %q, want %q", test.pattern, test.value, got, test.want)
+		}
+	}
+}
+
+// staticSecrets is a SecretProvider of fixed values
+type staticSecrets map[string]string
+
+func (s staticSecrets) Secret(ctx context.Context, name string) (string, error) {
+	return s[name], nil
+}
+
+// memoryCache is a StepCache in memory
+type memoryCache map[string]*CacheEntry
+
+func (c memoryCache) Get(key string) (*CacheEntry, error) {
+	return c[key], nil
+}
+
+func (c memoryCache) Put(entry *CacheEntry) error {
+	c[entry.Key] = entry
+	return nil
+}
+
+func TestSecretsInCachedOutputs(t *testing.T) {
+	cache := memoryCache{}
+	options := &WorkflowOptions{
+		Output:  NewOutputMultiplexer(&lockedBuffer{}, &MultiplexerOptions{NoColor: true, Raw: true}),
+		Timeout: 10 * time.Second,
+		Secrets: staticSecrets{"api": "t0ken-in-output"},
+		Cache:   cache,
+	}
+	w, err := LoadWorkflowFromBytes(context.Background(), options, []byte(`
+version: 1
+steps:
+  - name: login
+    command: echo token {{ secret "api" }}
+    cache_key:
+      env: [HOME]
+    outputs:
+      - name: token
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cache) != 1 {
+		t.Fatalf("%d entries were cached, want 1", len(cache))
+	}
+	for _, entry := range cache {
+		if got := entry.Outputs["token"]; strings.Contains(got, "t0ken-in-output") || !strings.Contains(got, SecretMask) {
+			t.Errorf("the cached output is %q, want the secret masked", got)
+		}
+	}
+	if got := w.Outputs()["login"]["token"]; !strings.Contains(got, "t0ken-in-output") {
+		t.Errorf("the output is %q, want the secret for the later steps", got)
+	}
+}
+
+func TestShortSecretsAreNotMasked(t *testing.T) {
+	AddSecretMask("a\nbc\nlong-secret")
+
+	if got := MaskSecrets("a bc long-secret"); got != "a bc "+SecretMask {
+		t.Errorf("the value is masked as %q", got)
+	}
+}
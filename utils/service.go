@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultProbeInterval is the time between the probes of a service
+	defaultProbeInterval = time.Second
+	// maxProbeAttempt is the longest a single probe of a service can take
+	maxProbeAttempt = 5 * time.Second
+)
+
+// service is the command of a background step (or a service) running until
+// the workflow or a stop step stops it
+type service struct {
+	step *Step
+	stop context.CancelFunc
+	done chan struct{}
+	err  error
+}
+
+// runsInBackground returns true if the command of the step keeps running
+// once the step is done
+func (s *Step) runsInBackground() bool {
+	return s.Service || s.Background
+}
+
+// startService starts the command of a background step and waits for it to
+// start, or for its probe to pass if it has one. The command runs until it's
+// stopped
+func (s *Step) startService(ctx context.Context, spinner *Spinner) error {
+	serviceCtx, stop := context.WithCancel(ctx)
+	svc := &service{step: s, stop: stop, done: make(chan struct{})}
+	go func() {
+		defer close(svc.done)
+		svc.err = spinner.Run(serviceCtx)
+	}()
+
+	if s.Probe == nil {
+		select {
+		case <-spinner.started:
+		case <-svc.done:
+			if svc.err != nil {
+				return svc.err
+			}
+		}
+
+		spinner.push(ctx, NewEvent(spinner, EventServiceReady, nil))
+		s.workflow.addService(svc)
+
+		return nil
+	}
+
+	probeSpinner, err := NewSpinnerForProbe(ctx, *s)
+	if err != nil {
+		svc.terminate()
+		return err
+	}
+
+	probeSpinner.push(ctx, NewEvent(probeSpinner, EventRunningProbe, nil))
+
+	if err = s.waitForService(ctx, probeSpinner, svc); err != nil {
+		svc.terminate()
+		return err
+	}
+
+	spinner.push(ctx, NewEvent(spinner, EventServiceReady, nil))
+	s.workflow.addService(svc)
+
+	return nil
+}
+
+// waitForService runs the probe of the step until it passes, the service
+// exits or the probe times out
+func (s *Step) waitForService(ctx context.Context, probeSpinner *Spinner, svc *service) error {
+	interval := defaultProbeInterval
+	if s.Probe.Interval != nil {
+		interval = *s.Probe.Interval
+	}
+	deadline := time.Now().Add(probeSpinner.timeout)
+
+	for {
+		attempt := maxProbeAttempt
+		if remaining := time.Until(deadline); remaining < attempt {
+			attempt = remaining
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, attempt)
+		err := s.Probe.check(attemptCtx, probeSpinner)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service not ready after %s: %s", probeSpinner.timeout, err)
+		}
+
+		select {
+		case <-svc.done:
+			if svc.err != nil {
+				return fmt.Errorf("service stopped before it was ready: %s", svc.err)
+			}
+
+			return fmt.Errorf("service stopped before it was ready")
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// stopBackgroundStep stops the background step named by stop_step
+func (s *Step) stopBackgroundStep(ctx context.Context, spinner *Spinner) error {
+	spinner.push(ctx, NewEvent(spinner, EventRunRequested, nil))
+	if !s.workflow.stopService(s.StopStep) {
+		s.logger.WithField(FldStep, s.Name).Warnf("%s is not running", s.StopStep)
+	}
+	spinner.push(ctx, NewEvent(spinner, EventRunSuccess, nil))
+
+	return nil
+}
+
+// markStarted records when the command started and tells the step of a
+// service it has
+func (s *Spinner) markStarted() {
+	s.startedAt = time.Now()
+	if s.started != nil {
+		close(s.started)
+	}
+}
+
+// terminate stops the service and waits for its command to exit
+func (svc *service) terminate() {
+	svc.stop()
+	<-svc.done
+}
+
+func (w *Workflow) addService(svc *service) {
+	w.signal.Lock()
+	defer w.signal.Unlock()
+
+	w.services = append(w.services, svc)
+}
+
+// stopService stops the service of the step with the name. It returns false
+// if it isn't running
+func (w *Workflow) stopService(name string) bool {
+	w.signal.Lock()
+	var svc *service
+	for idx, running := range w.services {
+		if running.step.Name == name {
+			svc = running
+			w.services = append(w.services[:idx], w.services[idx+1:]...)
+			break
+		}
+	}
+	w.signal.Unlock()
+
+	if svc == nil {
+		return false
+	}
+	svc.terminate()
+
+	return true
+}
+
+// stopServices stops the services started by the workflow, the last one
+// started first
+func (w *Workflow) stopServices() {
+	w.signal.Lock()
+	services := w.services
+	w.services = nil
+	w.signal.Unlock()
+
+	if len(services) == 0 {
+		return
+	}
+
+	w.logger.Info("Stopping services")
+	for idx := len(services) - 1; idx >= 0; idx-- {
+		services[idx].terminate()
+	}
+}
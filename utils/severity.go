@@ -0,0 +1,24 @@
+package utils
+
+const (
+	// SeverityInfo is for things that are only worth a look
+	SeverityInfo = "info"
+	// SeverityWarning is for things that might be a problem
+	SeverityWarning = "warning"
+	// SeverityError is for things that are most likely a problem
+	SeverityError = "error"
+)
+
+// severities are the valid severities from the least to the most severe
+var severities = []string{SeverityInfo, SeverityWarning, SeverityError}
+
+// severityLevel returns the rank of the severity, or -1 if it's invalid
+func severityLevel(severity string) int {
+	for idx, valid := range severities {
+		if valid == severity {
+			return idx
+		}
+	}
+
+	return -1
+}
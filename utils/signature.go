@@ -0,0 +1,24 @@
+package utils
+
+// SignatureVerifier checks the detached signatures of the workflow files
+type SignatureVerifier interface {
+	// Verify returns an error unless the signature is a valid signature of
+	// the content by a trusted key
+	Verify(content []byte, signature []byte) error
+	// Extension is added to the location of a workflow file to find its
+	// signature, like .sig
+	Extension() string
+}
+
+// verifySignature checks the signature of the workflow file. name describes
+// the file in errors
+func verifySignature(verifier SignatureVerifier, name string, content []byte, signature []byte) error {
+	if len(signature) == 0 {
+		return &SignatureError{Name: name}
+	}
+	if err := verifier.Verify(content, signature); err != nil {
+		return &SignatureError{Name: name, Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package utils
+
+import "time"
+
+// WorkflowSnapshot is a view of a workflow at one point of its run. It
+// doesn't change as the workflow runs
+type WorkflowSnapshot struct {
+	SessionID string `json:"session_id"`
+	// TakenAt is when the snapshot was taken
+	TakenAt time.Time `json:"taken_at"`
+	// StartedAt is when the last run started. It's zero if the workflow
+	// hasn't run
+	StartedAt time.Time `json:"started_at"`
+	Running   bool      `json:"running"`
+	Paused    bool      `json:"paused"`
+	// Steps are the steps of the workflow followed by its cleanup steps
+	Steps []*StepSnapshot `json:"steps"`
+	// Total is the number of steps, and Done the number of them done
+	Total int `json:"total"`
+	Done  int `json:"done"`
+}
+
+// StepSnapshot is a view of a step at one point of the run of its workflow
+type StepSnapshot struct {
+	Name     string     `json:"name"`
+	Stage    string     `json:"stage,omitempty"`
+	Cleanup  bool       `json:"cleanup,omitempty"`
+	Status   StepStatus `json:"status"`
+	Attempts int        `json:"attempts"`
+	// StartedAt is when the step first ran, and FinishedAt when it was
+	// done. They are zero until then
+	StartedAt   time.Time        `json:"started_at"`
+	FinishedAt  time.Time        `json:"finished_at"`
+	Transitions []StepTransition `json:"transitions"`
+	// Progress is the last progress read from the output of a step with a
+	// progress_regex, from 0 to 1. It's nil until its output has one
+	Progress *float64 `json:"progress,omitempty"`
+}
+
+// Snapshot returns the status of the workflow and its steps. It's safe to
+// call from another goroutine while the workflow runs, to show its progress
+func (w *Workflow) Snapshot() *WorkflowSnapshot {
+	w.signal.Lock()
+	defer w.signal.Unlock()
+
+	snapshot := &WorkflowSnapshot{
+		SessionID: w.sessionID,
+		TakenAt:   time.Now(),
+		StartedAt: w.startedAt,
+		Running:   w.running != nil,
+		Paused:    w.paused,
+		Steps:     make([]*StepSnapshot, 0, len(w.Steps)+len(w.Cleanup)),
+	}
+	for _, step := range w.Steps {
+		snapshot.Steps = append(snapshot.Steps, step.snapshot())
+	}
+	for _, step := range w.Cleanup {
+		stepSnapshot := step.snapshot()
+		stepSnapshot.Cleanup = true
+		snapshot.Steps = append(snapshot.Steps, stepSnapshot)
+	}
+
+	snapshot.Total = len(snapshot.Steps)
+	for _, step := range snapshot.Steps {
+		if step.Status.Done() {
+			snapshot.Done++
+		}
+	}
+
+	return snapshot
+}
+
+// Progress returns the share of the steps done, from 0 to 1
+func (s *WorkflowSnapshot) Progress() float64 {
+	if s.Total == 0 {
+		return 1
+	}
+
+	return float64(s.Done) / float64(s.Total)
+}
+
+// Step returns the snapshot of the step with the name, or nil if there is none
+func (s *WorkflowSnapshot) Step(name string) *StepSnapshot {
+	for _, step := range s.Steps {
+		if step.Name == name {
+			return step
+		}
+	}
+
+	return nil
+}
+
+// snapshot returns the view of the step. It's taken from its name, status
+// and transitions, which are changed under the lock of the workflow, and its
+// progress, which has its own
+func (s *Step) snapshot() *StepSnapshot {
+	snapshot := &StepSnapshot{
+		Name:        s.Name,
+		Stage:       s.stage,
+		Status:      s.status,
+		Transitions: append([]StepTransition{}, s.transitions...),
+		Progress:    s.progress.current(),
+	}
+	for _, transition := range s.transitions {
+		switch {
+		case transition.To == StepRunning:
+			snapshot.Attempts++
+			if snapshot.StartedAt.IsZero() {
+				snapshot.StartedAt = transition.At
+			}
+		case transition.To.Done():
+			snapshot.FinishedAt = transition.At
+		}
+	}
+
+	return snapshot
+}
+
+// setName changes the name of the step once it's rendered. The name is read
+// by Snapshot while the step runs
+func (s *Step) setName(name string) {
+	s.workflow.signal.Lock()
+	defer s.workflow.signal.Unlock()
+
+	s.Name = name
+}
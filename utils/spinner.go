@@ -3,9 +3,11 @@ package utils
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"syscall"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,16 +20,55 @@ type Spinner struct {
 	UUID string
 	Name string
 
-	cmd     string
-	args    []string
-	env     []string
-	timeout time.Duration
-	workdir string
-	step    Step
+	cmd         string
+	args        []string
+	env         []string
+	timeout     time.Duration
+	gracePeriod time.Duration
+	workdir     string
+	step        Step
+	capture     *capturedOutput
+	// agent runs the command on an agent matching it instead of here if set
+	agent *AgentSelector
+	// resources limits the resources the command can use
+	resources *ResourceLimits
+	// successExitCodes are the exit codes the command succeeds with. Only 0
+	// if empty
+	successExitCodes []int
+	// successOutput checks the output of the command when it's set
+	successOutput *SuccessOutput
+	// service runs the command without a timeout until it's stopped
+	service bool
+	// started is closed once the command of a service has started
+	started chan struct{}
+	// deadline is the time the command has to finish by, if set
+	deadline time.Time
+	// startedAt is when the command started
+	startedAt time.Time
+	// outputLimit caps the output of the command when it's set
+	outputLimit *OutputLimit
+	// signal, oomKilled and timedOut are how the command last exited
+	signal    string
+	oomKilled bool
+	timedOut  bool
+	// truncated is set if the last output of the command went past its limit
+	truncated *OutputTruncated
+	// usage is what the command used the last time it ran
+	usage *ResourceUsage
+	// progress is read from the output of the command of a step with a
+	// progress_regex. Its hooks and preflight checks don't change it
+	progress *stepProgress
+	// stallAfter is how long the command can go without output or using
+	// CPU before EventRunStalled is sent. 0 doesn't check
+	stallAfter time.Duration
 }
 
 // NewSpinnerForStep creates a new instance of Spinner based on the Options
 func NewSpinnerForStep(ctx context.Context, step Step) (*Spinner, error) {
+	if err := checkWiring(&step); err != nil {
+		return nil, err
+	}
+
 	spinner, err := newSpinnerForStep(ctx, step)
 	if err != nil {
 		return nil, err
@@ -40,6 +81,10 @@ func NewSpinnerForStep(ctx context.Context, step Step) (*Spinner, error) {
 
 // NewSpinnerForPreflight creates a new instance of Spinner based on the Options
 func NewSpinnerForPreflight(ctx context.Context, preflight *Preflight) (*Spinner, error) {
+	if err := checkWiring(preflight.step); err != nil {
+		return nil, err
+	}
+
 	spinner, err := newSpinnerForPreflight(ctx, preflight)
 	if err != nil {
 		return nil, err
@@ -50,8 +95,28 @@ func NewSpinnerForPreflight(ctx context.Context, preflight *Preflight) (*Spinner
 	return spinner, nil
 }
 
+// NewSpinnerForHook creates a new instance of Spinner for a hook of the step
+func NewSpinnerForHook(ctx context.Context, step Step, hook *Hook, kind string) (*Spinner, error) {
+	if err := checkWiring(&step); err != nil {
+		return nil, err
+	}
+
+	spinner, err := newSpinnerForHook(ctx, step, hook, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	spinner.validate(ctx)
+
+	return spinner, nil
+}
+
 // NewSpinnerForProbe creates a new instance of Spinner based on the Options
 func NewSpinnerForProbe(ctx context.Context, step Step) (*Spinner, error) {
+	if err := checkWiring(&step); err != nil {
+		return nil, err
+	}
+
 	spinner, err := newSpinnerForProbe(ctx, step)
 	if err != nil {
 		return nil, err
@@ -65,34 +130,97 @@ func NewSpinnerForProbe(ctx context.Context, step Step) (*Spinner, error) {
 func newSpinnerForStep(ctx context.Context, step Step) (*Spinner, error) {
 	if step.options == nil {
 		step.options = &StepOptions{
-			Notifier: step.workflow.options.Notifier,
+			Notifier: step.workflow.sendEvent,
 		}
 	}
 
-	parts, err := shellquote.Split(step.Command)
-	if err != nil {
-		return nil, err
+	id := uuid.New().String()
+	env := step.MergedEnv()
+
+	var parts []string
+	var err error
+	workdir := step.Workdir
+	switch step.Type {
+	case StepTypeK8sJob:
+		timeout := step.workflow.options.Timeout
+		if step.Timeout != nil {
+			timeout = *step.Timeout
+		}
+
+		var manifest string
+		if parts, manifest, err = step.K8sJob.commandParts("trackman-"+id, &step, timeout); err != nil {
+			return nil, err
+		}
+		env = append(env, manifest)
+	case StepTypeWorkflow, StepTypeQuorum:
+		// workflow and quorum steps run in process. the spinner is only used
+		// for their events
+		parts = []string{step.Type}
+	case StepTypeDocker:
+		if parts, err = step.stepCommandParts(); err != nil {
+			return nil, err
+		}
+		parts = step.Docker.commandParts("trackman-"+id, env, parts, step.Input != nil)
+	case StepTypeSSH:
+		if parts, err = step.stepCommandParts(); err != nil {
+			return nil, err
+		}
+		// the work directory is on the host
+		parts = step.SSH.commandParts(step.Workdir, env, parts)
+		workdir = ""
+	default:
+		if step.StopStep != "" {
+			// stop steps only stop a background step. the spinner is only used for their events
+			parts = []string{"stop"}
+			break
+		}
+		if parts, err = step.stepCommandParts(); err != nil {
+			return nil, err
+		}
 	}
 
-	return &Spinner{
-		UUID:    uuid.New().String(),
-		Name:    step.Name,
-		cmd:     parts[0],
-		args:    parts[1:],
-		step:    step,
-		env:     step.Env,
-		workdir: step.Workdir,
-	}, nil
+	spinner := &Spinner{
+		UUID:      id,
+		Name:      step.Name,
+		cmd:       parts[0],
+		args:      parts[1:],
+		step:      step,
+		env:       env,
+		workdir:   workdir,
+		agent:     step.Agent,
+		resources: step.Resources,
+
+		successExitCodes: step.SuccessExitCodes,
+		successOutput:    step.SuccessOutput,
+		service:          step.runsInBackground(),
+		outputLimit:      step.OutputLimit,
+		progress:         step.progress,
+	}
+	if spinner.service {
+		spinner.started = make(chan struct{})
+	}
+	if !spinner.service && step.Type != StepTypeWorkflow && step.Type != StepTypeQuorum {
+		spinner.stallAfter = step.workflow.options.StallAfter
+		if step.StallAfter != nil {
+			spinner.stallAfter = *step.StallAfter
+		}
+	}
+
+	if step.capturesStdout() {
+		spinner.capture = &capturedOutput{}
+	}
+
+	return spinner, nil
 }
 
 func newSpinnerForPreflight(ctx context.Context, preflight *Preflight) (*Spinner, error) {
 	if preflight.step.options == nil {
 		preflight.step.options = &StepOptions{
-			Notifier: preflight.step.workflow.options.Notifier,
+			Notifier: preflight.step.workflow.sendEvent,
 		}
 	}
 
-	parts, err := shellquote.Split(preflight.Command)
+	parts, err := preflight.step.commandParts(preflight.Command)
 	if err != nil {
 		return nil, err
 	}
@@ -109,41 +237,123 @@ func newSpinnerForPreflight(ctx context.Context, preflight *Preflight) (*Spinner
 		args:    parts[1:],
 		step:    *preflight.step,
 		workdir: preflight.Workdir,
-		env:     preflight.step.Env,
+		env:     preflight.step.MergedEnv(),
 		timeout: timeout,
 	}, nil
 }
 
-func newSpinnerForProbe(ctx context.Context, step Step) (*Spinner, error) {
+// newSpinnerForSkip creates a spinner that never runs, for the events of a
+// step skipped before its command is prepared
+func newSpinnerForSkip(step Step) *Spinner {
 	if step.options == nil {
 		step.options = &StepOptions{
-			Notifier: step.workflow.options.Notifier,
+			Notifier: step.workflow.sendEvent,
 		}
 	}
 
-	parts, err := shellquote.Split(step.Probe.Command)
+	return &Spinner{
+		UUID: uuid.New().String(),
+		Name: step.Name,
+		step: step,
+	}
+}
+
+func newSpinnerForHook(ctx context.Context, step Step, hook *Hook, kind string) (*Spinner, error) {
+	if step.options == nil {
+		step.options = &StepOptions{
+			Notifier: step.workflow.sendEvent,
+		}
+	}
+
+	parts, err := step.commandParts(hook.Command)
 	if err != nil {
 		return nil, err
 	}
 
+	var timeout time.Duration
+	if hook.Timeout != nil {
+		timeout = *hook.Timeout
+	}
+
+	workdir := hook.Workdir
+	if workdir == "" {
+		workdir = step.Workdir
+	}
+
+	return &Spinner{
+		UUID:    uuid.New().String(),
+		Name:    fmt.Sprintf("%s.%s", step.Name, kind),
+		cmd:     parts[0],
+		args:    parts[1:],
+		step:    step,
+		env:     step.MergedEnv(),
+		workdir: workdir,
+		timeout: timeout,
+	}, nil
+}
+
+func newSpinnerForProbe(ctx context.Context, step Step) (*Spinner, error) {
+	if step.options == nil {
+		step.options = &StepOptions{
+			Notifier: step.workflow.sendEvent,
+		}
+	}
+
+	// http and tcp probes are checked by trackman
+	parts := []string{"probe"}
+	if step.Probe.Command != "" {
+		var err error
+		if parts, err = step.commandParts(step.Probe.Command); err != nil {
+			return nil, err
+		}
+	}
+
+	var timeout time.Duration
+	if step.Probe.Timeout != nil {
+		timeout = *step.Probe.Timeout
+	}
+
+	workdir := step.Probe.Workdir
+	if workdir == "" {
+		workdir = step.Workdir
+	}
+
 	return &Spinner{
 		UUID:    uuid.New().String(),
 		Name:    fmt.Sprintf("%s.probe", step.Name),
 		cmd:     parts[0],
 		args:    parts[1:],
 		step:    step,
-		env:     step.Env,
-		workdir: step.Workdir,
+		env:     step.MergedEnv(),
+		workdir: workdir,
+		timeout: timeout,
 	}, nil
 }
 
+// checkWiring returns an error if the step isn't part of a loaded workflow,
+// which spinners need the options of
+func checkWiring(step *Step) error {
+	if step == nil {
+		return fmt.Errorf("no step to run")
+	}
+	if step.workflow == nil || step.workflow.options == nil {
+		return fmt.Errorf("step %s isn't part of a loaded workflow", step.Name)
+	}
+
+	return nil
+}
+
 func (s *Spinner) validate(ctx context.Context) {
-	if s.step.workflow == nil {
-		panic("no workflow")
+	if s.step.GracePeriod != nil {
+		s.gracePeriod = *s.step.GracePeriod
+	} else {
+		s.gracePeriod = s.step.workflow.options.GracePeriod
 	}
 
-	if s.step.workflow.options == nil {
-		panic("no workflow option")
+	// a spinner with its own timeout (like a preflight) keeps it,
+	// otherwise the step's timeout is used, falling back to the workflow's
+	if s.timeout != 0 {
+		return
 	}
 
 	if s.step.Timeout != nil {
@@ -155,9 +365,25 @@ func (s *Spinner) validate(ctx context.Context) {
 
 // Run runs the process required
 func (s *Spinner) Run(ctx context.Context) error {
+	ctx, span := s.step.workflow.startSpan(ctx, s.Name, map[string]string{
+		"trackman.step":         s.step.Name,
+		"trackman.spinner_uuid": s.UUID,
+	})
+	defer span.End()
+
+	err := s.run(ctx)
+	if err != nil {
+		span.SetError(err)
+	}
+
+	return err
+}
+
+func (s *Spinner) run(ctx context.Context) error {
+	s.signal, s.oomKilled, s.timedOut, s.truncated, s.usage = "", false, false, nil, nil
 	s.push(ctx, NewEvent(s, EventRunRequested, nil))
 
-	cmdCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	cmdCtx, cancel := s.commandContext(ctx)
 	defer cancel()
 
 	logger := s.step.logger
@@ -165,15 +391,152 @@ func (s *Spinner) Run(ctx context.Context) error {
 	// add this spinner to the context for the log writers
 	ctx = context.WithValue(ctx, CtxSpinner, s)
 
-	outChannel := NewLogWriter(ctx, logger, logrus.DebugLevel)
-	errChannel := NewLogWriter(ctx, logger, logrus.ErrorLevel)
+	var outChannel io.Writer = NewLogWriter(ctx, logger, logrus.DebugLevel)
+	var errChannel io.Writer = NewLogWriter(ctx, logger, logrus.ErrorLevel)
+	// the escape codes only stay in the output shown as it runs
+	stripANSI := s.step.workflow.options.StripANSI
+	if stripANSI {
+		outChannel, errChannel = newANSIStripper(outChannel), newANSIStripper(errChannel)
+	}
 
 	logger.WithField(FldStep, s.Name).Tracef("Running %s with %s", s.cmd, s.args)
 
 	cmd := exec.CommandContext(cmdCtx, s.cmd, s.args...)
-	cmd.Stderr = errChannel
-	cmd.Stdout = outChannel
+	var stdout, stderr io.Writer = outChannel, errChannel
+	closeStreams := func() {}
+	if output := s.step.workflow.output(); output != nil {
+		outStream := output.Writer(s.step.Name, s.Name)
+		errStream := output.Writer(s.step.Name, s.Name)
+		closeStreams = func() {
+			outStream.Close()
+			errStream.Close()
+		}
+
+		// the output is shown by the multiplexer, but still logged when
+		// the step logs to a file
+		stdout, stderr = outStream, errStream
+		if DefaultLogDefinition(s.step.Logger).Type == "file" {
+			stdout = io.MultiWriter(outChannel, outStream)
+			stderr = io.MultiWriter(errChannel, errStream)
+		}
+	}
+
+	if logs := s.step.workflow.options.StepLogs; logs != nil && logs.Dir != "" {
+		outFile, errFile, err := s.stepLogFiles()
+		if err != nil {
+			closeStreams()
+			s.push(ctx, NewEvent(s, EventRunError, nil))
+
+			return err
+		}
+
+		if s.step.workflow.timestamps() {
+			outFile, errFile = newTimestampWriter(outFile), newTimestampWriter(errFile)
+		}
+		var outLog, errLog io.Writer = outFile, errFile
+		if stripANSI {
+			outLog, errLog = newANSIStripper(outFile), newANSIStripper(errFile)
+		}
+		stdout = io.MultiWriter(stdout, outLog)
+		stderr = io.MultiWriter(stderr, errLog)
+		closeOutput := closeStreams
+		closeStreams = func() {
+			closeOutput()
+			outFile.Close()
+			errFile.Close()
+		}
+	}
+
+	if s.step.tail != nil {
+		var outTail, errTail io.Writer = s.step.tail, s.step.tail
+		if stripANSI {
+			outTail, errTail = newANSIStripper(s.step.tail), newANSIStripper(s.step.tail)
+		}
+		stdout = io.MultiWriter(stdout, outTail)
+		stderr = io.MultiWriter(stderr, errTail)
+	}
+
+	if progress := s.progress; progress != nil {
+		progress.reset()
+		changed := func(value float64) {
+			s.push(ctx, NewEvent(s, EventRunProgress, &ProgressPayload{Progress: value}))
+		}
+		stdout, stderr = progress.writer(stdout, changed), progress.writer(stderr, changed)
+	}
+
+	if s.outputLimit != nil {
+		limiter := newOutputLimiter(s.outputLimit, filepath.Join(os.TempDir(), "trackman-"+s.UUID+".out"))
+		stdout, stderr = limiter.writer(stdout), limiter.writer(stderr)
+		closeOutput := closeStreams
+		closeStreams = func() {
+			if s.truncated = limiter.close(); s.truncated != nil {
+				if s.truncated.Policy == OutputSpill && s.truncated.File == "" {
+					logger.WithField(FldStep, s.Name).Warn("Dropped the output past the limit as it couldn't be written to a file")
+				}
+				s.push(ctx, NewEvent(s, EventRunOutputTruncated, s.truncated))
+			}
+			closeOutput()
+		}
+	}
+
+	// secrets are masked before the output is shown or written anywhere
+	redactions := s.step.workflow.redactions
+	maskedOut, maskedErr := newMaskingWriter(stdout, redactions), newMaskingWriter(stderr, redactions)
+	stdout, stderr = maskedOut, maskedErr
+	closeOutput := closeStreams
+	closeStreams = func() {
+		_ = maskedOut.Flush()
+		_ = maskedErr.Flush()
+		closeOutput()
+	}
+
+	// a stall past the timeout is never seen
+	var watchdog *stallWatchdog
+	if s.stallAfter > 0 && (s.timeout == 0 || s.stallAfter < s.timeout) {
+		watchdog = newStallWatchdog(s.stallAfter)
+		stdout, stderr = watchdog.writer(stdout), watchdog.writer(stderr)
+	}
+
+	cmd.Stderr = stderr
+	cmd.Stdout = stdout
+	if s.capture != nil {
+		cmd.Stdout = io.MultiWriter(stdout, s.capture)
+	}
+
+	var output *outputChecker
+	if s.successOutput != nil {
+		output = newOutputChecker(s.successOutput)
+		if s.successOutput.Stream != "stderr" {
+			cmd.Stdout = output.writer(cmd.Stdout)
+		}
+		if s.successOutput.Stream != "stdout" {
+			cmd.Stderr = output.writer(cmd.Stderr)
+		}
+	}
+
+	if s.step.Input != nil {
+		stdin, err := s.step.Input.open(&s.step)
+		if err != nil {
+			closeStreams()
+			s.push(ctx, NewEvent(s, EventRunError, nil))
+
+			return err
+		}
+		defer stdin.Close()
+
+		cmd.Stdin = stdin
+	}
+
+	if s.agent != nil {
+		err := s.runOnAgent(ctx, cmdCtx, cmd.Stdin, cmd.Stdout, cmd.Stderr, output)
+		closeStreams()
+
+		return err
+	}
 	envs := os.Environ()
+	if s.step.NoColor {
+		envs = withoutColorForcing(envs)
+	}
 	for _, env := range s.env {
 		envs = append(envs, env)
 	}
@@ -181,34 +544,128 @@ func (s *Spinner) Run(ctx context.Context) error {
 	cmd.Env = envs
 	cmd.Dir = s.workdir
 
-	err := cmd.Start()
+	var resources *resourceGroup
+	if s.resources != nil {
+		resources = newResourceGroup(s.resources, "trackman-"+s.UUID)
+		defer resources.close()
+
+		if err := resources.prepare(cmd); err != nil {
+			logger.WithField(FldStep, s.Name).Warnf("Running without the memory limit: %s", err)
+		}
+	}
+
+	// the OOM killer might kill the command without a memory limit
+	oomKillsBefore := oomKills()
+
+	stopProcess := func() {}
+	start := func() (err error) {
+		stopProcess, err = StartProcess(cmd, s.gracePeriod)
+		return err
+	}
+	var err error
+	if resources != nil {
+		err = resources.start(start)
+	} else {
+		err = start()
+	}
 	if err != nil {
+		closeStreams()
 		s.push(ctx, NewEvent(s, EventRunError, nil))
 
 		return err
 	}
 
+	if resources != nil {
+		if err := resources.apply(cmd.Process.Pid); err != nil {
+			logger.WithField(FldStep, s.Name).Warnf("Running without some resource limits: %s", err)
+		}
+	}
+
 	s.push(ctx, NewEvent(s, EventRunStarted, nil))
+	s.markStarted()
+
+	stopWatchdog := func() {}
+	if watchdog != nil {
+		watchdog.touch()
+		// the CPU of docker, kubernetes and ssh steps isn't used here
+		cpu := func() (uint64, bool) { return 0, false }
+		if s.step.Type == "" || s.step.Type == StepTypeProcess {
+			pid := cmd.Process.Pid
+			cpu = func() (uint64, bool) { return processGroupCPU(pid) }
+		}
+
+		done := make(chan struct{})
+		stopWatchdog = func() { close(done) }
+		go watchdog.watch(done, cpu, func(since time.Time) {
+			s.push(ctx, NewEvent(s, EventRunStalled, &StalledPayload{Since: since, StallAfter: s.stallAfter}))
+		})
+	}
+
+	err = cmd.Wait()
+	stopProcess()
+	err = s.checkSuccess(err, output)
+	stopWatchdog()
+	closeStreams()
+	if s.step.Type == StepTypeDocker && cmdCtx.Err() != nil {
+		if err := removeContainer("trackman-" + s.UUID); err != nil {
+			logger.WithField(FldStep, s.Name).Warnf("Failed to remove the container of the step: %s", err)
+		}
+	}
+	s.signal = exitSignal(err)
+	// docker, kubernetes and ssh steps only run a client here
+	if s.step.Type == "" || s.step.Type == StepTypeProcess {
+		if s.usage = newResourceUsage(cmd.ProcessState, s.startedAt); s.usage != nil {
+			s.push(ctx, NewEvent(s, EventRunUsage, s.usage))
+		}
+	}
+	if resources != nil {
+		if exceeded := resources.exceeded(); exceeded != nil {
+			s.oomKilled = exceeded.Resource == "memory"
+			s.push(ctx, NewEvent(s, EventRunLimitExceeded, exceeded))
+		}
+	}
+	if !s.oomKilled && err != nil {
+		s.oomKilled = killedByOOM(err, oomKillsBefore)
+	}
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			if s.service {
+				s.push(ctx, NewEvent(s, EventServiceStopped, nil))
+				return nil
+			}
+
+			s.push(ctx, NewEvent(s, EventRunCancelled, nil))
+
+			return fmt.Errorf("Cancelled")
+		}
+
+		if s.pastDeadline(cmdCtx) {
+			s.push(ctx, NewEvent(s, EventRunDeadline, &DeadlinePayload{Deadline: s.deadline}))
+
+			return fmt.Errorf("Stopped at its deadline %s", s.deadline.Format(time.RFC3339))
+		}
 
-	if err := cmd.Wait(); err != nil {
 		if cmdCtx.Err() == context.DeadlineExceeded {
-			s.push(ctx, NewEvent(s, EventRunTimeout, nil))
+			s.timedOut = true
+			s.push(ctx, NewEvent(s, EventRunTimeout, s.timeoutPayload()))
+			s.step.workflow.metrics().StepTimedOut(&s.step)
+			spanFromContext(ctx).AddEvent("timeout", map[string]string{
+				"trackman.timeout": s.timeout.String(),
+			})
 
 			return fmt.Errorf("Timed out after %s", s.timeout)
 		}
 
-		if exitErr, ok := err.(*exec.ExitError); ok {
+		if code, ok := exitCode(err); ok {
 			// The program has exited with an exit code != 0
-			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-				s.push(ctx, NewEvent(s, EventRunFail, status))
-				return exitErr
-			}
-		} else {
-			// wait error
-			s.push(ctx, NewEvent(s, EventRunWaitError, s))
-
-			return exitErr
+			s.push(ctx, NewEvent(s, EventRunFail, &RunFailPayload{ExitCode: code, Signal: s.signal, OOMKilled: s.oomKilled}))
+			return err
 		}
+
+		// wait error
+		s.push(ctx, NewEvent(s, EventRunWaitError, &ErrorPayload{Error: s.step.workflow.MaskSecrets(err.Error())}))
+
+		return err
 	}
 
 	s.push(ctx, NewEvent(s, EventRunSuccess, nil))
@@ -216,9 +673,96 @@ func (s *Spinner) Run(ctx context.Context) error {
 	return nil
 }
 
+// capturedOutput returns the output of the command if it was captured
+func (s *Spinner) capturedOutput() (string, error) {
+	if s.capture == nil {
+		return "", nil
+	}
+
+	return s.capture.String()
+}
+
 func (s *Spinner) push(ctx context.Context, event *Event) {
 	err := s.step.options.Notifier(ctx, s.step.logger, event)
 	if err != nil {
 		fmt.Println(err)
 	}
 }
+
+// commandParts splits the command into the executable and its arguments. If
+// the step should run in a shell, the shell is returned with the command as
+// its argument
+func (s *Step) commandParts(command string) ([]string, error) {
+	var parts []string
+	var err error
+
+	shell := s.shell()
+	if shell == "" {
+		parts, err = shellquote.Split(command)
+	} else {
+		parts, err = shellquote.Split(shell)
+		parts = append(parts, shellArgs(parts)...)
+		parts = append(parts, command)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no command for %s", s.Name)
+	}
+
+	return parts, nil
+}
+
+// shell returns the shell the step should run in or empty if it should
+// run directly
+func (s *Step) shell() string {
+	shell := s.Shell
+	if shell == "" && s.workflow != nil {
+		shell = s.workflow.Shell
+	}
+
+	switch shell {
+	case "", "false":
+		return ""
+	case "true":
+		return defaultShell
+	default:
+		return shell
+	}
+}
+
+// shellArgs returns the arguments the shell takes the command to run after.
+// cmd and PowerShell don't take -c like the Unix shells
+func shellArgs(shell []string) []string {
+	if len(shell) == 0 {
+		return []string{"-c"}
+	}
+
+	name := strings.ToLower(filepath.Base(shell[0]))
+	name = strings.TrimSuffix(name, ".exe")
+	switch name {
+	case "cmd":
+		return []string{"/S", "/C"}
+	case "powershell", "pwsh":
+		return []string{"-NoProfile", "-NonInteractive", "-Command"}
+	default:
+		return []string{"-c"}
+	}
+}
+
+// exitCode returns the exit code of a process from the error returned by Run
+func exitCode(err error) (int, bool) {
+	if agentErr, ok := err.(*AgentExitError); ok {
+		return agentErr.ExitCode, true
+	}
+	if checkErr, ok := err.(*SuccessCheckError); ok {
+		return checkErr.ExitCode, true
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), true
+	}
+
+	return 0, false
+}
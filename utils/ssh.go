@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+)
+
+const (
+	// StepTypeSSH runs the command of the step on a remote host over SSH
+	StepTypeSSH = "ssh"
+
+	sshBinary = "ssh"
+)
+
+// SSHOptions configures the host an ssh step runs on
+type SSHOptions struct {
+	Host string `yaml:"host" json:"host"`
+	// User defaults to the one in the ssh config or the current user
+	User string `yaml:"user" json:"user"`
+	Port int    `yaml:"port" json:"port"`
+	// IdentityFile is the private key to log in with. The keys of the SSH
+	// agent are used if it's not set
+	IdentityFile string `yaml:"identity_file" json:"identity_file"`
+	// KnownHosts is the file the key of the host is checked against. Defaults
+	// to the known_hosts of the user
+	KnownHosts string `yaml:"known_hosts" json:"known_hosts"`
+	// ConnectTimeout is how long to wait for the connection to the host
+	ConnectTimeout *time.Duration `yaml:"connect_timeout" json:"connect_timeout"`
+}
+
+func (o *SSHOptions) validate() error {
+	if o == nil || strings.TrimSpace(o.Host) == "" {
+		return fmt.Errorf("ssh steps need a host")
+	}
+	if o.Port < 0 || o.Port > 65535 {
+		return fmt.Errorf("invalid ssh port %d", o.Port)
+	}
+
+	return nil
+}
+
+func (o *SSHOptions) enrich(ctx context.Context, step *Step) error {
+	var err error
+
+	for _, attribute := range []*string{&o.Host, &o.User, &o.IdentityFile, &o.KnownHosts} {
+		if *attribute, err = step.parseAttribute(ctx, *attribute); err != nil {
+			return err
+		}
+		if *attribute, err = ExpandEnvVars(ctx, *attribute); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commandParts wraps the parts of the command with the ssh command. The
+// command runs in the work directory on the host, with the environment of
+// the step exported first. The host has to be in known_hosts and no
+// passwords are asked for
+func (o *SSHOptions) commandParts(workdir string, env []string, parts []string) []string {
+	args := []string{sshBinary, "-T",
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=yes",
+	}
+	if o.KnownHosts != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+o.KnownHosts)
+	}
+	if o.IdentityFile != "" {
+		args = append(args, "-i", o.IdentityFile, "-o", "IdentitiesOnly=yes")
+	}
+	if o.ConnectTimeout != nil {
+		seconds := int(o.ConnectTimeout.Round(time.Second) / time.Second)
+		if seconds < 1 {
+			seconds = 1
+		}
+		args = append(args, "-o", "ConnectTimeout="+strconv.Itoa(seconds))
+	}
+	if o.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(o.Port))
+	}
+	if o.User != "" {
+		args = append(args, "-l", o.User)
+	}
+
+	// ssh runs the command with the shell of the user on the host
+	var remote []string
+	if workdir != "" {
+		remote = append(remote, "cd "+shellquote.Join(workdir))
+	}
+	if len(env) != 0 {
+		remote = append(remote, "export "+shellquote.Join(env...))
+	}
+	remote = append(remote, shellquote.Join(parts...))
+
+	return append(args, "--", o.Host, strings.Join(remote, " && "))
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Stage is a group of steps in a version 2 workflow. Steps in a stage can run
+// in parallel but only after all steps of the previous stage are done
+type Stage struct {
+	Name  string  `yaml:"name" json:"name"`
+	Steps []*Step `yaml:"steps" json:"steps"`
+}
+
+// StageName returns the name of the stage this step belongs to. It's empty
+// for version 1 workflows
+func (s *Step) StageName() string {
+	return s.stage
+}
+
+// flattenStages turns the stages of a version 2 workflow into steps. Each
+// step depends on all steps of the previous stage
+func (w *Workflow) flattenStages() error {
+	if len(w.Stages) == 0 {
+		return nil
+	}
+	if len(w.Steps) != 0 {
+		return fmt.Errorf("steps should be defined in stages for version 2 workflows")
+	}
+
+	var previous []*Step
+	for idx, stage := range w.Stages {
+		if stage.Name == "" {
+			return fmt.Errorf("stage %d has no name", idx+1)
+		}
+
+		for _, step := range stage.Steps {
+			step.stage = stage.Name
+			for _, priorStep := range previous {
+				if step.DependsOn.find(priorStep.Name) == nil {
+					step.DependsOn = append(step.DependsOn, &Dependency{Step: priorStep.Name})
+				}
+			}
+
+			w.Steps = append(w.Steps, step)
+		}
+
+		// steps after an empty stage still wait for the stages before it
+		if len(stage.Steps) != 0 {
+			previous = stage.Steps
+		}
+	}
+
+	// steps now hold all there is to know about the stages
+	w.Stages = nil
+
+	return nil
+}
+
+// MigrateWorkflowBytes converts a version 1 workflow into a version 2 one
+// with all the steps in a single stage
+func MigrateWorkflowBytes(buff []byte) ([]byte, error) {
+	var source yaml.MapSlice
+	if err := yaml.Unmarshal(buff, &source); err != nil {
+		return nil, err
+	}
+
+	result := make(yaml.MapSlice, 0, len(source))
+	for _, item := range source {
+		switch item.Key {
+		case "version":
+			if fmt.Sprintf("%v", item.Value) != "1" {
+				return nil, fmt.Errorf("only version 1 workflows can be migrated")
+			}
+
+			result = append(result, yaml.MapItem{Key: "version", Value: 2})
+		case "steps":
+			result = append(result, yaml.MapItem{
+				Key: "stages",
+				Value: []yaml.MapSlice{
+					{
+						{Key: "name", Value: "main"},
+						{Key: "steps", Value: item.Value},
+					},
+				},
+			})
+		default:
+			result = append(result, item)
+		}
+	}
+
+	return yaml.Marshal(result)
+}
+
+func contains(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFlattenStages(t *testing.T) {
+	options := &WorkflowOptions{Timeout: 10 * time.Second}
+	w, err := LoadWorkflowFromBytes(context.Background(), options, []byte(`
+version: 2
+stages:
+  - name: build
+    steps:
+      - name: api
+        command: echo api
+      - name: web
+        command: echo web
+  - name: empty
+  - name: test
+    steps:
+      - name: unit
+        command: echo unit
+  - name: deploy
+    steps:
+      - name: release
+        command: echo release
+        depends_on:
+          - api
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"api":     nil,
+		"web":     nil,
+		"unit":    {"api", "web"},
+		"release": {"api", "unit"},
+	}
+	stages := map[string]string{
+		"api":     "build",
+		"web":     "build",
+		"unit":    "test",
+		"release": "deploy",
+	}
+	if len(w.Steps) != len(want) {
+		t.Fatalf("there are %d steps, want %d", len(w.Steps), len(want))
+	}
+	for _, step := range w.Steps {
+		var dependsOn []string
+		for _, dependency := range step.DependsOn {
+			dependsOn = append(dependsOn, dependency.Step)
+		}
+		if !reflect.DeepEqual(dependsOn, want[step.Name]) {
+			t.Errorf("%s depends on %v, want %v", step.Name, dependsOn, want[step.Name])
+		}
+		if step.StageName() != stages[step.Name] {
+			t.Errorf("%s is in stage %s, want %s", step.Name, step.StageName(), stages[step.Name])
+		}
+	}
+}
+
+func TestStageWithoutName(t *testing.T) {
+	options := &WorkflowOptions{Timeout: 10 * time.Second}
+	_, err := LoadWorkflowFromBytes(context.Background(), options, []byte(`
+version: 2
+stages:
+  - steps:
+      - name: api
+        command: echo api
+`))
+	if err == nil {
+		t.Error("a stage without a name was loaded")
+	}
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// minStallCheck is the shortest interval the progress of a command is
+// checked at
+const minStallCheck = 10 * time.Millisecond
+
+// StalledPayload is the payload of EventRunStalled
+type StalledPayload struct {
+	// Since is when the command last printed output or used CPU
+	Since time.Time `json:"since"`
+	// StallAfter is how long the command can go without progress before
+	// it's stalled
+	StallAfter time.Duration `json:"stall_after"`
+}
+
+// stallWatchdog tells when a command goes without printing output and
+// without using CPU for longer than its period
+type stallWatchdog struct {
+	period       time.Duration
+	signal       *sync.Mutex
+	lastActivity time.Time
+}
+
+// activityWriter counts the output written to it as progress of the command
+type activityWriter struct {
+	watchdog *stallWatchdog
+	writer   io.Writer
+}
+
+func newStallWatchdog(period time.Duration) *stallWatchdog {
+	return &stallWatchdog{
+		period:       period,
+		signal:       &sync.Mutex{},
+		lastActivity: time.Now(),
+	}
+}
+
+// writer returns a writer counting the output going to out as progress
+func (w *stallWatchdog) writer(out io.Writer) io.Writer {
+	return &activityWriter{watchdog: w, writer: out}
+}
+
+// touch records progress of the command
+func (w *stallWatchdog) touch() {
+	w.signal.Lock()
+	defer w.signal.Unlock()
+
+	w.lastActivity = time.Now()
+}
+
+func (w *stallWatchdog) since() time.Time {
+	w.signal.Lock()
+	defer w.signal.Unlock()
+
+	return w.lastActivity
+}
+
+// watch checks the progress of the command until done is closed, and calls
+// stalled once each time the command stalls. cpu returns the CPU time used
+// by the command, and false if it's not known
+func (w *stallWatchdog) watch(done <-chan struct{}, cpu func() (uint64, bool), stalled func(since time.Time)) {
+	interval := w.period / 4
+	if interval < minStallCheck {
+		interval = minStallCheck
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastCPU uint64
+	reported := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		if used, ok := cpu(); ok && used != lastCPU {
+			lastCPU = used
+			w.touch()
+		}
+
+		since := w.since()
+		if time.Since(since) < w.period {
+			reported = false
+			continue
+		}
+		if !reported {
+			reported = true
+			stalled(since)
+		}
+	}
+}
+
+// Write implements io.Writer
+func (a *activityWriter) Write(p []byte) (int, error) {
+	a.watchdog.touch()
+
+	return a.writer.Write(p)
+}
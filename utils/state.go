@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// workflowState is what's saved in the state file to resume a workflow
+type workflowState struct {
+	SessionID string                `json:"session_id"`
+	Steps     map[string]*stepState `json:"steps"`
+}
+
+type stepState struct {
+	Status     string            `json:"status"`
+	RolledBack bool              `json:"rolled_back,omitempty"`
+	Outputs    map[string]string `json:"outputs,omitempty"`
+	Artifacts  []*Artifact       `json:"artifacts,omitempty"`
+}
+
+// saveState writes the state of all steps to the state file if there is one
+func (w *Workflow) saveState(ctx context.Context) error {
+	if w.stateFile == "" {
+		return nil
+	}
+
+	w.stateSignal.Lock()
+	defer w.stateSignal.Unlock()
+
+	buff, err := json.MarshalIndent(w.state(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file first so a crash never leaves a half written state
+	tempFile := w.stateFile + ".tmp"
+	if err = ioutil.WriteFile(tempFile, buff, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tempFile, w.stateFile)
+}
+
+// state is the state of all steps, read under the lock of the workflow as
+// the other steps can still be running
+func (w *Workflow) state() *workflowState {
+	w.signal.Lock()
+	defer w.signal.Unlock()
+
+	state := &workflowState{
+		SessionID: w.sessionID,
+		Steps:     make(map[string]*stepState, len(w.Steps)),
+	}
+	for _, step := range w.Steps {
+		state.Steps[step.Name] = &stepState{
+			Status:     step.result().Status,
+			RolledBack: step.rolledBack,
+			Outputs:    w.maskedOutputs(step.Name),
+			Artifacts:  step.artifacts,
+		}
+	}
+
+	return state
+}
+
+func loadState(stateFile string) (*workflowState, error) {
+	buff, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var state *workflowState
+	if err = json.Unmarshal(buff, &state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// Resume runs the workflow skipping all steps that have already finished
+// successfully according to the given state file. The state file is then
+// updated as the workflow runs
+func (w *Workflow) Resume(ctx context.Context, stateFile string) (*WorkflowResult, error) {
+	state, err := loadState(stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, step := range w.Steps {
+		previous, ok := state.Steps[step.Name]
+		// generate steps run again to add their steps to this run
+		if !ok || !previous.finished() || step.Generate {
+			continue
+		}
+
+		w.logger.WithField(FldStep, step.Name).Info("Already finished. Skipping")
+		if err = w.restoreStep(step, previous); err != nil {
+			return nil, err
+		}
+	}
+
+	w.stateFile = stateFile
+
+	return w.Run(ctx)
+}
+
+// finished returns true if the step doesn't need to run again
+func (s *stepState) finished() bool {
+	// rolled back steps need to run again
+	if s.RolledBack {
+		return false
+	}
+
+	return s.Status == ResultSuccess || s.Status == ResultSkipped || s.Status == ResultCached
+}
+
+// restoreStep marks the step as done with its state in a previous run
+func (w *Workflow) restoreStep(step *Step, previous *stepState) error {
+	step.skipped = previous.Status == ResultSkipped
+	step.cached = previous.Status == ResultCached
+	step.artifacts = previous.Artifacts
+	for name, value := range previous.Outputs {
+		w.setOutput(step.Name, name, value)
+	}
+
+	if step.skipped {
+		return step.markDone(StepSkipped)
+	}
+
+	return step.markDone(StepSucceeded)
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResume(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	load := func(command string) *Workflow {
+		t.Helper()
+
+		options := &WorkflowOptions{
+			Output:    NewOutputMultiplexer(&lockedBuffer{}, &MultiplexerOptions{NoColor: true, Raw: true}),
+			Timeout:   10 * time.Second,
+			StateFile: stateFile,
+		}
+		w, err := LoadWorkflowFromBytes(context.Background(), options, []byte(`
+version: 1
+steps:
+  - name: first
+    command: "true"
+  - name: second
+    command: "true"
+  - name: flaky
+    command: "`+command+`"
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return w
+	}
+
+	result, err := load("false").Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Failed()) != 1 {
+		t.Fatalf("%d steps failed in the first run, want 1", len(result.Failed()))
+	}
+	result, err = load("true").Resume(context.Background(), stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range result.Steps {
+		attempts := 0
+		if step.Name == "flaky" {
+			attempts = 1
+		}
+		if step.Status != ResultSuccess || step.Attempts != attempts {
+			t.Errorf("%s is %s after %d attempts, want success after %d", step.Name, step.Status, step.Attempts, attempts)
+		}
+	}
+}
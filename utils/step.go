@@ -1,54 +1,143 @@
 package utils
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"html/template"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
-)
-
-const (
-	stepPending = 1
-	stepRunning = 2
-	stepDone    = 3
+	"golang.org/x/sync/semaphore"
 )
 
 // StepOptions provides options for a Step
 type StepOptions struct {
-	Notifier func(ctx context.Context, logger *logrus.Logger, event *Event) error
+	Notifier Notifier
 }
 
 // Step is a single running Step
 type Step struct {
-	Metadata       map[string]string `yaml:"metadata" json:"metadata"`
-	Name           string            `yaml:"name" json:"name"`
-	Command        string            `yaml:"command" json:"command"`
-	ContinueOnFail bool              `yaml:"continue_on_fail" json:"continue_on_fail"`
-	Timeout        *time.Duration    `yaml:"timeout" json:"timeout"`
-	Workdir        string            `yaml:"workdir" json:"workdir"`
-	Env            []string          `yaml:"env" json:"env"`
-	Probe          *Probe            `yaml:"probe" json:"probe"`
-	DependsOn      []string          `yaml:"depends_on" json:"depends_on"`
-	Preflights     []Preflight       `yaml:"preflights" json:"preflights"`
-	AskToProceed   bool              `yaml:"ask_to_proceed" json:"ask_to_proceed"`
-	ShowCommand    bool              `yaml:"show_command" json:"show_command"`
-	Disabled       bool              `yaml:"disabled" json:"disabled"`
-	Logger         *LogDefinition    `yaml:"logger" json:"logger"`
-
-	options   *StepOptions
-	workflow  *Workflow
-	logger    *logrus.Logger
-	status    int
-	dependsOn []*Step
+	Metadata          map[string]string   `yaml:"metadata" json:"metadata"`
+	Name              string              `yaml:"name" json:"name"`
+	Command           string              `yaml:"command" json:"command"`
+	ContinueOnFail    bool                `yaml:"continue_on_fail" json:"continue_on_fail"`
+	Timeout           *time.Duration      `yaml:"timeout" json:"timeout"`
+	Deadline          string              `yaml:"deadline" json:"deadline"`
+	OnDeadline        string              `yaml:"on_deadline" json:"on_deadline"`
+	GracePeriod       *time.Duration      `yaml:"grace_period" json:"grace_period"`
+	Workdir           string              `yaml:"workdir" json:"workdir"`
+	Env               []string            `yaml:"env" json:"env"`
+	Probe             *Probe              `yaml:"probe" json:"probe"`
+	Service           bool                `yaml:"service" json:"service"`
+	Background        bool                `yaml:"background" json:"background"`
+	StopStep          string              `yaml:"stop_step" json:"stop_step"`
+	DependsOn         Dependencies        `yaml:"depends_on" json:"depends_on"`
+	Preflights        []Preflight         `yaml:"preflights" json:"preflights"`
+	AskToProceed      bool                `yaml:"ask_to_proceed" json:"ask_to_proceed"`
+	ShowCommand       bool                `yaml:"show_command" json:"show_command"`
+	Disabled          bool                `yaml:"disabled" json:"disabled"`
+	Logger            *LogDefinition      `yaml:"logger" json:"logger"`
+	Retry             *RetryPolicy        `yaml:"retry" json:"retry"`
+	When              string              `yaml:"when" json:"when"`
+	Shell             string              `yaml:"shell" json:"shell"`
+	OutputDefinitions []OutputDefinition  `yaml:"outputs" json:"outputs"`
+	Type              string              `yaml:"type" json:"type"`
+	Docker            *DockerOptions      `yaml:"docker" json:"docker"`
+	K8sJob            *K8sJobOptions      `yaml:"k8s_job" json:"k8s_job"`
+	SSH               *SSHOptions         `yaml:"ssh" json:"ssh"`
+	Agent             *AgentSelector      `yaml:"agent" json:"agent"`
+	Resources         *ResourceLimits     `yaml:"resources" json:"resources"`
+	Input             *Input              `yaml:"input" json:"input"`
+	SuccessExitCodes  []int               `yaml:"success_exit_codes" json:"success_exit_codes"`
+	SuccessOutput     *SuccessOutput      `yaml:"success_output" json:"success_output"`
+	Hooks             *Hooks              `yaml:"hooks" json:"hooks"`
+	Rollback          string              `yaml:"rollback" json:"rollback"`
+	Template          string              `yaml:"template" json:"template"`
+	Args              map[string]string   `yaml:"args" json:"args"`
+	MatrixDefinition  *MatrixDefinition   `yaml:"matrix" json:"matrix"`
+	Foreach           *Foreach            `yaml:"foreach" json:"foreach"`
+	SubWorkflow       *SubWorkflowOptions `yaml:"workflow" json:"workflow"`
+	Lock              string              `yaml:"lock" json:"lock"`
+	Group             string              `yaml:"group" json:"group"`
+	GlobalLock        string              `yaml:"global_lock" json:"global_lock"`
+	Priority          int                 `yaml:"priority" json:"priority"`
+	Watch             []string            `yaml:"watch" json:"watch"`
+	CacheKey          *CacheKey           `yaml:"cache_key" json:"cache_key"`
+	Artifacts         []string            `yaml:"artifacts" json:"artifacts"`
+	NeedsArtifacts    []string            `yaml:"needs_artifacts" json:"needs_artifacts"`
+	Quorum            *Quorum             `yaml:"quorum" json:"quorum"`
+	Generate          bool                `yaml:"generate" json:"generate"`
+	OutputLimit       *OutputLimit        `yaml:"output_limit" json:"output_limit"`
+	StallAfter        *time.Duration      `yaml:"stall_after" json:"stall_after"`
+	ProgressRegex     string              `yaml:"progress_regex" json:"progress_regex"`
+	NoColor           bool                `yaml:"no_color" json:"no_color"`
+	Exec              []string            `yaml:"-" json:"exec"`
+
+	options    *StepOptions
+	workflow   *Workflow
+	logger     *logrus.Logger
+	status     StepStatus
+	dependsOn  []*Step
+	stage      string
+	attempts   int
+	retryAt    time.Time
+	exitCode   int
+	signal     string
+	oomKilled  bool
+	timedOut   bool
+	usage      *ResourceUsage
+	err        error
+	skipped    bool
+	cached     bool
+	cancelled  bool
+	rolledBack bool
+	include    *Include
+	matrix     *matrixGroup
+	// locks are the semaphores of the lock and group of the step
+	locks []*semaphore.Weighted
+	// matrixValues are the values of the matrix for this step
+	matrixValues map[string]string
+	// item is the foreach item the command is running for
+	item string
+	// foreachExec is the command of a foreach step given as a list before
+	// it's rendered
+	foreachExec []string
+	// foreachCommand is the command of a foreach step before it's rendered
+	foreachCommand string
+	startedAt      time.Time
+	finishedAt     time.Time
+	// cacheKeyValue is the cache key of the step when it ran
+	cacheKeyValue string
+	// artifacts are the files the step saved to the artifact store
+	artifacts []*Artifact
+	// tail keeps the last lines of the output when OutputTail is set
+	tail *outputTail
+	// degraded is set for quorum steps that met their quorum with failures
+	degraded bool
+	// inQuorum is set for the steps a quorum step depends on
+	inQuorum bool
+	// transitions are the changes of status of the step
+	transitions []StepTransition
+	// outputTruncated is set if the output of the step went past its
+	// output_limit
+	outputTruncated *OutputTruncated
+	// globalUnlock releases the global lock of the step while it holds it
+	globalUnlock func() error
+	// waitingForLock is set once waiting for the global lock is logged
+	waitingForLock bool
+	// progress is read from the output when the step has a progress_regex
+	progress *stepProgress
+	// line is the line the step starts on in the workflow file, if known
+	line int
+	// enriched is set once the attributes of the step are rendered, so
+	// retries don't render them again
+	enriched bool
 }
 
 // String overrides string
 func (s *Step) String() string {
-	str := fmt.Sprintf("%s: %d", s.Name, s.status)
+	str := fmt.Sprintf("%s: %s", s.Name, s.status)
 	var deps []string
 	for _, step := range s.dependsOn {
 		deps = append(deps, step.String())
@@ -75,18 +164,50 @@ func (s *Step) MergedMetadata() map[string]string {
 	return result
 }
 
-// shouldRun returns a step that can be run, hasn't started, isn't done and isn't marked to be done
+// Var returns the workflow variables, or the variables of the included
+// workflow for included steps
+func (s *Step) Var() map[string]string {
+	if s.include != nil {
+		return s.include.resolved
+	}
+
+	return s.workflow.Var()
+}
+
+// MergedEnv returns the environment variables of the workflow followed by the
+// ones from the step so the step ones override the workflow ones
+func (s *Step) MergedEnv() []string {
+	result := make([]string, 0, len(s.workflow.Env)+len(s.Env))
+	result = append(result, s.workflow.Env...)
+	result = append(result, s.Env...)
+	if s.NoColor {
+		result = append(withoutColorForcing(result), noColorEnv...)
+	}
+
+	return result
+}
+
+// shouldRun returns true if the step can be queued to run: it's pending or
+// waiting for a retry, and its dependencies are done
 func (s *Step) shouldRun() bool {
-	// has this run or marked to run?
-	status := s.status != stepRunning && s.status != stepDone && s.status != stepPending
-	if !status {
-		// if it has, then it shouldn't run
+	// has this run or been queued to run?
+	if !s.status.CanTransitionTo(StepQueued) {
+		return false
+	}
+
+	// is this waiting for a retry?
+	if s.status == StepRetrying && time.Now().Before(s.retryAt) {
+		return false
+	}
+
+	// are there too many steps of its matrix running?
+	if s.matrix != nil && s.matrix.full() {
 		return false
 	}
 
 	// this can run but how about the dependencies?
 	for _, step := range s.dependsOn {
-		if !step.isDone() {
+		if !step.status.Done() {
 			// there is a dependency that is not done
 			return false
 		}
@@ -96,13 +217,25 @@ func (s *Step) shouldRun() bool {
 	return true
 }
 
-func (s *Step) isDone() bool {
-	return s.status == stepDone
+// ExitCode returns the exit status of the last run of the step's command
+func (s *Step) ExitCode() int {
+	return s.exitCode
 }
 
-// MarkAsPending marks the step as pending meaning it's waiting to run
-func (s *Step) MarkAsPending() {
-	s.status = stepPending
+// Failed returns true if the step has run and failed
+func (s *Step) Failed() bool {
+	return s.err != nil
+}
+
+// Skipped returns true if the step was skipped because of its when condition
+func (s *Step) Skipped() bool {
+	return s.skipped
+}
+
+// Cached returns true if the step didn't run because its cache key matched a
+// previous successful run
+func (s *Step) Cached() bool {
+	return s.cached
 }
 
 // GetMetaData returns metadata value of the key from this Step.
@@ -118,26 +251,143 @@ func (s *Step) GetMetaData(key string) string {
 
 // Run runs a Step and its probe
 func (s *Step) Run(ctx context.Context) error {
-	s.status = stepRunning
-	defer func() { s.status = stepDone }()
+	if err := s.moveTo(StepRunning); err != nil {
+		return err
+	}
+
+	ctx, span := s.workflow.startSpan(ctx, s.Name, map[string]string{
+		"trackman.step":    s.Name,
+		"trackman.stage":   s.stage,
+		"trackman.attempt": strconv.Itoa(s.attempts + 1),
+	})
+	defer span.End()
+
+	err := s.run(ctx)
+	s.update(func() {
+		switch {
+		case err != nil:
+			s.err = err
+		case s.status != StepRetrying:
+			// steps continuing on failure keep their error
+			err = s.err
+		}
+		s.cancelled = err != nil && ctx.Err() != nil
+	})
+	if finishErr := s.finish(); finishErr != nil && err == nil {
+		err = finishErr
+	}
+	if err != nil {
+		span.SetError(err)
+	}
+	if s.skipped {
+		span.AddEvent("skipped", nil)
+	}
+
+	return err
+}
+
+func (s *Step) run(ctx context.Context) (err error) {
+	s.update(func() {
+		s.attempts++
+		if s.startedAt.IsZero() {
+			s.startedAt = time.Now()
+		}
+	})
+	if lines := s.workflow.options.OutputTail; lines > 0 && s.tail == nil {
+		s.tail = newOutputTail(lines)
+	}
+	if s.ProgressRegex != "" && s.progress == nil {
+		// the progress is read by Snapshot while the step runs
+		s.workflow.signal.Lock()
+		s.progress = newStepProgress(s.ProgressRegex)
+		s.workflow.signal.Unlock()
+	}
+	// hooks run before the step is done so the steps depending on it wait for them
+	defer func() {
+		s.runCompletionHooks(ctx, err)
+	}()
 
 	if s.Disabled {
 		s.logger.WithField(FldStep, s.Name).Info("Disabled step. Skipping")
 		return nil
 	}
 
-	err := s.EnrichStep(ctx)
+	if !s.enriched {
+		if err = s.EnrichStep(ctx); err != nil {
+			return err
+		}
+		s.enriched = true
+	}
+
+	// skipped steps are skipped before their command is prepared to run
+	if dependency := s.unmetDependency(); dependency != nil {
+		s.update(func() { s.skipped = true })
+		s.logger.WithField(FldStep, s.Name).Infof("Skipping as %s didn't finish with %s", dependency.Step, dependency.Status)
+		s.pushSkipped(ctx)
+		return nil
+	}
+
+	shouldRun, err := s.evaluateCondition(ctx)
 	if err != nil {
 		return err
 	}
+	if !shouldRun {
+		s.update(func() { s.skipped = true })
+		s.pushSkipped(ctx)
+		return nil
+	}
 
-	spinner, err := NewSpinnerForStep(ctx, *s)
+	main := *s
+	if s.Foreach != nil {
+		// the command of foreach steps is rendered for each item. this
+		// spinner never runs it
+		main.Command = s.foreachCommand
+	}
+	spinner, err := NewSpinnerForStep(ctx, main)
 	if err != nil {
 		return err
 	}
+	if s.Deadline != "" {
+		if inTime, err := s.checkDeadline(ctx, spinner); !inTime {
+			return err
+		}
+	}
+	if s.restoreFromCache(ctx, spinner) {
+		return nil
+	}
+	if err = s.materializeArtifacts(); err != nil {
+		return err
+	}
+	if s.workflow.options.Debug {
+		var proceed bool
+		if spinner, proceed, err = s.debug(ctx, spinner); !proceed {
+			return err
+		}
+	}
 
-	err = spinner.Run(ctx)
+	// a failed pre_run hook fails the step like its command would
+	if err = s.runHooks(ctx, s.Hooks, HookPreRun); err == nil {
+		switch {
+		case s.Type == StepTypeWorkflow:
+			err = s.runSubWorkflow(ctx, spinner)
+		case s.Type == StepTypeQuorum:
+			err = s.runQuorum(ctx, spinner)
+		case s.Foreach != nil:
+			err = s.runForeach(ctx)
+		case s.StopStep != "":
+			err = s.stopBackgroundStep(ctx, spinner)
+		case s.runsInBackground():
+			err = s.startService(ctx, spinner)
+		default:
+			err = spinner.Run(ctx)
+		}
+	}
+	s.recordAttempt(spinner, err)
 	if err != nil {
+		if s.scheduleRetry(ctx, spinner, err) {
+			return nil
+		}
+
 		if !s.ContinueOnFail {
 			// main spinner failed and we need to get out
 			return err
@@ -149,7 +399,7 @@ func (s *Step) Run(ctx context.Context) error {
 	// main spinner is done. we should use the probe to check if
 	// it was successful
 
-	if s.Probe != nil {
+	if s.Probe != nil && !s.runsInBackground() {
 		probeSpinner, err := NewSpinnerForProbe(ctx, *s)
 		if err != nil {
 			return err
@@ -157,9 +407,14 @@ func (s *Step) Run(ctx context.Context) error {
 
 		probeSpinner.push(ctx, NewEvent(probeSpinner, EventRunningProbe, nil))
 
-		err = probeSpinner.Run(ctx)
+		err = s.Probe.run(ctx, probeSpinner)
 		if err != nil {
 			// probe failed
+			s.update(func() { s.err = err })
+			if s.scheduleRetry(ctx, probeSpinner, err) {
+				return nil
+			}
+
 			if !s.ContinueOnFail {
 				return err
 			}
@@ -168,14 +423,78 @@ func (s *Step) Run(ctx context.Context) error {
 		}
 	}
 
+	if s.err == nil {
+		stdout, err := spinner.capturedOutput()
+		if err != nil {
+			return err
+		}
+		if err = s.collectOutputs(ctx, stdout); err != nil {
+			return err
+		}
+		if err = s.collectArtifacts(); err != nil {
+			return err
+		}
+		if s.Generate {
+			if err = s.generateSteps(ctx, spinner, stdout); err != nil {
+				return err
+			}
+		}
+
+		s.saveToCache()
+	}
+
 	return nil
 }
 
+// recordAttempt keeps how the command of the step finished in its last
+// attempt
+func (s *Step) recordAttempt(spinner *Spinner, err error) {
+	s.update(func() {
+		s.exitCode, _ = exitCode(err)
+		s.signal, s.oomKilled, s.timedOut = spinner.signal, spinner.oomKilled, spinner.timedOut
+		s.outputTruncated = spinner.truncated
+		s.usage = spinner.usage
+		s.err = err
+	})
+}
+
+// scheduleRetry marks the step to be picked up again by the workflow if its
+// retry policy allows another attempt after the given error
+func (s *Step) scheduleRetry(ctx context.Context, spinner *Spinner, err error) bool {
+	if s.Retry == nil || ctx.Err() != nil || !s.Retry.shouldRetry(s.attempts, err) {
+		return false
+	}
+
+	delay := s.Retry.delay(s.attempts + 1)
+	spanFromContext(ctx).AddEvent("retry", map[string]string{
+		"trackman.attempt": strconv.Itoa(s.attempts + 1),
+		"trackman.delay":   delay.String(),
+	})
+	spinner.push(ctx, NewEvent(spinner, EventRunRetry, &RetryAttempt{
+		Attempt:     s.attempts + 1,
+		MaxAttempts: s.Retry.MaxAttempts,
+		Delay:       delay,
+	}))
+
+	s.retryAt = time.Now().Add(delay)
+	if err := s.moveTo(StepRetrying); err != nil {
+		return false
+	}
+	time.AfterFunc(delay, s.workflow.notify)
+
+	return true
+}
+
 // EnrichStep resolves environment variables and parses the command for the step
 // on all applicable attributes
 func (s *Step) EnrichStep(ctx context.Context) error {
 	var err error
 
+	if s.Foreach != nil && s.foreachCommand == "" {
+		s.foreachCommand = s.Command
+		s.foreachExec = s.Exec
+	}
+
 	// parse for meta data
 	if s.Metadata != nil {
 		for idx, metadata := range s.Metadata {
@@ -184,20 +503,66 @@ func (s *Step) EnrichStep(ctx context.Context) error {
 			}
 		}
 	}
-	if s.Command, err = s.parseAttribute(ctx, s.Command); err != nil {
+	if len(s.Exec) != 0 {
+		exec, err := s.renderExec(ctx, s.Exec)
+		if err != nil {
+			return err
+		}
+		s.setExec(exec)
+	} else if s.Command, err = s.parseAttribute(ctx, s.Command); err != nil {
 		return err
 	}
-	if s.Name, err = s.parseAttribute(ctx, s.Name); err != nil {
+	name, err := s.parseAttribute(ctx, s.Name)
+	if err != nil {
 		return err
 	}
+	s.setName(name)
 	if s.Workdir, err = s.parseAttribute(ctx, s.Workdir); err != nil {
 		return err
 	}
+	if s.Rollback, err = s.parseAttribute(ctx, s.Rollback); err != nil {
+		return err
+	}
+	for idx, env := range s.Env {
+		if s.Env[idx], err = s.parseAttribute(ctx, env); err != nil {
+			return err
+		}
+	}
 	if s.Probe != nil {
-		if s.Probe.Command, err = s.parseAttribute(ctx, s.Probe.Command); err != nil {
+		if err = s.Probe.enrich(ctx, s); err != nil {
+			return err
+		}
+	}
+	if s.Docker != nil {
+		if err = s.Docker.enrich(ctx, s); err != nil {
+			return err
+		}
+	}
+	if s.K8sJob != nil {
+		if err = s.K8sJob.enrich(ctx, s); err != nil {
+			return err
+		}
+	}
+	if s.SSH != nil {
+		if err = s.SSH.enrich(ctx, s); err != nil {
 			return err
 		}
-		if s.Probe.Workdir, err = s.parseAttribute(ctx, s.Probe.Workdir); err != nil {
+	}
+	if s.Input != nil {
+		if err = s.Input.enrich(ctx, s); err != nil {
+			return err
+		}
+	}
+	if err = s.Hooks.enrich(ctx, s.parseAttribute); err != nil {
+		return err
+	}
+	if s.Foreach != nil {
+		if err = s.Foreach.enrich(ctx, s); err != nil {
+			return err
+		}
+	}
+	if s.SubWorkflow != nil {
+		if err = s.SubWorkflow.enrich(ctx, s); err != nil {
 			return err
 		}
 	}
@@ -215,6 +580,11 @@ func (s *Step) EnrichStep(ctx context.Context) error {
 			return err
 		}
 	}
+	for idx, output := range s.OutputDefinitions {
+		if s.OutputDefinitions[idx].File, err = s.parseAttribute(ctx, output.File); err != nil {
+			return err
+		}
+	}
 	if s.Preflights != nil {
 		for idx, preFlight := range s.Preflights {
 			if s.Preflights[idx].Command, err = s.parseAttribute(ctx, preFlight.Command); err != nil {
@@ -237,29 +607,34 @@ func (s *Step) EnrichStep(ctx context.Context) error {
 			}
 		}
 	}
-	if s.Command, err = ExpandEnvVars(ctx, s.Command); err != nil {
-		return err
+	if len(s.Exec) == 0 {
+		if s.Command, err = ExpandEnvVars(ctx, s.Command); err != nil {
+			return err
+		}
 	}
 	if s.Workdir, err = ExpandEnvVars(ctx, s.Workdir); err != nil {
 		return err
 	}
-	if s.Command, err = ExpandEnvVars(ctx, s.Command); err != nil {
+	if s.Rollback, err = ExpandEnvVars(ctx, s.Rollback); err != nil {
 		return err
 	}
-	if s.Name, err = ExpandEnvVars(ctx, s.Name); err != nil {
-		return err
+	if len(s.Exec) == 0 {
+		if s.Command, err = ExpandEnvVars(ctx, s.Command); err != nil {
+			return err
+		}
 	}
-	if s.Workdir, err = ExpandEnvVars(ctx, s.Workdir); err != nil {
+	if name, err = ExpandEnvVars(ctx, s.Name); err != nil {
 		return err
 	}
-	if s.Probe != nil {
-		if s.Probe.Command, err = ExpandEnvVars(ctx, s.Probe.Command); err != nil {
-			return err
-		}
-		if s.Probe.Workdir, err = ExpandEnvVars(ctx, s.Probe.Workdir); err != nil {
+	s.setName(name)
+	for idx, env := range s.Env {
+		if s.Env[idx], err = ExpandEnvVars(ctx, env); err != nil {
 			return err
 		}
 	}
+	if s.Workdir, err = ExpandEnvVars(ctx, s.Workdir); err != nil {
+		return err
+	}
 	if s.Logger != nil {
 		if s.Logger.Destination, err = ExpandEnvVars(ctx, s.Logger.Destination); err != nil {
 			return err
@@ -274,6 +649,11 @@ func (s *Step) EnrichStep(ctx context.Context) error {
 			return err
 		}
 	}
+	for idx, output := range s.OutputDefinitions {
+		if s.OutputDefinitions[idx].File, err = ExpandEnvVars(ctx, output.File); err != nil {
+			return err
+		}
+	}
 	if s.Preflights != nil {
 		for idx, preFlight := range s.Preflights {
 			if s.Preflights[idx].Command, err = ExpandEnvVars(ctx, preFlight.Command); err != nil {
@@ -292,20 +672,94 @@ func (s *Step) EnrichStep(ctx context.Context) error {
 }
 
 func (s *Step) parseAttribute(ctx context.Context, value string) (string, error) {
-	if value == "" {
-		return "", nil
+	return renderTemplateWithFuncs("step", value, s, s.workflow.templateFuncs(ctx), s.workflow.TextTemplates)
+}
+
+// validateType checks the type of the step has what it needs to run
+func (s *Step) validateType() error {
+	if s.Resources != nil {
+		if (s.Type != "" && s.Type != StepTypeProcess) || s.Agent != nil {
+			return fmt.Errorf("only process steps running on this machine can have resources")
+		}
+		if err := s.Resources.validate(); err != nil {
+			return err
+		}
 	}
 
-	buf := &bytes.Buffer{}
-	tmpl, err := template.New("step").Parse(value)
-	if err != nil {
-		return "", err
+	if s.Input != nil {
+		if s.Type == StepTypeK8sJob || s.Type == StepTypeWorkflow {
+			return fmt.Errorf("%s steps can't have an input", s.Type)
+		}
+		if err := s.Input.validate(); err != nil {
+			return err
+		}
 	}
 
-	err = tmpl.Execute(buf, s)
-	if err != nil {
-		return "", err
+	if s.OutputLimit != nil {
+		if s.Type == StepTypeWorkflow || s.Type == StepTypeQuorum {
+			return fmt.Errorf("%s steps can't have an output_limit", s.Type)
+		}
+		if err := s.OutputLimit.validate(); err != nil {
+			return err
+		}
+	}
+
+	if s.ProgressRegex != "" && (s.Type == StepTypeWorkflow || s.Type == StepTypeQuorum) {
+		return fmt.Errorf("%s steps can't have a progress_regex", s.Type)
+	}
+	if s.StallAfter != nil && (s.Type == StepTypeWorkflow || s.Type == StepTypeQuorum || s.runsInBackground()) {
+		return fmt.Errorf("workflow, quorum and background steps can't have a stall_after")
 	}
 
-	return buf.String(), nil
+	if s.SuccessOutput != nil {
+		if err := s.SuccessOutput.validate(); err != nil {
+			return err
+		}
+	}
+	if (len(s.SuccessExitCodes) != 0 || s.SuccessOutput != nil) && s.Type == StepTypeWorkflow {
+		return fmt.Errorf("workflow steps can't have success_exit_codes or success_output")
+	}
+
+	if s.Service && s.Probe == nil {
+		return fmt.Errorf("service steps need a probe")
+	}
+	if s.runsInBackground() {
+		if s.Type != "" && s.Type != StepTypeProcess && s.Type != StepTypeDocker {
+			return fmt.Errorf("%s steps can't run in the background", s.Type)
+		}
+		if s.Foreach != nil || len(s.OutputDefinitions) != 0 || len(s.Artifacts) != 0 {
+			return fmt.Errorf("background steps can't have foreach, outputs or artifacts")
+		}
+	}
+	if s.Generate {
+		if err := s.validateGenerate(); err != nil {
+			return err
+		}
+	}
+	if s.StopStep != "" {
+		if s.Command != "" || s.Type != "" || s.Probe != nil || s.Foreach != nil || s.runsInBackground() {
+			return fmt.Errorf("steps with stop_step can't have a command, type, probe or foreach, or run in the background")
+		}
+	}
+
+	switch s.Type {
+	case "", StepTypeProcess:
+		return nil
+	case StepTypeDocker:
+		return s.Docker.validate()
+	case StepTypeK8sJob:
+		return s.K8sJob.validate(s.Command)
+	case StepTypeSSH:
+		return s.SSH.validate()
+	case StepTypeWorkflow:
+		if s.Foreach != nil || s.Probe != nil || s.Agent != nil {
+			return fmt.Errorf("workflow steps can't have a probe, foreach or agent")
+		}
+
+		return s.SubWorkflow.validate()
+	case StepTypeQuorum:
+		return s.validateQuorum()
+	default:
+		return fmt.Errorf("invalid type %s", s.Type)
+	}
 }
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"context"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+	"gopkg.in/yaml.v2"
+)
+
+// seqIntoString is the end of the error of yaml reading a list into the
+// command of a step
+const seqIntoString = "cannot unmarshal !!seq into string"
+
+// step has no custom (un)marshalling so the methods below can use it
+type step Step
+
+// stepCommand is the command of a step in a workflow. It's either a string
+// or a list of the executable and its arguments:
+//
+//	command: kubectl apply -f manifest.yaml
+//	command: ["kubectl", "apply", "-f", "manifest with spaces.yaml"]
+type stepCommand struct {
+	exec   []string
+	isList bool
+}
+
+// UnmarshalYAML reads the command as a list, and ignores it if it's a
+// string
+func (c *stepCommand) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var command string
+	if err := unmarshal(&command); err == nil {
+		return nil
+	}
+
+	c.isList = true
+	return unmarshal(&c.exec)
+}
+
+// UnmarshalYAML reads a step with its command as a string or as a list. A
+// list is kept in Exec, and in Command quoted like a shell would
+func (s *Step) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var command struct {
+		Command stepCommand            `yaml:"command"`
+		Others  map[string]interface{} `yaml:",inline"`
+	}
+	if err := unmarshal(&command); err != nil {
+		return err
+	}
+
+	err := unmarshal((*step)(s))
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		// a list can't be read into Command. Any other errors are kept, with
+		// the type of the step they are about
+		var errors []string
+		dropped := !command.Command.isList
+		for _, problem := range typeErr.Errors {
+			if !dropped && strings.HasSuffix(problem, seqIntoString) {
+				dropped = true
+				continue
+			}
+			errors = append(errors, strings.Replace(problem, "type utils.step", "type utils.Step", -1))
+		}
+
+		err = nil
+		if len(errors) != 0 {
+			err = &yaml.TypeError{Errors: errors}
+		}
+	}
+	if command.Command.isList {
+		s.setExec(command.Command.exec)
+	}
+
+	return err
+}
+
+// MarshalYAML writes the command of the step as a list if it has one
+func (s *Step) MarshalYAML() (interface{}, error) {
+	if len(s.Exec) == 0 {
+		return (*step)(s), nil
+	}
+
+	buff, err := yaml.Marshal((*step)(s))
+	if err != nil {
+		return nil, err
+	}
+
+	var fields yaml.MapSlice
+	if err = yaml.Unmarshal(buff, &fields); err != nil {
+		return nil, err
+	}
+	for idx := range fields {
+		if fields[idx].Key == "command" {
+			fields[idx].Value = s.Exec
+		}
+	}
+
+	return fields, nil
+}
+
+// setExec sets the command of the step to the executable and its arguments,
+// with Command showing them quoted
+func (s *Step) setExec(exec []string) {
+	s.Exec = exec
+	s.Command = shellquote.Join(exec...)
+}
+
+// renderExec parses and expands the environment variables of each argument
+// of a command given as a list. They are never split
+func (s *Step) renderExec(ctx context.Context, exec []string) ([]string, error) {
+	rendered := make([]string, len(exec))
+	for idx, arg := range exec {
+		var err error
+		if rendered[idx], err = s.parseAttribute(ctx, arg); err != nil {
+			return nil, err
+		}
+		if rendered[idx], err = ExpandEnvVars(ctx, rendered[idx]); err != nil {
+			return nil, err
+		}
+	}
+
+	return rendered, nil
+}
+
+// stepCommandParts returns the executable and arguments of the command of
+// the step. A command given as a list runs as it is, without a shell
+func (s *Step) stepCommandParts() ([]string, error) {
+	if len(s.Exec) != 0 {
+		return append([]string{}, s.Exec...), nil
+	}
+
+	return s.commandParts(s.Command)
+}
@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultStepLogWorkflow = "workflow"
+
+// StepLogOptions configures writing the output of each step to its own files
+type StepLogOptions struct {
+	// Dir is where the files are written. Each workflow has a directory under
+	// it with a .out and .err file for each step
+	Dir string
+	// Workflow is the name of the workflow directory. Defaults to workflow
+	Workflow string
+	// MaxSize is the size in bytes a file can grow to before it's rotated.
+	// Files are never rotated if it's 0
+	MaxSize int64
+	// MaxBackups is the number of rotated files to keep. All are kept if it's 0
+	MaxBackups int
+	// MaxAge is how long rotated files are kept. They are kept forever if it's 0
+	MaxAge time.Duration
+}
+
+// stepLogFiles opens the files the output of the spinner is written to
+func (s *Spinner) stepLogFiles() (io.WriteCloser, io.WriteCloser, error) {
+	options := s.step.workflow.options.StepLogs
+
+	workflow := options.Workflow
+	if workflow == "" {
+		workflow = defaultStepLogWorkflow
+	}
+
+	dir := filepath.Join(options.Dir, workflow)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+
+	header := fmt.Sprintf("# session %s started %s at %s\n", s.step.workflow.sessionID, s.Name, time.Now().Format(time.RFC3339))
+
+	name := stepLogName(s.Name)
+	stdout, err := openRotatingFile(filepath.Join(dir, name+".out"), options, header)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stderr, err := openRotatingFile(filepath.Join(dir, name+".err"), options, header)
+	if err != nil {
+		stdout.Close()
+		return nil, nil, err
+	}
+
+	return stdout, stderr, nil
+}
+
+// stepLogName returns the name of the step to use in the paths of its files.
+// Path separators and other characters that can't be in file names are
+// replaced, so a step named ../x or a/b stays in the log directory
+func stepLogName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', 0:
+			return '_'
+		}
+
+		return r
+	}, name)
+
+	if name == "" || name == "." || name == ".." {
+		return strings.Repeat("_", len(name)+1)
+	}
+
+	return name
+}
+
+// rotatingFile is a file that is rotated once it grows past its maximum size
+type rotatingFile struct {
+	path    string
+	options *StepLogOptions
+	file    *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, options *StepLogOptions, header string) (*rotatingFile, error) {
+	file := &rotatingFile{
+		path:    path,
+		options: options,
+	}
+
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Write([]byte(header)); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+
+	return nil
+}
+
+// Write implements io.Writer
+func (f *rotatingFile) Write(b []byte) (int, error) {
+	if f.options.MaxSize > 0 && f.size > 0 && f.size+int64(len(b)) > f.options.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// Close implements io.Closer
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
+
+// rotate moves the file to path.1, shifting the older ones up, and opens a new one
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+
+	// shift the oldest first so they are not overwritten
+	for idx := len(backups) - 1; idx >= 0; idx-- {
+		if err = os.Rename(backups[idx].path, fmt.Sprintf("%s.%d", f.path, backups[idx].number+1)); err != nil {
+			return err
+		}
+	}
+
+	if err = os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+
+	if err = f.prune(); err != nil {
+		return err
+	}
+
+	return f.open()
+}
+
+// prune removes the backups past the maximum number or age
+func (f *rotatingFile) prune() error {
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+
+	for idx, backup := range backups {
+		tooMany := f.options.MaxBackups > 0 && idx >= f.options.MaxBackups
+		tooOld := f.options.MaxAge > 0 && time.Since(backup.modTime) > f.options.MaxAge
+		if tooMany || tooOld {
+			if err = os.Remove(backup.path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type backupFile struct {
+	path    string
+	number  int
+	modTime time.Time
+}
+
+// backups returns the rotated files, newest first
+func (f *rotatingFile) backups() ([]*backupFile, error) {
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []*backupFile
+	for _, match := range matches {
+		number, err := strconv.Atoi(strings.TrimPrefix(match, f.path+"."))
+		if err != nil {
+			// not a backup of this file, like step.out.err for a step named step.out
+			continue
+		}
+
+		info, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+
+		backups = append(backups, &backupFile{path: match, number: number, modTime: info.ModTime()})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].number < backups[j].number
+	})
+
+	return backups, nil
+}
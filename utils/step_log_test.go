@@ -0,0 +1,23 @@
+package utils
+
+import "testing"
+
+func TestStepLogName(t *testing.T) {
+	tests := map[string]string{
+		"build":          "build",
+		"deploy.probe":   "deploy.probe",
+		"../x":           ".._x",
+		"a/b":            "a_b",
+		`c:\windows\x`:   "c__windows_x",
+		"..":             "___",
+		".":              "__",
+		"":               "_",
+		"ask?<now>|then": "ask__now__then",
+	}
+
+	for name, want := range tests {
+		if got := stepLogName(name); got != want {
+			t.Errorf("%q is %q, want %q", name, got, want)
+		}
+	}
+}
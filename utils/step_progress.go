@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// ProgressPayload is the payload of EventRunProgress
+type ProgressPayload struct {
+	// Progress is the share of the work of the step done, from 0 to 1
+	Progress float64 `json:"progress"`
+}
+
+// validateProgressRegex checks the progress_regex of a step captures a
+// percentage, or what's done and the total
+func validateProgressRegex(pattern string) error {
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid progress_regex %s: %s", pattern, err)
+	}
+	if groups := compiled.NumSubexp(); groups < 1 || groups > 2 {
+		return fmt.Errorf("progress_regex %s needs one group for a percentage, or two for what's done and the total", pattern)
+	}
+
+	return nil
+}
+
+// stepProgress follows the progress of a step from the lines of its output
+// matching its progress_regex. It's shared by the copies of the step
+type stepProgress struct {
+	pattern *regexp.Regexp
+	signal  *sync.Mutex
+	value   *float64
+	// percent is the last whole percentage reported
+	percent int
+}
+
+// progressWriter splits a stream of the output into lines for the progress.
+// Progress bars redraw their line with \r, so it ends lines too
+type progressWriter struct {
+	progress *stepProgress
+	out      io.Writer
+	changed  func(value float64)
+	buffer   []byte
+}
+
+func newStepProgress(pattern string) *stepProgress {
+	// the pattern is checked by validate
+	return &stepProgress{
+		pattern: regexp.MustCompile(pattern),
+		signal:  &sync.Mutex{},
+		percent: -1,
+	}
+}
+
+// reset clears the progress before the step runs again
+func (p *stepProgress) reset() {
+	p.signal.Lock()
+	defer p.signal.Unlock()
+
+	p.value = nil
+	p.percent = -1
+}
+
+// current returns the progress of the step, or nil if its output had none
+func (p *stepProgress) current() *float64 {
+	if p == nil {
+		return nil
+	}
+
+	p.signal.Lock()
+	defer p.signal.Unlock()
+
+	if p.value == nil {
+		return nil
+	}
+	value := *p.value
+
+	return &value
+}
+
+// writer returns a writer reading the progress from the lines written to it
+// and writing them to out. changed is called when the progress moves by a
+// whole percent
+func (p *stepProgress) writer(out io.Writer, changed func(value float64)) io.Writer {
+	return &progressWriter{progress: p, out: out, changed: changed}
+}
+
+func (p *stepProgress) parseLine(line []byte) (float64, bool) {
+	match := p.pattern.FindSubmatch(line)
+	if match == nil {
+		return 0, false
+	}
+
+	value, err := strconv.ParseFloat(string(match[1]), 64)
+	if err != nil {
+		return 0, false
+	}
+	if len(match) > 2 {
+		total, err := strconv.ParseFloat(string(match[2]), 64)
+		if err != nil || total <= 0 {
+			return 0, false
+		}
+		value = value / total
+	} else {
+		value = value / 100
+	}
+
+	switch {
+	case value < 0:
+		value = 0
+	case value > 1:
+		value = 1
+	}
+
+	return value, true
+}
+
+// update sets the progress from the line, and returns true if it moved by a
+// whole percent
+func (p *stepProgress) update(line []byte) (float64, bool) {
+	value, ok := p.parseLine(line)
+	if !ok {
+		return 0, false
+	}
+
+	p.signal.Lock()
+	defer p.signal.Unlock()
+
+	p.value = &value
+	percent := int(value * 100)
+	if percent == p.percent {
+		return value, false
+	}
+	p.percent = percent
+
+	return value, true
+}
+
+// Write implements io.Writer
+func (w *progressWriter) Write(b []byte) (int, error) {
+	w.buffer = append(w.buffer, b...)
+	for {
+		idx := bytes.IndexAny(w.buffer, "\r\n")
+		if idx < 0 {
+			break
+		}
+
+		if value, changed := w.progress.update(w.buffer[:idx]); changed {
+			w.changed(value)
+		}
+		w.buffer = w.buffer[idx+1:]
+	}
+	// a line this long is not a progress line
+	if len(w.buffer) > maxCheckedLine {
+		w.buffer = nil
+	}
+
+	return w.out.Write(b)
+}
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+)
+
+// StepStatus is where a step is in its lifecycle. A step starts as
+// StepPending, is StepQueued once picked to run, StepRunning while it runs
+// and ends in one of the done statuses. A failed step with a retry policy is
+// StepRetrying until it's queued again
+type StepStatus int
+
+const (
+	// StepPending step hasn't been picked to run
+	StepPending StepStatus = iota
+	// StepQueued step was picked to run and is waiting for a slot
+	StepQueued
+	// StepRunning step is running
+	StepRunning
+	// StepRetrying step failed and is waiting to be retried
+	StepRetrying
+	// StepSucceeded step finished successfully, was cached or finished in a
+	// previous run
+	StepSucceeded
+	// StepFailed step finished with an error
+	StepFailed
+	// StepSkipped step didn't run because of its when condition, its
+	// dependencies or because it's disabled or wasn't selected
+	StepSkipped
+	// StepCancelled step was stopped, or never ran, because the workflow was
+	// cancelled
+	StepCancelled
+	// StepTimedOut step was stopped because it ran longer than its timeout
+	StepTimedOut
+)
+
+var stepStatusNames = map[StepStatus]string{
+	StepPending:   "pending",
+	StepQueued:    "queued",
+	StepRunning:   "running",
+	StepRetrying:  "retrying",
+	StepSucceeded: "succeeded",
+	StepFailed:    "failed",
+	StepSkipped:   "skipped",
+	StepCancelled: "cancelled",
+	StepTimedOut:  "timed_out",
+}
+
+// stepTransitions are the statuses a step can go to from each status. Pending
+// steps can be done without running when they finished in a previous run or
+// weren't selected. Steps waiting to run are cancelled with the workflow
+var stepTransitions = map[StepStatus][]StepStatus{
+	StepPending:  {StepQueued, StepSucceeded, StepSkipped, StepCancelled},
+	StepQueued:   {StepRunning, StepCancelled},
+	StepRunning:  {StepRetrying, StepSucceeded, StepFailed, StepSkipped, StepCancelled, StepTimedOut},
+	StepRetrying: {StepQueued, StepCancelled},
+}
+
+// String returns the name of the status, like timed_out
+func (s StepStatus) String() string {
+	if name, ok := stepStatusNames[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("StepStatus(%d)", int(s))
+}
+
+// MarshalText implements encoding.TextMarshaler so the status is written by
+// its name
+func (s StepStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Done returns true if the status is one a step ends in
+func (s StepStatus) Done() bool {
+	return s >= StepSucceeded
+}
+
+// CanTransitionTo returns true if a step can go from the status to next
+func (s StepStatus) CanTransitionTo(next StepStatus) bool {
+	for _, to := range stepTransitions[s] {
+		if to == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+// StepTransition is a change of the status of a step
+type StepTransition struct {
+	From StepStatus `json:"from"`
+	To   StepStatus `json:"to"`
+	At   time.Time  `json:"at"`
+}
+
+// Status returns where the step is in its lifecycle
+func (s *Step) Status() StepStatus {
+	if s.workflow != nil && s.workflow.signal != nil {
+		s.workflow.signal.Lock()
+		defer s.workflow.signal.Unlock()
+	}
+
+	return s.status
+}
+
+// Transitions returns the changes of the status of the step so far, oldest
+// first
+func (s *Step) Transitions() []StepTransition {
+	if s.workflow != nil && s.workflow.signal != nil {
+		s.workflow.signal.Lock()
+		defer s.workflow.signal.Unlock()
+	}
+
+	return append([]StepTransition{}, s.transitions...)
+}
+
+// transition moves the step to the status, recording when. It needs the lock
+// of the workflow when it runs
+func (s *Step) transition(to StepStatus) error {
+	if !s.status.CanTransitionTo(to) {
+		return &TransitionError{Step: s.Name, From: s.status, To: to}
+	}
+
+	s.transitions = append(s.transitions, StepTransition{From: s.status, To: to, At: time.Now()})
+	s.status = to
+
+	return nil
+}
+
+// moveTo is transition taking the lock of the workflow
+func (s *Step) moveTo(to StepStatus) error {
+	s.workflow.signal.Lock()
+	defer s.workflow.signal.Unlock()
+
+	return s.transition(to)
+}
+
+// update changes the step under the lock of the workflow, as Progress reads
+// the step while it runs
+func (s *Step) update(change func()) {
+	s.workflow.signal.Lock()
+	defer s.workflow.signal.Unlock()
+
+	change()
+}
+
+// markDone marks a step that doesn't run as done with the status, for steps
+// that weren't selected or finished in a previous run. Steps already done
+// keep their status
+func (s *Step) markDone(to StepStatus) error {
+	s.workflow.signal.Lock()
+	defer s.workflow.signal.Unlock()
+
+	if s.status.Done() {
+		return nil
+	}
+
+	return s.transition(to)
+}
+
+// finish moves a running step to the status it finished with. Steps waiting
+// for a retry aren't done yet
+func (s *Step) finish() error {
+	s.workflow.signal.Lock()
+	defer s.workflow.signal.Unlock()
+
+	var to StepStatus
+	switch {
+	case s.status != StepRunning:
+		return nil
+	case s.Disabled, s.skipped:
+		to = StepSkipped
+	case s.cancelled:
+		to = StepCancelled
+	case s.err != nil && s.timedOut:
+		to = StepTimedOut
+	case s.err != nil:
+		to = StepFailed
+	default:
+		to = StepSucceeded
+	}
+
+	s.finishedAt = time.Now()
+
+	return s.transition(to)
+}
@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStep(status StepStatus) *Step {
+	return &Step{Name: "build", status: status, workflow: &Workflow{signal: &sync.Mutex{}}}
+}
+
+func TestCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from StepStatus
+		to   StepStatus
+		want bool
+	}{
+		{from: StepPending, to: StepQueued, want: true},
+		{from: StepPending, to: StepSucceeded, want: true},
+		{from: StepPending, to: StepSkipped, want: true},
+		{from: StepPending, to: StepCancelled, want: true},
+		{from: StepPending, to: StepRunning, want: false},
+		{from: StepPending, to: StepFailed, want: false},
+		{from: StepQueued, to: StepRunning, want: true},
+		{from: StepQueued, to: StepCancelled, want: true},
+		{from: StepQueued, to: StepSucceeded, want: false},
+		{from: StepRunning, to: StepRetrying, want: true},
+		{from: StepRunning, to: StepSucceeded, want: true},
+		{from: StepRunning, to: StepFailed, want: true},
+		{from: StepRunning, to: StepSkipped, want: true},
+		{from: StepRunning, to: StepCancelled, want: true},
+		{from: StepRunning, to: StepTimedOut, want: true},
+		{from: StepRunning, to: StepQueued, want: false},
+		{from: StepRetrying, to: StepQueued, want: true},
+		{from: StepRetrying, to: StepCancelled, want: true},
+		{from: StepRetrying, to: StepRunning, want: false},
+		{from: StepSucceeded, to: StepRunning, want: false},
+		{from: StepFailed, to: StepQueued, want: false},
+		{from: StepCancelled, to: StepCancelled, want: false},
+		{from: StepTimedOut, to: StepFailed, want: false},
+	}
+
+	for _, test := range tests {
+		if got := test.from.CanTransitionTo(test.to); got != test.want {
+			t.Errorf("%s to %s is %t, want %t", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestTransitionError(t *testing.T) {
+	tests := []struct {
+		from    StepStatus
+		to      StepStatus
+		wantErr string
+	}{
+		{from: StepPending, to: StepQueued},
+		{from: StepPending, to: StepRunning, wantErr: "step build can't go from pending to running"},
+		{from: StepSucceeded, to: StepRunning, wantErr: "step build can't go from succeeded to running"},
+		{from: StepRetrying, to: StepTimedOut, wantErr: "step build can't go from retrying to timed_out"},
+	}
+
+	for _, test := range tests {
+		step := newTestStep(test.from)
+		err := step.moveTo(test.to)
+
+		var transitionErr *TransitionError
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%s to %s: %s", test.from, test.to, err)
+		case test.wantErr == "" && step.Status() != test.to:
+			t.Errorf("%s to %s left the step %s", test.from, test.to, step.Status())
+		case test.wantErr == "":
+		case !errors.As(err, &transitionErr) || err.Error() != test.wantErr:
+			t.Errorf("%s to %s: got error %v, want %s", test.from, test.to, err, test.wantErr)
+		case transitionErr.From != test.from || transitionErr.To != test.to || step.Status() != test.from:
+			t.Errorf("%s to %s: got %s to %s with the step %s", test.from, test.to, transitionErr.From, transitionErr.To, step.Status())
+		}
+	}
+}
+
+func TestMarkDone(t *testing.T) {
+	tests := []struct {
+		from    StepStatus
+		to      StepStatus
+		want    StepStatus
+		wantErr bool
+	}{
+		{from: StepPending, to: StepSucceeded, want: StepSucceeded},
+		{from: StepPending, to: StepSkipped, want: StepSkipped},
+		{from: StepSucceeded, to: StepSkipped, want: StepSucceeded},
+		{from: StepFailed, to: StepSucceeded, want: StepFailed},
+		{from: StepRunning, to: StepSkipped, want: StepSkipped},
+		{from: StepQueued, to: StepSkipped, want: StepQueued, wantErr: true},
+	}
+
+	for _, test := range tests {
+		step := newTestStep(test.from)
+		err := step.markDone(test.to)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s marked %s returned %v", test.from, test.to, err)
+		}
+		if got := step.Status(); got != test.want {
+			t.Errorf("%s marked %s is %s, want %s", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	step := newTestStep(StepPending)
+	before := time.Now()
+	for _, to := range []StepStatus{StepQueued, StepRunning, StepRetrying, StepQueued, StepRunning} {
+		if err := step.moveTo(to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := step.finish(); err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now()
+
+	want := []StepStatus{StepPending, StepQueued, StepRunning, StepRetrying, StepQueued, StepRunning, StepSucceeded}
+	transitions := step.Transitions()
+	if len(transitions) != len(want)-1 {
+		t.Fatalf("got %d transitions, want %d", len(transitions), len(want)-1)
+	}
+
+	at := before
+	for idx, transition := range transitions {
+		if transition.From != want[idx] || transition.To != want[idx+1] {
+			t.Errorf("transition %d is %s to %s, want %s to %s", idx, transition.From, transition.To, want[idx], want[idx+1])
+		}
+		if transition.At.Before(at) || transition.At.After(after) {
+			t.Errorf("transition %d is at %s, want between %s and %s", idx, transition.At, at, after)
+		}
+		at = transition.At
+	}
+
+	// the transitions returned are a copy
+	transitions[0].To = StepFailed
+	if step.Transitions()[0].To != StepQueued {
+		t.Error("changing the transitions returned changed the step")
+	}
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v2"
+)
+
+// templateIdentityFields are the attributes that belong to the step itself,
+// and are never filled in from its template
+var templateIdentityFields = map[string]bool{
+	"Name":     true,
+	"Template": true,
+}
+
+// applyTemplates fills in the attributes of the steps that use a template
+// from the template. Attributes set on the step override the template ones
+func (w *Workflow) applyTemplates() error {
+	for name, template := range w.Templates {
+		if template == nil {
+			return fmt.Errorf("template %s is empty", name)
+		}
+		if template.Template != "" {
+			return fmt.Errorf("template %s can't use another template", name)
+		}
+	}
+
+	for _, step := range w.allSteps() {
+		if step.Template == "" {
+			continue
+		}
+
+		template, ok := w.Templates[step.Template]
+		if !ok {
+			return fmt.Errorf("step %s uses an unknown template %s", step.Name, step.Template)
+		}
+
+		if err := step.applyTemplate(template); err != nil {
+			return fmt.Errorf("failed to apply template %s to step %s: %s", step.Template, step.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Step) applyTemplate(template *Step) error {
+	// steps get their own copy of the template so they don't share anything
+	instance, err := copyStep(template)
+	if err != nil {
+		return err
+	}
+
+	// args of the template are defaults for the ones of the step
+	args := make(map[string]string, len(instance.Args)+len(s.Args))
+	for key, value := range instance.Args {
+		args[key] = value
+	}
+	for key, value := range s.Args {
+		args[key] = value
+	}
+
+	// a command of the step as a string replaces one of the template as a
+	// list
+	if s.Command != "" {
+		instance.Exec = nil
+	}
+
+	target := reflect.ValueOf(s).Elem()
+	source := reflect.ValueOf(instance).Elem()
+	for idx := 0; idx < target.NumField(); idx++ {
+		field := target.Field(idx)
+		if !field.CanSet() || !field.IsZero() || templateIdentityFields[target.Type().Field(idx).Name] {
+			continue
+		}
+
+		field.Set(source.Field(idx))
+	}
+
+	s.Args = args
+
+	return nil
+}
+
+// copyStep returns a deep copy of the attributes of the step
+func copyStep(step *Step) (*Step, error) {
+	buff, err := yaml.Marshal(step)
+	if err != nil {
+		return nil, err
+	}
+
+	var instance *Step
+	if err = yaml.Unmarshal(buff, &instance); err != nil {
+		return nil, err
+	}
+
+	return instance, nil
+}
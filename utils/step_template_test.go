@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestApplyTemplate(t *testing.T) {
+	options := &WorkflowOptions{Timeout: 10 * time.Second}
+	w, err := LoadWorkflowFromBytes(context.Background(), options, []byte(`
+version: 1
+templates:
+  deploy:
+    name: deploy-template
+    command: ./deploy.sh
+    workdir: /srv
+steps:
+  - name: staging
+    template: deploy
+  - name: production
+    template: deploy
+    workdir: /opt
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"staging": "/srv", "production": "/opt"}
+	for _, step := range w.Steps {
+		if step.Workdir != want[step.Name] {
+			t.Errorf("the workdir of %s is %s, want %s", step.Name, step.Workdir, want[step.Name])
+		}
+		if step.Command != "./deploy.sh" {
+			t.Errorf("the command of %s is %s", step.Name, step.Command)
+		}
+	}
+	if len(want) != len(w.Steps) {
+		t.Errorf("there are %d steps, want %d", len(w.Steps), len(want))
+	}
+}
+
+func TestApplyTemplateKeepsTheName(t *testing.T) {
+	options := &WorkflowOptions{Timeout: 10 * time.Second}
+	_, err := LoadWorkflowFromBytes(context.Background(), options, []byte(`
+version: 1
+templates:
+  deploy:
+    name: deploy
+    command: ./deploy.sh
+steps:
+  - template: deploy
+`))
+	if err == nil {
+		t.Error("a step without a name took the name of its template")
+	}
+}
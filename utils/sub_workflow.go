@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// StepTypeWorkflow runs another workflow file as part of the step
+	StepTypeWorkflow = "workflow"
+	// maxSubWorkflowDepth stops workflow steps going too deep
+	maxSubWorkflowDepth = 10
+)
+
+// SubWorkflowOptions configures the workflow a workflow step runs
+type SubWorkflowOptions struct {
+	// File is the workflow file to run. Relative paths are relative to the
+	// directory of the parent workflow
+	File string `yaml:"file" json:"file"`
+	// Concurrency of the workflow. Defaults to the one of the parent
+	Concurrency int `yaml:"concurrency" json:"concurrency"`
+	// Variables override the variables of the workflow
+	Variables map[string]string `yaml:"variables" json:"variables"`
+}
+
+func (o *SubWorkflowOptions) validate() error {
+	if o == nil || strings.TrimSpace(o.File) == "" {
+		return fmt.Errorf("workflow steps need a file")
+	}
+	if o.Concurrency < 0 {
+		return fmt.Errorf("invalid workflow concurrency %d", o.Concurrency)
+	}
+
+	return nil
+}
+
+func (o *SubWorkflowOptions) enrich(ctx context.Context, step *Step) error {
+	var err error
+
+	if o.File, err = step.parseAttribute(ctx, o.File); err != nil {
+		return err
+	}
+	if o.File, err = ExpandEnvVars(ctx, o.File); err != nil {
+		return err
+	}
+	for key, value := range o.Variables {
+		if value, err = step.parseAttribute(ctx, value); err != nil {
+			return err
+		}
+		if o.Variables[key], err = ExpandEnvVars(ctx, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runSubWorkflow runs the workflow of the step. The events of its steps are
+// sent to the notifiers of the parent workflow with their names prefixed
+// with the name of this step. The spinner is only used for the events of
+// the step itself
+func (s *Step) runSubWorkflow(ctx context.Context, spinner *Spinner) error {
+	spinner.push(ctx, NewEvent(spinner, EventRunRequested, nil))
+	startedAt := time.Now()
+
+	// the workflow timeout applies to each step of the workflow instead
+	if s.Timeout != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *s.Timeout)
+		defer cancel()
+	}
+
+	child, err := s.loadSubWorkflow(ctx)
+	if err != nil {
+		spinner.push(ctx, NewEvent(spinner, EventRunError, nil))
+		return err
+	}
+
+	spinner.push(ctx, NewEvent(spinner, EventRunStarted, nil))
+
+	result, err := child.Run(ctx)
+	if err == nil {
+		err = result.Errors
+	}
+
+	switch result.Outcome {
+	case OutcomeSuccess, OutcomePartial, OutcomeDegraded:
+		spinner.push(ctx, NewEvent(spinner, EventRunSuccess, nil))
+		return nil
+	case OutcomeCancelled:
+		if ctx.Err() == context.DeadlineExceeded {
+			spinner.push(ctx, NewEvent(spinner, EventRunTimeout, &TimeoutPayload{Elapsed: time.Since(startedAt), Limit: *s.Timeout}))
+			s.workflow.metrics().StepTimedOut(s)
+			return fmt.Errorf("Timed out after %s", *s.Timeout)
+		}
+
+		spinner.push(ctx, NewEvent(spinner, EventRunCancelled, nil))
+		return fmt.Errorf("Cancelled")
+	default:
+		spinner.push(ctx, NewEvent(spinner, EventRunFail, &RunFailPayload{Outcome: result.Outcome}))
+		if err == nil {
+			return fmt.Errorf("workflow %s %s", s.SubWorkflow.File, result.Outcome)
+		}
+
+		return fmt.Errorf("workflow %s %s: %s", s.SubWorkflow.File, result.Outcome, err)
+	}
+}
+
+func (s *Step) loadSubWorkflow(ctx context.Context) (*Workflow, error) {
+	parent := s.workflow.options
+
+	file := s.SubWorkflow.File
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(parent.Dir, file)
+	}
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// parents are the files of this workflow and the ones running it
+	parents := append([]string{}, parent.parents...)
+	if parent.file != "" {
+		parents = append(parents, parent.file)
+	}
+	if len(parents) >= maxSubWorkflowDepth {
+		return nil, fmt.Errorf("workflow steps are nested more than %d levels deep", maxSubWorkflowDepth)
+	}
+	if contains(parents, file) {
+		return nil, fmt.Errorf("circular workflow step running %s", file)
+	}
+
+	notifiers := NewNotifierRegistry()
+	if err := notifiers.Register("parent", s.forwardEvent); err != nil {
+		return nil, err
+	}
+
+	options := &WorkflowOptions{
+		Notifiers:   notifiers,
+		Concurrency: parent.Concurrency,
+		Timeout:     parent.Timeout,
+		Variables:   s.SubWorkflow.Variables,
+		GracePeriod: parent.GracePeriod,
+		Metrics:     parent.Metrics,
+		Tracer:      parent.Tracer,
+		Rollback:    parent.Rollback,
+		Cache:       parent.Cache,
+		Artifacts:   parent.Artifacts,
+		Secrets:     parent.Secrets,
+		Verifier:    parent.Verifier,
+		Agents:      parent.Agents,
+		parents:     parents,
+	}
+	if s.SubWorkflow.Concurrency != 0 {
+		options.Concurrency = s.SubWorkflow.Concurrency
+	}
+	if parent.Output != nil {
+		options.Output = &prefixedSink{sink: parent.Output, prefix: s.Name + "/"}
+	}
+	options.Timestamps = s.workflow.timestamps()
+	if parent.StepLogs != nil {
+		stepLogs := *parent.StepLogs
+		stepLogs.Workflow = filepath.Join(stepLogs.Workflow, stepLogName(s.Name))
+		options.StepLogs = &stepLogs
+	}
+
+	return LoadWorkflowFromFile(ctx, options, file)
+}
+
+// forwardEvent sends the step events of a sub-workflow to the notifiers of
+// the parent workflow, as if they came from the step running it
+func (s *Step) forwardEvent(ctx context.Context, logger *logrus.Logger, event *Event) error {
+	// the events of the sub-workflow itself are covered by the ones of the step
+	if event.Payload.Spinner == nil {
+		return nil
+	}
+
+	spinner := *event.Payload.Spinner
+	spinner.Name = fmt.Sprintf("%s/%s", s.Name, spinner.Name)
+
+	forwarded := *event
+	forwarded.Payload.Workflow = s.workflow
+	forwarded.Payload.Spinner = &spinner
+
+	return s.workflow.sendEvent(ctx, logger, &forwarded)
+}
+
+// prefixedSink adds a prefix to the names of the steps writing to a sink
+type prefixedSink struct {
+	sink   OutputSink
+	prefix string
+}
+
+// Writer implements OutputSink
+func (p *prefixedSink) Writer(step string, name string) io.WriteCloser {
+	return p.sink.Writer(p.prefix+step, p.prefix+name)
+}
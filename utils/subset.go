@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Select limits the steps of the workflow that run. If only isn't empty,
+// only the steps in it and the steps they depend on run. The steps in skip
+// don't run, but the steps depending on them do. The other steps are treated
+// as skipped, and cleanup steps always run
+func (w *Workflow) Select(only, skip []string) error {
+	for _, name := range append(append([]string{}, only...), skip...) {
+		if w.findStepByName(name) == nil {
+			return fmt.Errorf("no step named %s", name)
+		}
+	}
+
+	selected := make(map[*Step]bool)
+	for _, step := range w.Steps {
+		selected[step] = len(only) == 0
+	}
+	for _, name := range only {
+		selectWithDependencies(w.findStepByName(name), selected)
+	}
+	for _, name := range skip {
+		selected[w.findStepByName(name)] = false
+	}
+
+	var notSelected []string
+	for _, step := range w.Steps {
+		if !selected[step] {
+			step.skipped = true
+			if err := step.markDone(StepSkipped); err != nil {
+				return err
+			}
+			notSelected = append(notSelected, step.Name)
+		}
+	}
+
+	if len(notSelected) != 0 {
+		w.logger.Infof("Skipping %s", strings.Join(notSelected, ", "))
+	}
+
+	return nil
+}
+
+// SelectRange limits the steps of the workflow that run to the ones between
+// from and until, which can be empty. The steps from depends on are assumed
+// done: if the state file of the workflow exists, they have to have finished
+// in it and get their outputs and artifacts from it. The steps depending on
+// until don't run
+func (w *Workflow) SelectRange(from, until string) error {
+	if from != "" {
+		first := w.findStepByName(from)
+		if first == nil {
+			return fmt.Errorf("no step named %s", from)
+		}
+
+		before := make(map[*Step]bool)
+		for _, priorStep := range first.dependsOn {
+			selectWithDependencies(priorStep, before)
+		}
+
+		if err := w.assumeDone(before); err != nil {
+			return err
+		}
+	}
+
+	if until != "" {
+		last := w.findStepByName(until)
+		if last == nil {
+			return fmt.Errorf("no step named %s", until)
+		}
+
+		var after []string
+		for _, step := range w.Steps {
+			if step != last && step.dependsOnStep(last, make(map[*Step]bool)) {
+				step.skipped = true
+				if err := step.markDone(StepSkipped); err != nil {
+					return err
+				}
+				after = append(after, step.Name)
+			}
+		}
+
+		if len(after) != 0 {
+			w.logger.Infof("Not running %s after %s", strings.Join(after, ", "), until)
+		}
+	}
+
+	return nil
+}
+
+// assumeDone marks the steps as done, with their state in the state file of
+// the workflow if it exists
+func (w *Workflow) assumeDone(steps map[*Step]bool) error {
+	var state *workflowState
+	if w.stateFile != "" {
+		var err error
+		if state, err = loadState(w.stateFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	var names []string
+	for _, step := range w.Steps {
+		if !steps[step] {
+			continue
+		}
+
+		if state == nil {
+			step.skipped = true
+			if err := step.markDone(StepSkipped); err != nil {
+				return err
+			}
+		} else {
+			previous, ok := state.Steps[step.Name]
+			if !ok || !previous.finished() {
+				return fmt.Errorf("%s hasn't finished in %s", step.Name, w.stateFile)
+			}
+			if err := w.restoreStep(step, previous); err != nil {
+				return err
+			}
+		}
+		names = append(names, step.Name)
+	}
+
+	if len(names) != 0 {
+		w.logger.Infof("Assuming %s are done", strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+// RunSubset runs the steps with the names and the steps they depend on
+func (w *Workflow) RunSubset(ctx context.Context, names []string) (*WorkflowResult, error) {
+	if err := w.Select(names, nil); err != nil {
+		return nil, err
+	}
+
+	return w.Run(ctx)
+}
+
+func selectWithDependencies(step *Step, selected map[*Step]bool) {
+	if selected[step] {
+		return
+	}
+
+	selected[step] = true
+	for _, priorStep := range step.dependsOn {
+		selectWithDependencies(priorStep, selected)
+	}
+}
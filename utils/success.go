@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+)
+
+const (
+	// maxCheckedLine is the longest line the output checks see at once
+	maxCheckedLine = 64 * 1024
+)
+
+// SuccessOutput checks the output of a step to tell if it succeeded. The
+// patterns are regular expressions matched against each line
+type SuccessOutput struct {
+	// MustMatch are patterns that all have to match a line of the output
+	MustMatch []string `yaml:"must_match" json:"must_match"`
+	// MustNotMatch are patterns no line of the output can match
+	MustNotMatch []string `yaml:"must_not_match" json:"must_not_match"`
+	// Stream is the output checked: stdout, stderr or both
+	Stream string `yaml:"stream" json:"stream"`
+}
+
+// SuccessCheckError is returned when a command didn't succeed by the success
+// criteria of its step
+type SuccessCheckError struct {
+	ExitCode int
+	Reason   string
+}
+
+// Error implements error
+func (e *SuccessCheckError) Error() string {
+	return e.Reason
+}
+
+func (o *SuccessOutput) validate() error {
+	switch o.Stream {
+	case "", "both", "stdout", "stderr":
+	default:
+		return fmt.Errorf("invalid success_output stream %s. Valid values are stdout, stderr and both", o.Stream)
+	}
+
+	for _, pattern := range append(append([]string{}, o.MustMatch...), o.MustNotMatch...) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid success_output pattern %s: %s", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// successExitCode returns true if the exit code is a success for the command
+func (s *Spinner) successExitCode(code int) bool {
+	if len(s.successExitCodes) == 0 {
+		return code == 0
+	}
+
+	for _, successCode := range s.successExitCodes {
+		if code == successCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkSuccess applies the success criteria of the step to the error
+// returned by its command. It returns nil if the command succeeded, or a
+// SuccessCheckError if it only failed by the criteria
+func (s *Spinner) checkSuccess(err error, output *outputChecker) error {
+	code := 0
+	if err != nil {
+		var ok bool
+		if code, ok = exitCode(err); !ok || code == -1 {
+			// failed to run or killed
+			return err
+		}
+	}
+
+	if !s.successExitCode(code) {
+		if err == nil {
+			return &SuccessCheckError{ExitCode: code, Reason: "exit status 0 is not a success exit code"}
+		}
+
+		return err
+	}
+
+	if output != nil {
+		if reason := output.failure(); reason != "" {
+			return &SuccessCheckError{ExitCode: code, Reason: reason}
+		}
+	}
+
+	return nil
+}
+
+// outputChecker matches the lines of the output of a step against the
+// patterns of its SuccessOutput
+type outputChecker struct {
+	signal       *sync.Mutex
+	mustMatch    []*regexp.Regexp
+	matched      []bool
+	mustNotMatch []*regexp.Regexp
+	// unwanted is the first pattern of mustNotMatch that matched
+	unwanted string
+	writers  []*checkedWriter
+}
+
+func newOutputChecker(output *SuccessOutput) *outputChecker {
+	checker := &outputChecker{
+		signal:  &sync.Mutex{},
+		matched: make([]bool, len(output.MustMatch)),
+	}
+	// the patterns are checked by validate
+	for _, pattern := range output.MustMatch {
+		checker.mustMatch = append(checker.mustMatch, regexp.MustCompile(pattern))
+	}
+	for _, pattern := range output.MustNotMatch {
+		checker.mustNotMatch = append(checker.mustNotMatch, regexp.MustCompile(pattern))
+	}
+
+	return checker
+}
+
+// writer returns a writer checking the lines written to it and writing them
+// to out
+func (c *outputChecker) writer(out io.Writer) io.Writer {
+	writer := &checkedWriter{checker: c, out: out}
+	c.writers = append(c.writers, writer)
+
+	return writer
+}
+
+// failure returns why the output is not a success, or empty if it is
+func (c *outputChecker) failure() string {
+	for _, writer := range c.writers {
+		writer.flush()
+	}
+
+	c.signal.Lock()
+	defer c.signal.Unlock()
+
+	if c.unwanted != "" {
+		return fmt.Sprintf("output matched %s", c.unwanted)
+	}
+	for idx, matched := range c.matched {
+		if !matched {
+			return fmt.Sprintf("output didn't match %s", c.mustMatch[idx])
+		}
+	}
+
+	return ""
+}
+
+func (c *outputChecker) checkLine(line []byte) {
+	c.signal.Lock()
+	defer c.signal.Unlock()
+
+	for idx, pattern := range c.mustMatch {
+		if !c.matched[idx] && pattern.Match(line) {
+			c.matched[idx] = true
+		}
+	}
+	if c.unwanted == "" {
+		for _, pattern := range c.mustNotMatch {
+			if pattern.Match(line) {
+				c.unwanted = pattern.String()
+				break
+			}
+		}
+	}
+}
+
+// checkedWriter splits the output of a stream into lines for the checker
+type checkedWriter struct {
+	checker *outputChecker
+	out     io.Writer
+	buffer  []byte
+}
+
+// Write implements io.Writer
+func (w *checkedWriter) Write(b []byte) (int, error) {
+	w.buffer = append(w.buffer, b...)
+	for {
+		idx := bytes.IndexByte(w.buffer, '\n')
+		if idx < 0 {
+			break
+		}
+
+		w.checker.checkLine(bytes.TrimSuffix(w.buffer[:idx], []byte("\r")))
+		w.buffer = w.buffer[idx+1:]
+	}
+	if len(w.buffer) > maxCheckedLine {
+		w.flush()
+	}
+
+	return w.out.Write(b)
+}
+
+func (w *checkedWriter) flush() {
+	if len(w.buffer) != 0 {
+		w.checker.checkLine(w.buffer)
+		w.buffer = nil
+	}
+}
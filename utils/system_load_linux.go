@@ -0,0 +1,100 @@
+//go:build linux
+// +build linux
+
+package utils
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// readSystemLoad reads the 1 minute load average and the memory in use from
+// /proc
+func readSystemLoad() (*systemLoad, bool) {
+	buff, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil, false
+	}
+	fields := strings.Fields(string(buff))
+	if len(fields) == 0 {
+		return nil, false
+	}
+	average, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, false
+	}
+
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	var total, available float64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = value
+		case "MemAvailable:":
+			available = value
+		}
+	}
+	if total == 0 {
+		return nil, false
+	}
+
+	return &systemLoad{
+		cpu:    average / float64(runtime.NumCPU()),
+		memory: 1 - available/total,
+	}, true
+}
+
+// processGroupCPU returns the CPU time in clock ticks used by the processes
+// of the group, including their children that exited
+func processGroupCPU(pgid int) (uint64, bool) {
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return 0, false
+	}
+
+	var total uint64
+	found := false
+	for _, stat := range stats {
+		buff, err := ioutil.ReadFile(stat)
+		if err != nil {
+			// the process exited
+			continue
+		}
+
+		// the name of the command is in brackets and can have spaces
+		content := string(buff)
+		fields := strings.Fields(content[strings.LastIndex(content, ")")+1:])
+		if len(fields) < 15 || fields[2] != strconv.Itoa(pgid) {
+			continue
+		}
+
+		found = true
+		// utime, stime, cutime and cstime
+		for _, field := range fields[11:15] {
+			ticks, _ := strconv.ParseUint(field, 10, 64)
+			total += ticks
+		}
+	}
+
+	return total, found
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package utils
+
+// readSystemLoad doesn't know the load of the machine outside Linux, so the
+// concurrency isn't changed
+func readSystemLoad() (*systemLoad, bool) {
+	return nil, false
+}
+
+// processGroupCPU doesn't know the CPU time of processes outside Linux
+func processGroupCPU(pgid int) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// outputTimeFormat is RFC3339 with milliseconds
+const outputTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// TimestampedSink prefixes each line of the output of the steps with the
+// time it was written and the time since its step started, before sending
+// it to another sink
+type TimestampedSink struct {
+	sink OutputSink
+}
+
+// timestampWriter buffers the output until a whole line is written, and
+// writes it with its prefix
+type timestampWriter struct {
+	out       io.Writer
+	startedAt time.Time
+	buffer    *bytes.Buffer
+	signal    *sync.Mutex
+}
+
+// NewTimestampedSink creates a TimestampedSink sending the output to sink
+func NewTimestampedSink(sink OutputSink) *TimestampedSink {
+	return &TimestampedSink{sink: sink}
+}
+
+// Writer implements OutputSink
+func (t *TimestampedSink) Writer(step string, name string) io.WriteCloser {
+	return newTimestampWriter(t.sink.Writer(step, name))
+}
+
+func newTimestampWriter(out io.Writer) *timestampWriter {
+	return &timestampWriter{
+		out:       out,
+		startedAt: time.Now(),
+		buffer:    &bytes.Buffer{},
+		signal:    &sync.Mutex{},
+	}
+}
+
+// Write implements io.Writer
+func (w *timestampWriter) Write(b []byte) (int, error) {
+	w.signal.Lock()
+	defer w.signal.Unlock()
+
+	w.buffer.Write(b)
+	for {
+		idx := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+
+		if err := w.writeLine(w.buffer.Next(idx + 1)); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// Close implements io.Closer. The last line is written with a new line even
+// if it doesn't end with one, so the next line gets its own prefix
+func (w *timestampWriter) Close() error {
+	w.signal.Lock()
+	defer w.signal.Unlock()
+
+	var err error
+	if w.buffer.Len() != 0 {
+		w.buffer.WriteByte('\n')
+		err = w.writeLine(w.buffer.Bytes())
+		w.buffer.Reset()
+	}
+	if closer, ok := w.out.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+func (w *timestampWriter) writeLine(line []byte) error {
+	now := time.Now()
+	prefix := fmt.Sprintf("%s +%.3fs ", now.Format(outputTimeFormat), now.Sub(w.startedAt).Seconds())
+
+	_, err := w.out.Write(append([]byte(prefix), line...))
+	return err
+}
+
+// output returns the sink of the output of the steps, with timestamps if
+// the workflow or its options ask for them
+func (w *Workflow) output() OutputSink {
+	sink := w.options.Output
+	if sink != nil && w.timestamps() {
+		return NewTimestampedSink(sink)
+	}
+
+	return sink
+}
+
+func (w *Workflow) timestamps() bool {
+	return w.Timestamps || w.options.Timestamps
+}
@@ -0,0 +1,49 @@
+package utils
+
+import "context"
+
+// Tracer starts tracing spans for workflows, steps and the commands they run.
+// Spans started with a context holding a span should be its children
+type Tracer interface {
+	Start(ctx context.Context, name string, attributes map[string]string) (context.Context, Span)
+}
+
+// Span is a single traced operation
+type Span interface {
+	AddEvent(name string, attributes map[string]string)
+	SetError(err error)
+	End()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) AddEvent(string, map[string]string) {}
+func (noopSpan) SetError(error)                     {}
+func (noopSpan) End()                               {}
+
+// startSpan starts a span with the tracer of the workflow, if any, and puts it
+// on the returned context
+func (w *Workflow) startSpan(ctx context.Context, name string, attributes map[string]string) (context.Context, Span) {
+	if w.options.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	// the tracer is shared by workflows and only masks the secrets, so the
+	// redact patterns of the workflow are masked here
+	masked := make(map[string]string, len(attributes))
+	for key, value := range attributes {
+		masked[key] = w.MaskSecrets(value)
+	}
+	ctx, span := w.options.Tracer.Start(ctx, name, masked)
+
+	return context.WithValue(ctx, CtxSpan, span), span
+}
+
+// spanFromContext returns the current span on the context
+func spanFromContext(ctx context.Context) Span {
+	if span, ok := ctx.Value(CtxSpan).(Span); ok {
+		return span
+	}
+
+	return noopSpan{}
+}
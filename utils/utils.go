@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"bytes"
 	"context"
+	htmltemplate "html/template"
 	"os"
+	"text/template"
 
 	"github.com/fatih/color"
 )
@@ -21,3 +24,44 @@ func ExpandEnvVars(ctx context.Context, value string) (string, error) {
 func PrintError(format string, a ...interface{}) {
 	color.Red(format, a...)
 }
+
+// renderTemplate renders the value as a Golang template with the given data.
+// Templates are html/templates, escaping the values in them, unless text is
+// set. Then they are text/templates, and missing map keys are rendered as
+// empty values
+func renderTemplate(name string, value string, data interface{}, text bool) (string, error) {
+	return renderTemplateWithFuncs(name, value, data, nil, text)
+}
+
+// renderTemplateWithFuncs renders the value like renderTemplate with the
+// given functions available to the template
+func renderTemplateWithFuncs(name string, value string, data interface{}, funcs map[string]interface{}, text bool) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	buf := &bytes.Buffer{}
+	if !text {
+		tmpl, err := htmltemplate.New(name).Funcs(funcs).Parse(value)
+		if err != nil {
+			return "", err
+		}
+		if err = tmpl.Execute(buf, data); err != nil {
+			return "", err
+		}
+
+		return buf.String(), nil
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=zero").Funcs(funcs).Parse(value)
+	if err != nil {
+		return "", err
+	}
+
+	err = tmpl.Execute(buf, data)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
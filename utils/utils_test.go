@@ -0,0 +1,29 @@
+package utils
+
+import "testing"
+
+func TestRenderTemplate(t *testing.T) {
+	data := map[string]interface{}{
+		"Var": map[string]string{"query": "a=1&b=<2>"},
+	}
+	tests := []struct {
+		value string
+		text  bool
+		want  string
+	}{
+		{value: "curl '{{ .Var.query }}'", want: "curl 'a=1&amp;b=&lt;2&gt;'"},
+		{value: "curl '{{ .Var.query }}'", text: true, want: "curl 'a=1&b=<2>'"},
+		{value: "[{{ .Var.missing }}]", want: "[]"},
+		{value: "[{{ .Var.missing }}]", text: true, want: "[]"},
+	}
+
+	for _, test := range tests {
+		got, err := renderTemplate("test", test.value, data, test.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%s is rendered as %q, want %q (text: %t)", test.value, got, test.want, test.text)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// ValidateWorkflowBytes checks the given workflow for errors without
+// running it and returns all errors found. Includes are resolved relative to
+// the working directory
+func ValidateWorkflowBytes(buff []byte) error {
+	workflow, err := parseWorkflow(buff, &includeSource{})
+	if err != nil {
+		return err
+	}
+
+	return workflow.Validate()
+}
+
+// Validate checks the workflow for errors that would prevent it from running,
+// like circular dependencies between steps, and returns all of them in a
+// ValidationError
+func (w *Workflow) Validate() error {
+	var errors error
+
+	if w.Version != "1" && w.Version != "2" {
+		errors = multierror.Append(errors, &UnsupportedVersionError{Got: w.Version})
+	}
+	if w.Version == "1" && len(w.Stages) != 0 {
+		errors = multierror.Append(errors, fmt.Errorf("stages are only supported in version 2 workflows"))
+	}
+
+	if err := w.Hooks.validate(); err != nil {
+		errors = multierror.Append(errors, fmt.Errorf("workflow %s", err))
+	}
+	if w.RateLimit != nil {
+		if err := w.RateLimit.validate(); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("workflow %s", err))
+		}
+	}
+	if w.Timeout != nil && *w.Timeout <= 0 {
+		errors = multierror.Append(errors, fmt.Errorf("workflow has an invalid timeout %s", *w.Timeout))
+	}
+	if w.Schedule != nil {
+		if err := w.Schedule.Validate(); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+	if w.Watch != nil {
+		if err := w.Watch.validate(); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+	if err := w.validateParameters(); err != nil {
+		errors = multierror.Append(errors, err)
+	}
+	if err := w.validateProfiles(); err != nil {
+		errors = multierror.Append(errors, err)
+	}
+	for _, pattern := range w.Redact {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("invalid redact pattern %s: %s", pattern, err))
+		}
+	}
+	for idx, route := range w.Notifications {
+		if route.Notifier == "" {
+			errors = multierror.Append(errors, fmt.Errorf("notification %d has no notifier", idx+1))
+		}
+		if err := route.validate(); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("notification %d (%s) has an %s", idx+1, route.Notifier, err))
+		}
+	}
+
+	names := make(map[string]bool, len(w.Steps)+len(w.Cleanup))
+	for idx, step := range w.Steps {
+		// steps might not have a name so use their position for errors
+		stepID := fmt.Sprintf("step %d (%s)", idx+1, step.Name)
+		if err := w.validateStep(stepID, step, names); err != nil {
+			errors = multierror.Append(errors, atLine(step.line, err))
+		}
+	}
+
+	for idx, step := range w.Cleanup {
+		stepID := fmt.Sprintf("cleanup step %d (%s)", idx+1, step.Name)
+		if err := w.validateCleanupStep(stepID, step, names); err != nil {
+			errors = multierror.Append(errors, atLine(step.line, err))
+		}
+	}
+
+	if err := w.validateLocks(); err != nil {
+		errors = multierror.Append(errors, err)
+	}
+
+	if cycle := w.findCycle(); cycle != nil {
+		errors = multierror.Append(errors, cycleError(cycle))
+	}
+
+	return validationError(errors)
+}
+
+// validateCleanupStep checks the cleanup step and how it uses the other steps
+// of the workflow. names holds the names of the steps checked so far
+func (w *Workflow) validateCleanupStep(stepID string, step *Step, names map[string]bool) error {
+	var errors error
+
+	if err := step.validate(stepID, names); err != nil {
+		errors = multierror.Append(errors, err)
+	}
+
+	// cleanup steps run in order once all other steps are done
+	if len(step.DependsOn) != 0 {
+		errors = multierror.Append(errors, fmt.Errorf("%s can't have depends_on", stepID))
+	}
+	if step.AskToProceed {
+		errors = multierror.Append(errors, fmt.Errorf("%s can't ask to proceed", stepID))
+	}
+	for _, priorStepName := range step.NeedsArtifacts {
+		if w.findStepByName(priorStepName) == nil {
+			errors = multierror.Append(errors, fmt.Errorf("invalid step name in needs_artifacts for %s (%s)", stepID, priorStepName))
+		}
+	}
+	// cleanup steps run after all the others
+	if step.StopStep != "" {
+		if err := w.validateStopStep(step, false); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("%s: %s", stepID, err))
+		}
+	}
+
+	return errors
+}
+
+// atLine prefixes the problems with the line they are on, like the YAML
+// parser does. Problems with no known line are left as they are
+func atLine(line int, errors error) error {
+	if line == 0 {
+		return errors
+	}
+
+	merr, ok := errors.(*multierror.Error)
+	if !ok {
+		return fmt.Errorf("line %d: %w", line, errors)
+	}
+
+	var prefixed error
+	for _, err := range merr.Errors {
+		prefixed = multierror.Append(prefixed, fmt.Errorf("line %d: %w", line, err))
+	}
+
+	return prefixed
+}
+
+// validateStep checks the step and how it uses the other steps of the
+// workflow. names holds the names of the steps checked so far
+func (w *Workflow) validateStep(stepID string, step *Step, names map[string]bool) error {
+	var errors error
+
+	if err := step.validate(stepID, names); err != nil {
+		errors = multierror.Append(errors, err)
+	}
+
+	for _, dependency := range step.DependsOn {
+		if w.findStepByName(dependency.Step) == nil {
+			errors = multierror.Append(errors, fmt.Errorf("invalid step name in depends_on for %s (%s)", stepID, dependency.Step))
+		}
+		if err := dependency.validate(); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("%s has an %s in depends_on", stepID, err))
+		}
+	}
+
+	// the artifacts have to be saved before the step runs
+	for _, priorStepName := range step.NeedsArtifacts {
+		priorStep := w.findStepByName(priorStepName)
+		if priorStep == nil {
+			errors = multierror.Append(errors, fmt.Errorf("invalid step name in needs_artifacts for %s (%s)", stepID, priorStepName))
+		} else if !step.dependsOnStep(priorStep, make(map[*Step]bool)) {
+			errors = multierror.Append(errors, fmt.Errorf("%s needs the artifacts of %s but doesn't depend on it", stepID, priorStepName))
+		}
+	}
+
+	// the output has to be captured before the step runs
+	if step.Input != nil && step.Input.Output != "" {
+		if err := w.validateInputOutput(step); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("%s: %s", stepID, err))
+		}
+	}
+	if step.StopStep != "" {
+		if err := w.validateStopStep(step, true); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("%s: %s", stepID, err))
+		}
+	}
+
+	return errors
+}
+
+// validate checks the attributes of the step. names holds the names of the
+// steps checked so far to find duplicates
+func (s *Step) validate(stepID string, names map[string]bool) error {
+	var errors error
+
+	if s.Name == "" {
+		errors = multierror.Append(errors, fmt.Errorf("%s has no name", stepID))
+	} else if names[s.Name] {
+		errors = multierror.Append(errors, fmt.Errorf("%s has a duplicate name", stepID))
+	}
+	names[s.Name] = true
+
+	// jobs with a pod spec have their commands in the spec and workflow
+	// steps don't have one
+	hasPodSpec := s.Type == StepTypeK8sJob && s.K8sJob != nil && s.K8sJob.PodSpec != nil
+	if strings.TrimSpace(s.Command) == "" && !hasPodSpec && s.Type != StepTypeWorkflow && s.Type != StepTypeQuorum && s.StopStep == "" {
+		errors = multierror.Append(errors, fmt.Errorf("%s has no command", stepID))
+	}
+	if len(s.Exec) != 0 {
+		if strings.TrimSpace(s.Exec[0]) == "" {
+			errors = multierror.Append(errors, fmt.Errorf("%s has a command list without an executable", stepID))
+		}
+		if s.Shell != "" && s.Shell != "false" {
+			errors = multierror.Append(errors, fmt.Errorf("%s has a command list, which runs without a shell, and a shell", stepID))
+		}
+	}
+	if s.Probe != nil {
+		if err := s.Probe.validate(); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("%s: %s", stepID, err))
+		}
+	}
+	for _, preflight := range s.Preflights {
+		if strings.TrimSpace(preflight.Command) == "" {
+			errors = multierror.Append(errors, fmt.Errorf("%s has a preflight with no command", stepID))
+		}
+	}
+
+	if err := s.Hooks.validate(); err != nil {
+		errors = multierror.Append(errors, fmt.Errorf("%s %s", stepID, err))
+	}
+	for _, pattern := range s.Watch {
+		if err := validateGlob(pattern); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("%s has an %s to watch", stepID, err))
+		}
+	}
+
+	for _, pattern := range s.Artifacts {
+		if err := validateArtifact(pattern); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("%s: %s", stepID, err))
+		}
+	}
+
+	if s.ProgressRegex != "" {
+		if err := validateProgressRegex(s.ProgressRegex); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("%s: %s", stepID, err))
+		}
+	}
+
+	if s.CacheKey != nil {
+		if err := s.CacheKey.validate(); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("%s has an %s in its cache key", stepID, err))
+		}
+	}
+
+	if err := s.validateType(); err != nil {
+		errors = multierror.Append(errors, fmt.Errorf("%s: %s", stepID, err))
+	}
+
+	if s.Foreach != nil {
+		if err := s.Foreach.validate(); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("%s: %s", stepID, err))
+		}
+		if s.Probe != nil || len(s.OutputDefinitions) != 0 {
+			errors = multierror.Append(errors, fmt.Errorf("%s: foreach steps can't have a probe or outputs", stepID))
+		}
+	}
+
+	if s.Deadline != "" {
+		if err := s.validateDeadline(); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("%s has an %s", stepID, err))
+		}
+	}
+
+	if s.Timeout != nil && *s.Timeout <= 0 {
+		errors = multierror.Append(errors, fmt.Errorf("%s has an invalid timeout %s", stepID, *s.Timeout))
+	}
+	if s.StallAfter != nil {
+		if *s.StallAfter <= 0 {
+			errors = multierror.Append(errors, fmt.Errorf("%s has an invalid stall_after %s", stepID, *s.StallAfter))
+		} else if s.Timeout != nil && *s.StallAfter >= *s.Timeout {
+			errors = multierror.Append(errors, fmt.Errorf("%s has a stall_after %s that isn't shorter than its timeout %s", stepID, *s.StallAfter, *s.Timeout))
+		}
+	}
+
+	if s.Retry != nil {
+		if err := s.Retry.validate(); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("invalid retry for %s: %s", stepID, err))
+		}
+	}
+
+	return errors
+}
+
+// findCycle returns the steps that form a dependency cycle in the order they
+// depend on each other, starting and ending with the same step. It returns
+// nil if there are no cycles
+func (w *Workflow) findCycle() []*Step {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[*Step]int, len(w.Steps))
+	var path []*Step
+
+	var visit func(step *Step) []*Step
+	visit = func(step *Step) []*Step {
+		state[step] = visiting
+		path = append(path, step)
+
+		for _, priorStep := range step.dependsOn {
+			switch state[priorStep] {
+			case visiting:
+				// found a cycle. cut the path from where the cycle starts
+				for idx, item := range path {
+					if item == priorStep {
+						cycle := append([]*Step{}, path[idx:]...)
+						return append(cycle, priorStep)
+					}
+				}
+			case unvisited:
+				if cycle := visit(priorStep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[step] = visited
+
+		return nil
+	}
+
+	for _, step := range w.Steps {
+		if state[step] == unvisited {
+			if cycle := visit(step); cycle != nil {
+				return cycle
+			}
+		}
+	}
+
+	return nil
+}
+
+// cycleError returns the CycleError of the steps forming a dependency cycle
+func cycleError(cycle []*Step) error {
+	cycleNames := make([]string, len(cycle))
+	for idx, step := range cycle {
+		cycleNames[idx] = step.Name
+	}
+
+	return &CycleError{Steps: cycleNames}
+}
+
+// dependsOnStep returns true if the step depends on the other one, directly
+// or through the steps it depends on
+func (s *Step) dependsOnStep(other *Step, visited map[*Step]bool) bool {
+	if visited[s] {
+		return false
+	}
+	visited[s] = true
+
+	for _, priorStep := range s.dependsOn {
+		if priorStep == other || priorStep.dependsOnStep(other, visited) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateInputOutput checks the step depends on the step whose output is
+// its input, and that the output exists
+func (w *Workflow) validateInputOutput(step *Step) error {
+	// invalid names are reported with the step
+	stepName, name, err := step.Input.outputName()
+	if err != nil {
+		return nil
+	}
+
+	priorStep := w.findStepByName(stepName)
+	if priorStep == nil {
+		return fmt.Errorf("invalid step name in input (%s)", stepName)
+	}
+	if !step.dependsOnStep(priorStep, make(map[*Step]bool)) {
+		return fmt.Errorf("input is an output of %s but the step doesn't depend on it", stepName)
+	}
+	for _, output := range priorStep.OutputDefinitions {
+		if output.Name == name {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s has no output %s for the input", stepName, name)
+}
+
+// validateStopStep checks the step stopped by the step runs in the background
+// and, if the steps are ordered by their dependencies, is started before it
+func (w *Workflow) validateStopStep(step *Step, ordered bool) error {
+	background := w.findStepByName(step.StopStep)
+	if background == nil {
+		return fmt.Errorf("invalid step name in stop_step (%s)", step.StopStep)
+	}
+	if !background.runsInBackground() {
+		return fmt.Errorf("%s doesn't run in the background", step.StopStep)
+	}
+	if ordered && !step.dependsOnStep(background, make(map[*Step]bool)) {
+		return fmt.Errorf("stops %s but doesn't depend on it", step.StopStep)
+	}
+
+	return nil
+}
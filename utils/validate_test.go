@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateWorkflowBytes(t *testing.T) {
+	err := ValidateWorkflowBytes([]byte(`version: 1
+steps:
+  - name: build
+    comand: make
+  # the build again
+  - name: build
+    command: make
+
+  - name: deploy
+    command: make deploy
+    depends_on:
+      - missing
+cleanup:
+- name: clean
+`))
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("the error is %v, want a ValidationError", err)
+	}
+
+	var got []string
+	for _, err := range validationErr.Errors {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		"line 4: field comand not found in type utils.Step",
+		"line 3: step 1 (build) has no command",
+		"line 6: step 2 (build) has a duplicate name",
+		"line 9: invalid step name in depends_on for step 3 (deploy) (missing)",
+		"line 14: cleanup step 1 (clean) has no command",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("the errors are\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestStepLinesOfStages(t *testing.T) {
+	workflow, err := parseWorkflow([]byte(`version: 2
+stages:
+  - name: build
+    steps:
+      - name: compile
+        command: make
+      - name: test
+        command: make test
+  - steps:
+    - name: deploy
+      command: make deploy
+    name: release
+`), &includeSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{"compile": 5, "test": 7, "deploy": 10}
+	for _, step := range workflow.Steps {
+		if step.line != want[step.Name] {
+			t.Errorf("%s is on line %d, want %d", step.Name, step.line, want[step.Name])
+		}
+	}
+}
+
+func TestValidateWorkflowBytesWithDroppedStep(t *testing.T) {
+	err := ValidateWorkflowBytes([]byte(`version: 1
+steps:
+  - name: build
+    command: make
+    priority: high
+  - name: deploy
+`))
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Errors) != 2 {
+		t.Fatalf("the error is %v, want a ValidationError with 2 problems", err)
+	}
+	if got, want := validationErr.Errors[1].Error(), "line 6: step 1 (deploy) has no command"; got != want {
+		t.Errorf("the second problem is %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultDebounce is how long trackman watch waits for files to stop
+// changing before running the workflow
+const DefaultDebounce = 300 * time.Millisecond
+
+// WatchDefinition is what files trackman watch runs the workflow for when
+// they change
+type WatchDefinition struct {
+	// Paths are the directories, files or glob patterns to watch.
+	// Directories are watched with all their subdirectories
+	Paths []string `yaml:"paths" json:"paths"`
+	// Ignore are the patterns of files not to run the workflow for
+	Ignore []string `yaml:"ignore" json:"ignore"`
+	// Debounce is how long to wait for the files to stop changing
+	Debounce time.Duration `yaml:"debounce" json:"debounce"`
+}
+
+func (d *WatchDefinition) validate() error {
+	if d.Debounce < 0 {
+		return fmt.Errorf("watch debounce can't be negative")
+	}
+	for _, pattern := range append(append([]string{}, d.Paths...), d.Ignore...) {
+		if err := validateGlob(pattern); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateGlob checks each part of the pattern is a valid glob
+func validateGlob(pattern string) error {
+	for _, part := range strings.Split(filepath.ToSlash(pattern), "/") {
+		if _, err := path.Match(part, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s", pattern)
+		}
+	}
+
+	return nil
+}
+
+// MatchGlob returns true if the file matches the glob pattern. Patterns
+// without a / match any part of the path, like *.go or node_modules. Other
+// patterns match from the start of the path, where ** matches any number of
+// directories, and match all files under a matching directory
+func MatchGlob(pattern string, name string) bool {
+	pattern = strings.TrimPrefix(path.Clean(filepath.ToSlash(pattern)), "./")
+	parts := strings.Split(strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "./"), "/")
+
+	if !strings.Contains(pattern, "/") {
+		for _, part := range parts {
+			if ok, _ := path.Match(pattern, part); ok {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return matchGlobParts(strings.Split(pattern, "/"), parts)
+}
+
+func matchGlobParts(pattern []string, parts []string) bool {
+	if len(pattern) == 0 {
+		// everything under a matching directory matches
+		return true
+	}
+	if pattern[0] == "**" {
+		for idx := 0; idx <= len(parts); idx++ {
+			if matchGlobParts(pattern[1:], parts[idx:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+
+	return matchGlobParts(pattern[1:], parts[1:])
+}
+
+// GlobBase returns the directory of the pattern before the first part with
+// a glob character in it, or the pattern if it has none
+func GlobBase(pattern string) string {
+	parts := strings.Split(pattern, string(filepath.Separator))
+	for idx, part := range parts {
+		if strings.ContainsAny(part, "*?[") {
+			base := strings.Join(parts[:idx], string(filepath.Separator))
+			if base == "" {
+				if filepath.IsAbs(pattern) {
+					return string(filepath.Separator)
+				}
+
+				return "."
+			}
+
+			return base
+		}
+	}
+
+	return pattern
+}
+
+// StepsFor returns the steps to run for the changed files: the steps
+// watching them and the steps depending on those. The patterns of the steps
+// are relative to the directory of the workflow. If any of the files isn't
+// watched by a step, nil is returned as the whole workflow should run
+func (w *Workflow) StepsFor(files []string) []string {
+	dir := w.options.Dir
+	if dir == "" {
+		dir = "."
+	}
+
+	matched := make(map[*Step]bool)
+	for _, file := range files {
+		if relative, err := filepath.Rel(dir, file); err == nil {
+			file = relative
+		}
+
+		found := false
+		for _, step := range w.Steps {
+			for _, pattern := range step.Watch {
+				if MatchGlob(pattern, file) {
+					matched[step] = true
+					found = true
+					break
+				}
+			}
+		}
+
+		if !found {
+			return nil
+		}
+	}
+
+	// steps depending on the matched ones need to run again as well
+	for changed := true; changed; {
+		changed = false
+		for _, step := range w.Steps {
+			if matched[step] {
+				continue
+			}
+
+			for _, priorStep := range step.dependsOn {
+				if matched[priorStep] {
+					matched[step] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	var names []string
+	for _, step := range w.Steps {
+		if matched[step] {
+			names = append(names, step.Name)
+		}
+	}
+
+	return names
+}
+
+// Rerun runs the steps with the given names. The other steps are treated as
+// finished, with the outputs they had in a previous run
+func (w *Workflow) Rerun(ctx context.Context, names []string, outputs map[string]map[string]string) (*WorkflowResult, error) {
+	for idx, step := range w.Steps {
+		if contains(names, step.Name) {
+			continue
+		}
+
+		if err := w.Steps[idx].markDone(StepSucceeded); err != nil {
+			return nil, err
+		}
+		for name, value := range outputs[step.Name] {
+			w.setOutput(step.Name, name, value)
+		}
+	}
+
+	return w.Run(ctx)
+}
@@ -1,14 +1,15 @@
 package utils
 
 import (
-	"bytes"
 	"context"
-	"errors"
 	"fmt"
-	"html/template"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
@@ -21,82 +22,339 @@ import (
 
 // WorkflowOptions provides options for a workflow
 type WorkflowOptions struct {
-	Notifier    func(ctx context.Context, logger *logrus.Logger, event *Event) error
+	Notifiers   *NotifierRegistry
 	Concurrency int
-	Timeout     time.Duration
+	// Timeout is the timeout of the steps without one
+	Timeout time.Duration
+	// WorkflowTimeout is how long the whole run can take before its steps
+	// are stopped. It overrides the timeout of the workflow if set
+	WorkflowTimeout time.Duration
+	// Variables override the variables defined in the workflow
+	Variables map[string]string
+	// GracePeriod is how long a step has to stop after being asked to, before
+	// it's killed
+	GracePeriod time.Duration
+	// StateFile is where the state of the steps is saved as the workflow
+	// runs. It can be used to resume the workflow
+	StateFile string
+	// Metrics collects measurements of the workflow and its steps if set
+	Metrics MetricsCollector
+	// Tracer traces the workflow and its steps if set
+	Tracer Tracer
+	// Output shows the output of the steps if set. Otherwise the output is
+	// logged at debug level
+	Output OutputSink
+	// LogOutput keeps the output of the steps in the logs at debug level
+	// when there is no Output, instead of writing it to stdout
+	LogOutput bool
+	// StepLogs writes the output of each step to its own files if set
+	StepLogs *StepLogOptions
+	// Dir is the directory relative include paths are resolved against.
+	// Defaults to the working directory
+	Dir string
+	// CriticalPathFirst runs the steps with the longest chain of steps
+	// depending on them first, among the steps with the same priority
+	CriticalPathFirst bool
+	// Rollback runs the rollback commands of the successful steps if the
+	// workflow fails or is cancelled
+	Rollback bool
+	// Name identifies the workflow in its run history
+	Name string
+	// Cache keeps the results of the steps with a cache key, so they don't
+	// run again while their key doesn't change. Steps always run if it's nil
+	Cache StepCache
+	// Artifacts keeps the artifacts of the steps. It's needed to run steps
+	// with artifacts
+	Artifacts ArtifactStore
+	// Secrets provides the secrets used with the secret function in the
+	// templates of the workflow
+	Secrets SecretProvider
+	// Verifier refuses to load the workflow and its includes without a valid
+	// signature if set
+	Verifier SignatureVerifier
+	// Signature is the detached signature of the workflow. LoadWorkflowFromURL
+	// reads it next to the workflow if not set
+	Signature []byte
+	// Agents runs the steps with an agent selector. These steps fail if it's
+	// nil
+	Agents AgentDispatcher
+	// Debug asks before running each step whether to run it, skip it, edit
+	// its command or stop the workflow
+	Debug bool
+	// OutputTail is the number of lines of output kept for each step, to be
+	// in the results of the failed ones
+	OutputTail int
+	// Events sends the events to the notifiers in the background if set.
+	// Otherwise the steps wait for the notifiers
+	Events *EventBusOptions
+	// Locks holds the global locks of the steps, shared with other
+	// processes. Workflows with global locks can't be loaded without it
+	Locks LockProvider
+	// RateLimit limits how often steps start. It overrides the rate limit of
+	// the workflow if set
+	RateLimit *RateLimit
+	// AdaptiveConcurrency changes the concurrency with the load of the
+	// machine if set, instead of using Concurrency
+	AdaptiveConcurrency *AdaptiveConcurrency
+	// Timestamps prefixes each line of the output of the steps with the
+	// time and the time since the step started, even if the workflow
+	// doesn't ask for it
+	Timestamps bool
+	// StripANSI removes ANSI escape codes, like colors, from the output of
+	// the steps written to the logs, their log files and the output kept for
+	// notifications and reports. The Output keeps them
+	StripANSI bool
+	// StallAfter is how long the steps without a stall_after can go
+	// without output or using CPU before EventRunStalled is sent. 0
+	// doesn't check
+	StallAfter time.Duration
+	// Profile is the profile of the workflow overlaying its values. The
+	// workflow isn't loaded if it has no such profile
+	Profile string
+
+	// file is the workflow file, and parents the files of the workflows
+	// running it as a workflow step, to find cycles
+	file    string
+	parents []string
+}
+
+// withDefaults returns a copy of the options with what a workflow needs to
+// run and wasn't given. The logger, sink and notifier on the context are
+// used if there are any. Otherwise the events aren't sent anywhere and the
+// output of the steps is written to stdout, unless the logs are JSON, which
+// keep it
+func (o *WorkflowOptions) withDefaults(ctx context.Context) *WorkflowOptions {
+	options := &WorkflowOptions{}
+	if o != nil {
+		*options = *o
+	}
+
+	if options.Notifiers == nil {
+		options.Notifiers = NewNotifierRegistry()
+		if notifier := notifierFromContext(ctx); notifier != nil {
+			_ = options.Notifiers.Register("context", notifier)
+		}
+	}
+	if options.Output == nil && !options.LogOutput {
+		if sink := sinkFromContext(ctx); sink != nil {
+			options.Output = sink
+		} else if loggerFromContext(ctx) == nil && DefaultLogDefinition(nil).Format != "json" {
+			options.Output = NewOutputMultiplexer(os.Stdout, nil)
+		}
+	}
+	if options.Concurrency < 1 {
+		options.Concurrency = runtime.NumCPU()
+	}
+
+	return options
 }
 
 // Workflow is the internal object to hold a workflow file
 type Workflow struct {
-	Version  string            `yaml:"version" json:"version"`
-	Metadata map[string]string `yaml:"metadata" json:"metadata"`
-	Steps    []*Step           `yaml:"steps" json:"steps"`
-	Logger   *LogDefinition    `yaml:"logger" json:"logger"`
+	Version   string              `yaml:"version" json:"version"`
+	Include   []*Include          `yaml:"include" json:"include"`
+	Metadata  map[string]string   `yaml:"metadata" json:"metadata"`
+	Variables map[string]string   `yaml:"variables" json:"variables"`
+	Env       []string            `yaml:"env" json:"env"`
+	Shell     string              `yaml:"shell" json:"shell"`
+	Timeout   *time.Duration      `yaml:"timeout" json:"timeout"`
+	Steps     []*Step             `yaml:"steps" json:"steps"`
+	Stages    []*Stage            `yaml:"stages,omitempty" json:"stages,omitempty"`
+	Templates map[string]*Step    `yaml:"templates" json:"templates"`
+	Groups    map[string]int      `yaml:"groups" json:"groups"`
+	Cleanup   []*Step             `yaml:"cleanup" json:"cleanup"`
+	Hooks     *Hooks              `yaml:"hooks" json:"hooks"`
+	Schedule  *ScheduleDefinition `yaml:"schedule" json:"schedule"`
+	Watch     *WatchDefinition    `yaml:"watch" json:"watch"`
+	Logger    *LogDefinition      `yaml:"logger" json:"logger"`
+	Redact    []string            `yaml:"redact" json:"redact"`
+	// Requires are the names of the workflows this one runs after when they
+	// run together from a directory
+	Requires []string `yaml:"requires" json:"requires"`
+	// Notifications route the events to the notifiers
+	Notifications []*NotificationRoute `yaml:"notifications" json:"notifications"`
+	// Parameters are the typed inputs of the workflow, used as variables
+	Parameters []*Parameter `yaml:"parameters" json:"parameters"`
+	// Profiles overlay the values of the workflow by name, like staging
+	Profiles map[string]*Profile `yaml:"profiles" json:"profiles"`
+	// RateLimit limits how often steps start
+	RateLimit *RateLimit `yaml:"rate_limit" json:"rate_limit"`
+	// Timestamps prefixes each line of the output of the steps with the
+	// time and the time since the step started
+	Timestamps bool `yaml:"timestamps" json:"timestamps"`
+	// TextTemplates renders the templates with text/template instead of
+	// html/template, so the values in them are not escaped
+	TextTemplates bool `yaml:"text_templates" json:"text_templates"`
 
 	options    *WorkflowOptions
 	logger     *logrus.Logger
 	gatekeeper *semaphore.Weighted
 	signal     *sync.Mutex
-	stopFlag   bool
-	sessionID  string
+	dispatch   *sync.Cond
+	outputs    map[string]map[string]string
+	// outputsSignal is separate from signal as outputs are read while
+	// rendering steps
+	outputsSignal *sync.Mutex
+	stateFile     string
+	stateSignal   *sync.Mutex
+	stopFlag      bool
+	sessionID     string
+	includes      []*Include
+	// order is the order the steps are considered to run in
+	order []*Step
+	// secrets are the values of the secrets read so far by name
+	secrets       map[string]string
+	secretsSignal *sync.Mutex
+	// redactions are the redact patterns of the workflow
+	redactions *masker
+	// services are the service steps still running
+	services []*service
+	// startedAt is when the current run started
+	startedAt time.Time
+	// running cancels the current run
+	running *runControl
+	// paused stops the dispatcher from starting steps
+	paused bool
+	// debugSignal asks about one step at a time in debug mode
+	debugSignal *sync.Mutex
+	// lockSemaphores and groupSemaphores are the semaphores of the locks
+	// and groups of the steps by name
+	lockSemaphores  map[string]*semaphore.Weighted
+	groupSemaphores map[string]*semaphore.Weighted
+	// contextLogger is the logger given with WithLogger when the workflow
+	// was loaded
+	contextLogger *logrus.Logger
+	// events sends the events in the background when the options have Events
+	events *eventBus
+	// profile is the name of the profile applied to the workflow and
+	// profileChanges the values it changed
+	profile        string
+	profileChanges []*ProfileChange
+	// limiter spaces the starts of the steps out when there is a rate limit
+	limiter *rateLimiter
 }
 
 // LoadWorkflowFromBytes loads a workflow from bytes
 func LoadWorkflowFromBytes(ctx context.Context, options *WorkflowOptions, buff []byte) (*Workflow, error) {
-	var workflow *Workflow
-	err := yaml.Unmarshal(buff, &workflow)
+	options = options.withDefaults(ctx)
+	return loadWorkflow(ctx, options, buff, &includeSource{dir: options.Dir})
+}
+
+// loadWorkflow loads the workflow with its includes read relative to source
+func loadWorkflow(ctx context.Context, options *WorkflowOptions, buff []byte, source *includeSource) (*Workflow, error) {
+	if options.Verifier != nil {
+		name := "workflow"
+		if options.Name != "" && options.Name != name {
+			name = fmt.Sprintf("workflow %s", options.Name)
+		}
+		if err := verifySignature(options.Verifier, name, buff, options.Signature); err != nil {
+			return nil, err
+		}
+
+		// the includes are signed too
+		source.verifier = options.Verifier
+	}
+
+	workflow, err := parseWorkflow(buff, source)
 	if err != nil {
 		return nil, err
 	}
-	if options == nil {
-		panic("no options")
+
+	return setupWorkflow(ctx, options, workflow)
+}
+
+// LoadWorkflow loads a workflow defined in Go instead of a workflow file, like
+// one made with the builder package. It's checked and set up the same way.
+// Includes are resolved relative to Dir in the options. The workflow can't be
+// signed, so it's refused if the options have a Verifier
+func LoadWorkflow(ctx context.Context, options *WorkflowOptions, workflow *Workflow) (*Workflow, error) {
+	options = options.withDefaults(ctx)
+	if workflow == nil {
+		return nil, &ParseError{Err: ErrEmptyWorkflow}
+	}
+	if options.Verifier != nil {
+		return nil, fmt.Errorf("workflows defined in Go can't be signed")
+	}
+
+	if err := workflow.prepare(&includeSource{dir: options.Dir}); err != nil {
+		return nil, &ParseError{Err: err}
+	}
+
+	return setupWorkflow(ctx, options, workflow)
+}
+
+// setupWorkflow checks the parsed workflow and gets it ready to run with
+// the options, which have their defaults
+func setupWorkflow(ctx context.Context, options *WorkflowOptions, workflow *Workflow) (*Workflow, error) {
+	err := workflow.Validate()
+	if err != nil {
+		return nil, err
 	}
-	if options.Notifier == nil {
-		panic("no notifier")
+	if options.Profile != "" {
+		if err = workflow.applyProfile(options.Profile); err != nil {
+			return nil, err
+		}
 	}
 
-	if workflow.Version != "1" {
-		return nil, errors.New("invalid workflow version")
+	// the patterns are set before anything is logged
+	if workflow.redactions, err = newRedactions(workflow.Redact); err != nil {
+		return nil, err
 	}
 
 	workflow.sessionID = randstr.String(8)
 	workflow.gatekeeper = semaphore.NewWeighted(int64(options.Concurrency))
+	if options.AdaptiveConcurrency != nil {
+		if err = options.AdaptiveConcurrency.validate(); err != nil {
+			return nil, err
+		}
+		workflow.gatekeeper = semaphore.NewWeighted(int64(options.AdaptiveConcurrency.Max))
+	}
 	workflow.options = options
 	workflow.stopFlag = false
 	workflow.signal = &sync.Mutex{}
-
-	logger, err := NewLogger(workflow.Logger, NewLoggingContext(workflow, nil))
-	if err != nil {
-		return nil, err
+	workflow.dispatch = sync.NewCond(workflow.signal)
+	workflow.outputs = make(map[string]map[string]string)
+	workflow.outputsSignal = &sync.Mutex{}
+	workflow.secretsSignal = &sync.Mutex{}
+	workflow.debugSignal = &sync.Mutex{}
+	workflow.stateFile = options.StateFile
+	workflow.stateSignal = &sync.Mutex{}
+	workflow.setupLocks()
+	if err = workflow.validateGlobalLocks(); err != nil {
+		return nil, validationError(err)
 	}
-	workflow.logger = logger
-
-	// validate depends on and link them to the step
-	// TODO: check for circular dependencies
-	for idx, step := range workflow.Steps {
-		workflow.Steps[idx].workflow = workflow
-		for _, priorStepName := range step.DependsOn {
-			priorStep := workflow.findStepByName(priorStepName)
-			if priorStep == nil {
-				return nil, fmt.Errorf("invalid step name in depends_on for step %s (%s)", step.Name, priorStepName)
+	if rateLimit := workflow.RateLimit; options.RateLimit != nil || rateLimit != nil {
+		if options.RateLimit != nil {
+			if err = options.RateLimit.validate(); err != nil {
+				return nil, err
 			}
+			rateLimit = options.RateLimit
+		}
+		workflow.limiter = newRateLimiter(rateLimit)
+	}
+	workflow.order = workflow.scheduleOrder()
+	if options.Events != nil {
+		if err = options.Events.validate(); err != nil {
+			return nil, err
+		}
+		workflow.events = newEventBus(options.Notifiers, options.Events)
+	}
 
-			workflow.Steps[idx].dependsOn = append(workflow.Steps[idx].dependsOn, priorStep)
+	if workflow.contextLogger = loggerFromContext(ctx); workflow.contextLogger != nil && workflow.Logger == nil {
+		workflow.logger = workflow.contextLogger
+	} else {
+		logger, err := NewLogger(workflow.Logger, NewLoggingContext(workflow, nil))
+		if err != nil {
+			return nil, err
 		}
+		workflow.logger = logger
+	}
 
-		// setup logging for this step
-		if step.Logger == nil {
-			logger, err = NewLogger(workflow.Logger, NewLoggingContext(workflow, step))
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			logger, err = NewLogger(step.Logger, NewLoggingContext(workflow, step))
-			if err != nil {
-				return nil, err
-			}
+	// setup logging for the steps
+	for _, step := range workflow.allSteps() {
+		if err = workflow.setupStepLogger(step); err != nil {
+			return nil, err
 		}
-		step.logger = logger
 	}
 
 	if err = workflow.EnrichWorkflow(ctx); err != nil {
@@ -106,6 +364,120 @@ func LoadWorkflowFromBytes(ctx context.Context, options *WorkflowOptions, buff [
 	return workflow, nil
 }
 
+// setupStepLogger gives the step its logger, or one like the workflow's if it
+// has none
+func (w *Workflow) setupStepLogger(step *Step) error {
+	if step.Logger == nil && w.Logger == nil && w.contextLogger != nil {
+		step.logger = w.contextLogger
+		return nil
+	}
+
+	definition := step.Logger
+	if definition == nil {
+		definition = w.Logger
+	}
+
+	logger, err := NewLogger(definition, NewLoggingContext(w, step))
+	if err != nil {
+		return err
+	}
+	step.logger = logger
+
+	return nil
+}
+
+// parseWorkflow unmarshals the workflow, adds the included steps and links
+// the steps together. Unknown attributes are reported as errors, with the
+// other problems of the workflow. Includes are resolved relative to source
+func parseWorkflow(buff []byte, source *includeSource) (*Workflow, error) {
+	var workflow *Workflow
+	err := yaml.UnmarshalStrict(buff, &workflow)
+	var errors error
+	var dropped []int
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		for _, message := range typeErr.Errors {
+			errors = multierror.Append(errors, fmt.Errorf("%s", message))
+		}
+
+		// the parser leaves out the steps with problems, so the workflow is
+		// read again ignoring unknown attributes to check the rest of it.
+		// Only the steps with attributes of the wrong type are left out
+		workflow = nil
+		err = yaml.Unmarshal(buff, &workflow)
+		if typeErr, ok = err.(*yaml.TypeError); ok {
+			dropped = problemLines(typeErr.Errors)
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, &ParseError{Err: err}
+	}
+	if workflow == nil && errors != nil {
+		return nil, validationError(errors)
+	}
+	if workflow == nil {
+		return nil, &ParseError{Err: ErrEmptyWorkflow}
+	}
+	workflow.setStepLines(buff, dropped)
+
+	if err = workflow.prepare(source); err != nil && errors != nil {
+		return nil, validationError(multierror.Append(errors, &ParseError{Err: err}))
+	}
+	if err != nil {
+		return nil, &ParseError{Err: err}
+	}
+
+	if errors != nil {
+		// report all errors at once
+		if validationErr, ok := workflow.Validate().(*ValidationError); ok {
+			errors = multierror.Append(errors, validationErr.Errors...)
+		}
+
+		return nil, validationError(errors)
+	}
+
+	return workflow, nil
+}
+
+// prepare flattens the stages of the workflow, applies its templates and
+// matrices, adds the included steps and links the steps together. Includes
+// are resolved relative to source
+func (w *Workflow) prepare(source *includeSource) error {
+	var err error
+	if w.Version == "2" {
+		if err = w.flattenStages(); err != nil {
+			return err
+		}
+	}
+
+	if err = w.applyTemplates(); err != nil {
+		return err
+	}
+	if err = w.expandMatrices(); err != nil {
+		return err
+	}
+	if err = w.resolveIncludes(source); err != nil {
+		return err
+	}
+
+	// link the steps to the workflow and the steps they depend on. invalid
+	// step names are reported by Validate
+	for _, step := range w.Cleanup {
+		step.workflow = w
+	}
+	for idx, step := range w.Steps {
+		w.Steps[idx].workflow = w
+		for _, priorStepName := range step.DependsOn.Names() {
+			if priorStep := w.findStepByName(priorStepName); priorStep != nil {
+				w.Steps[idx].dependsOn = append(w.Steps[idx].dependsOn, priorStep)
+			}
+		}
+	}
+	w.markQuorums()
+
+	return nil
+}
+
 // LoadWorkflowFromReader loads a workflow from an io reader
 func LoadWorkflowFromReader(ctx context.Context, options *WorkflowOptions, reader io.Reader) (*Workflow, error) {
 	buff, err := ioutil.ReadAll(reader)
@@ -116,15 +488,48 @@ func LoadWorkflowFromReader(ctx context.Context, options *WorkflowOptions, reade
 	return LoadWorkflowFromBytes(ctx, options, buff)
 }
 
+// LoadWorkflowFromFile loads a workflow from a file. Relative includes are
+// read next to it, and so is its signature if it has to be signed
+func LoadWorkflowFromFile(ctx context.Context, options *WorkflowOptions, file string) (*Workflow, error) {
+	buff, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	options = options.withDefaults(ctx)
+	options.Dir = filepath.Dir(file)
+	if options.file, err = filepath.Abs(file); err != nil {
+		return nil, err
+	}
+	source := &includeSource{dir: options.Dir}
+	if options.Verifier != nil && len(options.Signature) == 0 {
+		if options.Signature, err = source.signature(file, options.Verifier.Extension()); err != nil {
+			return nil, fmt.Errorf("workflow %s isn't signed: %s", file, err)
+		}
+	}
+
+	return loadWorkflow(ctx, options, buff, source)
+}
+
+// Var returns the workflow variables
+func (w *Workflow) Var() map[string]string {
+	return w.Variables
+}
+
+// Name returns the name of the workflow given in its options
+func (w *Workflow) Name() string {
+	return w.options.Name
+}
+
 // SessionID returns the session id of this run for the workflow
 func (w *Workflow) SessionID() string {
 	return w.sessionID
 }
 
 func (w *Workflow) preflights(ctx context.Context) (preflights []*Preflight) {
-	for kdx, step := range w.Steps {
+	for _, step := range w.allSteps() {
 		for idx := range step.Preflights {
-			step.Preflights[idx].step = w.Steps[kdx]
+			step.Preflights[idx].step = step
 			preflights = append(preflights, &step.Preflights[idx])
 		}
 	}
@@ -140,64 +545,233 @@ func (w *Workflow) preflightChecks(ctx context.Context) error {
 				// dump the message
 				w.logger.WithField(FldStep, fmt.Sprintf("%s.preflight", preflight.step.Name)).Error(preflight.Message)
 			}
-			return err
+			return &PreflightError{Step: preflight.step.Name, Err: err}
 		}
 	}
 
 	return nil
 }
 
-// Run runs the entire workflow
-func (w *Workflow) Run(ctx context.Context) (runErrors error, stepErrors error) {
+// Run runs the entire workflow and returns the result of the run. Steps that
+// failed the workflow are reported in the result, while the returned error
+// is for failures that prevented the workflow from running, like a
+// PreflightError. It's ErrNoOptions if the workflow wasn't loaded
+func (w *Workflow) Run(ctx context.Context) (*WorkflowResult, error) {
+	if w.options == nil {
+		return nil, ErrNoOptions
+	}
+
+	ctx, span := w.startSpan(ctx, "workflow", map[string]string{
+		"trackman.session_id": w.sessionID,
+	})
+	defer span.End()
+
+	ctx, control := w.startRun(ctx)
+
+	w.push(ctx, NewWorkflowEvent(w, EventWorkflowStarted, nil))
+	w.metrics().WorkflowStarted(w)
+
+	runCtx, timedOut, stopTimeout := w.withTimeout(ctx)
+	result, err := w.run(runCtx)
+	stopTimeout()
+	if timedOut() {
+		// the result is cancelled, the timeout of the run fails it
+		result.Errors = multierror.Append(result.Errors, &WorkflowTimeoutError{Limit: w.runTimeout()})
+	}
+	if w.options.Rollback && (err != nil || result.Outcome == OutcomeFailed || result.Outcome == OutcomeCancelled) {
+		if rollbackErr := w.rollback(ctx); rollbackErr != nil {
+			result.Errors = multierror.Append(result.Errors, rollbackErr)
+		}
+		// pick up the rolled back steps
+		result = w.result(ctx, result.StartedAt, result.Errors)
+	}
+	if len(w.Cleanup) != 0 {
+		// the result should include the cleanup steps and their errors
+		errors := multierror.Append(result.Errors, w.runCleanup(ctx)).ErrorOrNil()
+		result = w.result(ctx, result.StartedAt, errors)
+	}
+	w.stopServices()
+	if timedOut() {
+		result.Outcome = OutcomeFailed
+		result.TimedOut = true
+	}
+	if err != nil {
+		result.Outcome = OutcomeFailed
+		result.Errors = multierror.Append(result.Errors, err)
+	}
+	result.CancelReason = w.cancelReason(control)
+
+	w.metrics().WorkflowFinished(w, result)
+
+	switch result.Outcome {
+	case OutcomeFailed, OutcomeCancelled:
+		// failure hooks can clean up after a cancelled workflow
+		if err := w.hookStep().runHooks(context.WithoutCancel(ctx), w.Hooks, HookOnFailure); err != nil {
+			w.logger.Error(err)
+		}
+	default:
+		if err := w.hookStep().runHooks(ctx, w.Hooks, HookPostRun); err != nil {
+			w.logger.Error(err)
+		}
+	}
+
+	switch result.Outcome {
+	case OutcomeFailed, OutcomeCancelled:
+		span.SetError(fmt.Errorf("workflow %s", result.Outcome))
+		w.push(ctx, NewWorkflowEvent(w, EventWorkflowFail, result))
+	default:
+		w.push(ctx, NewWorkflowEvent(w, EventWorkflowSuccess, result))
+	}
+	if w.events != nil {
+		// all events are sent once Run returns
+		if err := w.events.drain(context.WithoutCancel(ctx)); err != nil {
+			w.logger.Error(err)
+		}
+	}
+
+	w.finishRun(control, result)
+
+	return result, err
+}
+
+// runTimeout returns how long the run can take, or 0 if it has no limit
+func (w *Workflow) runTimeout() time.Duration {
+	if w.options.WorkflowTimeout != 0 {
+		return w.options.WorkflowTimeout
+	}
+	if w.Timeout != nil {
+		return *w.Timeout
+	}
+
+	return 0
+}
+
+// withTimeout returns a context cancelled once the timeout of the run is
+// over, so the steps running are stopped with their grace period, and a
+// function telling if it was
+func (w *Workflow) withTimeout(ctx context.Context) (context.Context, func() bool, func()) {
+	timeout := w.runTimeout()
+	if timeout == 0 {
+		return ctx, func() bool { return false }, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var timedOut int32
+	startedAt := time.Now()
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		w.logger.Errorf("Timed out after %s. Stopping the running steps", timeout)
+		w.push(ctx, NewWorkflowEvent(w, EventWorkflowTimeout, &TimeoutPayload{Elapsed: time.Since(startedAt), Limit: timeout}))
+		cancel()
+	})
+
+	isTimedOut := func() bool {
+		return atomic.LoadInt32(&timedOut) == 1
+	}
+	stop := func() {
+		timer.Stop()
+		cancel()
+	}
+
+	return ctx, isTimedOut, stop
+}
+
+func (w *Workflow) run(ctx context.Context) (*WorkflowResult, error) {
+	startedAt := time.Now()
+	w.signal.Lock()
+	w.startedAt = startedAt
+	w.signal.Unlock()
+
 	// if w.Logger is null, it's going to use the defaults which should be the same as with the app
 	// since the default values from from the same place
 	w.logger.Infof("Running Workflow with Session ID %s", w.sessionID)
 	w.logger.Info("Running Preflight checks")
 	err := w.preflightChecks(ctx)
 	if err != nil {
-		return err, nil
+		return w.result(ctx, startedAt, nil), err
 	}
 	w.logger.Info("Preflight checks complete")
 
+	if err = w.hookStep().runHooks(ctx, w.Hooks, HookPreRun); err != nil {
+		return w.result(ctx, startedAt, nil), err
+	}
+
 	joiner := sync.WaitGroup{}
+	var stepErrors error
+	stepErrorsSignal := &sync.Mutex{}
+
+	// wake up the dispatcher if the context is cancelled
+	runDone := make(chan struct{})
+	defer close(runDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.notify()
+		case <-runDone:
+		}
+	}()
+	if w.options.Locks != nil {
+		go w.pollGlobalLocks(runDone)
+	}
+	if w.options.AdaptiveConcurrency != nil {
+		controller := newConcurrencyController(w.options.AdaptiveConcurrency, w.gatekeeper, w.logger)
+		controller.start(ctx)
+		defer controller.stop()
+	}
 
 	// Run all that can run
 	for {
-		if w.shouldStop(ctx) {
-			return nil, stepErrors
-		}
-		if w.allDone() {
-			break
-		}
-
 		step := w.nextToRun(ctx)
 		if step == nil {
-			continue
+			break
 		}
 
 		w.logger.WithField(FldStep, step.Name).Trace("Next to run")
 
 		err := w.gatekeeper.Acquire(ctx, 1)
 		if err != nil {
-			return err, nil
+			step.releaseLocks()
+			if ctx.Err() != nil {
+				// cancelled while waiting to run the step
+				break
+			}
+
+			joiner.Wait()
+			return w.result(ctx, startedAt, stepErrors), err
 		}
 
-		if w.shouldStop(ctx) {
-			return
+		if w.shouldStop(ctx) && !step.runsOnFailure() {
+			step.releaseLocks()
+			w.gatekeeper.Release(1)
+			break
 		}
 
-		joiner.Add(1)
-		go func(toRun *Step) {
-			if w.shouldStop(ctx) {
-				return
+		if w.limiter != nil {
+			if err = w.limiter.wait(ctx); err != nil {
+				// cancelled while waiting to start the step
+				step.releaseLocks()
+				w.gatekeeper.Release(1)
+				break
 			}
+		}
 
+		joiner.Add(1)
+		go func(toRun *Step) {
 			defer func() {
 				w.logger.WithField(FldStep, toRun.Name).Trace("Done running")
+				toRun.releaseLocks()
+				if err := w.saveState(ctx); err != nil {
+					w.logger.WithField(FldStep, toRun.Name).Errorf("Failed to save state: %s", err)
+				}
 				w.gatekeeper.Release(1)
 				joiner.Done()
+				w.notify()
 			}()
 
+			if w.shouldStop(ctx) && !toRun.runsOnFailure() {
+				return
+			}
+
 			w.logger.WithField(FldStep, toRun.Name).Trace("Preparing to run")
 
 			if toRun.ShowCommand {
@@ -212,9 +786,24 @@ func (w *Workflow) Run(ctx context.Context) (runErrors error, stepErrors error)
 				}
 			}
 
+			w.metrics().StepStarted(toRun)
 			err := toRun.Run(ctx)
+			defer func() { w.metrics().StepFinished(toRun, toRun.result()) }()
+
+			if err != nil && (toRun.ContinueOnFail || toRun.inQuorum) {
+				// errors that happen before the command runs, like parsing, are
+				// also ignored for steps that should continue on failure
+				toRun.update(func() { toRun.err = err })
+				w.logger.WithField(FldStep, toRun.Name).Error(err)
+				return
+			}
 			if err != nil {
-				stepErrors = multierror.Append(err, stepErrors)
+				toRun.update(func() { toRun.err = err })
+
+				stepErrorsSignal.Lock()
+				stepErrors = multierror.Append(&StepError{Step: toRun.Name, Err: err}, stepErrors)
+				stepErrorsSignal.Unlock()
+
 				// run failed in some way that the whole workflow should stop
 				w.logger.WithField(FldStep, toRun.Name).Error(err)
 				w.logger.WithField(FldStep, toRun.Name).Error("Calling a stop to run")
@@ -224,37 +813,83 @@ func (w *Workflow) Run(ctx context.Context) (runErrors error, stepErrors error)
 	}
 
 	joiner.Wait()
+	if ctx.Err() != nil {
+		w.cancelWaitingSteps()
+	}
 
-	return nil, stepErrors
+	return w.result(ctx, startedAt, stepErrors), nil
 }
 
-// nextToRun returns the next step that can run
+func (w *Workflow) push(ctx context.Context, event *Event) {
+	err := w.sendEvent(ctx, w.logger, event)
+	if err != nil {
+		w.logger.Error(err)
+	}
+}
+
+// sendEvent sends the event to the notifiers, through the event bus if the
+// workflow has one
+func (w *Workflow) sendEvent(ctx context.Context, logger *logrus.Logger, event *Event) error {
+	if w.events != nil {
+		return w.events.publish(ctx, logger, event)
+	}
+
+	return w.options.Notifiers.Notify(ctx, logger, event)
+}
+
+// nextToRun blocks until there is a step that can run and returns it. It
+// returns nil once all steps are done or the workflow should stop. Once it
+// should stop, only the steps running on failure are returned, until no
+// step is running that they could be waiting for
 func (w *Workflow) nextToRun(ctx context.Context) *Step {
 	// using a universal lock per workflow to pick the next step to run
 	w.signal.Lock()
 	defer w.signal.Unlock()
 
-	for idx, step := range w.Steps {
-		if step.shouldRun() {
-			w.Steps[idx].MarkAsPending()
-			return w.Steps[idx]
+	for ctx.Err() == nil {
+		if w.stopFlag && !w.hasFailureSteps() {
+			return nil
+		}
+
+		allDone := true
+		running := false
+		for _, step := range w.order {
+			// a paused workflow is still done once its running steps are
+			if !w.paused && (!w.stopFlag || step.runsOnFailure()) && step.shouldRun() && step.acquireLocks(ctx) {
+				if err := step.transition(StepQueued); err != nil {
+					// shouldRun makes sure it can be queued
+					step.releaseLocks()
+					w.logger.WithField(FldStep, step.Name).Error(err)
+					continue
+				}
+				return step
+			}
+
+			if !step.status.Done() {
+				allDone = false
+			}
+			if step.status == StepRunning || step.status == StepQueued {
+				running = true
+			}
 		}
+
+		if allDone || (w.stopFlag && !running) {
+			return nil
+		}
+
+		// nothing to run now. wait for a step to finish
+		w.dispatch.Wait()
 	}
 
 	return nil
 }
 
-func (w *Workflow) allDone() bool {
+// notify wakes up the dispatcher to look for the next step to run
+func (w *Workflow) notify() {
 	w.signal.Lock()
 	defer w.signal.Unlock()
 
-	for _, step := range w.Steps {
-		if !step.isDone() {
-			return false
-		}
-	}
-
-	return true
+	w.dispatch.Broadcast()
 }
 
 func (w *Workflow) findStepByName(name string) *Step {
@@ -267,11 +902,23 @@ func (w *Workflow) findStepByName(name string) *Step {
 	return nil
 }
 
+// hasFailureSteps returns true if any step runs on failure
+func (w *Workflow) hasFailureSteps() bool {
+	for _, step := range w.Steps {
+		if step.runsOnFailure() {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (w *Workflow) stop(ctx context.Context) {
 	w.signal.Lock()
 	defer w.signal.Unlock()
 
 	w.stopFlag = true
+	w.dispatch.Broadcast()
 }
 
 func (w *Workflow) shouldStop(ctx context.Context) bool {
@@ -285,7 +932,26 @@ func (w *Workflow) shouldStop(ctx context.Context) bool {
 func (w *Workflow) EnrichWorkflow(ctx context.Context) error {
 	var err error
 
-	// meta data first
+	// variables first as everything else can use them
+	for key, value := range w.Variables {
+		if w.Variables[key], err = ExpandEnvVars(ctx, value); err != nil {
+			return err
+		}
+	}
+	if len(w.options.Variables) != 0 && w.Variables == nil {
+		w.Variables = make(map[string]string, len(w.options.Variables))
+	}
+	for key, value := range w.options.Variables {
+		w.Variables[key] = value
+	}
+	// parameters are checked before anything uses them
+	if err = w.resolveParameters(); err != nil {
+		return err
+	}
+	if err = w.enrichIncludes(ctx); err != nil {
+		return err
+	}
+
 	if w.Metadata != nil {
 		for idx, metadata := range w.Metadata {
 			if w.Metadata[idx], err = w.parseAttribute(ctx, metadata); err != nil {
@@ -302,24 +968,18 @@ func (w *Workflow) EnrichWorkflow(ctx context.Context) error {
 		}
 	}
 
-	return nil
-}
-
-func (w *Workflow) parseAttribute(ctx context.Context, value string) (string, error) {
-	if value == "" {
-		return "", nil
-	}
-
-	buf := &bytes.Buffer{}
-	tmpl, err := template.New("workflow").Parse(value)
-	if err != nil {
-		return "", err
+	for idx, env := range w.Env {
+		if w.Env[idx], err = w.parseAttribute(ctx, env); err != nil {
+			return err
+		}
+		if w.Env[idx], err = ExpandEnvVars(ctx, w.Env[idx]); err != nil {
+			return err
+		}
 	}
 
-	err = tmpl.Execute(buf, w)
-	if err != nil {
-		return "", err
-	}
+	return w.Hooks.enrich(ctx, w.parseAttribute)
+}
 
-	return buf.String(), nil
+func (w *Workflow) parseAttribute(ctx context.Context, value string) (string, error) {
+	return renderTemplateWithFuncs("workflow", value, w, w.templateFuncs(ctx), w.TextTemplates)
 }
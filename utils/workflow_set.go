@@ -0,0 +1,391 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+)
+
+// WorkflowSetOptions configures a WorkflowSet
+type WorkflowSetOptions struct {
+	// Concurrency is the most steps running at once across all workflows,
+	// instead of the concurrency of each workflow. 0 keeps the concurrency
+	// of each workflow
+	Concurrency int
+	// AdaptiveConcurrency changes the concurrency shared by all workflows
+	// with the load of the machine if set, instead of Concurrency and the
+	// adaptive concurrency of each workflow
+	AdaptiveConcurrency *AdaptiveConcurrency
+	// IgnoreRequires runs all workflows at once, without waiting for the
+	// workflows they require
+	IgnoreRequires bool
+}
+
+// WorkflowSet runs workflows together. Each workflow runs once the workflows
+// in its requires succeeded, and isn't run if one of them didn't
+type WorkflowSet struct {
+	Workflows []*Workflow
+
+	options   *WorkflowSetOptions
+	signal    *sync.Mutex
+	cancelled string
+	// running cancels the contexts of the workflows that started
+	running map[*Workflow]context.CancelFunc
+	logger  *logrus.Logger
+}
+
+// WorkflowSetResult holds the outcome of the run of a WorkflowSet
+type WorkflowSetResult struct {
+	// Outcome is the worst outcome of the workflows: failed if any of them
+	// failed or didn't run, then cancelled, stopped, partial, degraded and
+	// success
+	Outcome    string                  `json:"outcome"`
+	StartedAt  time.Time               `json:"started_at"`
+	FinishedAt time.Time               `json:"finished_at"`
+	Duration   time.Duration           `json:"duration"`
+	Workflows  []*WorkflowSetRunResult `json:"workflows"`
+}
+
+// WorkflowSetRunResult holds the outcome of a workflow of a WorkflowSet
+type WorkflowSetRunResult struct {
+	Name     string   `json:"name"`
+	Requires []string `json:"requires,omitempty"`
+	// Outcome is the outcome of the workflow, or not_run if it didn't run
+	// because a workflow it requires didn't succeed or the set was cancelled
+	Outcome string          `json:"outcome"`
+	Result  *WorkflowResult `json:"result,omitempty"`
+	// Error is why the workflow couldn't run, like a failed preflight check
+	Error error `json:"-"`
+}
+
+// outcomeRanks orders the outcomes of a set from the best to the worst
+var outcomeRanks = []string{OutcomeSuccess, OutcomeDegraded, OutcomePartial, OutcomeStopped, OutcomeCancelled, OutcomeFailed}
+
+// NewWorkflowSet creates a set of the workflows, checking the workflows
+// they require exist and don't require each other in a cycle. The workflows
+// are known by their name
+func NewWorkflowSet(workflows []*Workflow, options *WorkflowSetOptions) (*WorkflowSet, error) {
+	if options == nil {
+		options = &WorkflowSetOptions{}
+	}
+	if options.Concurrency < 0 {
+		return nil, fmt.Errorf("invalid concurrency %d", options.Concurrency)
+	}
+	if options.AdaptiveConcurrency != nil {
+		if err := options.AdaptiveConcurrency.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	byName := make(map[string]*Workflow, len(workflows))
+	var errors error
+	for _, workflow := range workflows {
+		if _, ok := byName[workflow.Name()]; ok {
+			errors = multierror.Append(errors, fmt.Errorf("duplicate workflow %s", workflow.Name()))
+		}
+		byName[workflow.Name()] = workflow
+	}
+	if !options.IgnoreRequires {
+		for _, workflow := range workflows {
+			for _, name := range workflow.Requires {
+				if _, ok := byName[name]; !ok {
+					errors = multierror.Append(errors, fmt.Errorf("workflow %s requires unknown workflow %s", workflow.Name(), name))
+				}
+			}
+		}
+	}
+	if errors != nil {
+		return nil, validationError(errors)
+	}
+
+	logger, err := NewLogger(nil, NewLoggingContext(nil, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	set := &WorkflowSet{
+		Workflows: workflows,
+		options:   options,
+		signal:    &sync.Mutex{},
+		running:   make(map[*Workflow]context.CancelFunc),
+		logger:    logger,
+	}
+	if !options.IgnoreRequires {
+		if cycle := set.findCycle(byName); cycle != nil {
+			return nil, validationError(fmt.Errorf("workflows require each other: %s", strings.Join(cycle, " -> ")))
+		}
+	}
+
+	return set, nil
+}
+
+// LoadWorkflowSetFromDir loads the workflow files (.yml and .yaml) of the
+// directory, not including its subdirectories, into a set. Each workflow is
+// named after its file without the extension, and loaded with the options
+// returned by newOptions for that name
+func LoadWorkflowSetFromDir(ctx context.Context, dir string, newOptions func(name string) *WorkflowOptions, options *WorkflowSetOptions) (*WorkflowSet, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var workflows []*Workflow
+	var errors error
+	for _, file := range files {
+		extension := filepath.Ext(file.Name())
+		if file.IsDir() || (extension != ".yml" && extension != ".yaml") {
+			continue
+		}
+
+		name := strings.TrimSuffix(file.Name(), extension)
+		workflowOptions := newOptions(name)
+		workflowOptions.Name = name
+
+		workflow, err := LoadWorkflowFromFile(ctx, workflowOptions, filepath.Join(dir, file.Name()))
+		if err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("%s: %s", file.Name(), err))
+			continue
+		}
+
+		workflows = append(workflows, workflow)
+	}
+	if errors != nil {
+		return nil, errors
+	}
+	if len(workflows) == 0 {
+		return nil, fmt.Errorf("no workflow files in %s", dir)
+	}
+
+	return NewWorkflowSet(workflows, options)
+}
+
+// findCycle returns the names of the workflows requiring each other in a
+// cycle, or nil if there is none
+func (s *WorkflowSet) findCycle(byName map[string]*Workflow) []string {
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	states := make(map[string]int, len(byName))
+	var path []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch states[name] {
+		case visited:
+			return nil
+		case visiting:
+			for idx, item := range path {
+				if item == name {
+					return append(append([]string{}, path[idx:]...), name)
+				}
+			}
+		}
+
+		states[name] = visiting
+		path = append(path, name)
+		for _, required := range byName[name].Requires {
+			if cycle := visit(required); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		states[name] = visited
+
+		return nil
+	}
+
+	for _, workflow := range s.Workflows {
+		if cycle := visit(workflow.Name()); cycle != nil {
+			return cycle
+		}
+	}
+
+	return nil
+}
+
+// Run runs the workflows of the set, each once the workflows it requires
+// succeeded, and returns their results in the order of the set
+func (s *WorkflowSet) Run(ctx context.Context) *WorkflowSetResult {
+	result := &WorkflowSetResult{StartedAt: time.Now()}
+
+	if adaptive := s.options.AdaptiveConcurrency; adaptive != nil {
+		// one controller changes the budget shared by all workflows
+		budget := semaphore.NewWeighted(int64(adaptive.Max))
+		for _, workflow := range s.Workflows {
+			workflow.gatekeeper = budget
+			workflow.options.AdaptiveConcurrency = nil
+		}
+
+		controller := newConcurrencyController(adaptive, budget, s.logger)
+		controller.start(ctx)
+		defer controller.stop()
+	} else if s.options.Concurrency > 0 {
+		// the steps of all workflows take their turn from the same budget
+		budget := semaphore.NewWeighted(int64(s.options.Concurrency))
+		for _, workflow := range s.Workflows {
+			workflow.gatekeeper = budget
+		}
+	}
+
+	done := make(map[string]chan struct{}, len(s.Workflows))
+	for _, workflow := range s.Workflows {
+		done[workflow.Name()] = make(chan struct{})
+		result.Workflows = append(result.Workflows, &WorkflowSetRunResult{
+			Name:     workflow.Name(),
+			Requires: workflow.Requires,
+			Outcome:  ResultNotRun,
+		})
+	}
+	byName := make(map[string]*WorkflowSetRunResult, len(result.Workflows))
+	for _, item := range result.Workflows {
+		byName[item.Name] = item
+	}
+
+	joiner := sync.WaitGroup{}
+	for idx, workflow := range s.Workflows {
+		joiner.Add(1)
+		go func(workflow *Workflow, item *WorkflowSetRunResult) {
+			defer joiner.Done()
+			defer close(done[item.Name])
+
+			if !s.options.IgnoreRequires {
+				for _, required := range workflow.Requires {
+					select {
+					case <-done[required]:
+					case <-ctx.Done():
+						return
+					}
+					if !succeeded(byName[required].Outcome) {
+						workflow.logger.Warnf("Not running %s as %s didn't succeed", item.Name, required)
+						return
+					}
+				}
+			}
+
+			s.signal.Lock()
+			if s.cancelled != "" || ctx.Err() != nil {
+				s.signal.Unlock()
+				return
+			}
+			runCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			s.running[workflow] = cancel
+			s.signal.Unlock()
+
+			workflowResult, err := workflow.Run(runCtx)
+			item.Result, item.Error = workflowResult, err
+			switch {
+			case err != nil:
+				item.Outcome = OutcomeFailed
+			case workflowResult != nil:
+				item.Outcome = workflowResult.Outcome
+			}
+		}(workflow, result.Workflows[idx])
+	}
+	joiner.Wait()
+
+	result.FinishedAt = time.Now()
+	result.Duration = result.FinishedAt.Sub(result.StartedAt)
+	result.Outcome = OutcomeSuccess
+	for _, item := range result.Workflows {
+		outcome := item.Outcome
+		if outcome == ResultNotRun {
+			outcome = OutcomeFailed
+			if ctx.Err() != nil || s.cancelReason() != "" {
+				outcome = OutcomeCancelled
+			}
+		}
+		if rank(outcome) > rank(result.Outcome) {
+			result.Outcome = outcome
+		}
+	}
+
+	return result
+}
+
+// Cancel cancels the running workflows of the set for the reason, and stops
+// the others from starting. It doesn't wait for them
+func (s *WorkflowSet) Cancel(reason string) {
+	s.signal.Lock()
+	s.cancelled = reason
+	running := make(map[*Workflow]context.CancelFunc, len(s.running))
+	for workflow, cancel := range s.running {
+		running[workflow] = cancel
+	}
+	s.signal.Unlock()
+
+	for workflow, cancel := range running {
+		go func(workflow *Workflow, cancel context.CancelFunc) {
+			if _, err := workflow.Cancel(context.Background(), reason); err != nil {
+				// not running yet
+				cancel()
+			}
+		}(workflow, cancel)
+	}
+}
+
+func (s *WorkflowSet) cancelReason() string {
+	s.signal.Lock()
+	defer s.signal.Unlock()
+
+	return s.cancelled
+}
+
+// ExitCode returns the exit code of trackman for the result: the highest
+// exit code of the workflows, or ExitError for a workflow that couldn't run
+func (r *WorkflowSetResult) ExitCode() int {
+	code := ExitSuccess
+	for _, item := range r.Workflows {
+		itemCode := ExitSuccess
+		switch {
+		case item.Error != nil:
+			itemCode = ExitError
+		case item.Result != nil:
+			itemCode = item.Result.ExitCode()
+		case r.Outcome == OutcomeCancelled:
+			itemCode = ExitCancelled
+		}
+
+		if itemCode > code {
+			code = itemCode
+		}
+	}
+
+	return code
+}
+
+// Workflow returns the result of the workflow with the name or nil
+func (r *WorkflowSetResult) Workflow(name string) *WorkflowSetRunResult {
+	for _, item := range r.Workflows {
+		if item.Name == name {
+			return item
+		}
+	}
+
+	return nil
+}
+
+// succeeded returns true for the outcomes the workflows requiring a
+// workflow run after
+func succeeded(outcome string) bool {
+	return outcome == OutcomeSuccess || outcome == OutcomePartial || outcome == OutcomeDegraded
+}
+
+func rank(outcome string) int {
+	for idx, item := range outcomeRanks {
+		if item == outcome {
+			return idx
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// setStepLines records the line each step starts on in the workflow file, so
+// the problems of a step can say where it is. The YAML parser doesn't keep
+// them, so they are found in block lists under steps, cleanup and the steps
+// of stages. Steps written any other way, like in a flow list, have none.
+// The steps the parser left out for the problems on the dropped lines are
+// skipped
+func (w *Workflow) setStepLines(buff []byte, dropped []int) {
+	lines := strings.Split(string(buff), "\n")
+
+	items, end := listItemLines(lines, 0, "steps", 0)
+	setLines(w.Steps, items, end, dropped)
+	items, end = listItemLines(lines, 0, "cleanup", 0)
+	setLines(w.Cleanup, items, end, dropped)
+
+	stages, stagesEnd := listItemLines(lines, 0, "stages", 0)
+	if len(stages) != len(w.Stages) {
+		return
+	}
+	for idx, stage := range w.Stages {
+		next := stagesEnd
+		if idx+1 < len(stages) {
+			next = stages[idx+1]
+		}
+
+		// the attributes of the stage start after the dash of its item
+		first := stages[idx] - 1
+		region := append([]string{strings.Replace(lines[first], "-", " ", 1)}, lines[first+1:next-1]...)
+		items, end = listItemLines(region, first, "steps", indentation(region[0]))
+		setLines(stage.Steps, items, end, dropped)
+	}
+}
+
+// setLines sets the line of each step from the items of its list, leaving out
+// the items with a dropped line. Nothing is set if they don't match
+func setLines(steps []*Step, items []int, end int, dropped []int) {
+	var kept []int
+	for idx, line := range items {
+		next := end
+		if idx+1 < len(items) {
+			next = items[idx+1]
+		}
+
+		isDropped := false
+		for _, droppedLine := range dropped {
+			if droppedLine >= line && droppedLine < next {
+				isDropped = true
+			}
+		}
+		if !isDropped {
+			kept = append(kept, line)
+		}
+	}
+	if len(steps) != len(kept) {
+		return
+	}
+
+	for idx, step := range steps {
+		step.line = kept[idx]
+	}
+}
+
+// listItemLines returns the line numbers of the items of the block list under
+// the key at the indentation, and of the line after the list. offset is the
+// number of lines before the given ones in the file
+func listItemLines(lines []string, offset int, key string, indent int) ([]int, int) {
+	start := -1
+	for idx, line := range lines {
+		if indentation(line) != indent {
+			continue
+		}
+
+		value := strings.TrimSpace(line)
+		if strings.HasPrefix(value, key+":") && isEmptyValue(strings.TrimPrefix(value, key+":")) {
+			start = idx + 1
+			break
+		}
+	}
+	if start == -1 {
+		return nil, 0
+	}
+
+	var items []int
+	itemIndent := -1
+	for idx := start; idx < len(lines); idx++ {
+		value := strings.TrimSpace(lines[idx])
+		if value == "" || strings.HasPrefix(value, "#") {
+			continue
+		}
+
+		isItem := value == "-" || strings.HasPrefix(value, "- ")
+		lineIndent := indentation(lines[idx])
+		if itemIndent == -1 {
+			if !isItem || lineIndent < indent {
+				return nil, 0
+			}
+			itemIndent = lineIndent
+		}
+
+		switch {
+		case lineIndent > itemIndent:
+			// attributes of the current item
+		case lineIndent == itemIndent && isItem:
+			items = append(items, offset+idx+1)
+		default:
+			return items, offset + idx + 1
+		}
+	}
+
+	return items, offset + len(lines) + 1
+}
+
+// problemLines returns the lines of the problems found by the YAML parser,
+// which start with the line they are on
+func problemLines(problems []string) []int {
+	var lines []int
+	for _, problem := range problems {
+		var line int
+		if _, err := fmt.Sscanf(problem, "line %d:", &line); err == nil {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// isEmptyValue returns true if nothing but a comment follows a key
+func isEmptyValue(value string) bool {
+	value = strings.TrimSpace(value)
+	return value == "" || strings.HasPrefix(value, "#")
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
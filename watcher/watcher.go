@@ -0,0 +1,220 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloud66-oss/trackman/utils"
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// Options configures a Watcher
+type Options struct {
+	// Paths are the directories, files or glob patterns to watch
+	Paths []string
+	// Ignore are the patterns of the files to ignore changes to
+	Ignore []string
+	// Debounce is how long to wait for the files to stop changing before
+	// reporting them
+	Debounce time.Duration
+	Logger   *logrus.Logger
+}
+
+// Watcher reports the files that changed under the watched paths. Changes
+// are collected until the files stop changing for the debounce time, and
+// while the last changes haven't been received
+type Watcher struct {
+	options  *Options
+	watcher  *fsnotify.Watcher
+	patterns []string
+	changes  chan []string
+	done     chan struct{}
+	joiner   *sync.WaitGroup
+}
+
+// NewWatcher creates a new Watcher and starts watching the paths
+func NewWatcher(options *Options) (*Watcher, error) {
+	if options.Debounce <= 0 {
+		options.Debounce = utils.DefaultDebounce
+	}
+	if options.Logger == nil {
+		options.Logger = logrus.StandardLogger()
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		options: options,
+		watcher: watcher,
+		changes: make(chan []string),
+		done:    make(chan struct{}),
+		joiner:  &sync.WaitGroup{},
+	}
+
+	for _, item := range options.Paths {
+		if err = w.add(item); err != nil {
+			_ = watcher.Close()
+			return nil, err
+		}
+	}
+
+	w.joiner.Add(1)
+	go w.watch()
+
+	return w, nil
+}
+
+// Changes receives the changed files, sorted by name
+func (w *Watcher) Changes() <-chan []string {
+	return w.changes
+}
+
+// Close stops watching the files
+func (w *Watcher) Close() error {
+	close(w.done)
+	w.joiner.Wait()
+
+	return w.watcher.Close()
+}
+
+// add watches a directory with its subdirectories, a file or the directory
+// of the files matching a glob pattern
+func (w *Watcher) add(item string) error {
+	item = filepath.Clean(item)
+
+	if base := utils.GlobBase(item); base != item {
+		w.patterns = append(w.patterns, item)
+		return w.addTree(base)
+	}
+
+	info, err := os.Stat(item)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		w.patterns = append(w.patterns, item)
+		return w.watcher.Add(filepath.Dir(item))
+	}
+
+	// everything in the directory is watched
+	w.patterns = append(w.patterns, item+string(filepath.Separator)+"**")
+	return w.addTree(item)
+}
+
+// addTree watches the directory and all its subdirectories that aren't
+// ignored
+func (w *Watcher) addTree(dir string) error {
+	return filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if name != dir && w.ignored(name) {
+			return filepath.SkipDir
+		}
+
+		return w.watcher.Add(name)
+	})
+}
+
+func (w *Watcher) ignored(name string) bool {
+	for _, pattern := range w.options.Ignore {
+		if utils.MatchGlob(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *Watcher) watched(name string) bool {
+	if w.ignored(name) {
+		return false
+	}
+
+	for _, pattern := range w.patterns {
+		if name == pattern || utils.MatchGlob(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *Watcher) watch() {
+	defer w.joiner.Done()
+
+	pending := make(map[string]bool)
+	timer := time.NewTimer(w.options.Debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	// changes is only set once the files have stopped changing, so they are
+	// only sent then
+	var changes chan []string
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+
+			name := filepath.Clean(event.Name)
+
+			// new directories need to be watched too, and their files are
+			// reported as they are added
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(name); err == nil && info.IsDir() {
+					if w.ignored(name) {
+						continue
+					}
+					if err = w.addTree(name); err != nil {
+						w.options.Logger.Warnf("Failed to watch %s: %s", name, err)
+					}
+
+					continue
+				}
+			}
+			if !w.watched(name) {
+				continue
+			}
+
+			pending[name] = true
+			changes = nil
+			timer.Reset(w.options.Debounce)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			w.options.Logger.Warnf("Failed to watch the files: %s", err)
+		case <-timer.C:
+			changes = w.changes
+		case changes <- sorted(pending):
+			pending = make(map[string]bool)
+			changes = nil
+		}
+	}
+}
+
+func sorted(items map[string]bool) []string {
+	names := make([]string, 0, len(items))
+	for name := range items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}